@@ -1,57 +1,134 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/glue"
+	"github.com/aws/aws-sdk-go/service/glue/glueiface"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/gin-gonic/gin"
 )
 
-var athenaClient *athena.Athena
-var s3Client *s3.S3
-var athenaResultsBucket string
+// Bucket names as S3 allows them: 3 to 63 lowercase letters, digits, dots and hyphens
+var s3BucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// Helper function to create the Athena, S3 and Glue clients from the environment.
+// ATHENA_DRIVER=fake swaps in an in-process fake for working without AWS.
+func newAWSClients() (athenaiface.AthenaAPI, s3iface.S3API, glueiface.GlueAPI, string, error) {
+	// Get configurable S3 bucket name
+	resultsBucket := os.Getenv("ATHENA_RESULTS_BUCKET")
+	if resultsBucket == "" {
+		return nil, nil, nil, "", fmt.Errorf("ATHENA_RESULTS_BUCKET environment variable must be set")
+	}
+
+	if os.Getenv("ATHENA_DRIVER") == "fake" {
+		fakeS3 := newFakeS3()
+		return newFakeAthena(fakeS3), fakeS3, newFakeGlue(), resultsBucket, nil
+	}
 
-func init() {
 	// Create AWS session using default profile credentials
 	region := os.Getenv("AWS_DEFAULT_REGION")
 	if region == "" {
 		region = "us-east-1" // Default region
 	}
 
-	// Get configurable S3 bucket name
-	athenaResultsBucket = os.Getenv("ATHENA_RESULTS_BUCKET")
-	if athenaResultsBucket == "" {
-		panic("ATHENA_RESULTS_BUCKET environment variable must be set")
-	}
-
 	sess, err := session.NewSession(&aws.Config{
 		Region: aws.String(region),
 	})
 	if err != nil {
-		panic(fmt.Sprintf("Failed to create AWS session: %v", err))
+		return nil, nil, nil, "", fmt.Errorf("failed to create AWS session: %v", err)
 	}
 
-	athenaClient = athena.New(sess)
-	s3Client = s3.New(sess)
+	return athena.New(sess), s3.New(sess), glue.New(sess), resultsBucket, nil
+}
+
+const defaultAthenaWorkGroup = "primary"
+
+// executionContext is where Athena runs a statement. Without a catalog and
+// database, unqualified table names resolve in the defaults of the workgroup.
+type executionContext struct {
+	Catalog   string
+	Database  string
+	WorkGroup string
+}
+
+// Helper function to pick where a statement runs: the overrides of the request
+// first, then the defaults saved with the query, then ATHENA_WORKGROUP
+func (s *Server) resolveExecutionContext(query *Query, override executionContext) executionContext {
+	exec := override
+	if query != nil {
+		if exec.Catalog == "" {
+			exec.Catalog = query.Catalog
+		}
+		if exec.Database == "" {
+			exec.Database = query.Database
+		}
+		if exec.WorkGroup == "" {
+			exec.WorkGroup = query.WorkGroup
+		}
+	}
+	if exec.WorkGroup == "" {
+		exec.WorkGroup = s.athenaWorkGroup
+	}
+	return exec
 }
 
-func executeAthenaQueryInternal(sql string) (string, error) {
+// Helper function to start a query. With a positive reuseFor Athena may answer
+// from the results of an identical query run within that time.
+func (s *Server) executeAthenaQueryInternal(sql string, exec executionContext, reuseFor time.Duration) (string, error) {
 	// Start query execution
 	input := &athena.StartQueryExecutionInput{
 		QueryString: aws.String(sql),
 		ResultConfiguration: &athena.ResultConfiguration{
-			OutputLocation: aws.String(fmt.Sprintf("s3://%s/", athenaResultsBucket)),
+			OutputLocation: aws.String(fmt.Sprintf("s3://%s/", s.resultsBucket)),
 		},
-		WorkGroup: aws.String("primary"),
+		WorkGroup: aws.String(exec.WorkGroup),
 	}
 
-	result, err := athenaClient.StartQueryExecution(input)
+	// Unqualified table names resolve in the catalog and database of the context
+	if exec.Catalog != "" || exec.Database != "" {
+		input.QueryExecutionContext = &athena.QueryExecutionContext{}
+		if exec.Catalog != "" {
+			input.QueryExecutionContext.Catalog = aws.String(exec.Catalog)
+		}
+		if exec.Database != "" {
+			input.QueryExecutionContext.Database = aws.String(exec.Database)
+		}
+	}
+
+	if reuseFor > 0 {
+		if reuseFor > maxAthenaResultReuse {
+			reuseFor = maxAthenaResultReuse
+		}
+		minutes := int64((reuseFor + time.Minute - 1) / time.Minute)
+		input.ResultReuseConfiguration = &athena.ResultReuseConfiguration{
+			ResultReuseByAgeConfiguration: &athena.ResultReuseByAgeConfiguration{
+				Enabled:         aws.Bool(true),
+				MaxAgeInMinutes: aws.Int64(minutes),
+			},
+		}
+	}
+
+	result, err := s.athena.StartQueryExecution(input)
+	if err != nil && input.ResultReuseConfiguration != nil && strings.Contains(strings.ToLower(err.Error()), "reuse") {
+		// Result reuse needs engine version 3, retry without it on older workgroups
+		input.ResultReuseConfiguration = nil
+		result, err = s.athena.StartQueryExecution(input)
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to start query execution: %v", err)
 	}
@@ -59,13 +136,13 @@ func executeAthenaQueryInternal(sql string) (string, error) {
 	return *result.QueryExecutionId, nil
 }
 
-func getAthenaResults(executionID string, page, size int) (*QueryResults, error) {
+func (s *Server) getAthenaResults(executionID string, page, size int) (*QueryResults, error) {
 	// Get query execution status
 	describeInput := &athena.GetQueryExecutionInput{
 		QueryExecutionId: aws.String(executionID),
 	}
 
-	describeResult, err := athenaClient.GetQueryExecution(describeInput)
+	describeResult, err := s.athena.GetQueryExecution(describeInput)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get query execution: %v", err)
 	}
@@ -110,7 +187,7 @@ func getAthenaResults(executionID string, page, size int) (*QueryResults, error)
 			resultsInput.NextToken = nextToken
 		}
 
-		resultsOutput, err := athenaClient.GetQueryResults(resultsInput)
+		resultsOutput, err := s.athena.GetQueryResults(resultsInput)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get query results: %v", err)
 		}
@@ -180,128 +257,171 @@ func getAthenaResults(executionID string, page, size int) (*QueryResults, error)
 	}, nil
 }
 
-func getResultsS3URL(executionID string) (string, error) {
+func (s *Server) getResultsS3URL(executionID string) (string, error) {
+	s3URL, _, err := s.getResultsOutput(executionID)
+	return s3URL, err
+}
+
+// Helper function to get the results location of an execution and whether
+// Athena reused the results of an earlier execution instead of running it
+func (s *Server) getResultsOutput(executionID string) (string, bool, error) {
 	// Get query execution details
 	describeInput := &athena.GetQueryExecutionInput{
 		QueryExecutionId: aws.String(executionID),
 	}
 
-	result, err := athenaClient.GetQueryExecution(describeInput)
+	result, err := s.athena.GetQueryExecution(describeInput)
 	if err != nil {
-		return "", fmt.Errorf("failed to get query execution: %v", err)
+		return "", false, fmt.Errorf("failed to get query execution: %v", err)
 	}
 
-	if result.QueryExecution.ResultConfiguration.OutputLocation == nil {
-		return "", fmt.Errorf("no output location found")
+	if result.QueryExecution.ResultConfiguration == nil || result.QueryExecution.ResultConfiguration.OutputLocation == nil {
+		return "", false, fmt.Errorf("no output location found")
 	}
 
-	return *result.QueryExecution.ResultConfiguration.OutputLocation, nil
+	reused := false
+	if statistics := result.QueryExecution.Statistics; statistics != nil && statistics.ResultReuseInformation != nil {
+		reused = aws.BoolValue(statistics.ResultReuseInformation.ReusedPreviousResult)
+	}
+
+	return *result.QueryExecution.ResultConfiguration.OutputLocation, reused, nil
 }
 
-func proxyS3File(c *gin.Context, s3URL string) error {
-	// Parse S3 URL
-	parts := strings.Split(strings.TrimPrefix(s3URL, "s3://"), "/")
-	if len(parts) < 2 {
-		return fmt.Errorf("invalid S3 URL")
+// Helper function to stream a file from S3 to the client. Range and If-Range
+// are passed on to S3 so interrupted downloads can resume, and the S3 read is
+// cancelled when the client goes away. Once headers are sent the returned error
+// can't change the response; see abortDownload.
+func (s *Server) proxyS3File(c *gin.Context, s3URL string) error {
+	bucket, key, err := parseS3URL(s3URL)
+	if err != nil {
+		return err
 	}
 
-	bucket := parts[0]
-	key := strings.Join(parts[1:], "/")
-
-	// Get object from S3
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	}
+	if rangeHeader := c.GetHeader("Range"); rangeHeader != "" {
+		input.Range = aws.String(rangeHeader)
+
+		// Only resume from the range if the file is still the one the client has
+		if ifRange := c.GetHeader("If-Range"); ifRange != "" {
+			if t, err := http.ParseTime(ifRange); err == nil {
+				input.IfUnmodifiedSince = aws.Time(t)
+			} else {
+				input.IfMatch = aws.String(ifRange)
+			}
+		}
+	}
 
-	result, err := s3Client.GetObject(input)
+	ctx := c.Request.Context()
+	result, err := s.s3.GetObjectWithContext(ctx, input)
+	if awsErrorCode(err) == "PreconditionFailed" {
+		// The file changed, so send all of it
+		input.Range, input.IfMatch, input.IfUnmodifiedSince = nil, nil, nil
+		result, err = s.s3.GetObjectWithContext(ctx, input)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get S3 object: %v", err)
+		return fmt.Errorf("failed to get S3 object: %w", err)
 	}
 	defer result.Body.Close()
 
-	// Stream the file to the client
-	_, err = io.Copy(c.Writer, result.Body)
-	return err
-}
-
-func fetchAthenaCatalog() (*AthenaCatalog, error) {
-	var databases []CatalogDatabase
-
-	// For now, let's focus on the default 'AwsDataCatalog'
-	// In a production environment, you'd iterate through all catalogs
-	catalogName := "AwsDataCatalog"
-
-	// List databases in the default catalog
-	listDatabasesInput := &athena.ListDatabasesInput{
-		CatalogName: &catalogName,
+	header := c.Writer.Header()
+	header.Set("Accept-Ranges", "bytes")
+	if result.ContentLength != nil {
+		header.Set("Content-Length", strconv.FormatInt(*result.ContentLength, 10))
+	}
+	if result.ETag != nil {
+		header.Set("ETag", *result.ETag)
+	}
+	if result.LastModified != nil {
+		header.Set("Last-Modified", result.LastModified.UTC().Format(http.TimeFormat))
 	}
 
-	databasesResult, err := athenaClient.ListDatabases(listDatabasesInput)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list databases: %v", err)
+	status := http.StatusOK
+	if result.ContentRange != nil {
+		header.Set("Content-Range", *result.ContentRange)
+		status = http.StatusPartialContent
 	}
+	c.Status(status)
+	c.Writer.WriteHeaderNow()
 
-	for _, db := range databasesResult.DatabaseList {
-		database := CatalogDatabase{
-			Name:        *db.Name,
-			Description: "",
-			Tables:      []CatalogTable{},
-		}
+	// Stream the file to the client
+	if _, err := io.Copy(c.Writer, result.Body); err != nil {
+		return fmt.Errorf("failed to stream S3 object: %w", err)
+	}
+	return nil
+}
 
-		if db.Description != nil {
-			database.Description = *db.Description
-		}
+// Helper function to split an s3://bucket/key URL into its bucket and key. The
+// key is taken as it is, so keys containing "?", "#", "%" or "//" survive.
+func parseS3URL(s3URL string) (string, string, error) {
+	rest, ok := strings.CutPrefix(s3URL, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("invalid S3 URL: %s", s3URL)
+	}
 
-		// List tables in the database
-		listTablesInput := &athena.ListTableMetadataInput{
-			CatalogName:  &catalogName,
-			DatabaseName: db.Name,
-		}
+	bucket, key, _ := strings.Cut(rest, "/")
+	if !s3BucketPattern.MatchString(bucket) {
+		return "", "", fmt.Errorf("invalid S3 URL: %s has an invalid bucket name", s3URL)
+	}
+	if key == "" {
+		return "", "", fmt.Errorf("invalid S3 URL: %s has no key", s3URL)
+	}
 
-		tablesResult, err := athenaClient.ListTableMetadata(listTablesInput)
-		if err != nil {
-			// Continue even if we can't list tables for this database
-			databases = append(databases, database)
-			continue
-		}
+	return bucket, key, nil
+}
 
-		for _, table := range tablesResult.TableMetadataList {
-			catalogTable := CatalogTable{
-				Name:    *table.Name,
-				Type:    "",
-				Columns: []Column{},
-			}
+// Helper function to get the code of an AWS error, "" for other errors
+func awsErrorCode(err error) string {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return awsErr.Code()
+	}
+	return ""
+}
 
-			if table.TableType != nil {
-				catalogTable.Type = *table.TableType
-			}
+// Helper function to pick the status reported for a failed S3 read
+func s3ErrorStatus(err error) int {
+	switch awsErrorCode(err) {
+	case "":
+		return http.StatusInternalServerError
+	case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, "NotFound":
+		return http.StatusNotFound
+	case "InvalidRange":
+		return http.StatusRequestedRangeNotSatisfiable
+	default:
+		return http.StatusBadGateway
+	}
+}
 
-			if table.Parameters != nil {
-				if location, ok := table.Parameters["location"]; ok && location != nil {
-					catalogTable.Location = *location
-				}
-				if inputFormat, ok := table.Parameters["inputformat"]; ok && inputFormat != nil {
-					catalogTable.InputFormat = *inputFormat
-				}
-			}
+// Helper function to delete a query result file together with the
+// .metadata file Athena writes next to it
+func (s *Server) deleteS3Results(s3URL string) error {
+	bucket, key, err := parseS3URL(s3URL)
+	if err != nil {
+		return err
+	}
 
-			// Add columns
-			for _, col := range table.Columns {
-				column := Column{
-					Name: *col.Name,
-					Type: *col.Type,
-				}
-				catalogTable.Columns = append(catalogTable.Columns, column)
-			}
+	input := &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &s3.Delete{
+			Objects: []*s3.ObjectIdentifier{
+				{Key: aws.String(key)},
+				{Key: aws.String(key + ".metadata")},
+			},
+			Quiet: aws.Bool(true),
+		},
+	}
 
-			database.Tables = append(database.Tables, catalogTable)
-		}
+	result, err := s.s3.DeleteObjects(input)
+	if err != nil {
+		return fmt.Errorf("failed to delete S3 objects: %v", err)
+	}
 
-		databases = append(databases, database)
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("failed to delete s3://%s/%s: %s", bucket, aws.StringValue(result.Errors[0].Key), aws.StringValue(result.Errors[0].Message))
 	}
 
-	return &AthenaCatalog{
-		Databases: databases,
-	}, nil
+	return nil
 }
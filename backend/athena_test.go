@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseS3URL(t *testing.T) {
+	tests := []struct {
+		url    string
+		bucket string
+		key    string
+		err    bool
+	}{
+		{url: "s3://results/run.csv", bucket: "results", key: "run.csv"},
+		{url: "s3://my.results-bucket/a//b/run?x#y%20.csv", bucket: "my.results-bucket", key: "a//b/run?x#y%20.csv"},
+		{url: "https://results.s3.amazonaws.com/run.csv", err: true},
+		{url: "s3://results", err: true},
+		{url: "s3://results/", err: true},
+		{url: "s3://Results/run.csv", err: true},
+		{url: "s3://ab/run.csv", err: true},
+		{url: "s3:///run.csv", err: true},
+	}
+
+	for _, tt := range tests {
+		bucket, key, err := parseS3URL(tt.url)
+		if (err != nil) != tt.err {
+			t.Errorf("parseS3URL(%q) error %v, want error %v", tt.url, err, tt.err)
+			continue
+		}
+		if bucket != tt.bucket || key != tt.key {
+			t.Errorf("parseS3URL(%q) = %q, %q; want %q, %q", tt.url, bucket, key, tt.bucket, tt.key)
+		}
+	}
+}
+
+func TestExportRanges(t *testing.T) {
+	ts := newTestServer(t)
+	executionID := runScriptedQuery(t, ts, exportTestScript)
+	path := "/api/athena/export/" + executionID
+
+	full := ts.do(t, http.MethodGet, path, nil)
+	if full.Code != http.StatusOK || full.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("status %d, Accept-Ranges %q", full.Code, full.Header().Get("Accept-Ranges"))
+	}
+	file := full.Body.String()
+	etag := full.Header().Get("ETag")
+	modified := full.Header().Get("Last-Modified")
+
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+		body    string
+	}{
+		{name: "first bytes", headers: []string{"Range", "bytes=0-3"}, status: http.StatusPartialContent, body: file[:4]},
+		{name: "resume", headers: []string{"Range", "bytes=10-"}, status: http.StatusPartialContent, body: file[10:]},
+		{name: "suffix", headers: []string{"Range", "bytes=-5"}, status: http.StatusPartialContent, body: file[len(file)-5:]},
+		{name: "same ETag", headers: []string{"Range", "bytes=10-", "If-Range", etag}, status: http.StatusPartialContent, body: file[10:]},
+		{name: "same date", headers: []string{"Range", "bytes=10-", "If-Range", modified}, status: http.StatusPartialContent, body: file[10:]},
+		{name: "file changed", headers: []string{"Range", "bytes=10-", "If-Range", `"stale"`}, status: http.StatusOK, body: file},
+		{name: "past the end", headers: []string{"Range", "bytes=100000-"}, status: http.StatusRequestedRangeNotSatisfiable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, path, nil, tt.headers...)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body %q, want %q", rec.Body.String(), tt.body)
+			}
+			if tt.status == http.StatusPartialContent && !strings.HasPrefix(rec.Header().Get("Content-Range"), "bytes ") {
+				t.Errorf("Content-Range %q", rec.Header().Get("Content-Range"))
+			}
+			if tt.status >= 400 && rec.Header().Get("Content-Disposition") != "" {
+				t.Error("an error kept the file headers")
+			}
+		})
+	}
+}
+
+func TestExportCancelled(t *testing.T) {
+	ts := newTestServer(t)
+	executionID := runScriptedQuery(t, ts, exportTestScript)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/athena/export/"+executionID, nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		ts.router.ServeHTTP(rec, req)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a cancelled download didn't stop")
+	}
+	if rec.Code == http.StatusOK && strings.HasPrefix(rec.Body.String(), "name,") {
+		t.Error("a cancelled download was sent")
+	}
+}
+
+func TestExecutionContext(t *testing.T) {
+	tests := []struct {
+		name      string
+		defaultWG string // ATHENA_WORKGROUP
+		query     gin.H  // Defaults saved with the query
+		run       gin.H  // Overrides of the run
+		catalog   string
+		database  string
+		workGroup string
+	}{
+		{name: "defaults", workGroup: "primary"},
+		{name: "ATHENA_WORKGROUP", defaultWG: "etl", workGroup: "etl"},
+		{
+			name:    "saved with the query",
+			query:   gin.H{"catalog": "lambda_sales", "database": "sales", "workGroup": "analytics"},
+			catalog: "lambda_sales", database: "sales", workGroup: "analytics",
+		},
+		{
+			name:    "overridden by the run",
+			query:   gin.H{"catalog": "lambda_sales", "database": "sales", "workGroup": "analytics"},
+			run:     gin.H{"database": "finance", "workGroup": "adhoc"},
+			catalog: "lambda_sales", database: "finance", workGroup: "adhoc",
+		},
+		{name: "database only", run: gin.H{"database": "sales"}, database: "sales", workGroup: "primary"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			if tt.defaultWG != "" {
+				ts.athenaWorkGroup = tt.defaultWG
+			}
+
+			body := gin.H{"name": "Orders", "sql": "SELECT * FROM orders"}
+			for key, value := range tt.query {
+				body[key] = value
+			}
+			query := doJSON[Query](t, ts, http.MethodPost, "/api/queries", body, http.StatusCreated)
+			run := runTestQuery(t, ts, query, tt.run)
+
+			if run.Catalog != tt.catalog || run.Database != tt.database || run.WorkGroup != tt.workGroup {
+				t.Errorf("run in %q/%q/%q, want %q/%q/%q", run.Catalog, run.Database, run.WorkGroup, tt.catalog, tt.database, tt.workGroup)
+			}
+
+			input := ts.athena.executions[run.ExecutionID].input
+			if aws.StringValue(input.WorkGroup) != tt.workGroup {
+				t.Errorf("executed in workgroup %q, want %q", aws.StringValue(input.WorkGroup), tt.workGroup)
+			}
+			exec := input.QueryExecutionContext
+			if (exec != nil) != (tt.catalog != "" || tt.database != "") {
+				t.Fatalf("execution context %v", exec)
+			}
+			if exec != nil && (aws.StringValue(exec.Catalog) != tt.catalog || aws.StringValue(exec.Database) != tt.database) {
+				t.Errorf("execution context %v, want %q/%q", exec, tt.catalog, tt.database)
+			}
+		})
+	}
+}
+
+func TestExecutionContextResultCache(t *testing.T) {
+	ts := newTestServer(t)
+	query := createTestQuery(t, ts, "Orders", "SELECT * FROM orders")
+
+	first := runTestQuery(t, ts, query, nil)
+	again := runTestQuery(t, ts, query, nil)
+	other := runTestQuery(t, ts, query, gin.H{"workGroup": "analytics"})
+	elsewhere := runTestQuery(t, ts, query, gin.H{"database": "archive"})
+
+	if !again.FromCache || again.ExecutionID != first.ExecutionID {
+		t.Errorf("run in the same context executed %s, want the results of %s", again.ExecutionID, first.ExecutionID)
+	}
+	for _, run := range []QueryRun{other, elsewhere} {
+		if run.FromCache || run.ExecutionID == first.ExecutionID {
+			t.Errorf("run in %s/%s reused results of another context", run.Database, run.WorkGroup)
+		}
+	}
+
+	execution := doJSON[gin.H](t, ts, http.MethodPost, "/api/athena/execute",
+		gin.H{"sql": "SELECT * FROM orders", "database": "archive", "workGroup": "primary"}, http.StatusOK)
+	if execution["executionId"] != elsewhere.ExecutionID || execution["fromCache"] != true {
+		t.Errorf("execution %v, want the results of %s", execution, elsewhere.ExecutionID)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const defaultResultCacheTTL = 5 * time.Minute
+
+// Longest result reuse Athena accepts (7 days)
+const maxAthenaResultReuse = 7 * 24 * time.Hour
+
+// Helper function to read RESULT_CACHE_TTL (a duration, 0 disables the cache)
+func loadResultCacheTTL() (time.Duration, error) {
+	value := os.Getenv("RESULT_CACHE_TTL")
+	if value == "" {
+		return defaultResultCacheTTL, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid RESULT_CACHE_TTL: %s", value)
+	}
+	return ttl, nil
+}
+
+// Helper function to normalize SQL for cache lookups: -- comments are dropped,
+// whitespace outside string literals and quoted identifiers is collapsed,
+// keywords are lowercased and a trailing semicolon is dropped. Literals are kept
+// as they are. Comments go before whitespace is collapsed, as the newline ending
+// one is all that separates it from the SQL after it.
+func normalizeSQL(sql string) string {
+	var b strings.Builder
+	var quote rune
+	pendingSpace := false
+	runes := []rune(strings.TrimSpace(sql))
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if quote != 0 {
+			b.WriteRune(r)
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch {
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			pendingSpace = true
+			continue
+		case unicode.IsSpace(r):
+			pendingSpace = true
+			continue
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		}
+
+		if pendingSpace && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		pendingSpace = false
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	return strings.TrimRight(strings.TrimSpace(b.String()), "; ")
+}
+
+// Helper function to name the engine part of cache keys. Results are never
+// shared between workgroups, nor between catalogs and databases where the same
+// unqualified table names may refer to different tables.
+func resultCacheEngine(exec executionContext) string {
+	engine := "athena/" + exec.WorkGroup
+	if exec.Catalog != "" || exec.Database != "" {
+		engine += "/" + exec.Catalog + "/" + exec.Database
+	}
+	return engine
+}
+
+// Helper function to compute the cache key of an execution from its final SQL,
+// its parameters and the engine it runs on
+func resultCacheKey(engine, finalSQL string, parameters map[string]string) string {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00", engine, normalizeSQL(finalSQL))
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s=%s\x00", name, parameters[name])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Helper function to pick the cache TTL of a query, which may override the default
+func (s *Server) queryResultCacheTTL(query *Query) time.Duration {
+	if query != nil && query.ResultCacheTTL != nil {
+		return time.Duration(*query.ResultCacheTTL) * time.Second
+	}
+	return s.resultCacheTTL
+}
+
+// Helper function to check whether runs other than run point at its result
+// files, as happens when results are served from the cache
+func (s *Server) resultsShared(ctx context.Context, run QueryRun) (bool, error) {
+	filter := QueryRunFilter{ResultsS3URL: run.ResultsS3URL, Trash: anyTrashState}
+	page := pageRequest{Sort: queryRunSortFields["executed"], Limit: 1}
+
+	_, _, total, err := s.store.ListQueryRuns(ctx, filter, page)
+	return total > 1, err
+}
+
+// Helper function to find the newest successful execution with the same cache
+// key that started within ttl and whose results can be reused. Runs served from
+// the cache carry no key, so a result is never reused for longer than ttl after
+// Athena produced it.
+func (s *Server) findCachedRun(ctx context.Context, cacheKey string, ttl time.Duration) (*QueryRun, error) {
+	if ttl <= 0 {
+		return nil, nil
+	}
+
+	since := time.Now().Add(-ttl)
+	filter := QueryRunFilter{
+		CacheKey:      cacheKey,
+		Statuses:      []string{"SUCCEEDED"},
+		ExecutedAfter: &since,
+	}
+	page := pageRequest{Sort: queryRunSortFields["executed"], Desc: true, Limit: 0}
+
+	runs, _, _, err := s.store.ListQueryRuns(ctx, filter, page)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, run := range runs {
+		// Athena may have reused older results itself, only pass on fresh ones
+		if run.ResultsS3URL != "" && !run.FromCache {
+			return &runs[i], nil
+		}
+	}
+	return nil, nil
+}
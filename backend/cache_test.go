@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{sql: "SELECT  *\n\tFROM orders;", want: "select * from orders"},
+		{sql: "select * from orders ; ", want: "select * from orders"},
+		{sql: "SELECT 'A  B' FROM \"My  Table\"", want: "select 'A  B' from \"My  Table\""},
+		{sql: "SELECT 1 -- Note", want: "select 1"},
+		{sql: "SELECT * FROM t -- recent\nWHERE dt > '2024'", want: "select * from t where dt > '2024'"},
+		{sql: "SELECT * FROM t -- recent WHERE dt > '2024'", want: "select * from t"},
+		{sql: "SELECT '--  x' -- y\n;", want: "select '--  x'"},
+		{sql: "SELECT 1 - -1", want: "select 1 - -1"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeSQL(tt.sql); got != tt.want {
+			t.Errorf("normalizeSQL(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	key := resultCacheKey("athena/primary", "SELECT * FROM orders", map[string]string{"a": "1", "b": "2"})
+
+	if other := resultCacheKey("athena/primary", "select *\nfrom orders;", map[string]string{"b": "2", "a": "1"}); other != key {
+		t.Error("formatting or parameter order changed the key")
+	}
+	for name, other := range map[string]string{
+		"engine":     resultCacheKey("athena/analysts", "SELECT * FROM orders", map[string]string{"a": "1", "b": "2"}),
+		"literal":    resultCacheKey("athena/primary", "SELECT * FROM Orders WHERE x = 'A'", map[string]string{"a": "1", "b": "2"}),
+		"parameters": resultCacheKey("athena/primary", "SELECT * FROM orders", map[string]string{"a": "1", "b": "3"}),
+	} {
+		if other == key {
+			t.Errorf("a different %s gave the same key", name)
+		}
+	}
+}
+
+func TestExecuteReusesResults(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  gin.H
+		second gin.H
+		reused bool
+	}{
+		{
+			name:   "same SQL",
+			first:  gin.H{"sql": "SELECT * FROM orders"},
+			second: gin.H{"sql": "select *\n  from orders;"},
+			reused: true,
+		},
+		{
+			name:   "same parameters",
+			first:  gin.H{"sql": "SELECT * FROM orders WHERE region = '{{region}}'", "parameters": gin.H{"region": "eu"}},
+			second: gin.H{"sql": "SELECT * FROM orders WHERE region = '{{region}}'", "parameters": gin.H{"region": "eu"}},
+			reused: true,
+		},
+		{
+			name:   "other parameters",
+			first:  gin.H{"sql": "SELECT * FROM orders WHERE region = '{{region}}'", "parameters": gin.H{"region": "eu"}},
+			second: gin.H{"sql": "SELECT * FROM orders WHERE region = '{{region}}'", "parameters": gin.H{"region": "us"}},
+		},
+		{
+			name:   "other database",
+			first:  gin.H{"sql": "SELECT * FROM orders", "database": "sales"},
+			second: gin.H{"sql": "SELECT * FROM orders", "database": "staging"},
+		},
+		{
+			name:   "cache bypassed",
+			first:  gin.H{"sql": "SELECT * FROM orders"},
+			second: gin.H{"sql": "SELECT * FROM orders", "noCache": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			query := createTestQuery(t, ts, "Orders", "")
+			first := runTestQuery(t, ts, query, tt.first)
+
+			started := doJSON[gin.H](t, ts, http.MethodPost, "/api/athena/execute", tt.second, http.StatusOK)
+			if (started["fromCache"] == true) != tt.reused || (started["executionId"] == first.ExecutionID) != tt.reused {
+				t.Errorf("execute answered %v after %s, want reused %v", started, first.ExecutionID, tt.reused)
+			}
+
+			second := runTestQuery(t, ts, query, tt.second)
+			if second.FromCache != tt.reused || (second.ExecutionID == first.ExecutionID) != tt.reused {
+				t.Errorf("second run fromCache %v with execution %s after %s, want reused %v",
+					second.FromCache, second.ExecutionID, first.ExecutionID, tt.reused)
+			}
+		})
+	}
+}
+
+func TestResultCacheTTL(t *testing.T) {
+	ts := newTestServer(t)
+	query := doJSON[Query](t, ts, http.MethodPost, "/api/queries",
+		gin.H{"name": "Uncached", "sql": "SELECT * FROM orders", "resultCacheTtl": 0}, http.StatusCreated)
+
+	first := runTestQuery(t, ts, query, nil)
+	if second := runTestQuery(t, ts, query, nil); second.FromCache || second.ExecutionID == first.ExecutionID {
+		t.Error("a query with a TTL of 0 reused results")
+	}
+
+	ts.resultCacheTTL = 0
+	doJSON[gin.H](t, ts, http.MethodPost, "/api/athena/execute", gin.H{"sql": "SELECT 1"}, http.StatusOK)
+	if started := doJSON[gin.H](t, ts, http.MethodPost, "/api/athena/execute", gin.H{"sql": "SELECT 1"}, http.StatusOK); started["fromCache"] == true {
+		t.Error("results reused with RESULT_CACHE_TTL=0")
+	}
+}
+
+func TestFindCachedRunSkipsUnusableRuns(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	exec := ts.resolveExecutionContext(nil, executionContext{})
+	key := resultCacheKey(resultCacheEngine(exec), "SELECT * FROM orders", nil)
+
+	now := time.Now()
+	for _, run := range []QueryRun{
+		{ExecutionID: "expired", ResultsS3URL: "s3://results/expired.csv", ExecutedAt: now.Add(-time.Hour)},
+		{ExecutionID: "fresh", ResultsS3URL: "s3://results/fresh.csv", ExecutedAt: now.Add(-3 * time.Minute)},
+		{ExecutionID: "no-results", ExecutedAt: now.Add(-2 * time.Minute)},
+		{ExecutionID: "reused-by-athena", ResultsS3URL: "s3://results/old.csv", FromCache: true, ExecutedAt: now.Add(-time.Minute)},
+	} {
+		run.Status = "SUCCEEDED"
+		run.CacheKey = key
+		if err := ts.store.CreateQueryRun(ctx, &run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failed := QueryRun{ExecutionID: "failed", Status: "FAILED", CacheKey: key, ExecutedAt: now}
+	if err := ts.store.CreateQueryRun(ctx, &failed); err != nil {
+		t.Fatal(err)
+	}
+
+	started := doJSON[gin.H](t, ts, http.MethodPost, "/api/athena/execute", gin.H{"sql": "SELECT * FROM orders"}, http.StatusOK)
+	if started["executionId"] != "fresh" || started["fromCache"] != true {
+		t.Errorf("execute answered %v, want the fresh run reused", started)
+	}
+}
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/glue/glueiface"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+const defaultCatalogName = "AwsDataCatalog"
+
+const defaultCatalogRefreshInterval = 10 * time.Minute
+
+// Largest page the Athena List operations return
+const catalogPageSize = 50
+
+// Most databases whose tables are listed at once
+const catalogLoadConcurrency = 4
+
+var errCatalogNotFound = errors.New("Data catalog not found")
+
+// catalogCaches holds the data catalogs registered with Athena and a cache for
+// each catalog that was browsed
+type catalogCaches struct {
+	mu       sync.Mutex
+	catalogs []DataCatalog
+	listedAt time.Time // Zero until the catalogs were listed
+	byName   map[string]*catalogCache
+
+	// Listings in progress, so concurrent requests share them
+	loads singleflight.Group
+}
+
+// catalogCache holds the databases of a catalog and the tables of those that
+// were asked for. Tables are listed the first time a database is needed and
+// kept fresh by the background refresh, so requests don't wait on Athena.
+type catalogCache struct {
+	name   string
+	athena athenaiface.AthenaAPI
+	glue   glueiface.GlueAPI // Lists partitions; nil unless the catalog is AwsDataCatalog
+
+	mu        sync.RWMutex
+	names     []string                    // Databases in listing order
+	databases map[string]*CatalogDatabase // By name; Tables is nil until loaded
+	listedAt  time.Time                   // Zero until the databases were listed
+
+	// Partition summaries of the tables that were asked for, by partitionKey
+	partitions map[string]*TablePartitions
+
+	// Listings in progress, so concurrent requests share them
+	loads singleflight.Group
+}
+
+// Helper function to read CATALOG_REFRESH_INTERVAL and start refreshing the
+// cached catalogs in the background (0 disables the refresh)
+func (s *Server) startCatalogRefresher() error {
+	interval := defaultCatalogRefreshInterval
+	if value := os.Getenv("CATALOG_REFRESH_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("invalid CATALOG_REFRESH_INTERVAL: %s", value)
+		}
+		interval = parsed
+	}
+
+	if interval == 0 {
+		log.Println("Catalog refresh is disabled")
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.refreshCatalogs()
+		}
+	}()
+
+	return nil
+}
+
+// Helper function to refresh every catalog that was browsed
+func (s *Server) refreshCatalogs() {
+	s.catalogs.mu.Lock()
+	caches := make([]*catalogCache, 0, len(s.catalogs.byName))
+	for _, cache := range s.catalogs.byName {
+		caches = append(caches, cache)
+	}
+	s.catalogs.mu.Unlock()
+
+	for _, cache := range caches {
+		cache.refresh()
+	}
+}
+
+// Helper function to list the data catalogs registered with Athena, following NextToken
+func (s *Server) listDataCatalogs() ([]DataCatalog, error) {
+	input := &athena.ListDataCatalogsInput{MaxResults: aws.Int64(catalogPageSize)}
+
+	catalogs := []DataCatalog{}
+	for {
+		output, err := s.athena.ListDataCatalogs(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list data catalogs: %v", err)
+		}
+
+		for _, catalog := range output.DataCatalogsSummary {
+			catalogs = append(catalogs, DataCatalog{
+				Name: aws.StringValue(catalog.CatalogName),
+				Type: aws.StringValue(catalog.Type),
+			})
+		}
+
+		if aws.StringValue(output.NextToken) == "" {
+			return catalogs, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// Helper function to list the data catalogs again, or only when they weren't
+// listed yet unless refresh is set
+func (s *Server) dataCatalogs(refresh bool) ([]DataCatalog, error) {
+	s.catalogs.mu.Lock()
+	listed := !s.catalogs.listedAt.IsZero()
+	catalogs := s.catalogs.catalogs
+	s.catalogs.mu.Unlock()
+
+	if listed && !refresh {
+		return catalogs, nil
+	}
+
+	result, err, _ := s.catalogs.loads.Do("catalogs", func() (interface{}, error) {
+		catalogs, err := s.listDataCatalogs()
+		if err != nil {
+			return nil, err
+		}
+
+		s.catalogs.mu.Lock()
+		s.catalogs.catalogs = catalogs
+		s.catalogs.listedAt = time.Now()
+		s.catalogs.mu.Unlock()
+		return catalogs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]DataCatalog), nil
+}
+
+// Helper function to return the cache of a catalog ("" is AwsDataCatalog).
+// Other catalogs must be registered with Athena; they are listed again once
+// when an unknown name is asked for.
+func (s *Server) catalogCache(name string, refresh bool) (*catalogCache, error) {
+	if name == "" {
+		name = defaultCatalogName
+	}
+
+	s.catalogs.mu.Lock()
+	cache, ok := s.catalogs.byName[name]
+	s.catalogs.mu.Unlock()
+	if ok {
+		return cache, nil
+	}
+
+	if name != defaultCatalogName {
+		catalogs, err := s.dataCatalogs(refresh)
+		if err == nil && !hasDataCatalog(catalogs, name) && !refresh {
+			catalogs, err = s.dataCatalogs(true)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !hasDataCatalog(catalogs, name) {
+			return nil, errCatalogNotFound
+		}
+	}
+
+	s.catalogs.mu.Lock()
+	defer s.catalogs.mu.Unlock()
+
+	if cache, ok := s.catalogs.byName[name]; ok {
+		return cache, nil
+	}
+	if s.catalogs.byName == nil {
+		s.catalogs.byName = map[string]*catalogCache{}
+	}
+	cache = &catalogCache{name: name, athena: s.athena}
+	if name == defaultCatalogName {
+		cache.glue = s.glue
+	}
+	s.catalogs.byName[name] = cache
+	return cache, nil
+}
+
+func hasDataCatalog(catalogs []DataCatalog, name string) bool {
+	for _, catalog := range catalogs {
+		if catalog.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to list the databases again and reload the tables of those
+// that were loaded before. Databases that were never asked for stay unloaded.
+func (cache *catalogCache) refresh() {
+	cache.mu.Lock()
+	listed := !cache.listedAt.IsZero()
+	// Partitions are listed again when their tables are next asked for
+	cache.partitions = nil
+	cache.mu.Unlock()
+	if !listed {
+		return
+	}
+
+	if err := cache.refreshDatabases(); err != nil {
+		log.Printf("Failed to refresh catalog %s: %v", cache.name, err)
+		return
+	}
+
+	var loaded []string
+	cache.mu.RLock()
+	for _, name := range cache.names {
+		if cache.databases[name].LoadedAt != nil {
+			loaded = append(loaded, name)
+		}
+	}
+	cache.mu.RUnlock()
+
+	cache.loadTablesOf(loaded)
+}
+
+// Helper function to list every database of the catalog, following NextToken
+func (cache *catalogCache) listDatabases() ([]CatalogDatabase, error) {
+	input := &athena.ListDatabasesInput{
+		CatalogName: aws.String(cache.name),
+		MaxResults:  aws.Int64(catalogPageSize),
+	}
+
+	var databases []CatalogDatabase
+	for {
+		output, err := cache.athena.ListDatabases(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list databases: %v", err)
+		}
+
+		for _, db := range output.DatabaseList {
+			databases = append(databases, CatalogDatabase{
+				Name:        aws.StringValue(db.Name),
+				Description: aws.StringValue(db.Description),
+			})
+		}
+
+		if aws.StringValue(output.NextToken) == "" {
+			return databases, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// Helper function to list every table of a database, following NextToken. On
+// failure the tables listed before it are returned with the error.
+func (cache *catalogCache) listTables(database string) ([]CatalogTable, error) {
+	input := &athena.ListTableMetadataInput{
+		CatalogName:  aws.String(cache.name),
+		DatabaseName: aws.String(database),
+		MaxResults:   aws.Int64(catalogPageSize),
+	}
+
+	tables := []CatalogTable{}
+	for {
+		output, err := cache.athena.ListTableMetadata(input)
+		if err != nil {
+			return tables, fmt.Errorf("failed to list tables of %s after %d tables: %v", database, len(tables), err)
+		}
+
+		for _, table := range output.TableMetadataList {
+			tables = append(tables, catalogTable(table))
+		}
+
+		if aws.StringValue(output.NextToken) == "" {
+			return tables, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// Helper function to convert Athena table metadata. Table parameters with a
+// field of their own are left out of Properties.
+func catalogTable(table *athena.TableMetadata) CatalogTable {
+	catalogTable := CatalogTable{
+		Name:      aws.StringValue(table.Name),
+		Type:      aws.StringValue(table.TableType),
+		Columns:   []Column{},
+		CreatedAt: table.CreateTime,
+	}
+
+	for key, value := range table.Parameters {
+		value := aws.StringValue(value)
+		switch key {
+		case "location":
+			catalogTable.Location = value
+		case "inputformat":
+			catalogTable.InputFormat = value
+		case "outputformat":
+			catalogTable.OutputFormat = value
+		case "serde.serialization.lib":
+			catalogTable.SerDe = value
+		case "classification":
+			catalogTable.Classification = value
+		case "comment":
+			catalogTable.Comment = value
+		case "transient_lastDdlTime":
+			// Seconds since the epoch
+			if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+				updatedAt := time.Unix(seconds, 0).UTC()
+				catalogTable.UpdatedAt = &updatedAt
+			}
+		default:
+			if catalogTable.Properties == nil {
+				catalogTable.Properties = map[string]string{}
+			}
+			catalogTable.Properties[key] = value
+		}
+	}
+	if catalogTable.UpdatedAt == nil {
+		catalogTable.UpdatedAt = table.CreateTime
+	}
+
+	catalogTable.Columns = catalogColumns(table.Columns)
+	if len(table.PartitionKeys) > 0 {
+		catalogTable.PartitionKeys = catalogColumns(table.PartitionKeys)
+	}
+
+	return catalogTable
+}
+
+func catalogColumns(columns []*athena.Column) []Column {
+	result := make([]Column, 0, len(columns))
+	for _, col := range columns {
+		result = append(result, Column{
+			Name:    aws.StringValue(col.Name),
+			Type:    aws.StringValue(col.Type),
+			Comment: aws.StringValue(col.Comment),
+		})
+	}
+	return result
+}
+
+// Helper function to list the databases again, keeping the tables loaded for
+// those that are still there
+func (cache *catalogCache) refreshDatabases() error {
+	_, err, _ := cache.loads.Do("databases", func() (interface{}, error) {
+		databases, err := cache.listDatabases()
+		if err != nil {
+			return nil, err
+		}
+
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+
+		names := make([]string, 0, len(databases))
+		byName := make(map[string]*CatalogDatabase, len(databases))
+		for i := range databases {
+			db := &databases[i]
+			if cached, ok := cache.databases[db.Name]; ok {
+				cached.Description = db.Description
+				db = cached
+			}
+			names = append(names, db.Name)
+			byName[db.Name] = db
+		}
+
+		cache.names = names
+		cache.databases = byName
+		cache.listedAt = time.Now()
+		return nil, nil
+	})
+	return err
+}
+
+// Helper function to (re)load the tables of a database. A failure is recorded
+// on the database, which keeps the tables of its last complete listing, or the
+// tables listed before the failure when it was never loaded completely.
+func (cache *catalogCache) loadTables(name string) {
+	cache.loads.Do("tables:"+name, func() (interface{}, error) {
+		tables, err := cache.listTables(name)
+		if err != nil {
+			log.Printf("Failed to load database %s of catalog %s: %v", name, cache.name, err)
+		}
+
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+
+		db, ok := cache.databases[name]
+		if !ok {
+			// Dropped by a refresh in the meantime
+			return nil, nil
+		}
+
+		if err != nil {
+			db.Error = err.Error()
+			if db.LoadedAt == nil {
+				db.Tables = tables
+			}
+			return nil, nil
+		}
+
+		now := time.Now()
+		db.Tables = tables
+		db.LoadedAt = &now
+		db.Error = ""
+		return nil, nil
+	})
+}
+
+// Helper function to load the tables of several databases, a few at a time
+func (cache *catalogCache) loadTablesOf(names []string) {
+	sem := make(chan struct{}, catalogLoadConcurrency)
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			cache.loadTables(name)
+		}(name)
+	}
+	wg.Wait()
+}
+
+// Helper function to list the databases unless they are cached; refresh lists
+// them again regardless
+func (cache *catalogCache) ensureDatabases(refresh bool) error {
+	cache.mu.RLock()
+	listed := !cache.listedAt.IsZero()
+	cache.mu.RUnlock()
+
+	if listed && !refresh {
+		return nil
+	}
+	return cache.refreshDatabases()
+}
+
+// Helper function to find the cached name of a database, ignoring case like Athena does
+func (cache *catalogCache) databaseName(name string) (string, bool) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	if _, ok := cache.databases[name]; ok {
+		return name, true
+	}
+	for _, cached := range cache.names {
+		if strings.EqualFold(cached, name) {
+			return cached, true
+		}
+	}
+	return "", false
+}
+
+// Helper function to return a database with its tables, loading them unless
+// they are cached; refresh loads them again regardless
+func (cache *catalogCache) database(name string, refresh bool) (CatalogDatabase, bool) {
+	cache.mu.RLock()
+	db, ok := cache.databases[name]
+	loaded := ok && db.LoadedAt != nil
+	cache.mu.RUnlock()
+	if !ok {
+		return CatalogDatabase{}, false
+	}
+
+	if refresh || !loaded {
+		cache.loadTables(name)
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	db, ok = cache.databases[name]
+	if !ok {
+		return CatalogDatabase{}, false
+	}
+	// Table slices are replaced rather than modified, so they can be shared
+	result := *db
+	if result.Tables == nil {
+		result.Tables = []CatalogTable{}
+	}
+	return result, true
+}
+
+// Helper function to return the whole catalog, loading the tables of every
+// database that isn't cached yet
+func (cache *catalogCache) fetch(refresh bool) (*AthenaCatalog, error) {
+	if err := cache.ensureDatabases(refresh); err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	cache.mu.RLock()
+	for _, name := range cache.names {
+		if refresh || cache.databases[name].LoadedAt == nil {
+			missing = append(missing, name)
+		}
+	}
+	cache.mu.RUnlock()
+
+	cache.loadTablesOf(missing)
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	catalog := &AthenaCatalog{Catalog: cache.name, Databases: []CatalogDatabase{}, ListedAt: cache.listedAt}
+	for _, name := range cache.names {
+		db := *cache.databases[name]
+		if db.Tables == nil {
+			db.Tables = []CatalogTable{}
+		}
+		catalog.Databases = append(catalog.Databases, db)
+	}
+	return catalog, nil
+}
+
+// Helper function to find the catalog of a request, from its catalog parameter.
+// It responds itself and returns false when there is none.
+func (s *Server) requestCatalogCache(c *gin.Context) (*catalogCache, bool) {
+	cache, err := s.catalogCache(c.Query("catalog"), c.Query("refresh") == "true")
+	if err == errCatalogNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return cache, true
+}
+
+func (s *Server) getDataCatalogs(c *gin.Context) {
+	catalogs, err := s.dataCatalogs(c.Query("refresh") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, catalogs)
+}
+
+func (s *Server) getWorkGroups(c *gin.Context) {
+	input := &athena.ListWorkGroupsInput{MaxResults: aws.Int64(catalogPageSize)}
+
+	workGroups := []WorkGroup{}
+	for {
+		output, err := s.athena.ListWorkGroups(input)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list workgroups: " + err.Error()})
+			return
+		}
+
+		for _, summary := range output.WorkGroups {
+			workGroup := WorkGroup{
+				Name:        aws.StringValue(summary.Name),
+				State:       aws.StringValue(summary.State),
+				Description: aws.StringValue(summary.Description),
+				CreatedAt:   summary.CreationTime,
+			}
+			if summary.EngineVersion != nil {
+				workGroup.EngineVersion = aws.StringValue(summary.EngineVersion.EffectiveEngineVersion)
+			}
+			workGroups = append(workGroups, workGroup)
+		}
+
+		if aws.StringValue(output.NextToken) == "" {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+
+	c.JSON(http.StatusOK, WorkGroupList{Default: s.athenaWorkGroup, WorkGroups: workGroups})
+}
+
+func (s *Server) getCatalogDatabases(c *gin.Context) {
+	cache, ok := s.requestCatalogCache(c)
+	if !ok {
+		return
+	}
+
+	if err := cache.ensureDatabases(c.Query("refresh") == "true"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	databases := make([]CatalogDatabaseInfo, 0, len(cache.names))
+	for _, name := range cache.names {
+		db := cache.databases[name]
+		info := CatalogDatabaseInfo{
+			Name:        db.Name,
+			Description: db.Description,
+			LoadedAt:    db.LoadedAt,
+			Error:       db.Error,
+		}
+		if db.Tables != nil {
+			count := len(db.Tables)
+			info.TableCount = &count
+		}
+		databases = append(databases, info)
+	}
+
+	c.JSON(http.StatusOK, databases)
+}
+
+// Helper function to look up the database of a request, listing the databases
+// first when needed. It responds itself and returns false when there is none.
+func (s *Server) requestCatalogDatabase(c *gin.Context) (*catalogCache, CatalogDatabase, bool) {
+	cache, ok := s.requestCatalogCache(c)
+	if !ok {
+		return nil, CatalogDatabase{}, false
+	}
+
+	refresh := c.Query("refresh") == "true"
+	if err := cache.ensureDatabases(refresh); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, CatalogDatabase{}, false
+	}
+
+	name, ok := cache.databaseName(c.Param("db"))
+	if ok {
+		var db CatalogDatabase
+		if db, ok = cache.database(name, refresh); ok {
+			return cache, db, true
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "Database not found"})
+	return nil, CatalogDatabase{}, false
+}
+
+func (s *Server) getCatalogDatabase(c *gin.Context) {
+	_, db, ok := s.requestCatalogDatabase(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, db)
+}
+
+// Helper function to look up the table of a request in its database. It
+// responds itself and returns false when there is none.
+func (s *Server) requestCatalogTable(c *gin.Context) (*catalogCache, CatalogDatabase, CatalogTable, bool) {
+	cache, db, ok := s.requestCatalogDatabase(c)
+	if !ok {
+		return nil, CatalogDatabase{}, CatalogTable{}, false
+	}
+
+	for _, table := range db.Tables {
+		if strings.EqualFold(table.Name, c.Param("table")) {
+			return cache, db, table, true
+		}
+	}
+
+	if db.Error != "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Table not found in partially loaded database: " + db.Error})
+		return nil, CatalogDatabase{}, CatalogTable{}, false
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+	return nil, CatalogDatabase{}, CatalogTable{}, false
+}
+
+func (s *Server) getCatalogTable(c *gin.Context) {
+	cache, db, table, ok := s.requestCatalogTable(c)
+	if !ok {
+		return
+	}
+
+	// Only tables asked for one at a time carry their partitions
+	table.Partitions = cache.tablePartitions(db.Name, table, c.Query("refresh") == "true")
+	c.JSON(http.StatusOK, table)
+}
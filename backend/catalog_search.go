@@ -0,0 +1,659 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxAutocompleteSuggestions = 50
+
+// How much a match of each kind of catalog object counts, so tables come
+// before the columns of the same name
+var catalogSearchWeights = map[string]float64{"table": 1, "database": 0.95, "column": 0.9}
+
+// Names that can be inserted in SQL without quotes
+var bareIdentifierPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Keywords that may follow a table reference and are therefore not aliases
+var sqlClauseKeywords = map[string]bool{
+	"where": true, "join": true, "inner": true, "left": true, "right": true, "full": true,
+	"cross": true, "natural": true, "on": true, "using": true, "group": true, "order": true,
+	"having": true, "limit": true, "offset": true, "union": true, "except": true,
+	"intersect": true, "window": true, "tablesample": true, "select": true, "from": true,
+	"as": true, "with": true, "fetch": true, "outer": true, "lateral": true, "unnest": true,
+}
+
+// Helper function to score how well a name matches a lowercase search term:
+// exact matches first, then prefixes, matches at a word boundary, substrings,
+// near misses and finally the letters of the term in order. 0 is no match.
+func catalogMatchScore(term, name string) float64 {
+	name = strings.ToLower(name)
+	if term == "" || name == "" {
+		return 0
+	}
+	ratio := float64(len(term)) / float64(len(name))
+
+	if name == term {
+		return 100
+	}
+	if strings.HasPrefix(name, term) {
+		return 80 + 10*ratio
+	}
+	if i := strings.Index(name, term); i >= 0 {
+		if strings.ContainsRune("_.-", rune(name[i-1])) {
+			return 60 + 10*ratio
+		}
+		return 40 + 10*ratio
+	}
+
+	if len(term) >= 4 {
+		typos := 1
+		if len(term) >= 8 {
+			typos = 2
+		}
+		if d := editDistance(term, name, typos); d <= typos {
+			return 35 - 5*float64(d)
+		}
+	}
+
+	if len(term) >= 3 {
+		if gaps, ok := subsequenceGaps(term, name); ok {
+			score := 10 + 20*ratio - 2*float64(gaps)
+			if score < 1 {
+				score = 1
+			}
+			return score
+		}
+	}
+	return 0
+}
+
+// Helper function to compute the Levenshtein distance of two strings, giving
+// up with limit+1 once it is larger than limit
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > limit {
+		return limit + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			best = min(best, cur[j])
+		}
+		if best > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Helper function to check that the letters of term appear in name in order,
+// counting the gaps between them
+func subsequenceGaps(term, name string) (int, bool) {
+	gaps, last := 0, -1
+	i := 0
+	for j, r := range name {
+		if i == len(term) {
+			break
+		}
+		if r == rune(term[i]) {
+			if last >= 0 && j != last+1 {
+				gaps++
+			}
+			last = j
+			i++
+		}
+	}
+	return gaps, i == len(term)
+}
+
+// Helper function to score a catalog object on its name and on its qualified
+// names, such as sales.orders, which count slightly less than the name itself
+func catalogObjectScore(term string, names ...string) float64 {
+	if !strings.Contains(term, ".") {
+		names = names[:1]
+	}
+
+	best := 0.0
+	for i, name := range names {
+		score := catalogMatchScore(term, name) * (1 - 0.05*float64(i))
+		if score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// Helper function to rank catalog search hits by score, then shorter names first
+func sortCatalogHits(hits []CatalogSearchHit) {
+	name := func(hit CatalogSearchHit) string {
+		return hit.Database + "." + hit.Table + "." + hit.Column
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		a, b := name(hits[i]), name(hits[j])
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+}
+
+// Helper function to search the databases, tables and columns of a catalog
+func searchCatalog(catalog *AthenaCatalog, q string, types map[string]bool) []CatalogSearchHit {
+	term := strings.ToLower(q)
+	hits := []CatalogSearchHit{}
+	add := func(hit CatalogSearchHit, score float64) {
+		if score > 0 && types[hit.Type] {
+			hit.Score = score * catalogSearchWeights[hit.Type]
+			hits = append(hits, hit)
+		}
+	}
+
+	for _, db := range catalog.Databases {
+		add(CatalogSearchHit{Type: "database", Database: db.Name, Description: db.Description},
+			catalogObjectScore(term, db.Name))
+
+		for _, table := range db.Tables {
+			add(CatalogSearchHit{Type: "table", Database: db.Name, Table: table.Name, Description: table.Comment},
+				catalogObjectScore(term, table.Name, db.Name+"."+table.Name))
+
+			if !types["column"] {
+				continue
+			}
+			for _, col := range tableColumns(table) {
+				add(CatalogSearchHit{
+					Type:        "column",
+					Database:    db.Name,
+					Table:       table.Name,
+					Column:      col.Name,
+					DataType:    col.Type,
+					Description: col.Comment,
+				}, catalogObjectScore(term, col.Name, table.Name+"."+col.Name, db.Name+"."+table.Name+"."+col.Name))
+			}
+		}
+	}
+
+	sortCatalogHits(hits)
+	return hits
+}
+
+// Helper function to list the columns of a table followed by its partition keys,
+// which are queried like any other column
+func tableColumns(table CatalogTable) []Column {
+	return append(append([]Column{}, table.Columns...), table.PartitionKeys...)
+}
+
+func (s *Server) searchCatalog(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSearchLimit)))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	types := map[string]bool{"database": true, "table": true, "column": true}
+	if value := c.Query("type"); value != "" {
+		types = map[string]bool{}
+		for _, t := range strings.Split(value, ",") {
+			t = strings.TrimSpace(t)
+			if _, ok := catalogSearchWeights[t]; !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "type must be database, table or column"})
+				return
+			}
+			types[t] = true
+		}
+	}
+
+	cache, ok := s.requestCatalogCache(c)
+	if !ok {
+		return
+	}
+	catalog, err := cache.fetch(c.Query("refresh") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	hits := searchCatalog(catalog, q, types)
+	total := len(hits)
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	c.JSON(http.StatusOK, CatalogSearchResponse{Query: q, Catalog: catalog.Catalog, Hits: hits, Total: total})
+}
+
+// Autocomplete
+
+// Helper function to remove the quotes of an identifier
+func unquoteIdentifier(name string) string {
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, `"`) {
+		name = strings.TrimPrefix(name, `"`)
+		name = strings.TrimSuffix(name, `"`)
+		return strings.ReplaceAll(name, `""`, `"`)
+	}
+	return name
+}
+
+// Helper function to split a qualified name such as sales."order lines" into
+// its unquoted parts
+func splitQualifiedName(name string) []string {
+	var parts []string
+	var part strings.Builder
+	quoted := false
+	for _, r := range name {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			part.WriteRune(r)
+		case r == '.' && !quoted:
+			parts = append(parts, unquoteIdentifier(part.String()))
+			part.Reset()
+		default:
+			part.WriteRune(r)
+		}
+	}
+	return append(parts, unquoteIdentifier(part.String()))
+}
+
+// Helper function to format a name for insertion in SQL
+func sqlIdentifier(name string) string {
+	if bareIdentifierPattern.MatchString(name) {
+		return name
+	}
+	return quoteIdentifier(name)
+}
+
+// catalogIndex looks up the databases and tables of a catalog ignoring case
+type catalogIndex struct {
+	catalog   *AthenaCatalog
+	databases map[string]*CatalogDatabase
+}
+
+func newCatalogIndex(catalog *AthenaCatalog) catalogIndex {
+	index := catalogIndex{catalog: catalog, databases: map[string]*CatalogDatabase{}}
+	for i := range catalog.Databases {
+		index.databases[strings.ToLower(catalog.Databases[i].Name)] = &catalog.Databases[i]
+	}
+	return index
+}
+
+func (index catalogIndex) table(database, name string) (*CatalogDatabase, *CatalogTable) {
+	db, ok := index.databases[strings.ToLower(database)]
+	if !ok {
+		return nil, nil
+	}
+	for i := range db.Tables {
+		if strings.EqualFold(db.Tables[i].Name, name) {
+			return db, &db.Tables[i]
+		}
+	}
+	return nil, nil
+}
+
+// Helper function to resolve a table reference of a statement: qualified names
+// name their database (and catalog), others are looked up in the default
+// database and then in every database
+func (index catalogIndex) resolve(parts []string, defaultDatabase string) (*CatalogDatabase, *CatalogTable) {
+	switch len(parts) {
+	case 1:
+		if defaultDatabase != "" {
+			if db, table := index.table(defaultDatabase, parts[0]); table != nil {
+				return db, table
+			}
+		}
+		for _, db := range index.catalog.Databases {
+			if db, table := index.table(db.Name, parts[0]); table != nil {
+				return db, table
+			}
+		}
+		return nil, nil
+	case 2:
+		return index.table(parts[0], parts[1])
+	case 3:
+		if !strings.EqualFold(parts[0], index.catalog.Catalog) {
+			return nil, nil
+		}
+		return index.table(parts[1], parts[2])
+	}
+	return nil, nil
+}
+
+// tableReference is a table of a statement with the names it goes by: its
+// alias, and those of the subqueries and CTEs reading from it
+type tableReference struct {
+	aliases  []string
+	database *CatalogDatabase
+	table    *CatalogTable
+}
+
+func (ref tableReference) goesBy(name string) bool {
+	if strings.EqualFold(name, ref.table.Name) {
+		return true
+	}
+	for _, alias := range ref.aliases {
+		if strings.EqualFold(name, alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to find the catalog tables a statement reads from, in order.
+// Names in strings and comments, CTEs and subqueries aren't tables.
+func (index catalogIndex) references(sql, defaultDatabase string) []tableReference {
+	p := parseSQL(sql)
+	var items []sqlFromItem
+	for _, block := range p.blocks {
+		items = append(items, block.items...)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].start < items[j].start
+	})
+
+	// The items inside a subquery or CTE query are also known by its alias
+	aliases := make([][]string, len(items))
+	for i, item := range items {
+		if item.alias != "" {
+			aliases[i] = append(aliases[i], item.alias)
+		}
+	}
+	for _, outer := range items {
+		if outer.body == 0 || outer.alias == "" {
+			continue
+		}
+		close := p.match[outer.body]
+		if close < 0 {
+			close = len(p.tokens)
+		}
+		first := sort.Search(len(items), func(i int) bool { return items[i].start > outer.body })
+		for i := first; i < len(items) && items[i].start < close; i++ {
+			aliases[i] = append(aliases[i], outer.alias)
+		}
+	}
+
+	var refs []tableReference
+	for i, item := range items {
+		if item.name == nil {
+			continue
+		}
+		db, table := index.resolve(item.name, defaultDatabase)
+		if table == nil {
+			continue
+		}
+		refs = append(refs, tableReference{database: db, table: table, aliases: aliases[i]})
+	}
+	return refs
+}
+
+// Helper function to tell what is being typed from the tokens before the name
+// at the cursor: a table after FROM or JOIN and in FROM lists, nothing where
+// an alias or a literal goes or in a comment, and a column anywhere else
+func autocompleteContext(before string) string {
+	var tokens []sqlToken
+	for _, token := range tokenizeSQL(before) {
+		if token.Offset+len(token.Text) == len(before) && token.unterminated() {
+			// Inside a string literal or a comment
+			return "none"
+		}
+		if token.Kind != sqlComment {
+			tokens = append(tokens, token)
+		}
+	}
+	if len(tokens) == 0 {
+		return "none"
+	}
+
+	last := tokens[len(tokens)-1]
+	switch strings.ToLower(last.Text) {
+	case "from", "join":
+		return "table"
+	case "as":
+		return "none"
+	case ",":
+		// A FROM list continues until the next clause
+		for i := len(tokens) - 2; i >= 0; i-- {
+			word := strings.ToLower(tokens[i].Text)
+			if word == "from" {
+				return "table"
+			}
+			if sqlClauseKeywords[word] || word == "by" {
+				break
+			}
+		}
+		return "column"
+	}
+
+	if len(tokens) >= 2 && !sqlClauseKeywords[strings.ToLower(last.Text)] && isIdentifierToken(last) {
+		// Naming the alias of a table just referenced
+		for i := len(tokens) - 2; i >= 0 && i >= len(tokens)-6; i-- {
+			if word := strings.ToLower(tokens[i].Text); word == "from" || word == "join" {
+				return "none"
+			}
+			if tokens[i].Text != "." && !isIdentifierToken(tokens[i]) {
+				break
+			}
+		}
+	}
+	return "column"
+}
+
+func isIdentifierToken(token sqlToken) bool {
+	return token.Kind == sqlWord || token.Kind == sqlQuoted
+}
+
+// Helper function to find the possibly qualified name at the end of the text
+// before the cursor. It returns where the name starts and where its last part
+// starts, in bytes; both are the end of the text when no name is being typed.
+func nameAtCursor(before string) (int, int) {
+	start, last := len(before), len(before)
+	tokens := tokenizeSQL(before)
+	for i := len(tokens) - 1; i >= 0 && tokens[i].Offset+len(tokens[i].Text) == start; i-- {
+		token := tokens[i]
+		dot := token.Kind == sqlSymbol && token.Text == "."
+		if !dot && !isIdentifierToken(token) {
+			break
+		}
+		if !dot && last == len(before) && start == len(before) {
+			last = token.Offset
+		}
+		start = token.Offset
+	}
+	return start, last
+}
+
+// Helper function to keep the best suggestions whose score matches the prefix;
+// every suggestion matches an empty prefix and keeps its order
+func rankSuggestions(suggestions []AutocompleteSuggestion, prefix string, names []string) []AutocompleteSuggestion {
+	term := strings.ToLower(prefix)
+	ranked := []AutocompleteSuggestion{}
+	for i, suggestion := range suggestions {
+		if term == "" {
+			ranked = append(ranked, suggestion)
+			continue
+		}
+		if score := catalogMatchScore(term, names[i]); score > 0 {
+			suggestion.Score = score * catalogSearchWeights[suggestion.Type]
+			ranked = append(ranked, suggestion)
+		}
+	}
+
+	if term != "" {
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return ranked[i].Score > ranked[j].Score
+		})
+	}
+	if len(ranked) > maxAutocompleteSuggestions {
+		ranked = ranked[:maxAutocompleteSuggestions]
+	}
+	return ranked
+}
+
+// Helper function to suggest tables, and databases to qualify them with
+func tableSuggestions(index catalogIndex, qualifier []string, prefix, defaultDatabase string) []AutocompleteSuggestion {
+	var suggestions []AutocompleteSuggestion
+	var names []string
+	addTables := func(db CatalogDatabase, qualify bool) {
+		for _, table := range db.Tables {
+			insert := sqlIdentifier(table.Name)
+			label := table.Name
+			if qualify {
+				insert = sqlIdentifier(db.Name) + "." + insert
+				label = db.Name + "." + table.Name
+			}
+			suggestions = append(suggestions, AutocompleteSuggestion{
+				Label: label, Type: "table", Detail: db.Name, InsertText: insert,
+			})
+			names = append(names, table.Name)
+		}
+	}
+
+	if len(qualifier) > 0 {
+		// Tables of the database typed before the dot
+		if db, ok := index.databases[strings.ToLower(qualifier[len(qualifier)-1])]; ok {
+			addTables(*db, false)
+		}
+		return rankSuggestions(suggestions, prefix, names)
+	}
+
+	if db, ok := index.databases[strings.ToLower(defaultDatabase)]; ok {
+		addTables(*db, false)
+	}
+	for _, db := range index.catalog.Databases {
+		suggestions = append(suggestions, AutocompleteSuggestion{
+			Label: db.Name, Type: "database", Detail: db.Description, InsertText: sqlIdentifier(db.Name) + ".",
+		})
+		names = append(names, db.Name)
+	}
+	for _, db := range index.catalog.Databases {
+		if !strings.EqualFold(db.Name, defaultDatabase) {
+			addTables(db, true)
+		}
+	}
+	return rankSuggestions(suggestions, prefix, names)
+}
+
+// Helper function to suggest the columns of the tables a statement reads from,
+// or of the table or alias typed before the dot
+func columnSuggestions(refs []tableReference, qualifier []string, prefix string) []AutocompleteSuggestion {
+	var suggestions []AutocompleteSuggestion
+	var names []string
+	for _, ref := range refs {
+		if len(qualifier) > 0 && !ref.goesBy(qualifier[len(qualifier)-1]) {
+			continue
+		}
+		for _, col := range tableColumns(*ref.table) {
+			suggestions = append(suggestions, AutocompleteSuggestion{
+				Label:      col.Name,
+				Type:       "column",
+				Detail:     ref.table.Name + " · " + col.Type,
+				InsertText: sqlIdentifier(col.Name),
+			})
+			names = append(names, col.Name)
+		}
+	}
+	return rankSuggestions(suggestions, prefix, names)
+}
+
+func (s *Server) autocompleteSQL(c *gin.Context) {
+	var req AutocompleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkSQLSize(c, req.SQL) {
+		return
+	}
+
+	runes := []rune(req.SQL)
+	cursor := len(runes)
+	if req.Cursor != nil {
+		cursor = *req.Cursor
+	}
+	if cursor < 0 || cursor > len(runes) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cursor must be within the SQL"})
+		return
+	}
+
+	cache, err := s.catalogCache(req.Catalog, false)
+	if err == errCatalogNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The name being typed, and the database, table or alias qualifying it
+	before := string(runes[:cursor])
+	start, last := nameAtCursor(before)
+	parts := splitQualifiedName(before[start:])
+	qualifier, prefix := parts[:len(parts)-1], parts[len(parts)-1]
+
+	response := AutocompleteResponse{
+		Context:     autocompleteContext(before[:start]),
+		Prefix:      prefix,
+		ReplaceFrom: utf8.RuneCountInString(before[:last]),
+		Suggestions: []AutocompleteSuggestion{},
+	}
+	if response.Context == "none" {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	catalog, err := cache.fetch(false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	index := newCatalogIndex(catalog)
+
+	if response.Context == "table" {
+		response.Suggestions = tableSuggestions(index, qualifier, prefix, req.Database)
+	} else {
+		// The name being typed is left out, as it may not parse until complete
+		refs := index.references(before[:start]+string(runes[cursor:]), req.Database)
+		response.Suggestions = columnSuggestions(refs, qualifier, prefix)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
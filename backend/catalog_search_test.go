@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/gin-gonic/gin"
+)
+
+// Helper function to set up a server with a small catalog to search
+func newSearchTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ts := newTestServer(t)
+	orders := testTable("orders", "id:bigint", "customer_id:bigint", "order_date:date")
+	orders.PartitionKeys = []*athena.Column{{Name: aws.String("dt"), Type: aws.String("string")}}
+	ts.athena.AddTable("sales", orders)
+	ts.athena.AddTable("sales", testTable("order lines", "line_id:bigint", "quantity:int"))
+	ts.athena.AddTable("marketing", testTable("campaigns", "id:bigint", "name:varchar", "customer_segment:varchar"))
+	return ts
+}
+
+// Helper function to name search hits as database.table.column
+func hitNames(hits []CatalogSearchHit) []string {
+	names := make([]string, len(hits))
+	for i, hit := range hits {
+		names[i] = hit.Database
+		if hit.Table != "" {
+			names[i] += "." + hit.Table
+		}
+		if hit.Column != "" {
+			names[i] += "." + hit.Column
+		}
+	}
+	return names
+}
+
+func TestSearchCatalog(t *testing.T) {
+	ts := newSearchTestServer(t)
+
+	tests := []struct {
+		name   string
+		params string
+		status int
+		first  []string // Names of the first hits
+		total  int
+	}{
+		{name: "exact table", params: "q=orders&type=table", status: http.StatusOK, first: []string{"sales.orders", "sales.order lines"}, total: 2},
+		{name: "tables before databases and columns", params: "q=sales", status: http.StatusOK, first: []string{"sales"}},
+		{name: "qualified name", params: "q=sales.ord&type=table", status: http.StatusOK, first: []string{"sales.orders", "sales.order lines"}, total: 2},
+		{name: "columns by prefix", params: "q=customer&type=column", status: http.StatusOK, first: []string{"sales.orders.customer_id", "marketing.campaigns.customer_segment"}, total: 2},
+		{name: "partition keys", params: "q=dt&type=column", status: http.StatusOK, first: []string{"sales.orders.dt"}, total: 1},
+		{name: "typo", params: "q=ordrs&type=table", status: http.StatusOK, first: []string{"sales.orders"}},
+		{name: "limit", params: "q=id&type=column&limit=1", status: http.StatusOK, first: []string{"sales.orders.id"}, total: 4},
+		{name: "nothing", params: "q=zzzz", status: http.StatusOK, total: 0},
+		{name: "missing q", params: "q=+", status: http.StatusBadRequest},
+		{name: "invalid limit", params: "q=id&limit=0", status: http.StatusBadRequest},
+		{name: "unknown type", params: "q=id&type=view", status: http.StatusBadRequest},
+		{name: "unknown catalog", params: "q=id&catalog=nope", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, "/api/catalog/search?"+tt.params, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var response CatalogSearchResponse
+			decodeBody(t, rec, &response)
+			names := hitNames(response.Hits)
+			if len(names) < len(tt.first) || (len(tt.first) > 0 && !reflect.DeepEqual(names[:len(tt.first)], tt.first)) {
+				t.Errorf("hits %v, want %v first", names, tt.first)
+			}
+			if tt.total > 0 && response.Total != tt.total {
+				t.Errorf("%d hits in total, want %d", response.Total, tt.total)
+			}
+			if tt.total == 0 && len(tt.first) == 0 && len(names) != 0 {
+				t.Errorf("hits %v, want none", names)
+			}
+			if response.Catalog != defaultCatalogName {
+				t.Errorf("catalog %q", response.Catalog)
+			}
+		})
+	}
+
+	// Exact matches score highest and kinds of objects are weighted
+	response := doJSON[CatalogSearchResponse](t, ts, http.MethodGet, "/api/catalog/search?q="+url.QueryEscape("campaigns"), nil, http.StatusOK)
+	if response.Hits[0].Score != 100*catalogSearchWeights["table"] {
+		t.Errorf("exact match scored %v", response.Hits[0].Score)
+	}
+}
+
+func TestAutocompleteSQL(t *testing.T) {
+	ts := newSearchTestServer(t)
+	cursor := func(n int) *int { return &n }
+
+	tests := []struct {
+		name        string
+		body        AutocompleteRequest
+		context     string
+		prefix      string
+		replaceFrom int
+		labels      []string // Of the first suggestions
+		insert      string   // Of the first suggestion
+	}{
+		{
+			name:    "tables of the default database",
+			body:    AutocompleteRequest{SQL: "SELECT * FROM ord", Database: "sales"},
+			context: "table", prefix: "ord", replaceFrom: 14,
+			labels: []string{"orders", "order lines"}, insert: "orders",
+		},
+		{
+			name:    "qualified tables elsewhere",
+			body:    AutocompleteRequest{SQL: "SELECT * FROM camp"},
+			context: "table", prefix: "camp", replaceFrom: 14,
+			labels: []string{"marketing.campaigns"}, insert: "marketing.campaigns",
+		},
+		{
+			name:    "tables of a database",
+			body:    AutocompleteRequest{SQL: "SELECT * FROM sales.order"},
+			context: "table", prefix: "order", replaceFrom: 20,
+			labels: []string{"orders", "order lines"}, insert: "orders",
+		},
+		{
+			name:    "quoted table",
+			body:    AutocompleteRequest{SQL: `SELECT * FROM sales."order l`},
+			context: "table", prefix: "order l", replaceFrom: 20,
+			labels: []string{"order lines"}, insert: `"order lines"`,
+		},
+		{
+			name:    "tables in a FROM list",
+			body:    AutocompleteRequest{SQL: "SELECT * FROM sales.orders, marketing.", Database: "sales"},
+			context: "table", replaceFrom: 38,
+			labels: []string{"campaigns"}, insert: "campaigns",
+		},
+		{
+			name:    "columns of an alias",
+			body:    AutocompleteRequest{SQL: "SELECT o. FROM sales.orders o JOIN marketing.campaigns c ON true", Cursor: cursor(9)},
+			context: "column", replaceFrom: 9,
+			labels: []string{"id", "customer_id", "order_date", "dt"}, insert: "id",
+		},
+		{
+			name:    "columns of every table",
+			body:    AutocompleteRequest{SQL: "SELECT cust FROM sales.orders JOIN marketing.campaigns c ON true", Cursor: cursor(11)},
+			context: "column", prefix: "cust", replaceFrom: 7,
+			labels: []string{"customer_id", "customer_segment"}, insert: "customer_id",
+		},
+		{
+			name:    "cursor in characters",
+			body:    AutocompleteRequest{SQL: "SELECT 'é', cu FROM sales.orders", Cursor: cursor(14)},
+			context: "column", prefix: "cu", replaceFrom: 12,
+			labels: []string{"customer_id"}, insert: "customer_id",
+		},
+		{
+			name:    "columns of a subquery",
+			body:    AutocompleteRequest{SQL: "SELECT o. FROM (SELECT * FROM sales.orders) o", Cursor: cursor(9)},
+			context: "column", replaceFrom: 9,
+			labels: []string{"id", "customer_id", "order_date", "dt"}, insert: "id",
+		},
+		{
+			name:    "columns of a CTE",
+			body:    AutocompleteRequest{SQL: "WITH recent AS (SELECT * FROM sales.orders) SELECT recent. FROM recent", Cursor: cursor(58)},
+			context: "column", replaceFrom: 58,
+			labels: []string{"id", "customer_id", "order_date", "dt"}, insert: "id",
+		},
+		{
+			name:    "CTE named like a table",
+			body:    AutocompleteRequest{SQL: "WITH orders AS (SELECT * FROM marketing.campaigns) SELECT orders.cust FROM orders", Database: "sales", Cursor: cursor(69)},
+			context: "column", prefix: "cust", replaceFrom: 65,
+			labels: []string{"customer_segment"}, insert: "customer_segment",
+		},
+		{
+			name:    "table in a comment",
+			body:    AutocompleteRequest{SQL: "SELECT c. FROM sales.orders o -- JOIN marketing.campaigns c", Cursor: cursor(9)},
+			context: "column", replaceFrom: 9,
+		},
+		{
+			name:    "table in a string literal",
+			body:    AutocompleteRequest{SQL: "SELECT c. FROM sales.orders WHERE note = 'JOIN marketing.campaigns c'", Cursor: cursor(9)},
+			context: "column", replaceFrom: 9,
+		},
+		{name: "comment", body: AutocompleteRequest{SQL: "SELECT * FROM -- ord"}, context: "none", replaceFrom: 20},
+		{name: "alias", body: AutocompleteRequest{SQL: "SELECT * FROM sales.orders o"}, context: "none", prefix: "o", replaceFrom: 27},
+		{name: "after AS", body: AutocompleteRequest{SQL: "SELECT id AS "}, context: "none", replaceFrom: 13},
+		{name: "string literal", body: AutocompleteRequest{SQL: "SELECT * FROM sales.orders WHERE dt = '20"}, context: "none", replaceFrom: 41},
+		{name: "quote in a string literal", body: AutocompleteRequest{SQL: "SELECT * FROM sales.orders WHERE note = 'it''s"}, context: "none", replaceFrom: 46},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := doJSON[AutocompleteResponse](t, ts, http.MethodPost, "/api/catalog/autocomplete", tt.body, http.StatusOK)
+			if response.Context != tt.context || response.Prefix != tt.prefix || response.ReplaceFrom != tt.replaceFrom {
+				t.Errorf("context %s, prefix %q from %d; want %s, %q from %d",
+					response.Context, response.Prefix, response.ReplaceFrom, tt.context, tt.prefix, tt.replaceFrom)
+			}
+			if len(tt.labels) == 0 {
+				if len(response.Suggestions) != 0 {
+					t.Errorf("suggestions %+v, want none", response.Suggestions)
+				}
+				return
+			}
+
+			var labels []string
+			for _, suggestion := range response.Suggestions {
+				labels = append(labels, suggestion.Label)
+			}
+			if len(labels) < len(tt.labels) || !reflect.DeepEqual(labels[:len(tt.labels)], tt.labels) {
+				t.Fatalf("suggestions %v, want %v first", labels, tt.labels)
+			}
+			if response.Suggestions[0].InsertText != tt.insert {
+				t.Errorf("inserts %q, want %q", response.Suggestions[0].InsertText, tt.insert)
+			}
+		})
+	}
+
+	doJSON[gin.H](t, ts, http.MethodPost, "/api/catalog/autocomplete", gin.H{"sql": "SELECT", "cursor": 7}, http.StatusBadRequest)
+	doJSON[gin.H](t, ts, http.MethodPost, "/api/catalog/autocomplete", gin.H{"sql": "SELECT", "cursor": -1}, http.StatusBadRequest)
+	doJSON[gin.H](t, ts, http.MethodPost, "/api/catalog/autocomplete", gin.H{"sql": "SELECT", "catalog": "nope"}, http.StatusNotFound)
+	doJSON[gin.H](t, ts, http.MethodPost, "/api/catalog/autocomplete", gin.H{"sql": strings.Repeat("(", maxSQLNesting+1)}, http.StatusBadRequest)
+	doJSON[gin.H](t, ts, http.MethodPost, "/api/catalog/autocomplete", "{", http.StatusBadRequest)
+}
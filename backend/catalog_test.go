@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/glue"
+	"github.com/gin-gonic/gin"
+)
+
+// Helper function to describe a table for the fake catalog, with columns given
+// as name:type
+func testTable(name string, columns ...string) *athena.TableMetadata {
+	table := &athena.TableMetadata{Name: aws.String(name), TableType: aws.String("EXTERNAL_TABLE")}
+	for _, column := range columns {
+		columnName, columnType, _ := strings.Cut(column, ":")
+		table.Columns = append(table.Columns, &athena.Column{Name: aws.String(columnName), Type: aws.String(columnType)})
+	}
+	return table
+}
+
+// Helper function to list the table names of a database
+func tableNames(db CatalogDatabase) []string {
+	names := make([]string, len(db.Tables))
+	for i, table := range db.Tables {
+		names[i] = table.Name
+	}
+	return names
+}
+
+func TestCatalogPagination(t *testing.T) {
+	ts := newTestServer(t)
+	for i := 0; i < catalogPageSize+5; i++ {
+		ts.athena.AddTable(fmt.Sprintf("db%02d", i), testTable("t", "id:bigint"))
+	}
+	for i := 0; i < catalogPageSize*2+1; i++ {
+		ts.athena.AddTable("wide", testTable(fmt.Sprintf("t%03d", i), "id:bigint"))
+	}
+
+	catalog := doJSON[AthenaCatalog](t, ts, http.MethodGet, "/api/athena/catalog", nil, http.StatusOK)
+	if catalog.Catalog != defaultCatalogName || len(catalog.Databases) != catalogPageSize+6 || catalog.ListedAt.IsZero() {
+		t.Fatalf("catalog %s with %d databases listed at %v", catalog.Catalog, len(catalog.Databases), catalog.ListedAt)
+	}
+	wide := catalog.Databases[len(catalog.Databases)-1]
+	if wide.Name != "wide" || len(wide.Tables) != catalogPageSize*2+1 || wide.LoadedAt == nil || wide.Error != "" {
+		t.Errorf("database %s with %d tables, loaded at %v: %s", wide.Name, len(wide.Tables), wide.LoadedAt, wide.Error)
+	}
+}
+
+func TestCatalogLazyLoading(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.AddTable("sales", testTable("orders", "id:bigint", "amount:decimal(10,2)"))
+	ts.athena.AddTable("sales", testTable("customers", "id:bigint"))
+	ts.athena.AddTable("logs", testTable("events", "ts:timestamp"))
+
+	databases := doJSON[[]CatalogDatabaseInfo](t, ts, http.MethodGet, "/api/catalog", nil, http.StatusOK)
+	if len(databases) != 2 || databases[0].Name != "logs" || databases[0].TableCount != nil || databases[0].LoadedAt != nil {
+		t.Fatalf("databases %+v, want both unloaded", databases)
+	}
+
+	sales := doJSON[CatalogDatabase](t, ts, http.MethodGet, "/api/catalog/SALES", nil, http.StatusOK)
+	if sales.Name != "sales" || strings.Join(tableNames(sales), ",") != "orders,customers" || sales.LoadedAt == nil {
+		t.Errorf("database %+v", sales)
+	}
+
+	databases = doJSON[[]CatalogDatabaseInfo](t, ts, http.MethodGet, "/api/catalog", nil, http.StatusOK)
+	if databases[0].TableCount != nil || databases[1].TableCount == nil || *databases[1].TableCount != 2 {
+		t.Errorf("databases %+v, want sales loaded with 2 tables", databases)
+	}
+
+	orders := doJSON[CatalogTable](t, ts, http.MethodGet, "/api/catalog/sales/Orders", nil, http.StatusOK)
+	if orders.Name != "orders" || len(orders.Columns) != 2 || orders.Columns[1].Type != "decimal(10,2)" {
+		t.Errorf("table %+v", orders)
+	}
+
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/catalog/sales/returns", nil, http.StatusNotFound)
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/catalog/finance", nil, http.StatusNotFound)
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/catalog?catalog=missing", nil, http.StatusNotFound)
+}
+
+func TestCatalogRefresh(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.AddTable("sales", testTable("orders", "id:bigint"))
+	ts.athena.AddTable("logs", testTable("events", "ts:timestamp"))
+	doJSON[CatalogDatabase](t, ts, http.MethodGet, "/api/catalog/sales", nil, http.StatusOK)
+
+	// Cached listings don't see new tables until refreshed
+	ts.athena.AddTable("sales", testTable("returns", "id:bigint"))
+	ts.athena.AddTable("finance", testTable("invoices", "id:bigint"))
+	sales := doJSON[CatalogDatabase](t, ts, http.MethodGet, "/api/catalog/sales", nil, http.StatusOK)
+	if len(sales.Tables) != 1 {
+		t.Errorf("tables %v, want the cached listing", tableNames(sales))
+	}
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/catalog/finance", nil, http.StatusNotFound)
+
+	sales = doJSON[CatalogDatabase](t, ts, http.MethodGet, "/api/catalog/sales?refresh=true", nil, http.StatusOK)
+	if len(sales.Tables) != 2 {
+		t.Errorf("tables %v after a refresh, want 2", tableNames(sales))
+	}
+
+	// The background refresh lists databases again and reloads those that were loaded
+	ts.athena.AddTable("sales", testTable("refunds", "id:bigint"))
+	ts.refreshCatalogs()
+	databases := doJSON[[]CatalogDatabaseInfo](t, ts, http.MethodGet, "/api/catalog", nil, http.StatusOK)
+	counts := map[string]string{}
+	for _, db := range databases {
+		counts[db.Name] = "unloaded"
+		if db.TableCount != nil {
+			counts[db.Name] = fmt.Sprint(*db.TableCount)
+		}
+	}
+	if want := map[string]string{"finance": "unloaded", "logs": "unloaded", "sales": "3"}; fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("tables by database %v, want %v", counts, want)
+	}
+}
+
+func TestCatalogFailures(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.AddTable("sales", testTable("orders", "id:bigint"))
+	throttled := awserr.New(athena.ErrCodeTooManyRequestsException, "Rate exceeded", nil)
+
+	ts.athena.FailNext("ListDatabases", throttled)
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/catalog", nil, http.StatusInternalServerError)
+
+	// A database that never loaded reports why, and its tables can't be told missing
+	ts.athena.FailNext("ListTableMetadata", throttled)
+	sales := doJSON[CatalogDatabase](t, ts, http.MethodGet, "/api/catalog/sales", nil, http.StatusOK)
+	if sales.LoadedAt != nil || len(sales.Tables) != 0 || !strings.Contains(sales.Error, "Rate exceeded") {
+		t.Errorf("database %+v, want the failure", sales)
+	}
+	ts.athena.FailNext("ListTableMetadata", throttled)
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/catalog/sales/orders", nil, http.StatusInternalServerError)
+
+	// Later requests try again
+	sales = doJSON[CatalogDatabase](t, ts, http.MethodGet, "/api/catalog/sales", nil, http.StatusOK)
+	if sales.LoadedAt == nil || len(sales.Tables) != 1 || sales.Error != "" {
+		t.Errorf("database %+v, want it loaded", sales)
+	}
+
+	// A failed refresh keeps the tables of the last complete listing
+	ts.athena.FailNext("ListTableMetadata", throttled)
+	sales = doJSON[CatalogDatabase](t, ts, http.MethodGet, "/api/catalog/sales?refresh=true", nil, http.StatusOK)
+	if sales.LoadedAt == nil || len(sales.Tables) != 1 || sales.Error == "" {
+		t.Errorf("database %+v, want the stale tables with the failure", sales)
+	}
+	doJSON[CatalogTable](t, ts, http.MethodGet, "/api/catalog/sales/orders", nil, http.StatusOK)
+
+	catalog := doJSON[AthenaCatalog](t, ts, http.MethodGet, "/api/athena/catalog", nil, http.StatusOK)
+	if len(catalog.Databases) != 1 || catalog.Databases[0].Error == "" {
+		t.Errorf("catalog %+v, want the failure of sales", catalog)
+	}
+}
+
+func TestDataCatalogs(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.AddCatalog("lambda_sales", athena.DataCatalogTypeLambda)
+	for i := 0; i < catalogPageSize; i++ {
+		ts.athena.AddCatalog(fmt.Sprintf("hive%02d", i), athena.DataCatalogTypeHive)
+	}
+
+	catalogs := doJSON[[]DataCatalog](t, ts, http.MethodGet, "/api/catalogs", nil, http.StatusOK)
+	if len(catalogs) != catalogPageSize+2 || catalogs[0] != (DataCatalog{Name: defaultCatalogName, Type: "GLUE"}) ||
+		catalogs[1] != (DataCatalog{Name: "lambda_sales", Type: "LAMBDA"}) {
+		t.Fatalf("%d catalogs, starting with %v", len(catalogs), catalogs[:2])
+	}
+
+	// Listings are cached, but catalogs registered since are found when asked for
+	ts.athena.AddCatalog("late", athena.DataCatalogTypeGlue)
+	if catalogs := doJSON[[]DataCatalog](t, ts, http.MethodGet, "/api/catalogs", nil, http.StatusOK); len(catalogs) != catalogPageSize+2 {
+		t.Errorf("%d catalogs, want the cached listing", len(catalogs))
+	}
+	if databases := doJSON[[]CatalogDatabaseInfo](t, ts, http.MethodGet, "/api/catalog?catalog=late", nil, http.StatusOK); len(databases) != 0 {
+		t.Errorf("databases %+v of an empty catalog", databases)
+	}
+	if catalogs := doJSON[[]DataCatalog](t, ts, http.MethodGet, "/api/catalogs", nil, http.StatusOK); len(catalogs) != catalogPageSize+3 {
+		t.Errorf("%d catalogs, want the late one listed", len(catalogs))
+	}
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/catalog?catalog=missing", nil, http.StatusNotFound)
+
+	ts.athena.FailNext("ListDataCatalogs", awserr.New(athena.ErrCodeInternalServerException, "Internal error", nil))
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/catalogs?refresh=true", nil, http.StatusInternalServerError)
+}
+
+func TestWorkGroups(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athenaWorkGroup = "analytics"
+	for i := 0; i < catalogPageSize; i++ {
+		ts.athena.AddWorkGroup(fmt.Sprintf("team%02d", i))
+	}
+	ts.athena.AddWorkGroup("analytics")
+
+	list := doJSON[WorkGroupList](t, ts, http.MethodGet, "/api/workgroups", nil, http.StatusOK)
+	if list.Default != "analytics" || len(list.WorkGroups) != catalogPageSize+2 {
+		t.Fatalf("default %s with %d workgroups", list.Default, len(list.WorkGroups))
+	}
+	primary := list.WorkGroups[0]
+	if primary.Name != "primary" || primary.State != "ENABLED" || primary.EngineVersion != "Athena engine version 3" {
+		t.Errorf("workgroup %+v", primary)
+	}
+	if last := list.WorkGroups[len(list.WorkGroups)-1]; last.Name != "analytics" {
+		t.Errorf("last workgroup %s, want analytics", last.Name)
+	}
+
+	ts.athena.FailNext("ListWorkGroups", awserr.New(athena.ErrCodeInternalServerException, "Internal error", nil))
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/workgroups", nil, http.StatusInternalServerError)
+}
+
+func TestCatalogTableDetails(t *testing.T) {
+	ts := newTestServer(t)
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	table := testTable("orders", "id:bigint", "amount:decimal(10,2)")
+	table.CreateTime = aws.Time(created)
+	table.PartitionKeys = []*athena.Column{{Name: aws.String("dt"), Type: aws.String("string"), Comment: aws.String("Order day")}}
+	table.Parameters = aws.StringMap(map[string]string{
+		"location":                "s3://data/orders/",
+		"inputformat":             "org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat",
+		"serde.serialization.lib": "org.apache.hadoop.hive.ql.io.parquet.serde.ParquetHiveSerDe",
+		"classification":          "parquet",
+		"comment":                 "One row per order",
+		"transient_lastDdlTime":   "1717200000",
+		"parquet.compression":     "SNAPPY",
+	})
+	ts.athena.AddTable("sales", table)
+	ts.athena.AddTable("sales", testTable("customers", "id:bigint"))
+
+	orders := doJSON[CatalogTable](t, ts, http.MethodGet, "/api/catalog/sales/orders", nil, http.StatusOK)
+	if orders.Location != "s3://data/orders/" || orders.Classification != "parquet" || orders.Comment != "One row per order" ||
+		!strings.HasSuffix(orders.SerDe, "ParquetHiveSerDe") || !strings.HasSuffix(orders.InputFormat, "MapredParquetInputFormat") {
+		t.Errorf("table %+v", orders)
+	}
+	if len(orders.Properties) != 1 || orders.Properties["parquet.compression"] != "SNAPPY" {
+		t.Errorf("properties %v, want only the parameters without a field", orders.Properties)
+	}
+	if !orders.CreatedAt.Equal(created) || !orders.UpdatedAt.Equal(time.Unix(1717200000, 0)) {
+		t.Errorf("created %v, updated %v", orders.CreatedAt, orders.UpdatedAt)
+	}
+	if len(orders.PartitionKeys) != 1 || orders.PartitionKeys[0] != (Column{Name: "dt", Type: "string", Comment: "Order day"}) {
+		t.Errorf("partition keys %+v", orders.PartitionKeys)
+	}
+	if orders.Partitions == nil || orders.Partitions.Count != 0 || len(orders.Partitions.Recent) != 0 {
+		t.Errorf("partitions %+v, want none", orders.Partitions)
+	}
+
+	customers := doJSON[CatalogTable](t, ts, http.MethodGet, "/api/catalog/sales/customers", nil, http.StatusOK)
+	if customers.Partitions != nil || customers.PartitionKeys != nil || customers.UpdatedAt != nil {
+		t.Errorf("unpartitioned table %+v", customers)
+	}
+}
+
+func TestCatalogTablePartitions(t *testing.T) {
+	ts := newTestServer(t)
+	table := testTable("events", "id:bigint")
+	table.PartitionKeys = []*athena.Column{{Name: aws.String("dt"), Type: aws.String("string")}, {Name: aws.String("region"), Type: aws.String("string")}}
+	ts.athena.AddTable("logs", table)
+	projected := testTable("clicks", "id:bigint")
+	projected.PartitionKeys = table.PartitionKeys
+	projected.Parameters = aws.StringMap(map[string]string{"projection.enabled": "true", "projection.dt.type": "date"})
+	ts.athena.AddTable("logs", projected)
+
+	// More partitions than Glue returns at once; the newest were created together
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < partitionPageSize+200; i++ {
+		ts.glue.AddPartition("logs", "events", []string{start.AddDate(0, 0, i/2).Format("2006-01-02"), []string{"eu", "us"}[i%2]}, start.Add(time.Duration(i/2)*time.Hour))
+	}
+	path := "/api/catalog/logs/events"
+
+	events := doJSON[CatalogTable](t, ts, http.MethodGet, path, nil, http.StatusOK)
+	partitions := events.Partitions
+	if partitions == nil || partitions.Count != partitionPageSize+200 || partitions.Truncated || len(partitions.Recent) != recentPartitionCount {
+		t.Fatalf("partitions %+v", partitions)
+	}
+	newest := start.AddDate(0, 0, (partitionPageSize+199)/2).Format("2006-01-02")
+	if got := strings.Join(partitions.Recent[0].Values, "/"); got != newest+"/us" {
+		t.Errorf("newest partition %s, want %s/us", got, newest)
+	}
+	if got := strings.Join(partitions.Recent[1].Values, "/"); got != newest+"/eu" {
+		t.Errorf("second partition %s, want %s/eu", got, newest)
+	}
+	if !strings.HasPrefix(partitions.Recent[0].Location, "s3://") || partitions.Recent[0].CreatedAt == nil {
+		t.Errorf("partition %+v", partitions.Recent[0])
+	}
+
+	// Summaries are cached until refreshed, and a failed refresh keeps the last one
+	ts.glue.AddPartition("logs", "events", []string{"2030-01-01", "eu"}, start.AddDate(10, 0, 0))
+	if cached := doJSON[CatalogTable](t, ts, http.MethodGet, path, nil, http.StatusOK).Partitions; cached.Count != partitions.Count {
+		t.Errorf("%d partitions, want the cached %d", cached.Count, partitions.Count)
+	}
+	ts.glue.FailNext("GetPartitions", awserr.New("ThrottlingException", "Rate exceeded", nil))
+	stale := doJSON[CatalogTable](t, ts, http.MethodGet, path+"?refresh=true", nil, http.StatusOK).Partitions
+	if stale.Count != partitions.Count || !strings.Contains(stale.Error, "Rate exceeded") {
+		t.Errorf("partitions %+v, want the last summary with the failure", stale)
+	}
+	refreshed := doJSON[CatalogTable](t, ts, http.MethodGet, path+"?refresh=true", nil, http.StatusOK).Partitions
+	if refreshed.Count != partitions.Count+1 || refreshed.Error != "" || refreshed.Recent[0].Values[0] != "2030-01-01" {
+		t.Errorf("partitions %+v after a refresh", refreshed)
+	}
+
+	clicks := doJSON[CatalogTable](t, ts, http.MethodGet, "/api/catalog/logs/clicks", nil, http.StatusOK)
+	if clicks.Partitions == nil || !clicks.Partitions.Projected || clicks.Properties["projection.dt.type"] != "date" {
+		t.Errorf("projected table %+v", clicks)
+	}
+
+	// Listings leave partitions out
+	logs := doJSON[CatalogDatabase](t, ts, http.MethodGet, "/api/catalog/logs", nil, http.StatusOK)
+	for _, table := range logs.Tables {
+		if table.Partitions != nil {
+			t.Errorf("listed table %s with partitions", table.Name)
+		}
+	}
+}
+
+func TestCatalogTablePartitionsTruncated(t *testing.T) {
+	var partitions []*glue.Partition
+	for i := 0; i < maxPartitionCount+partitionPageSize; i++ {
+		partitions = append(partitions, &glue.Partition{Values: aws.StringSlice([]string{strconv.Itoa(i)})})
+	}
+	fake := newFakeGlue()
+	fake.partitions["logs/events"] = partitions
+	cache := &catalogCache{name: defaultCatalogName, glue: fake}
+
+	summary, err := cache.listPartitions("logs", "events")
+	if err != nil || summary.Count != maxPartitionCount || !summary.Truncated {
+		t.Errorf("%d partitions, truncated %v: %v", summary.Count, summary.Truncated, err)
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultDashboardConcurrency = 4
+
+const maxDashboardWidgets = 50
+
+// Runs read at a time while looking for the latest run of a widget
+const dashboardRunsPageSize = 100
+
+// Columns of the dashboard grid, and the size of widgets placed without one
+const dashboardGridColumns = 12
+const defaultWidgetWidth = 6
+const defaultWidgetHeight = 4
+
+// errInvalidDashboard marks dashboards that can't be saved as sent
+var errInvalidDashboard = errors.New("invalid dashboard")
+
+// Placeholders of query parameters, as recognized by the editor
+var parameterPattern = regexp.MustCompile(`\{\{([^}]+)\}\}`)
+
+// Helper function to read DASHBOARD_REFRESH_CONCURRENCY, the most queries a
+// dashboard refresh starts at the same time
+func loadDashboardConcurrency() (int, error) {
+	value := os.Getenv("DASHBOARD_REFRESH_CONCURRENCY")
+	if value == "" {
+		return defaultDashboardConcurrency, nil
+	}
+
+	concurrency, err := strconv.Atoi(value)
+	if err != nil || concurrency < 1 {
+		return 0, fmt.Errorf("invalid DASHBOARD_REFRESH_CONCURRENCY: %s", value)
+	}
+	return concurrency, nil
+}
+
+// Helper function to list the parameter names of SQL in order of appearance
+func extractParameters(sql string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, match := range parameterPattern.FindAllStringSubmatch(sql, -1) {
+		if name := match[1]; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Helper function to check a dashboard before saving it: widgets get IDs and
+// default sizes, and must reference live queries and their own visualizations
+func (s *Server) checkDashboard(ctx context.Context, dashboard *Dashboard) error {
+	if dashboard.Parameters == nil {
+		dashboard.Parameters = []DashboardParameter{}
+	}
+	if dashboard.Widgets == nil {
+		dashboard.Widgets = []DashboardWidget{}
+	}
+	if len(dashboard.Widgets) > maxDashboardWidgets {
+		return fmt.Errorf("%w: dashboards hold at most %d widgets", errInvalidDashboard, maxDashboardWidgets)
+	}
+
+	parameters := map[string]bool{}
+	for _, parameter := range dashboard.Parameters {
+		if parameter.Name == "" || parameters[parameter.Name] {
+			return fmt.Errorf("%w: parameter names must be unique and not empty", errInvalidDashboard)
+		}
+		parameters[parameter.Name] = true
+	}
+
+	widgetIDs := map[string]bool{}
+	for i := range dashboard.Widgets {
+		widget := &dashboard.Widgets[i]
+		if widget.ID == "" {
+			widget.ID = primitive.NewObjectID().Hex()
+		}
+		if widgetIDs[widget.ID] {
+			return fmt.Errorf("%w: duplicate widget ID %q", errInvalidDashboard, widget.ID)
+		}
+		widgetIDs[widget.ID] = true
+
+		layout := &widget.Layout
+		if layout.W == 0 {
+			layout.W = defaultWidgetWidth
+		}
+		if layout.H == 0 {
+			layout.H = defaultWidgetHeight
+		}
+		if layout.X < 0 || layout.Y < 0 || layout.W < 0 || layout.H < 0 || layout.X+layout.W > dashboardGridColumns {
+			return fmt.Errorf("%w: widget %s doesn't fit the %d column grid", errInvalidDashboard, widget.ID, dashboardGridColumns)
+		}
+
+		for placeholder, parameter := range widget.Parameters {
+			if !parameters[parameter] {
+				return fmt.Errorf("%w: widget %s maps %q to unknown parameter %q", errInvalidDashboard, widget.ID, placeholder, parameter)
+			}
+		}
+
+		query, err := s.store.GetQuery(ctx, widget.QueryID)
+		if err == ErrNotFound || err == nil && query.DeletedAt != nil {
+			return fmt.Errorf("%w: widget %s references a missing query", errInvalidDashboard, widget.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		if widget.VisualizationID != nil {
+			visualization, err := s.store.GetVisualization(ctx, *widget.VisualizationID)
+			if err == ErrNotFound || err == nil && visualization.QueryID != widget.QueryID {
+				return fmt.Errorf("%w: widget %s references a visualization of another query", errInvalidDashboard, widget.ID)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Helper function to resolve the values of dashboard parameters: the values of
+// the refresh, falling back to the defaults
+func dashboardValues(dashboard Dashboard, overrides map[string]string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, parameter := range dashboard.Parameters {
+		values[parameter.Name] = parameter.Default
+	}
+	for name, value := range overrides {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("unknown dashboard parameter %q", name)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// Helper function to fill the placeholders of a widget query from the values of
+// the dashboard parameters
+func widgetParameters(widget DashboardWidget, sql string, values map[string]string) (map[string]string, error) {
+	var parameters map[string]string
+	for _, placeholder := range extractParameters(sql) {
+		name := placeholder
+		if mapped, ok := widget.Parameters[placeholder]; ok {
+			name = mapped
+		}
+
+		value := values[name]
+		if strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("no value for parameter %q", placeholder)
+		}
+		if parameters == nil {
+			parameters = map[string]string{}
+		}
+		parameters[placeholder] = value
+	}
+	return parameters, nil
+}
+
+// Helper function to load the dashboard of the id parameter, answering the
+// request when it can't
+func (s *Server) loadDashboard(ctx context.Context, c *gin.Context) (Dashboard, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dashboard ID"})
+		return Dashboard{}, false
+	}
+
+	dashboard, err := s.store.GetDashboard(ctx, id)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dashboard not found"})
+		return dashboard, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return dashboard, false
+	}
+	return dashboard, true
+}
+
+func (s *Server) getDashboards(c *gin.Context) {
+	dashboards, err := s.store.ListDashboards(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, dashboards)
+}
+
+func (s *Server) createDashboard(c *gin.Context) {
+	var req DashboardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	dashboard := Dashboard{
+		Name:        req.Name,
+		Description: req.Description,
+		Parameters:  req.Parameters,
+		Widgets:     req.Widgets,
+		CreatedBy:   requestUser(c),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := s.checkDashboard(ctx, &dashboard); err != nil {
+		c.JSON(dashboardErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.store.CreateDashboard(ctx, &dashboard); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, dashboard)
+}
+
+func (s *Server) getDashboard(c *gin.Context) {
+	dashboard, ok := s.loadDashboard(context.Background(), c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, dashboard)
+}
+
+func (s *Server) updateDashboard(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dashboard ID"})
+		return
+	}
+
+	var req DashboardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	dashboard := Dashboard{
+		ID:          id,
+		Name:        req.Name,
+		Description: req.Description,
+		Parameters:  req.Parameters,
+		Widgets:     req.Widgets,
+		UpdatedAt:   time.Now(),
+	}
+	if err := s.checkDashboard(ctx, &dashboard); err != nil {
+		c.JSON(dashboardErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	err = s.store.UpdateDashboard(ctx, &dashboard)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dashboard not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, dashboard)
+}
+
+func (s *Server) deleteDashboard(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dashboard ID"})
+		return
+	}
+
+	err = s.store.DeleteDashboard(context.Background(), id)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dashboard not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Dashboard deleted"})
+}
+
+// Helper function to pick the status of a failed dashboard check
+func dashboardErrorStatus(err error) int {
+	if errors.Is(err, errInvalidDashboard) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// dashboardJob is one run started by a refresh, shared by the widgets showing
+// the same query with the same parameters
+type dashboardJob struct {
+	query      Query
+	parameters map[string]string
+	widgets    []int
+}
+
+// Helper function to key a widget query run by its query and final SQL, so
+// widgets showing the same query with the same values share a run
+func dashboardJobKey(queryID primitive.ObjectID, sql string, parameters map[string]string) string {
+	return queryID.Hex() + "\x00" + substituteParameters(sql, parameters)
+}
+
+// Helper function to load the query of a widget once per request, nil when it
+// is missing or deleted
+func (s *Server) widgetQuery(ctx context.Context, queries map[primitive.ObjectID]*Query, widget DashboardWidget) (*Query, error) {
+	if query, loaded := queries[widget.QueryID]; loaded {
+		return query, nil
+	}
+
+	var query *Query
+	saved, err := s.store.GetQuery(ctx, widget.QueryID)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	if err == nil && saved.DeletedAt == nil {
+		query = &saved
+	}
+	queries[widget.QueryID] = query
+	return query, nil
+}
+
+// Helper function to find the latest run of a query with a job key, going
+// through its runs newest first. Runs of the query with other parameter values
+// or an older version of its SQL are skipped.
+func (s *Server) latestDashboardRun(ctx context.Context, queryID primitive.ObjectID, key string) (*QueryRun, error) {
+	field := queryRunSortFields["executed"]
+	filter := QueryRunFilter{QueryID: &queryID}
+	page := pageRequest{Sort: field, Desc: true, Limit: dashboardRunsPageSize}
+
+	for {
+		runs, next, _, err := s.store.ListQueryRuns(ctx, filter, page)
+		if err != nil {
+			return nil, err
+		}
+		for i, run := range runs {
+			if dashboardJobKey(run.QueryID, run.SQL, run.Parameters) == key {
+				return &runs[i], nil
+			}
+		}
+		if next == "" {
+			return nil, nil
+		}
+		if page.After, err = decodeCursor(next, field); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Runs every widget query with the dashboard parameters, at most
+// DASHBOARD_REFRESH_CONCURRENCY at a time. The runs are returned as started;
+// their progress is followed like any other run.
+func (s *Server) refreshDashboard(c *gin.Context) {
+	ctx := context.Background()
+
+	dashboard, ok := s.loadDashboard(ctx, c)
+	if !ok {
+		return
+	}
+
+	var req RefreshDashboardRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	values, err := dashboardValues(dashboard, req.Parameters)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	runs := make([]WidgetRun, len(dashboard.Widgets))
+	queries := map[primitive.ObjectID]*Query{}
+	jobs := map[string]*dashboardJob{}
+	var order []*dashboardJob
+
+	for i, widget := range dashboard.Widgets {
+		runs[i].WidgetID = widget.ID
+
+		query, err := s.widgetQuery(ctx, queries, widget)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if query == nil {
+			runs[i].Error = "Query not found"
+			continue
+		}
+
+		parameters, err := widgetParameters(widget, query.SQL, values)
+		if err != nil {
+			runs[i].Error = err.Error()
+			continue
+		}
+
+		key := dashboardJobKey(query.ID, query.SQL, parameters)
+		job := jobs[key]
+		if job == nil {
+			job = &dashboardJob{query: *query, parameters: parameters}
+			jobs[key] = job
+			order = append(order, job)
+		}
+		job.widgets = append(job.widgets, i)
+	}
+
+	executedBy := requestUser(c)
+	slots := make(chan struct{}, s.dashboardConcurrency)
+	var wg sync.WaitGroup
+	for _, job := range order {
+		wg.Add(1)
+		go func(job *dashboardJob) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			run, err := s.startQueryRun(ctx, job.query.ID, job.query.SQL, job.parameters, executionContext{}, req.NoCache, executedBy)
+			// Each widget is only written by the job it belongs to
+			for _, i := range job.widgets {
+				if err != nil {
+					runs[i].Error = err.Error()
+				} else {
+					runs[i].Run = &run
+				}
+			}
+		}(job)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, DashboardRuns{DashboardID: dashboard.ID, Widgets: runs})
+}
+
+// Returns the latest run of each widget with the parameter values given in the
+// query string, falling back to the defaults like a refresh does. Runs are
+// matched on their final SQL, so widgets only get runs of the values they
+// show. Runs that haven't finished yet are checked with Athena.
+func (s *Server) getDashboardRuns(c *gin.Context) {
+	ctx := context.Background()
+
+	dashboard, ok := s.loadDashboard(ctx, c)
+	if !ok {
+		return
+	}
+
+	overrides := map[string]string{}
+	for name, given := range c.Request.URL.Query() {
+		overrides[name] = given[len(given)-1]
+	}
+	values, err := dashboardValues(dashboard, overrides)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	queries := map[primitive.ObjectID]*Query{}
+	latest := map[string]*QueryRun{}
+	runs := make([]WidgetRun, len(dashboard.Widgets))
+	for i, widget := range dashboard.Widgets {
+		runs[i].WidgetID = widget.ID
+
+		query, err := s.widgetQuery(ctx, queries, widget)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if query == nil {
+			runs[i].Error = "Query not found"
+			continue
+		}
+
+		parameters, err := widgetParameters(widget, query.SQL, values)
+		if err != nil {
+			runs[i].Error = err.Error()
+			continue
+		}
+
+		key := dashboardJobKey(query.ID, query.SQL, parameters)
+		run, loaded := latest[key]
+		if !loaded {
+			if run, err = s.latestDashboardRun(ctx, query.ID, key); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if run != nil && (run.Status == "RUNNING" || run.Status == "QUEUED") {
+				if updated, err := s.updateQueryRunStatus(ctx, *run); err == nil {
+					run = &updated
+				}
+			}
+			latest[key] = run
+		}
+		runs[i].Run = run
+	}
+
+	c.JSON(http.StatusOK, DashboardRuns{DashboardID: dashboard.ID, Widgets: runs})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDashboardValidation(t *testing.T) {
+	ts := newTestServer(t)
+	query := createTestQuery(t, ts, "Sales", "SELECT * FROM sales")
+	other := createTestQuery(t, ts, "Other", "SELECT 1")
+	visualization := doJSON[Visualization](t, ts, http.MethodPost, "/api/queries/"+other.ID.Hex()+"/visualizations",
+		gin.H{"name": "Rows", "type": "counter", "aggregation": "count"}, http.StatusCreated)
+
+	tests := []struct {
+		name   string
+		body   gin.H
+		status int
+	}{
+		{name: "empty", body: gin.H{"name": "Sales"}, status: http.StatusCreated},
+		{name: "widgets", body: gin.H{"name": "Sales", "parameters": []gin.H{{"name": "country", "default": "US"}}, "widgets": []gin.H{
+			{"queryId": query.ID, "parameters": gin.H{"c": "country"}},
+			{"queryId": query.ID, "layout": gin.H{"x": 6, "w": 6}},
+		}}, status: http.StatusCreated},
+		{name: "missing name", body: gin.H{}, status: http.StatusBadRequest},
+		{name: "duplicate parameter", body: gin.H{"name": "Sales", "parameters": []gin.H{{"name": "a"}, {"name": "a"}}}, status: http.StatusBadRequest},
+		{name: "duplicate widget ID", body: gin.H{"name": "Sales", "widgets": []gin.H{{"id": "w", "queryId": query.ID}, {"id": "w", "queryId": query.ID}}}, status: http.StatusBadRequest},
+		{name: "off the grid", body: gin.H{"name": "Sales", "widgets": []gin.H{{"queryId": query.ID, "layout": gin.H{"x": 8, "w": 6}}}}, status: http.StatusBadRequest},
+		{name: "unknown parameter", body: gin.H{"name": "Sales", "widgets": []gin.H{{"queryId": query.ID, "parameters": gin.H{"c": "country"}}}}, status: http.StatusBadRequest},
+		{name: "missing query", body: gin.H{"name": "Sales", "widgets": []gin.H{{"queryId": "0123456789abcdef01234567"}}}, status: http.StatusBadRequest},
+		{name: "visualization of another query", body: gin.H{"name": "Sales", "widgets": []gin.H{{"queryId": query.ID, "visualizationId": visualization.ID}}}, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.do(t, http.MethodPost, "/api/dashboards", tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if rec.Code != http.StatusCreated {
+				return
+			}
+			var dashboard Dashboard
+			decodeBody(t, rec, &dashboard)
+			for _, widget := range dashboard.Widgets {
+				if widget.ID == "" || widget.Layout.W != defaultWidgetWidth || widget.Layout.H != defaultWidgetHeight {
+					t.Errorf("widget %+v, want an ID and the default size", widget)
+				}
+			}
+		})
+	}
+}
+
+// Helper function to find the runs of a dashboard for a query string
+func getTestDashboardRuns(t *testing.T, ts *testServer, dashboard Dashboard, params string) []WidgetRun {
+	t.Helper()
+	runs := doJSON[DashboardRuns](t, ts, http.MethodGet, "/api/dashboards/"+dashboard.ID.Hex()+"/runs"+params, nil, http.StatusOK)
+	if len(runs.Widgets) != len(dashboard.Widgets) {
+		t.Fatalf("%d widget runs for %d widgets", len(runs.Widgets), len(dashboard.Widgets))
+	}
+	return runs.Widgets
+}
+
+func TestDashboardRefreshAndRuns(t *testing.T) {
+	ts := newTestServer(t)
+	sales := createTestQuery(t, ts, "Sales", "SELECT * FROM sales WHERE country = '{{country}}'")
+	total := createTestQuery(t, ts, "Total", "SELECT count(*) FROM sales")
+	broken := createTestQuery(t, ts, "Broken", "SELECT * FROM sales WHERE day = '{{day}}'")
+
+	dashboard := doJSON[Dashboard](t, ts, http.MethodPost, "/api/dashboards", gin.H{
+		"name":       "Sales",
+		"parameters": []gin.H{{"name": "country", "default": "US"}, {"name": "home", "default": "DE"}},
+		"widgets": []gin.H{
+			{"id": "us", "queryId": sales.ID},
+			{"id": "us-chart", "queryId": sales.ID},
+			{"id": "home", "queryId": sales.ID, "parameters": gin.H{"country": "home"}},
+			{"id": "total", "queryId": total.ID},
+			{"id": "broken", "queryId": broken.ID},
+		},
+	}, http.StatusCreated)
+	refreshPath := "/api/dashboards/" + dashboard.ID.Hex() + "/refresh"
+
+	if runs := getTestDashboardRuns(t, ts, dashboard, ""); runs[0].Run != nil {
+		t.Errorf("run %+v before any refresh", runs[0].Run)
+	}
+
+	refreshed := doJSON[DashboardRuns](t, ts, http.MethodPost, refreshPath, nil, http.StatusOK).Widgets
+	if refreshed[0].Run == nil || refreshed[1].Run == nil || refreshed[0].Run.ID != refreshed[1].Run.ID {
+		t.Errorf("widgets showing the same values got runs %+v and %+v", refreshed[0].Run, refreshed[1].Run)
+	}
+	if refreshed[2].Run == nil || refreshed[2].Run.ID == refreshed[0].Run.ID || refreshed[2].Run.Parameters["country"] != "DE" {
+		t.Errorf("mapped widget got run %+v", refreshed[2].Run)
+	}
+	if refreshed[3].Run == nil || refreshed[4].Run != nil || refreshed[4].Error == "" {
+		t.Errorf("widgets %+v", refreshed[3:])
+	}
+	if len(ts.athena.executions) != 3 {
+		t.Errorf("%d executions, want 3", len(ts.athena.executions))
+	}
+
+	// A refresh with other values doesn't replace the runs of the defaults
+	overridden := doJSON[DashboardRuns](t, ts, http.MethodPost, refreshPath, gin.H{"parameters": gin.H{"country": "CA"}}, http.StatusOK).Widgets
+
+	// Widgets the override doesn't change show the runs it started
+	latest := []*QueryRun{refreshed[0].Run, refreshed[1].Run, overridden[2].Run, overridden[3].Run}
+
+	runs := getTestDashboardRuns(t, ts, dashboard, "")
+	for i, widget := range []string{"us", "us-chart", "home", "total"} {
+		if runs[i].Run == nil || runs[i].Run.ID != latest[i].ID {
+			t.Errorf("widget %s got run %+v, want %s", widget, runs[i].Run, latest[i].ID.Hex())
+		} else if runs[i].Run.Status != "SUCCEEDED" {
+			t.Errorf("widget %s run is %s, want it checked with Athena", widget, runs[i].Run.Status)
+		}
+	}
+	if runs[4].Run != nil || runs[4].Error == "" {
+		t.Errorf("widget without values got %+v", runs[4])
+	}
+
+	runs = getTestDashboardRuns(t, ts, dashboard, "?country=CA")
+	if runs[0].Run == nil || runs[0].Run.ID != overridden[0].Run.ID || runs[2].Run.ID != overridden[2].Run.ID {
+		t.Errorf("runs for CA %+v", runs)
+	}
+
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/dashboards/"+dashboard.ID.Hex()+"/runs?city=Berlin", nil, http.StatusBadRequest)
+	doJSON[gin.H](t, ts, http.MethodPost, refreshPath, gin.H{"parameters": gin.H{"city": "Berlin"}}, http.StatusBadRequest)
+
+	// Runs of an older version of the query no longer show
+	doJSON[Query](t, ts, http.MethodPatch, "/api/queries/"+total.ID.Hex(), gin.H{"sql": "SELECT count(*) FROM orders"}, http.StatusOK)
+	if runs := getTestDashboardRuns(t, ts, dashboard, ""); runs[3].Run != nil {
+		t.Errorf("widget of the changed query got run %+v", runs[3].Run)
+	}
+}
+
+func TestDashboardRunsPastFirstPage(t *testing.T) {
+	ts := newTestServer(t)
+	query := createTestQuery(t, ts, "Sales", "SELECT * FROM sales WHERE country = '{{country}}'")
+	dashboard := doJSON[Dashboard](t, ts, http.MethodPost, "/api/dashboards", gin.H{
+		"name":       "Sales",
+		"parameters": []gin.H{{"name": "country", "default": "US"}},
+		"widgets":    []gin.H{{"queryId": query.ID}},
+	}, http.StatusCreated)
+
+	refreshed := doJSON[DashboardRuns](t, ts, http.MethodPost, "/api/dashboards/"+dashboard.ID.Hex()+"/refresh", nil, http.StatusOK).Widgets
+
+	// Newer runs of other values fill more than a page
+	for i := 0; i < dashboardRunsPageSize+10; i++ {
+		run := QueryRun{
+			QueryID:    query.ID,
+			SQL:        query.SQL,
+			Status:     "SUCCEEDED",
+			Parameters: map[string]string{"country": "CA"},
+			ExecutedAt: time.Now().Add(time.Duration(i+1) * time.Second),
+		}
+		if err := ts.store.CreateQueryRun(context.Background(), &run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runs := getTestDashboardRuns(t, ts, dashboard, "")
+	if runs[0].Run == nil || runs[0].Run.ID != refreshed[0].Run.ID {
+		t.Errorf("got run %+v, want %s", runs[0].Run, refreshed[0].Run.ID.Hex())
+	}
+}
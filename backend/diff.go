@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultDiffLimit = 100
+const maxDiffLimit = 1000
+
+// Rows of the earlier run held in memory while diffing
+const maxDiffRows = 1000000
+
+// diffSide is one run of a diff with the positions of the compared columns in its results
+type diffSide struct {
+	run     QueryRun
+	columns []resultColumn
+	keys    []int // Indexes of the key columns
+	values  []int // Indexes of RunDiff.Columns
+}
+
+// diffBaseRow is a row of the earlier run waiting to be matched
+type diffBaseRow struct {
+	key     []string
+	values  []string
+	matched bool
+}
+
+// Helper function to pick the values of the given columns out of a record
+func pickFields(record []string, indexes []int) []string {
+	values := make([]string, len(indexes))
+	for i, index := range indexes {
+		values[i] = record[index]
+	}
+	return values
+}
+
+// Helper function to compare two cells of a column. Cells that differ as text
+// can still be equal values, such as 1.50 and 1.5 in a decimal column.
+func diffCell(column, fromType, toType, before, after string) (DiffCell, bool) {
+	cell := DiffCell{Column: column, Before: before, After: after}
+	if before == after {
+		return cell, false
+	}
+
+	fromValue, toValue := typedValue(fromType, before), typedValue(toType, after)
+	if fromType == toType && fromValue != nil && toValue != nil && compareResultValues(fromValue, toValue) == 0 {
+		return cell, false
+	}
+
+	if x, ok := numericValue(fromValue); ok {
+		if y, ok := numericValue(toValue); ok {
+			if delta := y - x; !math.IsNaN(delta) && !math.IsInf(delta, 0) {
+				cell.Delta = &delta
+			}
+		}
+	}
+	return cell, true
+}
+
+// Helper function to diff the result files of two runs on key columns. Lists
+// stop growing at limit while the summary keeps counting.
+func (s *Server) diffResults(ctx context.Context, from, to *diffSide, keys []string, limit int) (*RunDiff, error) {
+	diff := &RunDiff{
+		From:    from.run.ID,
+		To:      to.run.ID,
+		Keys:    keys,
+		Columns: []string{},
+		Added:   []DiffRow{},
+		Removed: []DiffRow{},
+		Changed: []DiffChange{},
+		Summary: RunDiffSummary{AddedColumns: []string{}, RemovedColumns: []string{}},
+	}
+
+	for _, key := range keys {
+		i, j := findResultColumn(from.columns, key), findResultColumn(to.columns, key)
+		if i < 0 || j < 0 {
+			return nil, fmt.Errorf("%w: key column %q is not in the results of both runs", ErrInvalidResultQuery, key)
+		}
+		from.keys = append(from.keys, i)
+		to.keys = append(to.keys, j)
+	}
+
+	// Compare the columns of the later run that the earlier one has too
+	for j, column := range to.columns {
+		if findResultColumn(to.columns, column.Name) != j {
+			continue
+		}
+		i := findResultColumn(from.columns, column.Name)
+		if i < 0 {
+			diff.Summary.AddedColumns = append(diff.Summary.AddedColumns, column.Name)
+			continue
+		}
+		diff.Columns = append(diff.Columns, column.Name)
+		from.values = append(from.values, i)
+		to.values = append(to.values, j)
+	}
+	for i, column := range from.columns {
+		if findResultColumn(from.columns, column.Name) == i && findResultColumn(to.columns, column.Name) < 0 {
+			diff.Summary.RemovedColumns = append(diff.Summary.RemovedColumns, column.Name)
+		}
+	}
+
+	isKey := make([]bool, len(diff.Columns))
+	for i, name := range diff.Columns {
+		for _, key := range keys {
+			isKey[i] = isKey[i] || key == name
+		}
+	}
+
+	// Hold the earlier run by key, in file order for listing removed rows
+	baseRows := []*diffBaseRow{}
+	byKey := map[string]*diffBaseRow{}
+	err := s.scanResultFile(ctx, from.run.ResultsS3URL, len(from.columns), func(record []string) error {
+		diff.Summary.FromRows++
+		if diff.Summary.FromRows > maxDiffRows {
+			return fmt.Errorf("%w: runs with more than %d rows can't be diffed", ErrInvalidResultQuery, maxDiffRows)
+		}
+
+		row := &diffBaseRow{key: pickFields(record, from.keys), values: pickFields(record, from.values)}
+		id := strings.Join(row.key, "\x00")
+		if byKey[id] != nil {
+			diff.Summary.DuplicateKeys++
+			return nil
+		}
+		byKey[id] = row
+		baseRows = append(baseRows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	err = s.scanResultFile(ctx, to.run.ResultsS3URL, len(to.columns), func(record []string) error {
+		diff.Summary.ToRows++
+		if diff.Summary.ToRows > maxDiffRows {
+			return fmt.Errorf("%w: runs with more than %d rows can't be diffed", ErrInvalidResultQuery, maxDiffRows)
+		}
+
+		key := pickFields(record, to.keys)
+		id := strings.Join(key, "\x00")
+		if seen[id] {
+			diff.Summary.DuplicateKeys++
+			return nil
+		}
+		seen[id] = true
+
+		values := pickFields(record, to.values)
+		base := byKey[id]
+		if base == nil {
+			diff.Summary.Added++
+			if len(diff.Added) < limit {
+				diff.Added = append(diff.Added, DiffRow{Key: key, Values: values})
+			} else {
+				diff.Truncated = true
+			}
+			return nil
+		}
+		base.matched = true
+
+		var cells []DiffCell
+		for i, name := range diff.Columns {
+			if isKey[i] {
+				continue
+			}
+			fromType, toType := from.columns[from.values[i]].Type, to.columns[to.values[i]].Type
+			if cell, changed := diffCell(name, fromType, toType, base.values[i], values[i]); changed {
+				cells = append(cells, cell)
+			}
+		}
+		if len(cells) == 0 {
+			diff.Summary.Unchanged++
+			return nil
+		}
+
+		diff.Summary.Changed++
+		if len(diff.Changed) < limit {
+			diff.Changed = append(diff.Changed, DiffChange{Key: key, Cells: cells})
+		} else {
+			diff.Truncated = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range baseRows {
+		if row.matched {
+			continue
+		}
+		diff.Summary.Removed++
+		if len(diff.Removed) < limit {
+			diff.Removed = append(diff.Removed, DiffRow{Key: row.key, Values: row.values})
+		} else {
+			diff.Truncated = true
+		}
+	}
+
+	return diff, nil
+}
+
+// Helper function to load a run for a diff, reporting why it can't be diffed
+func (s *Server) loadDiffRun(ctx context.Context, c *gin.Context, param string) (*diffSide, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param(param))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query run ID"})
+		return nil, false
+	}
+
+	run, err := s.store.GetQueryRun(ctx, id)
+	if err == nil && run.DeletedAt != nil {
+		err = ErrNotFound
+	}
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query run not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	if run.Status == "QUEUED" || run.Status == "RUNNING" {
+		if run, err = s.updateQueryRunStatus(ctx, run); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, false
+		}
+	}
+
+	if run.Status != "SUCCEEDED" || run.ResultsS3URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Query run %s has not succeeded", run.ID.Hex())})
+		return nil, false
+	}
+
+	return &diffSide{run: run}, true
+}
+
+func (s *Server) diffQueryRuns(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var keys []string
+	for _, key := range strings.Split(c.Query("keys"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keys is required"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultDiffLimit)))
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	if limit > maxDiffLimit {
+		limit = maxDiffLimit
+	}
+
+	from, ok := s.loadDiffRun(ctx, c, "id")
+	if !ok {
+		return
+	}
+	to, ok := s.loadDiffRun(ctx, c, "otherId")
+	if !ok {
+		return
+	}
+	if from.run.QueryID != to.run.QueryID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query runs belong to different queries"})
+		return
+	}
+
+	if from.columns, err = s.getResultColumns(from.run.ExecutionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if to.columns, err = s.getResultColumns(to.run.ExecutionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	diff, err := s.diffResults(ctx, from, to, keys, limit)
+	if errors.Is(err, ErrInvalidResultQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(s3ErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
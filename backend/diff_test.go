@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Helper function to run the same query twice, answered by different scripts
+func runDiffTestQueries(t *testing.T, ts *testServer, before, after fakeQuery) (QueryRun, QueryRun) {
+	t.Helper()
+	ts.athena.Script("from orders_v1", before)
+	ts.athena.Script("from orders_v2", after)
+
+	query := createTestQuery(t, ts, "Orders", "SELECT * FROM orders_v1")
+	from := runTestQuery(t, ts, query, gin.H{"sql": "SELECT * FROM orders_v1"})
+	to := runTestQuery(t, ts, query, gin.H{"sql": "SELECT * FROM orders_v2"})
+	if from.Status != "SUCCEEDED" || to.Status != "SUCCEEDED" {
+		t.Fatalf("runs %s and %s, want SUCCEEDED", from.Status, to.Status)
+	}
+	return from, to
+}
+
+func TestDiffQueryRuns(t *testing.T) {
+	ts := newTestServer(t)
+	from, to := runDiffTestQueries(t, ts,
+		fakeQuery{
+			Columns: []string{"id", "amount", "status", "region"},
+			Types:   []string{"bigint", "decimal(10,2)", "varchar", "varchar"},
+			Rows: [][]string{
+				{"1", "10.50", "open", "eu"},
+				{"2", "20.00", "open", "us"},
+				{"3", "5.00", "closed", "us"},
+				{"3", "7.00", "closed", "us"},
+			},
+		},
+		fakeQuery{
+			Columns: []string{"id", "amount", "status", "channel"},
+			Types:   []string{"bigint", "decimal(10,2)", "varchar", "varchar"},
+			Rows: [][]string{
+				{"1", "10.5", "open", "web"},
+				{"2", "25.00", "closed", "app"},
+				{"4", "1.00", "open", "web"},
+			},
+		})
+
+	diff := doJSON[RunDiff](t, ts, http.MethodGet,
+		"/api/query-runs/"+from.ID.Hex()+"/diff/"+to.ID.Hex()+"?keys=id", nil, http.StatusOK)
+
+	if diff.From != from.ID || diff.To != to.ID {
+		t.Errorf("diff of %s and %s, want %s and %s", diff.From.Hex(), diff.To.Hex(), from.ID.Hex(), to.ID.Hex())
+	}
+	if want := []string{"id", "amount", "status"}; !reflect.DeepEqual(diff.Columns, want) {
+		t.Errorf("columns %v, want %v", diff.Columns, want)
+	}
+	if want := []DiffRow{{Key: []string{"4"}, Values: []string{"4", "1.00", "open"}}}; !reflect.DeepEqual(diff.Added, want) {
+		t.Errorf("added %v, want %v", diff.Added, want)
+	}
+	if want := []DiffRow{{Key: []string{"3"}, Values: []string{"3", "5.00", "closed"}}}; !reflect.DeepEqual(diff.Removed, want) {
+		t.Errorf("removed %v, want %v", diff.Removed, want)
+	}
+
+	// 10.50 and 10.5 are the same decimal
+	if len(diff.Changed) != 1 || !reflect.DeepEqual(diff.Changed[0].Key, []string{"2"}) {
+		t.Fatalf("changed %+v, want row 2 only", diff.Changed)
+	}
+	cells := diff.Changed[0].Cells
+	if len(cells) != 2 || cells[0].Column != "amount" || cells[0].Delta == nil || *cells[0].Delta != 5 {
+		t.Errorf("cells %+v, want amount +5 and status", cells)
+	}
+	if cells[1].Column != "status" || cells[1].Before != "open" || cells[1].After != "closed" || cells[1].Delta != nil {
+		t.Errorf("status cell %+v", cells[1])
+	}
+
+	want := RunDiffSummary{
+		FromRows: 4, ToRows: 3, Added: 1, Removed: 1, Changed: 1, Unchanged: 1, DuplicateKeys: 1,
+		AddedColumns: []string{"channel"}, RemovedColumns: []string{"region"},
+	}
+	if !reflect.DeepEqual(diff.Summary, want) {
+		t.Errorf("summary %+v, want %+v", diff.Summary, want)
+	}
+	if diff.Truncated {
+		t.Error("a diff within the limit was truncated")
+	}
+}
+
+func TestDiffQueryRunsLimit(t *testing.T) {
+	ts := newTestServer(t)
+	from, to := runDiffTestQueries(t, ts,
+		fakeQuery{Columns: []string{"id"}, Rows: [][]string{{"a"}, {"b"}, {"c"}}},
+		fakeQuery{Columns: []string{"id"}, Rows: [][]string{{"d"}, {"e"}, {"f"}}})
+
+	diff := doJSON[RunDiff](t, ts, http.MethodGet,
+		"/api/query-runs/"+from.ID.Hex()+"/diff/"+to.ID.Hex()+"?keys=id&limit=2", nil, http.StatusOK)
+	if len(diff.Added) != 2 || len(diff.Removed) != 2 || !diff.Truncated {
+		t.Errorf("%d added and %d removed, truncated %v; want 2 of each, truncated", len(diff.Added), len(diff.Removed), diff.Truncated)
+	}
+	if diff.Summary.Added != 3 || diff.Summary.Removed != 3 {
+		t.Errorf("summary %+v, want 3 added and 3 removed", diff.Summary)
+	}
+}
+
+func TestDiffQueryRunsRefreshesStatus(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.Script("from orders", fakeQuery{Columns: []string{"id"}, Rows: [][]string{{"1"}}})
+	query := createTestQuery(t, ts, "Orders", "SELECT * FROM orders")
+	from := runTestQuery(t, ts, query, nil)
+
+	// A run still marked as running is checked with Athena before the diff
+	started := doJSON[QueryRun](t, ts, http.MethodPost, "/api/queries/"+query.ID.Hex()+"/runs",
+		gin.H{"sql": query.SQL, "noCache": true}, http.StatusCreated)
+	if started.Status != "QUEUED" && started.Status != "RUNNING" {
+		t.Fatalf("started run %s, want it still running", started.Status)
+	}
+	diff := doJSON[RunDiff](t, ts, http.MethodGet,
+		"/api/query-runs/"+from.ID.Hex()+"/diff/"+started.ID.Hex()+"?keys=id", nil, http.StatusOK)
+	if diff.Summary.Unchanged != 1 {
+		t.Errorf("summary %+v, want the row unchanged", diff.Summary)
+	}
+}
+
+func TestDiffQueryRunsMaxRows(t *testing.T) {
+	ts := newTestServer(t)
+	from, to := runDiffTestQueries(t, ts,
+		fakeQuery{Columns: []string{"id"}, Rows: [][]string{{"1"}}},
+		fakeQuery{Columns: []string{"id"}, Rows: [][]string{{"1"}}})
+
+	// The later run is capped like the earlier one, even with every key repeated
+	bucket, key, err := parseS3URL(to.ResultsS3URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.s3.putObject(bucket, key, []byte("id\n"+strings.Repeat("1\n", maxDiffRows+1)))
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/query-runs/"+from.ID.Hex()+"/diff/"+to.ID.Hex()+"?keys=id", nil, http.StatusBadRequest)
+}
+
+func TestDiffQueryRunsInvalid(t *testing.T) {
+	ts := newTestServer(t)
+	from, to := runDiffTestQueries(t, ts,
+		fakeQuery{Columns: []string{"id"}, Rows: [][]string{{"1"}}},
+		fakeQuery{Columns: []string{"key"}, Rows: [][]string{{"1"}}})
+
+	ts.athena.Script("from failing", fakeQuery{States: []string{"FAILED"}, Reason: "boom"})
+	failing := createTestQuery(t, ts, "Failing", "SELECT * FROM failing")
+	failed := runTestQuery(t, ts, failing, nil)
+	other := createTestQuery(t, ts, "Other", "SELECT * FROM orders_v1")
+	otherRun := runTestQuery(t, ts, other, nil)
+	trashed := runTestQuery(t, ts, failing, gin.H{"sql": "SELECT * FROM orders_v1"})
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/query-runs/"+trashed.ID.Hex(), nil, http.StatusOK)
+
+	path := func(a, b string) string { return "/api/query-runs/" + a + "/diff/" + b }
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{name: "missing keys", path: path(from.ID.Hex(), to.ID.Hex()), status: http.StatusBadRequest},
+		{name: "invalid limit", path: path(from.ID.Hex(), to.ID.Hex()) + "?keys=id&limit=-1", status: http.StatusBadRequest},
+		{name: "key missing from a run", path: path(from.ID.Hex(), to.ID.Hex()) + "?keys=id", status: http.StatusBadRequest},
+		{name: "invalid ID", path: path("nope", to.ID.Hex()) + "?keys=id", status: http.StatusBadRequest},
+		{name: "unknown run", path: path(from.ID.Hex(), "0123456789abcdef01234567") + "?keys=id", status: http.StatusNotFound},
+		{name: "trashed run", path: path(trashed.ID.Hex(), from.ID.Hex()) + "?keys=id", status: http.StatusNotFound},
+		{name: "failed run", path: path(failed.ID.Hex(), from.ID.Hex()) + "?keys=id", status: http.StatusBadRequest},
+		{name: "different queries", path: path(from.ID.Hex(), otherRun.ID.Hex()) + "?keys=id", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doJSON[gin.H](t, ts, http.MethodGet, tt.path, nil, tt.status)
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultPresignedURLExpiry = 15 * time.Minute
+
+// Longest expiry S3 accepts for presigned URLs signed with Signature Version 4
+const maxPresignedURLExpiry = 7 * 24 * time.Hour
+
+// DownloadLinkResponse is returned by exports requested with link=json. Without
+// presigned URLs the link points back at the proxied export.
+type DownloadLinkResponse struct {
+	URL       string     `json:"url"`
+	Presigned bool       `json:"presigned"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Helper function to read PRESIGNED_URLS (false when clients can't reach the
+// results bucket) and PRESIGNED_URL_EXPIRY (a duration)
+func loadPresignConfig() (bool, time.Duration, error) {
+	enabled := true
+	if value := os.Getenv("PRESIGNED_URLS"); value != "" {
+		var err error
+		if enabled, err = strconv.ParseBool(value); err != nil {
+			return false, 0, fmt.Errorf("invalid PRESIGNED_URLS: %s", value)
+		}
+	}
+
+	expiry := defaultPresignedURLExpiry
+	if value := os.Getenv("PRESIGNED_URL_EXPIRY"); value != "" {
+		var err error
+		expiry, err = time.ParseDuration(value)
+		if err != nil || expiry <= 0 || expiry > maxPresignedURLExpiry {
+			return false, 0, fmt.Errorf("invalid PRESIGNED_URL_EXPIRY: %s (must be between 1s and 168h)", value)
+		}
+	}
+
+	return enabled, expiry, nil
+}
+
+// Helper function to read the expiresIn parameter of a link request (seconds),
+// which may shorten the configured expiry but not extend it
+func (s *Server) linkExpiry(c *gin.Context) (time.Duration, error) {
+	value := c.Query("expiresIn")
+	if value == "" {
+		return s.presignedURLExpiry, nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 1 {
+		return 0, fmt.Errorf("expiresIn must be a positive number of seconds")
+	}
+
+	expiry := time.Duration(seconds) * time.Second
+	if expiry > s.presignedURLExpiry {
+		expiry = s.presignedURLExpiry
+	}
+	return expiry, nil
+}
+
+// Helper function to presign a GET of a result file which downloads under filename
+func (s *Server) presignResultsURL(s3URL, filename string, expiry time.Duration) (string, error) {
+	bucket, key, err := parseS3URL(s3URL)
+	if err != nil {
+		return "", err
+	}
+
+	req, _ := s.s3.GetObjectRequest(&s3.GetObjectInput{
+		Bucket:                     aws.String(bucket),
+		Key:                        aws.String(key),
+		ResponseContentType:        aws.String("text/csv"),
+		ResponseContentDisposition: aws.String("attachment; filename=" + filename),
+	})
+	return req.Presign(expiry)
+}
+
+// Helper function to answer an export requested with link=redirect or link=json.
+// A presigned URL is recorded with the requesting user before it is handed out.
+// It returns false when no URL could be presigned and the file should be proxied.
+func (s *Server) sendDownloadLink(c *gin.Context, mode, executionID, s3URL, filename string) bool {
+	expiry, err := s.linkExpiry(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return true
+	}
+
+	url := ""
+	if s.presignedURLs {
+		url, err = s.presignResultsURL(s3URL, filename, expiry)
+		if err != nil {
+			log.Printf("Failed to presign %s, proxying instead: %v", s3URL, err)
+			url = ""
+		}
+	}
+
+	if url == "" {
+		if mode == "json" {
+			// Point at this export without the link parameters
+			c.JSON(http.StatusOK, DownloadLinkResponse{URL: c.Request.URL.Path, Presigned: false})
+			return true
+		}
+		return false
+	}
+
+	now := time.Now()
+	link := DownloadLink{
+		ExecutionID:  executionID,
+		ResultsS3URL: s3URL,
+		RequestedBy:  requestUser(c),
+		ClientIP:     c.ClientIP(),
+		RequestedAt:  now,
+		ExpiresAt:    now.Add(expiry),
+	}
+	if err := s.store.CreateDownloadLink(context.Background(), &link); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record download link: " + err.Error()})
+		return true
+	}
+
+	if mode == "json" {
+		c.JSON(http.StatusOK, DownloadLinkResponse{URL: url, Presigned: true, ExpiresAt: &link.ExpiresAt})
+	} else {
+		c.Redirect(http.StatusTemporaryRedirect, url)
+	}
+	return true
+}
+
+// Helper function to report a failed download. Before the response has started
+// the error replaces the file headers; afterwards the status is already sent, so
+// the error is logged and the connection closed early, which clients see as a
+// truncated (Content-Length) or unterminated (chunked) body rather than a
+// complete file.
+func abortDownload(c *gin.Context, executionID string, err error) {
+	c.Abort()
+
+	if !c.Writer.Written() {
+		header := c.Writer.Header()
+		for _, name := range []string{"Content-Type", "Content-Disposition", "Content-Length",
+			"Content-Range", "Accept-Ranges", "ETag", "Last-Modified"} {
+			header.Del(name)
+		}
+		c.JSON(s3ErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Request.Context().Err() != nil {
+		log.Printf("Download of %s cancelled by the client", executionID)
+		return
+	}
+	log.Printf("Download of %s failed after the response started: %v", executionID, err)
+
+	// Hijacking isn't possible over HTTP/2, where only a short Content-Length is detected
+	if conn, _, hijackErr := c.Writer.Hijack(); hijackErr == nil {
+		conn.Close()
+	}
+}
+
+func (s *Server) getDownloadLinks(c *gin.Context) {
+	links, err := s.store.ListDownloadLinks(context.Background(), c.Param("executionId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, links)
+}
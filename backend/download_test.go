@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadLinks(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     string
+		disabled   bool
+		presignErr bool
+		status     int
+		presigned  bool
+		expires    string // X-Amz-Expires of the presigned URL
+	}{
+		{name: "json", params: "link=json", status: http.StatusOK, presigned: true, expires: "900"},
+		{name: "json with a shorter expiry", params: "link=json&expiresIn=300", status: http.StatusOK, presigned: true, expires: "300"},
+		{name: "json with a longer expiry", params: "link=json&expiresIn=86400", status: http.StatusOK, presigned: true, expires: "900"},
+		{name: "redirect", params: "link=redirect", status: http.StatusTemporaryRedirect, presigned: true, expires: "900"},
+		{name: "invalid expiry", params: "link=json&expiresIn=soon", status: http.StatusBadRequest},
+		{name: "json when disabled", params: "link=json", disabled: true, status: http.StatusOK},
+		{name: "redirect when disabled", params: "link=redirect", disabled: true, status: http.StatusOK},
+		{name: "json when presigning fails", params: "link=json", presignErr: true, status: http.StatusOK},
+		{name: "redirect when presigning fails", params: "link=redirect", presignErr: true, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.presignedURLs = !tt.disabled
+			if tt.presignErr {
+				ts.s3.FailNext("GetObjectRequest", errors.New("no credentials"))
+			}
+			executionID := runScriptedQuery(t, ts, exportTestScript)
+			path := "/api/athena/export/" + executionID
+
+			rec := ts.do(t, http.MethodGet, path+"?"+tt.params, nil, "X-Forwarded-User", "jane")
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+
+			var link string
+			switch {
+			case rec.Code == http.StatusTemporaryRedirect:
+				link = rec.Header().Get("Location")
+			case strings.Contains(tt.params, "link=json") && rec.Code == http.StatusOK:
+				var response DownloadLinkResponse
+				decodeBody(t, rec, &response)
+				if response.Presigned != tt.presigned || (response.ExpiresAt != nil) != tt.presigned {
+					t.Errorf("response %+v, want presigned %v", response, tt.presigned)
+				}
+				if !tt.presigned && response.URL != path {
+					t.Errorf("url %q, want the proxied export %q", response.URL, path)
+				}
+				link = response.URL
+			case rec.Code == http.StatusOK:
+				// Proxied instead of redirected
+				if !strings.HasPrefix(rec.Body.String(), "name,orders") {
+					t.Errorf("body %q, want the CSV", rec.Body.String())
+				}
+			}
+
+			links := doJSON[[]DownloadLink](t, ts, http.MethodGet, path+"/links", nil, http.StatusOK)
+			if !tt.presigned {
+				if len(links) != 0 {
+					t.Errorf("links %v recorded without a presigned URL", links)
+				}
+				return
+			}
+
+			u, err := url.Parse(link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Query().Get("X-Amz-Signature") == "" || u.Query().Get("X-Amz-Expires") != tt.expires {
+				t.Errorf("url %q, want one signed for %s seconds", link, tt.expires)
+			}
+			if !strings.Contains(u.Query().Get("response-content-disposition"), "filename=query_results_") {
+				t.Errorf("url %q doesn't name the download", link)
+			}
+			if len(links) != 1 || links[0].RequestedBy != "jane" || links[0].ExecutionID != executionID {
+				t.Errorf("links %+v, want one requested by jane", links)
+			}
+			if expiry := time.Until(links[0].ExpiresAt); expiry <= 0 || expiry > ts.presignedURLExpiry {
+				t.Errorf("link expires in %v", expiry)
+			}
+		})
+	}
+}
+
+func TestLoadPresignConfig(t *testing.T) {
+	tests := []struct {
+		enabled string
+		expiry  string
+		want    bool
+		wantTTL time.Duration
+		err     bool
+	}{
+		{want: true, wantTTL: defaultPresignedURLExpiry},
+		{enabled: "false", expiry: "1h", want: false, wantTTL: time.Hour},
+		{enabled: "maybe", err: true},
+		{expiry: "0s", err: true},
+		{expiry: "200h", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("PRESIGNED_URLS", tt.enabled)
+		t.Setenv("PRESIGNED_URL_EXPIRY", tt.expiry)
+
+		enabled, expiry, err := loadPresignConfig()
+		if (err != nil) != tt.err {
+			t.Errorf("PRESIGNED_URLS=%q PRESIGNED_URL_EXPIRY=%q: error %v", tt.enabled, tt.expiry, err)
+			continue
+		}
+		if err == nil && (enabled != tt.want || expiry != tt.wantTTL) {
+			t.Errorf("PRESIGNED_URLS=%q PRESIGNED_URL_EXPIRY=%q: got %v, %v", tt.enabled, tt.expiry, enabled, expiry)
+		}
+	}
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/parquet-go/parquet-go"
+	"github.com/xuri/excelize/v2"
+)
+
+// Rows buffered per Parquet row group; bounds the memory used by an export
+const parquetRowGroupSize = 64 * 1024
+
+// Parquet schemas need at least one column, which results of statements such
+// as CREATE TABLE don't have
+var errNoParquetColumns = errors.New("results without columns can't be exported as Parquet")
+
+// How Athena formats timestamps in result files
+const athenaTimestampLayout = "2006-01-02 15:04:05.999999999"
+
+type resultColumn struct {
+	Name string
+	Type string // Athena type such as varchar, bigint or timestamp
+}
+
+// resultWriter converts result rows into an export format as they are read
+type resultWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+type exportFormat struct {
+	ContentType string
+	Extension   string
+	NewWriter   func(w io.Writer, columns []resultColumn) (resultWriter, error)
+}
+
+// Formats accepted by ?format= on the export endpoint besides csv, which is
+// Athena's own result file
+var exportFormats = map[string]exportFormat{
+	"json": {
+		ContentType: "application/json",
+		Extension:   "json",
+		NewWriter: func(w io.Writer, columns []resultColumn) (resultWriter, error) {
+			return newJSONResultWriter(w, columns, true), nil
+		},
+	},
+	"ndjson": {
+		ContentType: "application/x-ndjson",
+		Extension:   "ndjson",
+		NewWriter: func(w io.Writer, columns []resultColumn) (resultWriter, error) {
+			return newJSONResultWriter(w, columns, false), nil
+		},
+	},
+	"parquet": {
+		ContentType: "application/vnd.apache.parquet",
+		Extension:   "parquet",
+		NewWriter:   newParquetResultWriter,
+	},
+	"xlsx": {
+		ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		Extension:   "xlsx",
+		NewWriter:   newXLSXResultWriter,
+	},
+}
+
+// Helper function to read the column names and types of a succeeded execution
+func (s *Server) getResultColumns(executionID string) ([]resultColumn, error) {
+	output, err := s.athena.GetQueryResults(&athena.GetQueryResultsInput{
+		QueryExecutionId: aws.String(executionID),
+		MaxResults:       aws.Int64(1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get result metadata: %v", err)
+	}
+	if output.ResultSet == nil || output.ResultSet.ResultSetMetadata == nil {
+		return nil, fmt.Errorf("no result metadata found")
+	}
+
+	columns := []resultColumn{}
+	for _, info := range output.ResultSet.ResultSetMetadata.ColumnInfo {
+		columns = append(columns, resultColumn{
+			Name: aws.StringValue(info.Name),
+			Type: strings.ToLower(aws.StringValue(info.Type)),
+		})
+	}
+	return columns, nil
+}
+
+// Helper function to open the CSV result file of an execution past its header.
+// Records are reused between reads, and the S3 read stops when ctx is cancelled.
+func (s *Server) openResultFile(ctx context.Context, s3URL string, columnCount int) (*csv.Reader, io.Closer, error) {
+	bucket, key, err := parseS3URL(s3URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	object, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get S3 object: %w", err)
+	}
+
+	reader := csv.NewReader(bufio.NewReader(object.Body))
+	reader.FieldsPerRecord = columnCount
+	reader.ReuseRecord = true
+
+	// The first record holds the column names
+	if _, err := reader.Read(); err != nil && err != io.EOF {
+		object.Body.Close()
+		return nil, nil, fmt.Errorf("failed to read results: %v", err)
+	}
+	return reader, object.Body, nil
+}
+
+// Helper function to call fn with each record of the CSV result file of an execution
+func (s *Server) scanResultFile(ctx context.Context, s3URL string, columnCount int, fn func(record []string) error) error {
+	reader, body, err := s.openResultFile(ctx, s3URL, columnCount)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read results: %v", err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// Helper function to stream the CSV result file of an execution into another
+// format, one row at a time. The S3 read stops when ctx is cancelled.
+func (s *Server) convertResults(ctx context.Context, w io.Writer, s3URL string, columns []resultColumn, format exportFormat) error {
+	reader, body, err := s.openResultFile(ctx, s3URL, len(columns))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	writer, err := format.NewWriter(w, columns)
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read results: %v", err)
+		}
+
+		for i, column := range columns {
+			values[i] = typedValue(column.Type, record[i])
+		}
+		if err := writer.WriteRow(values); err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// Helper function to strip the parameters off a type such as decimal(10,2)
+func athenaBaseType(columnType string) string {
+	if i := strings.IndexByte(columnType, '('); i >= 0 {
+		return columnType[:i]
+	}
+	return columnType
+}
+
+// Helper function to convert a CSV field to the Go value of its Athena type.
+// Athena writes NULL as an empty field, so empty non-string fields are nil.
+// Values that don't parse are kept as strings rather than dropped.
+func typedValue(columnType, raw string) interface{} {
+	baseType := athenaBaseType(columnType)
+
+	switch baseType {
+	case "varchar", "char", "string":
+		return raw
+	}
+	if raw == "" {
+		return nil
+	}
+
+	switch baseType {
+	case "boolean":
+		if value, err := strconv.ParseBool(raw); err == nil {
+			return value
+		}
+	case "tinyint", "smallint", "integer", "int", "bigint":
+		if value, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return value
+		}
+	case "real", "float", "double":
+		if value, err := strconv.ParseFloat(raw, 64); err == nil {
+			return value
+		}
+	case "decimal":
+		// Kept exact; json.Number is written as a number without going through float64
+		if _, err := strconv.ParseFloat(raw, 64); err == nil {
+			return json.Number(raw)
+		}
+	case "date":
+		if value, err := time.Parse("2006-01-02", raw); err == nil {
+			return value
+		}
+	case "timestamp":
+		if value, err := time.Parse(athenaTimestampLayout, raw); err == nil {
+			return value
+		}
+	}
+	return raw
+}
+
+// jsonResultWriter writes rows as objects keyed by column name, either as a
+// JSON array or as newline delimited JSON
+type jsonResultWriter struct {
+	w       *bufio.Writer
+	columns []resultColumn
+	keys    [][]byte
+	array   bool
+	rows    int
+}
+
+// Helper function to make column names unique; Athena allows duplicates, object
+// keys and Parquet columns don't
+func uniqueColumnNames(columns []resultColumn) []string {
+	seen := map[string]bool{}
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		name := column.Name
+		for n := 2; seen[name]; n++ {
+			name = fmt.Sprintf("%s_%d", column.Name, n)
+		}
+		seen[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+func newJSONResultWriter(w io.Writer, columns []resultColumn, array bool) *jsonResultWriter {
+	keys := make([][]byte, len(columns))
+	for i, name := range uniqueColumnNames(columns) {
+		keys[i], _ = json.Marshal(name)
+	}
+	return &jsonResultWriter{w: bufio.NewWriter(w), columns: columns, keys: keys, array: array}
+}
+
+func (j *jsonResultWriter) WriteRow(values []interface{}) error {
+	switch {
+	case !j.array:
+	case j.rows == 0:
+		j.w.WriteString("[\n")
+	default:
+		j.w.WriteString(",\n")
+	}
+	j.rows++
+
+	j.w.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		j.w.Write(j.keys[i])
+		j.w.WriteByte(':')
+
+		switch v := value.(type) {
+		case float64:
+			// JSON has no NaN or Infinity
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				value = strconv.FormatFloat(v, 'g', -1, 64)
+			}
+		case time.Time:
+			// Dates and timestamps are written the way Athena displays them
+			if j.columns[i].Type == "date" {
+				value = v.Format("2006-01-02")
+			} else {
+				value = v.Format("2006-01-02 15:04:05.000")
+			}
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		j.w.Write(data)
+	}
+	j.w.WriteByte('}')
+
+	if !j.array {
+		j.w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (j *jsonResultWriter) Close() error {
+	if j.array {
+		if j.rows == 0 {
+			j.w.WriteString("[")
+		}
+		j.w.WriteString("\n]\n")
+	}
+	return j.w.Flush()
+}
+
+// parquetColumns is a Parquet group that keeps the column order of the results;
+// parquet.Group orders its fields by name
+type parquetColumns struct {
+	parquet.Group
+	names []string
+}
+
+type parquetColumn struct {
+	parquet.Node
+	name string
+}
+
+func (c parquetColumn) Name() string { return c.name }
+
+func (c parquetColumn) Value(base reflect.Value) reflect.Value {
+	return base.MapIndex(reflect.ValueOf(c.name))
+}
+
+func (g parquetColumns) Fields() []parquet.Field {
+	fields := make([]parquet.Field, len(g.names))
+	for i, name := range g.names {
+		fields[i] = parquetColumn{Node: g.Group[name], name: name}
+	}
+	return fields
+}
+
+// Helper function to choose the Parquet type of an Athena column. Decimals and
+// nested types are written as strings.
+func parquetNode(columnType string) parquet.Node {
+	switch athenaBaseType(columnType) {
+	case "boolean":
+		return parquet.Optional(parquet.Leaf(parquet.BooleanType))
+	case "tinyint", "smallint", "integer", "int", "bigint":
+		return parquet.Optional(parquet.Int(64))
+	case "real", "float", "double":
+		return parquet.Optional(parquet.Leaf(parquet.DoubleType))
+	case "date":
+		return parquet.Optional(parquet.Date())
+	case "timestamp":
+		return parquet.Optional(parquet.Timestamp(parquet.Millisecond))
+	default:
+		return parquet.Optional(parquet.String())
+	}
+}
+
+type parquetResultWriter struct {
+	writer *parquet.Writer
+	nodes  []parquet.Node
+	rows   []parquet.Row
+}
+
+func newParquetResultWriter(w io.Writer, columns []resultColumn) (resultWriter, error) {
+	if len(columns) == 0 {
+		return nil, errNoParquetColumns
+	}
+
+	group := parquetColumns{Group: parquet.Group{}}
+	nodes := make([]parquet.Node, len(columns))
+	for i, name := range uniqueColumnNames(columns) {
+		nodes[i] = parquetNode(columns[i].Type)
+		group.Group[name] = nodes[i]
+		group.names = append(group.names, name)
+	}
+
+	schema := parquet.NewSchema("results", group)
+	writer := parquet.NewWriter(w, schema, parquet.Compression(&parquet.Snappy))
+	return &parquetResultWriter{writer: writer, nodes: nodes}, nil
+}
+
+// Helper function to convert a typed value into a Parquet value of its column
+func parquetValue(node parquet.Node, value interface{}) parquet.Value {
+	if value == nil {
+		return parquet.NullValue()
+	}
+
+	kind := node.Type().Kind()
+	switch v := value.(type) {
+	case bool:
+		if kind == parquet.Boolean {
+			return parquet.BooleanValue(v)
+		}
+	case int64:
+		if kind == parquet.Int64 {
+			return parquet.Int64Value(v)
+		}
+	case float64:
+		if kind == parquet.Double {
+			return parquet.DoubleValue(v)
+		}
+	case time.Time:
+		switch kind {
+		case parquet.Int32:
+			return parquet.Int32Value(int32(v.Unix() / 86400))
+		case parquet.Int64:
+			return parquet.Int64Value(v.UnixMilli())
+		}
+	case json.Number:
+		return parquet.ByteArrayValue([]byte(v))
+	case string:
+		if kind == parquet.ByteArray {
+			return parquet.ByteArrayValue([]byte(v))
+		}
+	}
+
+	// A value that didn't parse as the column type
+	return parquet.NullValue()
+}
+
+func (p *parquetResultWriter) WriteRow(values []interface{}) error {
+	row := make(parquet.Row, len(values))
+	for i, value := range values {
+		v := parquetValue(p.nodes[i], value)
+		definitionLevel := 1
+		if v.IsNull() {
+			definitionLevel = 0
+		}
+		row[i] = v.Level(0, definitionLevel, i)
+	}
+
+	p.rows = append(p.rows, row)
+	if len(p.rows) < parquetRowGroupSize {
+		return nil
+	}
+	return p.flush()
+}
+
+// Helper function to write the buffered rows as a row group
+func (p *parquetResultWriter) flush() error {
+	if len(p.rows) == 0 {
+		return nil
+	}
+	if _, err := p.writer.WriteRows(p.rows); err != nil {
+		return err
+	}
+	p.rows = p.rows[:0]
+	return p.writer.Flush()
+}
+
+func (p *parquetResultWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return p.writer.Close()
+}
+
+// xlsxResultWriter writes rows with excelize's stream writer, which spills to
+// a temporary file instead of keeping the sheet in memory. Results longer than
+// a sheet continue on additional sheets.
+type xlsxResultWriter struct {
+	w       io.Writer
+	file    *excelize.File
+	stream  *excelize.StreamWriter
+	header  []interface{}
+	sheets  int
+	row     int
+	dateID  int
+	timeID  int
+	columns []resultColumn
+}
+
+func newXLSXResultWriter(w io.Writer, columns []resultColumn) (resultWriter, error) {
+	x := &xlsxResultWriter{w: w, file: excelize.NewFile(), columns: columns}
+
+	var err error
+	if x.dateID, err = x.file.NewStyle(&excelize.Style{NumFmt: 14}); err != nil {
+		return nil, err
+	}
+	if x.timeID, err = x.file.NewStyle(&excelize.Style{NumFmt: 22}); err != nil {
+		return nil, err
+	}
+
+	for _, column := range columns {
+		x.header = append(x.header, column.Name)
+	}
+	if err := x.nextSheet(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// Helper function to finish the current sheet and start the next with the header row
+func (x *xlsxResultWriter) nextSheet() error {
+	if x.stream != nil {
+		if err := x.stream.Flush(); err != nil {
+			return err
+		}
+	}
+
+	x.sheets++
+	name := "Results"
+	if x.sheets > 1 {
+		name = fmt.Sprintf("Results %d", x.sheets)
+		if _, err := x.file.NewSheet(name); err != nil {
+			return err
+		}
+	} else if err := x.file.SetSheetName("Sheet1", name); err != nil {
+		return err
+	}
+
+	stream, err := x.file.NewStreamWriter(name)
+	if err != nil {
+		return err
+	}
+	x.stream = stream
+	x.row = 1
+	return x.stream.SetRow("A1", x.header)
+}
+
+func (x *xlsxResultWriter) WriteRow(values []interface{}) error {
+	if x.row == excelize.TotalRows {
+		if err := x.nextSheet(); err != nil {
+			return err
+		}
+	}
+	x.row++
+
+	cells := make([]interface{}, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case time.Time:
+			style := x.timeID
+			if x.columns[i].Type == "date" {
+				style = x.dateID
+			}
+			cells[i] = excelize.Cell{StyleID: style, Value: v}
+		case json.Number:
+			cells[i] = string(v)
+			if f, err := v.Float64(); err == nil {
+				cells[i] = f
+			}
+		default:
+			cells[i] = value
+		}
+	}
+
+	cell, err := excelize.CoordinatesToCellName(1, x.row)
+	if err != nil {
+		return err
+	}
+	return x.stream.SetRow(cell, cells)
+}
+
+func (x *xlsxResultWriter) Close() error {
+	defer x.file.Close()
+
+	if err := x.stream.Flush(); err != nil {
+		return err
+	}
+	return x.file.Write(x.w)
+}
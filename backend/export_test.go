@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/parquet-go/parquet-go"
+	"github.com/xuri/excelize/v2"
+)
+
+// Helper function to run a scripted query and return its execution ID
+func runScriptedQuery(t *testing.T, ts *testServer, script fakeQuery) string {
+	t.Helper()
+	ts.athena.Script("from scripted", script)
+	query := createTestQuery(t, ts, "Scripted", "SELECT * FROM scripted")
+	run := runTestQuery(t, ts, query, nil)
+	if run.Status != "SUCCEEDED" {
+		t.Fatalf("run %s, want SUCCEEDED", run.Status)
+	}
+	return run.ExecutionID
+}
+
+var exportTestScript = fakeQuery{
+	Columns: []string{"name", "orders", "amount", "day", "active"},
+	Types:   []string{"varchar", "bigint", "decimal(10,2)", "date", "boolean"},
+	Rows: [][]string{
+		{"Ann", "3", "12.50", "2024-01-02", "true"},
+		{"Bob", "", "", "", ""},
+	},
+}
+
+func TestExportFormats(t *testing.T) {
+	tests := []struct {
+		format      string
+		contentType string
+		check       func(t *testing.T, body []byte)
+	}{
+		{
+			format:      "csv",
+			contentType: "text/csv",
+			check: func(t *testing.T, body []byte) {
+				if !strings.HasPrefix(string(body), "name,orders,amount,day,active\nAnn,3,12.50,2024-01-02,true\n") {
+					t.Errorf("CSV %q", body)
+				}
+			},
+		},
+		{
+			format:      "json",
+			contentType: "application/json",
+			check: func(t *testing.T, body []byte) {
+				var rows []map[string]interface{}
+				if err := json.Unmarshal(body, &rows); err != nil {
+					t.Fatalf("%v: %s", err, body)
+				}
+				want := []map[string]interface{}{
+					{"name": "Ann", "orders": 3.0, "amount": 12.5, "day": "2024-01-02", "active": true},
+					{"name": "Bob", "orders": nil, "amount": nil, "day": nil, "active": nil},
+				}
+				if !reflect.DeepEqual(rows, want) {
+					t.Errorf("rows %v, want %v", rows, want)
+				}
+			},
+		},
+		{
+			format:      "ndjson",
+			contentType: "application/x-ndjson",
+			check: func(t *testing.T, body []byte) {
+				lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+				if len(lines) != 2 || lines[0] != `{"name":"Ann","orders":3,"amount":12.50,"day":"2024-01-02","active":true}` {
+					t.Errorf("NDJSON %q", body)
+				}
+			},
+		},
+		{
+			format:      "parquet",
+			contentType: "application/vnd.apache.parquet",
+			check: func(t *testing.T, body []byte) {
+				file, err := parquet.OpenFile(bytes.NewReader(body), int64(len(body)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, field := range file.Schema().Fields() {
+					names = append(names, field.Name())
+				}
+				if !reflect.DeepEqual(names, exportTestScript.Columns) {
+					t.Errorf("columns %v, want %v", names, exportTestScript.Columns)
+				}
+				if file.NumRows() != 2 {
+					t.Errorf("%d rows, want 2", file.NumRows())
+				}
+			},
+		},
+		{
+			format:      "xlsx",
+			contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			check: func(t *testing.T, body []byte) {
+				file, err := excelize.OpenReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows, err := file.GetRows("Results")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(rows) != 3 || !reflect.DeepEqual(rows[0], exportTestScript.Columns) || rows[1][0] != "Ann" {
+					t.Errorf("sheet %v", rows)
+				}
+			},
+		},
+	}
+
+	ts := newTestServer(t)
+	executionID := runScriptedQuery(t, ts, exportTestScript)
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, "/api/athena/export/"+executionID+"?format="+tt.format, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type %q, want %q", got, tt.contentType)
+			}
+			if got := rec.Header().Get("Content-Disposition"); !strings.HasSuffix(got, "."+tt.format) {
+				t.Errorf("Content-Disposition %q has no .%s file name", got, tt.format)
+			}
+			tt.check(t, rec.Body.Bytes())
+		})
+	}
+}
+
+func TestExportWithoutColumns(t *testing.T) {
+	tests := []struct {
+		format string
+		status int
+	}{
+		{format: "csv", status: http.StatusOK},
+		{format: "json", status: http.StatusOK},
+		{format: "ndjson", status: http.StatusOK},
+		{format: "xlsx", status: http.StatusOK},
+		{format: "parquet", status: http.StatusUnprocessableEntity},
+	}
+
+	ts := newTestServer(t)
+	executionID := runScriptedQuery(t, ts, fakeQuery{})
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, "/api/athena/export/"+executionID+"?format="+tt.format, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+
+	if _, err := newParquetResultWriter(&bytes.Buffer{}, nil); err != errNoParquetColumns {
+		t.Errorf("newParquetResultWriter without columns returned %v", err)
+	}
+}
+
+func TestExportInvalidRequests(t *testing.T) {
+	ts := newTestServer(t)
+	executionID := runScriptedQuery(t, ts, exportTestScript)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{name: "unknown format", path: "/api/athena/export/" + executionID + "?format=avro", status: http.StatusBadRequest},
+		{name: "unknown link", path: "/api/athena/export/" + executionID + "?link=email", status: http.StatusBadRequest},
+		{name: "link to a converted export", path: "/api/athena/export/" + executionID + "?format=json&link=json", status: http.StatusBadRequest},
+		{name: "unknown execution", path: "/api/athena/export/fake-404", status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doJSON[gin.H](t, ts, http.MethodGet, tt.path, nil, tt.status)
+		})
+	}
+}
@@ -0,0 +1,726 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/glue"
+	"github.com/aws/aws-sdk-go/service/glue/glueiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// How many rows the fake returns per GetQueryResults page unless scripted otherwise
+const defaultFakePageSize = 1000
+
+// fakeQuery scripts how the fake Athena answers a statement
+type fakeQuery struct {
+	States  []string // Reported by successive GetQueryExecution calls; the last one sticks
+	Reason  string   // State change reason once the query FAILED or was CANCELLED
+	Columns []string
+	Types   []string // Athena types of the columns, varchar when missing
+	Rows    [][]string
+}
+
+type fakeScript struct {
+	contains string
+	query    fakeQuery
+}
+
+type fakeExecution struct {
+	input          *athena.StartQueryExecutionInput
+	query          fakeQuery
+	polls          int
+	cancelled      bool
+	written        bool // Whether the result file was written, which Athena does once
+	outputLocation string
+	submittedAt    time.Time
+}
+
+// fakeAthena is an in-process stand-in for Athena used by ATHENA_DRIVER=fake
+// and handler tests. Statements succeed immediately with no rows unless a
+// script matches them. Operations it doesn't implement panic through the nil
+// embedded interface.
+type fakeAthena struct {
+	athenaiface.AthenaAPI
+
+	mu         sync.Mutex
+	s3         *fakeS3
+	scripts    []fakeScript
+	executions map[string]*fakeExecution
+	nextID     int
+	pageSize   int64
+	failures   map[string]error
+	databases  map[string][]*athena.TableMetadata // Of AwsDataCatalog
+	catalogs   []*athena.DataCatalogSummary       // Other catalogs, which have no databases
+	workGroups []string
+}
+
+func newFakeAthena(s3Client *fakeS3) *fakeAthena {
+	return &fakeAthena{
+		s3:         s3Client,
+		executions: map[string]*fakeExecution{},
+		pageSize:   defaultFakePageSize,
+		failures:   map[string]error{},
+		databases:  map[string][]*athena.TableMetadata{},
+		workGroups: []string{"primary"},
+	}
+}
+
+// Script makes statements containing the given text (case-insensitive) answer
+// with query. Later scripts take precedence over earlier ones.
+func (f *fakeAthena) Script(contains string, query fakeQuery) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scripts = append(f.scripts, fakeScript{contains: strings.ToLower(contains), query: query})
+}
+
+// FailNext makes the next call of an operation (such as "StartQueryExecution") return err
+func (f *fakeAthena) FailNext(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[operation] = err
+}
+
+// SetPageSize limits how many rows each GetQueryResults page holds
+func (f *fakeAthena) SetPageSize(size int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pageSize = size
+}
+
+// AddTable adds a table to the fake catalog, creating its database if needed
+func (f *fakeAthena) AddTable(database string, table *athena.TableMetadata) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.databases[database] = append(f.databases[database], table)
+}
+
+// AddCatalog registers a data catalog besides AwsDataCatalog
+func (f *fakeAthena) AddCatalog(name, catalogType string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.catalogs = append(f.catalogs, &athena.DataCatalogSummary{CatalogName: aws.String(name), Type: aws.String(catalogType)})
+}
+
+// AddWorkGroup adds a workgroup besides primary
+func (f *fakeAthena) AddWorkGroup(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.workGroups = append(f.workGroups, name)
+}
+
+// Helper function to check that a catalog exists and whether it is AwsDataCatalog; callers hold f.mu
+func (f *fakeAthena) catalog(name *string) (bool, error) {
+	if aws.StringValue(name) == "" || aws.StringValue(name) == "AwsDataCatalog" {
+		return true, nil
+	}
+	for _, catalog := range f.catalogs {
+		if aws.StringValue(catalog.CatalogName) == aws.StringValue(name) {
+			return false, nil
+		}
+	}
+	return false, awserr.New(athena.ErrCodeInvalidRequestException,
+		fmt.Sprintf("Catalog %s was not found", aws.StringValue(name)), nil)
+}
+
+// Helper function to pop the scripted failure of an operation; callers hold f.mu
+func (f *fakeAthena) failure(operation string) error {
+	err := f.failures[operation]
+	delete(f.failures, operation)
+	return err
+}
+
+// Helper function to look up an execution; callers hold f.mu
+func (f *fakeAthena) execution(id *string) (*fakeExecution, error) {
+	execution, ok := f.executions[aws.StringValue(id)]
+	if !ok {
+		return nil, awserr.New(athena.ErrCodeInvalidRequestException,
+			fmt.Sprintf("QueryExecution %s was not found", aws.StringValue(id)), nil)
+	}
+	return execution, nil
+}
+
+// Helper function to read the current state of an execution; callers hold f.mu
+func (e *fakeExecution) state() string {
+	if e.cancelled {
+		return athena.QueryExecutionStateCancelled
+	}
+	if len(e.query.States) == 0 {
+		return athena.QueryExecutionStateSucceeded
+	}
+	if e.polls >= len(e.query.States) {
+		return e.query.States[len(e.query.States)-1]
+	}
+	return e.query.States[e.polls]
+}
+
+func (f *fakeAthena) StartQueryExecution(input *athena.StartQueryExecutionInput) (*athena.StartQueryExecutionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("StartQueryExecution"); err != nil {
+		return nil, err
+	}
+
+	sql := strings.ToLower(aws.StringValue(input.QueryString))
+	execution := &fakeExecution{input: input, submittedAt: time.Now()}
+	for i := len(f.scripts) - 1; i >= 0; i-- {
+		if strings.Contains(sql, f.scripts[i].contains) {
+			execution.query = f.scripts[i].query
+			break
+		}
+	}
+
+	f.nextID++
+	id := fmt.Sprintf("fake-%08d", f.nextID)
+	location := "s3://fake-results/"
+	if input.ResultConfiguration != nil && input.ResultConfiguration.OutputLocation != nil {
+		location = *input.ResultConfiguration.OutputLocation
+	}
+	execution.outputLocation = strings.TrimSuffix(location, "/") + "/" + id + ".csv"
+	f.executions[id] = execution
+
+	return &athena.StartQueryExecutionOutput{QueryExecutionId: aws.String(id)}, nil
+}
+
+func (f *fakeAthena) GetQueryExecution(input *athena.GetQueryExecutionInput) (*athena.GetQueryExecutionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("GetQueryExecution"); err != nil {
+		return nil, err
+	}
+
+	execution, err := f.execution(input.QueryExecutionId)
+	if err != nil {
+		return nil, err
+	}
+
+	state := execution.state()
+	execution.polls++
+
+	status := &athena.QueryExecutionStatus{
+		State:              aws.String(state),
+		SubmissionDateTime: aws.Time(execution.submittedAt),
+	}
+	switch state {
+	case athena.QueryExecutionStateFailed, athena.QueryExecutionStateCancelled:
+		if execution.query.Reason != "" {
+			status.StateChangeReason = aws.String(execution.query.Reason)
+		}
+		status.CompletionDateTime = aws.Time(time.Now())
+	case athena.QueryExecutionStateSucceeded:
+		status.CompletionDateTime = aws.Time(time.Now())
+		if err := f.writeResults(execution); err != nil {
+			return nil, err
+		}
+	}
+
+	return &athena.GetQueryExecutionOutput{
+		QueryExecution: &athena.QueryExecution{
+			QueryExecutionId: input.QueryExecutionId,
+			Query:            execution.input.QueryString,
+			ResultConfiguration: &athena.ResultConfiguration{
+				OutputLocation: aws.String(execution.outputLocation),
+			},
+			Status: status,
+		},
+	}, nil
+}
+
+// Helper function to write the CSV Athena leaves in S3 for a succeeded query
+func (f *fakeAthena) writeResults(execution *fakeExecution) error {
+	if f.s3 == nil || execution.written {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(execution.query.Columns)
+	writer.WriteAll(execution.query.Rows)
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	bucket, key, err := parseS3URL(execution.outputLocation)
+	if err != nil {
+		return err
+	}
+	f.s3.putObject(bucket, key, buf.Bytes())
+	execution.written = true
+	return nil
+}
+
+func (f *fakeAthena) GetQueryResults(input *athena.GetQueryResultsInput) (*athena.GetQueryResultsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("GetQueryResults"); err != nil {
+		return nil, err
+	}
+
+	execution, err := f.execution(input.QueryExecutionId)
+	if err != nil {
+		return nil, err
+	}
+	if state := execution.state(); state != athena.QueryExecutionStateSucceeded {
+		return nil, awserr.New(athena.ErrCodeInvalidRequestException,
+			fmt.Sprintf("Query has not yet finished. Current state: %s", state), nil)
+	}
+
+	// Like Athena, the header row counts towards the first page
+	rows := append([][]string{execution.query.Columns}, execution.query.Rows...)
+
+	offset := 0
+	if input.NextToken != nil {
+		offset, err = strconv.Atoi(*input.NextToken)
+		if err != nil || offset < 0 || offset > len(rows) {
+			return nil, awserr.New(athena.ErrCodeInvalidRequestException, "Invalid NextToken", nil)
+		}
+	}
+
+	size := f.pageSize
+	if input.MaxResults != nil && *input.MaxResults < size {
+		size = *input.MaxResults
+	}
+	end := offset + int(size)
+	if end > len(rows) {
+		end = len(rows)
+	}
+
+	resultSet := &athena.ResultSet{ResultSetMetadata: &athena.ResultSetMetadata{}}
+	for i, column := range execution.query.Columns {
+		columnType := "varchar"
+		if i < len(execution.query.Types) {
+			columnType = execution.query.Types[i]
+		}
+		resultSet.ResultSetMetadata.ColumnInfo = append(resultSet.ResultSetMetadata.ColumnInfo, &athena.ColumnInfo{
+			Name: aws.String(column),
+			Type: aws.String(columnType),
+		})
+	}
+	for _, row := range rows[offset:end] {
+		data := make([]*athena.Datum, len(row))
+		for i, value := range row {
+			data[i] = &athena.Datum{VarCharValue: aws.String(value)}
+		}
+		resultSet.Rows = append(resultSet.Rows, &athena.Row{Data: data})
+	}
+
+	output := &athena.GetQueryResultsOutput{ResultSet: resultSet}
+	if end < len(rows) {
+		output.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return output, nil
+}
+
+func (f *fakeAthena) StopQueryExecution(input *athena.StopQueryExecutionInput) (*athena.StopQueryExecutionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("StopQueryExecution"); err != nil {
+		return nil, err
+	}
+
+	execution, err := f.execution(input.QueryExecutionId)
+	if err != nil {
+		return nil, err
+	}
+	switch execution.state() {
+	case athena.QueryExecutionStateQueued, athena.QueryExecutionStateRunning:
+		execution.cancelled = true
+	}
+	return &athena.StopQueryExecutionOutput{}, nil
+}
+
+func (f *fakeAthena) ListDatabases(input *athena.ListDatabasesInput) (*athena.ListDatabasesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("ListDatabases"); err != nil {
+		return nil, err
+	}
+
+	isDefault, err := f.catalog(input.CatalogName)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(f.databases))
+	for name := range f.databases {
+		if isDefault {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	start, end, next, err := fakeListPage(len(names), input.NextToken, input.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &athena.ListDatabasesOutput{NextToken: next}
+	for _, name := range names[start:end] {
+		output.DatabaseList = append(output.DatabaseList, &athena.Database{Name: aws.String(name)})
+	}
+	return output, nil
+}
+
+func (f *fakeAthena) ListTableMetadata(input *athena.ListTableMetadataInput) (*athena.ListTableMetadataOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("ListTableMetadata"); err != nil {
+		return nil, err
+	}
+
+	isDefault, err := f.catalog(input.CatalogName)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, ok := f.databases[aws.StringValue(input.DatabaseName)]
+	if !ok || !isDefault {
+		return nil, awserr.New(athena.ErrCodeMetadataException,
+			fmt.Sprintf("Database %s not found", aws.StringValue(input.DatabaseName)), nil)
+	}
+	start, end, next, err := fakeListPage(len(tables), input.NextToken, input.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	return &athena.ListTableMetadataOutput{TableMetadataList: tables[start:end], NextToken: next}, nil
+}
+
+func (f *fakeAthena) ListDataCatalogs(input *athena.ListDataCatalogsInput) (*athena.ListDataCatalogsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("ListDataCatalogs"); err != nil {
+		return nil, err
+	}
+
+	catalogs := append([]*athena.DataCatalogSummary{
+		{CatalogName: aws.String("AwsDataCatalog"), Type: aws.String(athena.DataCatalogTypeGlue)},
+	}, f.catalogs...)
+
+	start, end, next, err := fakeListPage(len(catalogs), input.NextToken, input.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	return &athena.ListDataCatalogsOutput{DataCatalogsSummary: catalogs[start:end], NextToken: next}, nil
+}
+
+func (f *fakeAthena) ListWorkGroups(input *athena.ListWorkGroupsInput) (*athena.ListWorkGroupsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("ListWorkGroups"); err != nil {
+		return nil, err
+	}
+
+	start, end, next, err := fakeListPage(len(f.workGroups), input.NextToken, input.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &athena.ListWorkGroupsOutput{NextToken: next}
+	for _, name := range f.workGroups[start:end] {
+		output.WorkGroups = append(output.WorkGroups, &athena.WorkGroupSummary{
+			Name:  aws.String(name),
+			State: aws.String(athena.WorkGroupStateEnabled),
+			EngineVersion: &athena.EngineVersion{
+				SelectedEngineVersion:  aws.String("AUTO"),
+				EffectiveEngineVersion: aws.String("Athena engine version 3"),
+			},
+		})
+	}
+	return output, nil
+}
+
+// Helper function to page through a listing of total items like the Athena
+// List operations do, with the offset of the next page as its token
+func fakeListPage(total int, token *string, maxResults *int64) (int, int, *string, error) {
+	start := 0
+	if aws.StringValue(token) != "" {
+		var err error
+		start, err = strconv.Atoi(aws.StringValue(token))
+		if err != nil || start < 0 || start > total {
+			return 0, 0, nil, awserr.New(athena.ErrCodeInvalidRequestException, "Invalid NextToken", nil)
+		}
+	}
+
+	end := total
+	if size := int(aws.Int64Value(maxResults)); size > 0 && start+size < total {
+		end = start + size
+	}
+
+	if end == total {
+		return start, end, nil, nil
+	}
+	return start, end, aws.String(strconv.Itoa(end)), nil
+}
+
+// fakeS3 is an in-memory object store holding the result files of fakeAthena
+type fakeS3 struct {
+	s3iface.S3API
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+	failures map[string]error
+
+	// Signs presigned URLs for a made up endpoint; nothing is sent to it
+	presigner *s3.S3
+}
+
+func newFakeS3() *fakeS3 {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String("http://fake-s3.localhost"),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("FAKEACCESSKEY", "fake-secret", ""),
+	}))
+
+	return &fakeS3{
+		objects:   map[string][]byte{},
+		modified:  map[string]time.Time{},
+		failures:  map[string]error{},
+		presigner: s3.New(sess),
+	}
+}
+
+// FailNext makes the next call of an operation (such as "GetObject") return err
+func (f *fakeS3) FailNext(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[operation] = err
+}
+
+// Helper function to pop the scripted failure of an operation; callers hold f.mu
+func (f *fakeS3) failure(operation string) error {
+	err := f.failures[operation]
+	delete(f.failures, operation)
+	return err
+}
+
+func (f *fakeS3) putObject(bucket, key string, body []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[bucket+"/"+key] = body
+	f.modified[bucket+"/"+key] = time.Now()
+}
+
+func (f *fakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return f.GetObjectWithContext(aws.BackgroundContext(), input)
+}
+
+// GetObjectWithContext answers single byte ranges and the IfMatch and
+// IfUnmodifiedSince conditions like S3 does, which compares dates in seconds
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	if err := f.failure("GetObject"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.Bucket) + "/" + aws.StringValue(input.Key)
+	body, ok := f.objects[name]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+
+	etag := fmt.Sprintf(`"%x"`, md5.Sum(body))
+	modified := f.modified[name]
+	if input.IfMatch != nil && aws.StringValue(input.IfMatch) != etag ||
+		input.IfUnmodifiedSince != nil && modified.Truncate(time.Second).After(*input.IfUnmodifiedSince) {
+		return nil, awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+	}
+
+	output := &s3.GetObjectOutput{
+		AcceptRanges: aws.String("bytes"),
+		ContentType:  aws.String("text/csv"),
+		ETag:         aws.String(etag),
+		LastModified: aws.Time(modified),
+	}
+
+	if input.Range != nil {
+		start, end, ok := fakeByteRange(aws.StringValue(input.Range), int64(len(body)))
+		if !ok {
+			return nil, awserr.New("InvalidRange", "The requested range is not satisfiable", nil)
+		}
+		output.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(body)))
+		body = body[start:end]
+	}
+
+	output.Body = io.NopCloser(bytes.NewReader(body))
+	output.ContentLength = aws.Int64(int64(len(body)))
+	return output, nil
+}
+
+// Helper function to resolve a "bytes=start-end", "bytes=start-" or "bytes=-suffix"
+// range of an object of the given size into [start, end)
+func fakeByteRange(header string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end++
+		if end > size {
+			end = size
+		}
+	}
+	return start, end, true
+}
+
+// GetObjectRequest only supports presigning; the request fails with the scripted
+// "GetObjectRequest" failure if there is one
+func (f *fakeS3) GetObjectRequest(input *s3.GetObjectInput) (*request.Request, *s3.GetObjectOutput) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	req, output := f.presigner.GetObjectRequest(input)
+	if err := f.failure("GetObjectRequest"); err != nil {
+		req.Error = err
+	}
+	return req, output
+}
+
+func (f *fakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	if err := f.failure("PutObject"); err != nil {
+		f.mu.Unlock()
+		return nil, err
+	}
+	f.mu.Unlock()
+
+	var body []byte
+	if input.Body != nil {
+		var err error
+		if body, err = io.ReadAll(input.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	f.putObject(aws.StringValue(input.Bucket), aws.StringValue(input.Key), body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("DeleteObjects"); err != nil {
+		return nil, err
+	}
+
+	output := &s3.DeleteObjectsOutput{}
+	for _, object := range input.Delete.Objects {
+		delete(f.objects, aws.StringValue(input.Bucket)+"/"+aws.StringValue(object.Key))
+		delete(f.modified, aws.StringValue(input.Bucket)+"/"+aws.StringValue(object.Key))
+		output.Deleted = append(output.Deleted, &s3.DeletedObject{Key: object.Key})
+	}
+	return output, nil
+}
+
+// fakeGlue holds the partitions of the tables of fakeAthena, which has no
+// partitions unless they are added
+type fakeGlue struct {
+	glueiface.GlueAPI
+
+	mu         sync.Mutex
+	partitions map[string][]*glue.Partition // By database/table
+	failures   map[string]error
+}
+
+func newFakeGlue() *fakeGlue {
+	return &fakeGlue{
+		partitions: map[string][]*glue.Partition{},
+		failures:   map[string]error{},
+	}
+}
+
+// AddPartition adds a partition with the given values to a table
+func (f *fakeGlue) AddPartition(database, table string, values []string, createdAt time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := database + "/" + table
+	f.partitions[key] = append(f.partitions[key], &glue.Partition{
+		DatabaseName: aws.String(database),
+		TableName:    aws.String(table),
+		Values:       aws.StringSlice(values),
+		CreationTime: aws.Time(createdAt),
+		StorageDescriptor: &glue.StorageDescriptor{
+			Location: aws.String(fmt.Sprintf("s3://fake-data/%s/%s/%s/", database, table, strings.Join(values, "/"))),
+		},
+	})
+}
+
+// FailNext makes the next call of an operation (such as "GetPartitions") return err
+func (f *fakeGlue) FailNext(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[operation] = err
+}
+
+func (f *fakeGlue) GetPartitions(input *glue.GetPartitionsInput) (*glue.GetPartitionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failures["GetPartitions"]; err != nil {
+		delete(f.failures, "GetPartitions")
+		return nil, err
+	}
+
+	partitions := f.partitions[aws.StringValue(input.DatabaseName)+"/"+aws.StringValue(input.TableName)]
+	start, end, next, err := fakeListPage(len(partitions), input.NextToken, input.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	return &glue.GetPartitionsOutput{Partitions: partitions[start:end], NextToken: next}, nil
+}
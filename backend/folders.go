@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxFolderDepth = 10
+const maxTagLength = 64
+
+// Helper function to normalize a folder path such as "/Marketing//weekly/" into
+// "Marketing/weekly". The empty string is the root folder.
+func normalizeFolder(folder string) (string, error) {
+	var segments []string
+	for _, segment := range strings.Split(folder, "/") {
+		segment = strings.TrimSpace(segment)
+		if segment == "" {
+			continue
+		}
+		if segment == "." || segment == ".." {
+			return "", fmt.Errorf("folder segments cannot be %q", segment)
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(segments) > maxFolderDepth {
+		return "", fmt.Errorf("folders cannot be nested more than %d levels deep", maxFolderDepth)
+	}
+
+	return strings.Join(segments, "/"), nil
+}
+
+// Helper function to trim, lowercase and de-duplicate tags while keeping their order
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags cannot be longer than %d characters", maxTagLength)
+		}
+		if strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("tags cannot contain commas")
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	return result, nil
+}
+
+// Helper function to build the query listing filter from ?folder=&tag= parameters.
+// Folders match recursively unless recursive=false; multiple tags must all match.
+func queryListFilter(c *gin.Context) (QueryFilter, error) {
+	var filter QueryFilter
+
+	if rawFolder, ok := c.GetQuery("folder"); ok {
+		folder, err := normalizeFolder(rawFolder)
+		if err != nil {
+			return filter, err
+		}
+		filter.Folder = &folder
+		filter.Recursive = c.DefaultQuery("recursive", "true") != "false"
+	}
+
+	if rawTags := c.QueryArray("tag"); len(rawTags) > 0 {
+		tags, err := normalizeTags(rawTags)
+		if err != nil {
+			return filter, err
+		}
+		filter.Tags = tags
+	}
+
+	return filter, nil
+}
+
+// Helper function to write the response of a query update
+func respondWithUpdatedQuery(c *gin.Context, query Query, err error) {
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, query)
+}
+
+func (s *Server) moveQuery(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
+		return
+	}
+
+	var req MoveQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	folder, err := normalizeFolder(req.Folder)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	query, err := s.store.UpdateQuery(context.Background(), id, QueryUpdate{Folder: &folder, UpdatedAt: &now})
+	respondWithUpdatedQuery(c, query, err)
+}
+
+func (s *Server) setQueryTags(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
+		return
+	}
+
+	var req TagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	query, err := s.store.UpdateQuery(context.Background(), id, QueryUpdate{Tags: &tags, UpdatedAt: &now})
+	respondWithUpdatedQuery(c, query, err)
+}
+
+func (s *Server) addQueryTags(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
+		return
+	}
+
+	var req TagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	query, err := s.store.UpdateQuery(context.Background(), id, QueryUpdate{AddTags: tags, UpdatedAt: &now})
+	respondWithUpdatedQuery(c, query, err)
+}
+
+func (s *Server) removeQueryTag(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
+		return
+	}
+
+	tag := strings.ToLower(strings.TrimSpace(c.Param("tag")))
+
+	now := time.Now()
+	query, err := s.store.UpdateQuery(context.Background(), id, QueryUpdate{RemoveTags: []string{tag}, UpdatedAt: &now})
+	respondWithUpdatedQuery(c, query, err)
+}
+
+func (s *Server) getTags(c *gin.Context) {
+	tags, err := s.store.CountTags(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tags)
+}
+
+func (s *Server) getFolders(c *gin.Context) {
+	groups, err := s.store.CountFolders(context.Background())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Roll the counts up into every ancestor so parents include their subfolders
+	counts := map[string]int64{}
+	for folder, count := range groups {
+		segments := strings.Split(folder, "/")
+		for i := range segments {
+			counts[strings.Join(segments[:i+1], "/")] += count
+		}
+	}
+
+	folders := []FolderCount{}
+	for path, count := range counts {
+		segments := strings.Split(path, "/")
+		folders = append(folders, FolderCount{
+			Path:  path,
+			Name:  segments[len(segments)-1],
+			Depth: len(segments),
+			Count: count,
+		})
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].Path < folders[j].Path
+	})
+
+	c.JSON(http.StatusOK, folders)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeFolder(t *testing.T) {
+	tests := []struct {
+		folder  string
+		want    string
+		invalid bool
+	}{
+		{folder: "", want: ""},
+		{folder: "/", want: ""},
+		{folder: "/Marketing//weekly/", want: "Marketing/weekly"},
+		{folder: " sales / EU ", want: "sales/EU"},
+		{folder: "sales/../hr", invalid: true},
+		{folder: "./sales", invalid: true},
+		{folder: strings.Repeat("a/", maxFolderDepth), want: strings.TrimSuffix(strings.Repeat("a/", maxFolderDepth), "/")},
+		{folder: strings.Repeat("a/", maxFolderDepth+1), invalid: true},
+	}
+
+	for _, tt := range tests {
+		got, err := normalizeFolder(tt.folder)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("normalizeFolder(%q) = %q, want an error", tt.folder, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeFolder(%q) = %q, %v; want %q", tt.folder, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		tags    []string
+		want    []string
+		invalid bool
+	}{
+		{tags: nil, want: []string{}},
+		{tags: []string{" Finance", "weekly", "FINANCE ", "", "eu"}, want: []string{"finance", "weekly", "eu"}},
+		{tags: []string{strings.Repeat("x", maxTagLength)}, want: []string{strings.Repeat("x", maxTagLength)}},
+		{tags: []string{strings.Repeat("x", maxTagLength+1)}, invalid: true},
+		{tags: []string{"a,b"}, invalid: true},
+	}
+
+	for _, tt := range tests {
+		got, err := normalizeTags(tt.tags)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("normalizeTags(%q) = %q, want an error", tt.tags, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeTags(%q) = %q, %v; want %q", tt.tags, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoveAndTagQueries(t *testing.T) {
+	ts := newTestServer(t)
+	query := createTestQuery(t, ts, "Orders", "SELECT * FROM sales.orders")
+	path := "/api/queries/" + query.ID.Hex()
+
+	moved := doJSON[Query](t, ts, http.MethodPut, path+"/folder", gin.H{"folder": "/Sales//weekly/"}, http.StatusOK)
+	if moved.Folder != "Sales/weekly" || moved.UpdatedAt.Before(query.UpdatedAt) {
+		t.Errorf("moved to %q, updated at %v", moved.Folder, moved.UpdatedAt)
+	}
+
+	tagged := doJSON[Query](t, ts, http.MethodPut, path+"/tags", gin.H{"tags": []string{"Finance", "weekly", "finance"}}, http.StatusOK)
+	if !reflect.DeepEqual(tagged.Tags, []string{"finance", "weekly"}) {
+		t.Errorf("tags %v after setting them", tagged.Tags)
+	}
+	added := doJSON[Query](t, ts, http.MethodPost, path+"/tags", gin.H{"tags": []string{"EU", "weekly"}}, http.StatusOK)
+	if !reflect.DeepEqual(added.Tags, []string{"finance", "weekly", "eu"}) {
+		t.Errorf("tags %v after adding some", added.Tags)
+	}
+	removed := doJSON[Query](t, ts, http.MethodDelete, path+"/tags/Weekly", nil, http.StatusOK)
+	if !reflect.DeepEqual(removed.Tags, []string{"finance", "eu"}) {
+		t.Errorf("tags %v after removing weekly", removed.Tags)
+	}
+	if fetched := doJSON[Query](t, ts, http.MethodGet, path, nil, http.StatusOK); fetched.Folder != "Sales/weekly" || !reflect.DeepEqual(fetched.Tags, removed.Tags) {
+		t.Errorf("fetched folder %q and tags %v", fetched.Folder, fetched.Tags)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{name: "folder above the root", method: http.MethodPut, path: path + "/folder", body: gin.H{"folder": "../x"}, status: http.StatusBadRequest},
+		{name: "folder too deep", method: http.MethodPut, path: path + "/folder", body: gin.H{"folder": strings.Repeat("a/", maxFolderDepth+1)}, status: http.StatusBadRequest},
+		{name: "tag with a comma", method: http.MethodPut, path: path + "/tags", body: gin.H{"tags": []string{"a,b"}}, status: http.StatusBadRequest},
+		{name: "missing tags", method: http.MethodPost, path: path + "/tags", body: gin.H{}, status: http.StatusBadRequest},
+		{name: "unknown query", method: http.MethodPut, path: "/api/queries/0123456789abcdef01234567/folder", body: gin.H{"folder": "x"}, status: http.StatusNotFound},
+		{name: "invalid query ID", method: http.MethodDelete, path: "/api/queries/nope/tags/eu", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doJSON[gin.H](t, ts, tt.method, tt.path, tt.body, tt.status)
+		})
+	}
+}
+
+func TestFoldersAndTags(t *testing.T) {
+	ts := newTestServer(t)
+	for _, query := range []struct {
+		name   string
+		folder string
+		tags   []string
+	}{
+		{name: "Root", tags: []string{"finance"}},
+		{name: "Sales", folder: "sales", tags: []string{"finance", "eu"}},
+		{name: "Weekly", folder: "sales/weekly", tags: []string{"finance"}},
+		{name: "Daily EU", folder: "sales/daily/eu"},
+		{name: "Hiring", folder: "hr", tags: []string{"people"}},
+		{name: "Trashed", folder: "legal", tags: []string{"legal"}},
+	} {
+		created := doJSON[Query](t, ts, http.MethodPost, "/api/queries",
+			gin.H{"name": query.name, "sql": "SELECT 1", "folder": query.folder, "tags": query.tags}, http.StatusCreated)
+		if query.name == "Trashed" {
+			doJSON[gin.H](t, ts, http.MethodDelete, "/api/queries/"+created.ID.Hex(), nil, http.StatusOK)
+		}
+	}
+
+	// Parents count the queries of their subfolders, and trashed queries don't count
+	folders := doJSON[[]FolderCount](t, ts, http.MethodGet, "/api/folders", nil, http.StatusOK)
+	want := []FolderCount{
+		{Path: "hr", Name: "hr", Depth: 1, Count: 1},
+		{Path: "sales", Name: "sales", Depth: 1, Count: 3},
+		{Path: "sales/daily", Name: "daily", Depth: 2, Count: 1},
+		{Path: "sales/daily/eu", Name: "eu", Depth: 3, Count: 1},
+		{Path: "sales/weekly", Name: "weekly", Depth: 2, Count: 1},
+	}
+	if !reflect.DeepEqual(folders, want) {
+		t.Errorf("folders %+v, want %+v", folders, want)
+	}
+
+	tags := doJSON[[]TagCount](t, ts, http.MethodGet, "/api/tags", nil, http.StatusOK)
+	wantTags := []TagCount{{Tag: "finance", Count: 3}, {Tag: "eu", Count: 1}, {Tag: "people", Count: 1}}
+	if !reflect.DeepEqual(tags, wantTags) {
+		t.Errorf("tags %+v, want %+v", tags, wantTags)
+	}
+
+	// Listings filter on folders, recursively by default, and on every tag given
+	tests := []struct {
+		params string
+		names  []string
+	}{
+		{params: "folder=sales", names: []string{"Daily EU", "Sales", "Weekly"}},
+		{params: "folder=/sales/&recursive=false", names: []string{"Sales"}},
+		{params: "folder=&recursive=false", names: []string{"Root"}},
+		{params: "tag=finance", names: []string{"Root", "Sales", "Weekly"}},
+		{params: "tag=FINANCE&tag=eu", names: []string{"Sales"}},
+		{params: "folder=sales&tag=people", names: []string{}},
+	}
+	for _, tt := range tests {
+		queries := doJSON[[]Query](t, ts, http.MethodGet, "/api/queries?sort=name&order=asc&"+tt.params, nil, http.StatusOK)
+		names := []string{}
+		for _, query := range queries {
+			names = append(names, query.Name)
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%s: queries %v, want %v", tt.params, names, tt.names)
+		}
+	}
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/queries?folder=../x", nil, http.StatusBadRequest)
+}
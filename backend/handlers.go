@@ -2,16 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Helper function to substitute parameters in SQL
@@ -26,72 +28,117 @@ func substituteParameters(sql string, parameters map[string]string) string {
 	return result
 }
 
+// Helper function to identify the caller. Zeus runs behind an authenticating
+// proxy which forwards the user name; anonymous requests return "".
+func requestUser(c *gin.Context) string {
+	if user := c.GetHeader("X-Forwarded-User"); user != "" {
+		return user
+	}
+	return c.GetHeader("X-Forwarded-Email")
+}
+
 // Query handlers
-func getQueries(c *gin.Context) {
-	ctx := context.Background()
-	collection := db.Collection("queries")
+func (s *Server) getQueries(c *gin.Context) {
+	filter, err := queryListFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	cursor, err := collection.Find(ctx, bson.M{})
+	filter.CreatedAfter, filter.CreatedBefore, err = parseDateRange(c, "createdAfter", "createdBefore")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.UpdatedAfter, filter.UpdatedBefore, err = parseDateRange(c, "updatedAfter", "updatedBefore")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var queries []Query
-	if err := cursor.All(ctx, &queries); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	page, err := parsePageRequest(c, querySortFields, "updated")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if queries == nil {
-		queries = []Query{}
+	queries, next, total, err := s.store.ListQueries(context.Background(), filter, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
+	setPaginationHeaders(c, total, next)
 	c.JSON(http.StatusOK, queries)
 }
 
-func createQuery(c *gin.Context) {
+func (s *Server) createQuery(c *gin.Context) {
 	var req CreateQueryRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	folder, err := normalizeFolder(req.Folder)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ResultCacheTTL != nil && *req.ResultCacheTTL < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "resultCacheTtl cannot be negative"})
+		return
+	}
+
+	if err := sqlSizeError(req.SQL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	refs := parseSQLReferences(req.SQL)
 	query := Query{
 		Name:        req.Name,
 		SQL:         req.SQL,
 		Description: req.Description,
+		Folder:      folder,
+		Tags:        tags,
+		Tables:      refs.Tables,
+		Databases:   refs.Databases,
+		Columns:     refs.Columns,
+		Lineage:     refs.Lineage,
+		CreatedBy:   requestUser(c),
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
-	}
 
-	ctx := context.Background()
-	collection := db.Collection("queries")
+		ResultCacheTTL: req.ResultCacheTTL,
+		Catalog:        strings.TrimSpace(req.Catalog),
+		Database:       strings.TrimSpace(req.Database),
+		WorkGroup:      strings.TrimSpace(req.WorkGroup),
+	}
 
-	result, err := collection.InsertOne(ctx, query)
-	if err != nil {
+	if err := s.store.CreateQuery(context.Background(), &query); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	query.ID = result.InsertedID.(primitive.ObjectID)
 	c.JSON(http.StatusCreated, query)
 }
 
-func getQuery(c *gin.Context) {
+func (s *Server) getQuery(c *gin.Context) {
 	id, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
 		return
 	}
 
-	ctx := context.Background()
-	collection := db.Collection("queries")
-
-	var query Query
-	err = collection.FindOne(ctx, bson.M{"_id": id}).Decode(&query)
-	if err != nil {
+	query, err := s.store.GetQuery(context.Background(), id)
+	if err != nil || query.DeletedAt != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Query not found"})
 		return
 	}
@@ -99,7 +146,7 @@ func getQuery(c *gin.Context) {
 	c.JSON(http.StatusOK, query)
 }
 
-func updateQuery(c *gin.Context) {
+func (s *Server) updateQuery(c *gin.Context) {
 	id, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
@@ -112,110 +159,258 @@ func updateQuery(c *gin.Context) {
 		return
 	}
 
-	ctx := context.Background()
-	collection := db.Collection("queries")
+	if err := sqlSizeError(req.SQL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	refs := parseSQLReferences(req.SQL)
+	now := time.Now()
+	query, err := s.store.UpdateQuery(context.Background(), id, QueryUpdate{
+		Name:        &req.Name,
+		SQL:         &req.SQL,
+		Description: &req.Description,
+		References:  &refs,
+		UpdatedAt:   &now,
+	})
+	respondWithUpdatedQuery(c, query, err)
+}
+
+// Helper function to turn a JSON Merge Patch (RFC 7396) into a query update.
+// Only the fields present in the patch are changed and null clears optional
+// fields. Problems are reported per field so the client can show them inline.
+func parseQueryPatch(body []byte) (QueryUpdate, map[string]string, error) {
+	var update QueryUpdate
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return update, nil, err
+	}
+	if patch == nil {
+		return update, nil, fmt.Errorf("patch must be a JSON object")
+	}
+
+	fieldErrors := map[string]string{}
+
+	for field, raw := range patch {
+		switch field {
+		case "name", "sql", "description", "catalog", "database", "workGroup":
+		case "folder":
+			if err := patchFolder(raw, &update); err != nil {
+				fieldErrors[field] = err.Error()
+			}
+			continue
+		case "tags":
+			if err := patchTags(raw, &update); err != nil {
+				fieldErrors[field] = err.Error()
+			}
+			continue
+		case "resultCacheTtl":
+			if err := patchResultCacheTTL(raw, &update); err != nil {
+				fieldErrors[field] = err.Error()
+			}
+			continue
+		default:
+			fieldErrors[field] = "unknown field"
+			continue
+		}
+
+		var value string
+		if string(raw) == "null" {
+			if field == "name" || field == "sql" {
+				fieldErrors[field] = field + " cannot be removed"
+				continue
+			}
+		} else if err := json.Unmarshal(raw, &value); err != nil {
+			fieldErrors[field] = "must be a string"
+			continue
+		}
+
+		switch field {
+		case "name":
+			if strings.TrimSpace(value) == "" {
+				fieldErrors[field] = "name cannot be empty"
+				continue
+			}
+			update.Name = &value
+		case "sql":
+			if strings.TrimSpace(value) == "" {
+				fieldErrors[field] = "sql cannot be empty"
+				continue
+			}
+			if err := sqlSizeError(value); err != nil {
+				fieldErrors[field] = err.Error()
+				continue
+			}
+			refs := parseSQLReferences(value)
+			update.SQL = &value
+			update.References = &refs
+		case "description":
+			update.Description = &value
+		case "catalog":
+			value = strings.TrimSpace(value)
+			update.Catalog = &value
+		case "database":
+			value = strings.TrimSpace(value)
+			update.Database = &value
+		case "workGroup":
+			value = strings.TrimSpace(value)
+			update.WorkGroup = &value
+		}
+	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"name":        req.Name,
-			"sql":         req.SQL,
-			"description": req.Description,
-			"updatedAt":   time.Now(),
-		},
+	return update, fieldErrors, nil
+}
+
+// Helper function to apply a "folder" merge patch value, null moves to the root
+func patchFolder(raw json.RawMessage, update *QueryUpdate) error {
+	var folder string
+	if string(raw) != "null" {
+		if err := json.Unmarshal(raw, &folder); err != nil {
+			return fmt.Errorf("must be a string")
+		}
 	}
 
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	folder, err := normalizeFolder(folder)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return err
 	}
+	update.Folder = &folder
+	return nil
+}
 
-	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Query not found"})
-		return
+// Helper function to apply a "tags" merge patch value, null removes all tags
+func patchTags(raw json.RawMessage, update *QueryUpdate) error {
+	var tags []string
+	if string(raw) != "null" {
+		if err := json.Unmarshal(raw, &tags); err != nil {
+			return fmt.Errorf("must be an array of strings")
+		}
 	}
 
-	var updatedQuery Query
-	err = collection.FindOne(ctx, bson.M{"_id": id}).Decode(&updatedQuery)
+	tags, err := normalizeTags(tags)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return err
 	}
+	update.Tags = &tags
+	return nil
+}
 
-	c.JSON(http.StatusOK, updatedQuery)
+// Helper function to apply a "resultCacheTtl" merge patch value, null falls back to the default TTL
+func patchResultCacheTTL(raw json.RawMessage, update *QueryUpdate) error {
+	ttl := -1
+	if string(raw) != "null" {
+		if err := json.Unmarshal(raw, &ttl); err != nil || ttl < 0 {
+			return fmt.Errorf("must be a non-negative number of seconds")
+		}
+	}
+
+	update.ResultCacheTTL = &ttl
+	return nil
 }
 
-func deleteQuery(c *gin.Context) {
+func (s *Server) patchQuery(c *gin.Context) {
 	id, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
 		return
 	}
 
-	ctx := context.Background()
-	collection := db.Collection("queries")
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	result, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	update, fieldErrors, err := parseQueryPatch(body)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON merge patch: " + err.Error()})
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Query not found"})
+	if len(fieldErrors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Validation failed", "fields": fieldErrors})
+		return
+	}
+
+	now := time.Now()
+	update.UpdatedAt = &now
+	query, err := s.store.UpdateQuery(context.Background(), id, update)
+	respondWithUpdatedQuery(c, query, err)
+}
+
+// Queries are moved to the trash rather than deleted. Their runs are trashed with
+// the same timestamp so restoring the query brings them back too.
+func (s *Server) deleteQuery(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
 		return
 	}
 
-	// Also delete associated query runs
-	runsCollection := db.Collection("queryruns")
-	runsCollection.DeleteMany(ctx, bson.M{"queryId": id})
+	err = s.store.TrashQuery(context.Background(), id, requestUser(c), time.Now())
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Query deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Query moved to trash"})
 }
 
 // Query run handlers
-func getQueryRuns(c *gin.Context) {
+func (s *Server) getQueryRuns(c *gin.Context) {
 	queryID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
 		return
 	}
 
-	ctx := context.Background()
-	collection := db.Collection("queryruns")
+	filter := QueryRunFilter{QueryID: &queryID}
 
-	opts := options.Find().SetSort(bson.D{{Key: "executedAt", Value: -1}})
-	cursor, err := collection.Find(ctx, bson.M{"queryId": queryID}, opts)
+	filter.Statuses, err = parseStatusFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var runs []QueryRun
-	if err := cursor.All(ctx, &runs); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	filter.ExecutedAfter, filter.ExecutedBefore, err = parseDateRange(c, "executedAfter", "executedBefore")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if runs == nil {
-		runs = []QueryRun{}
+	page, err := parsePageRequest(c, queryRunSortFields, "executed")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+
+	runs, next, total, err := s.store.ListQueryRuns(ctx, filter, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Update status of non-final queries
 	for i, run := range runs {
 		if run.Status == "RUNNING" || run.Status == "QUEUED" {
-			updatedRun, err := updateQueryRunStatus(ctx, collection, run)
+			updatedRun, err := s.updateQueryRunStatus(ctx, run)
 			if err == nil {
 				runs[i] = updatedRun
 			}
 		}
 	}
 
+	setPaginationHeaders(c, total, next)
 	c.JSON(http.StatusOK, runs)
 }
 
-func executeQuery(c *gin.Context) {
+func (s *Server) executeQuery(c *gin.Context) {
 	queryID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
@@ -228,65 +423,102 @@ func executeQuery(c *gin.Context) {
 		return
 	}
 
-	// Substitute parameters in SQL
-	finalSQL := substituteParameters(req.SQL, req.Parameters)
-
-	// Execute the query through Athena
-	executionID, err := executeAthenaQueryInternal(finalSQL)
+	override := executionContext{Catalog: req.Catalog, Database: req.Database, WorkGroup: req.WorkGroup}
+	queryRun, err := s.startQueryRun(context.Background(), queryID, req.SQL, req.Parameters, override, req.NoCache, requestUser(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.JSON(http.StatusCreated, queryRun)
+}
+
+// Helper function to run SQL for a saved query and record the run. Recent
+// results of the same SQL and parameters are reused instead of scanning again.
+// Fields of override replace where the saved query runs.
+func (s *Server) startQueryRun(ctx context.Context, queryID primitive.ObjectID, sql string, parameters map[string]string, override executionContext, noCache bool, executedBy string) (QueryRun, error) {
+	// Substitute parameters in SQL
+	finalSQL := substituteParameters(sql, parameters)
+
+	// Saved queries may override how long their results are reused for
+	var query *Query
+	if saved, err := s.store.GetQuery(ctx, queryID); err == nil {
+		query = &saved
+	}
+	ttl := s.queryResultCacheTTL(query)
+	if noCache {
+		ttl = 0
+	}
+	exec := s.resolveExecutionContext(query, override)
+
 	// Create query run record
 	queryRun := QueryRun{
-		QueryID:     queryID,
-		SQL:         req.SQL,
-		ExecutionID: executionID,
-		Status:      "QUEUED",
-		Parameters:  req.Parameters,
-		ExecutedAt:  time.Now(),
+		QueryID:    queryID,
+		SQL:        sql,
+		Status:     "QUEUED",
+		Parameters: parameters,
+		ExecutedBy: executedBy,
+		ExecutedAt: time.Now(),
+		Catalog:    exec.Catalog,
+		Database:   exec.Database,
+		WorkGroup:  exec.WorkGroup,
+	}
+
+	cacheKey := resultCacheKey(resultCacheEngine(exec), finalSQL, parameters)
+	cached, err := s.findCachedRun(ctx, cacheKey, ttl)
+	if err != nil {
+		return queryRun, err
 	}
 
-	ctx := context.Background()
-	collection := db.Collection("queryruns")
+	if cached != nil {
+		// Serve the results of the recent execution instead of scanning again
+		queryRun.ExecutionID = cached.ExecutionID
+		queryRun.Status = cached.Status
+		queryRun.ResultsS3URL = cached.ResultsS3URL
+		queryRun.CompletedAt = cached.CompletedAt
+		queryRun.FromCache = true
+	} else {
+		// Execute the query through Athena
+		queryRun.ExecutionID, err = s.executeAthenaQueryInternal(finalSQL, exec, ttl)
+		if err != nil {
+			return queryRun, err
+		}
+		queryRun.CacheKey = cacheKey
+	}
 
-	result, err := collection.InsertOne(ctx, queryRun)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if err := s.store.CreateQueryRun(ctx, &queryRun); err != nil {
+		return queryRun, err
 	}
 
-	queryRun.ID = result.InsertedID.(primitive.ObjectID)
-	c.JSON(http.StatusCreated, queryRun)
+	_, err = s.store.UpdateQuery(ctx, queryID, QueryUpdate{LastRunAt: &queryRun.ExecutedAt})
+	if err != nil && err != ErrNotFound {
+		return queryRun, err
+	}
+	return queryRun, nil
 }
 
-func deleteQueryRun(c *gin.Context) {
+func (s *Server) deleteQueryRun(c *gin.Context) {
 	id, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query run ID"})
 		return
 	}
 
-	ctx := context.Background()
-	collection := db.Collection("queryruns")
-
-	result, err := collection.DeleteOne(ctx, bson.M{"_id": id})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err = s.store.TrashQueryRun(context.Background(), id, requestUser(c), time.Now())
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query run not found"})
 		return
 	}
-
-	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Query run not found"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Query run deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Query run moved to trash"})
 }
 
 // Athena handlers
-func executeAthenaQuery(c *gin.Context) {
+func (s *Server) executeAthenaQuery(c *gin.Context) {
 	var req ExecuteQueryRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -296,7 +528,25 @@ func executeAthenaQuery(c *gin.Context) {
 	// Substitute parameters in SQL
 	finalSQL := substituteParameters(req.SQL, req.Parameters)
 
-	executionID, err := executeAthenaQueryInternal(finalSQL)
+	ttl := s.resultCacheTTL
+	if req.NoCache {
+		ttl = 0
+	}
+
+	exec := s.resolveExecutionContext(nil, executionContext{Catalog: req.Catalog, Database: req.Database, WorkGroup: req.WorkGroup})
+
+	cacheKey := resultCacheKey(resultCacheEngine(exec), finalSQL, req.Parameters)
+	cached, err := s.findCachedRun(context.Background(), cacheKey, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if cached != nil {
+		c.JSON(http.StatusOK, gin.H{"executionId": cached.ExecutionID, "fromCache": true})
+		return
+	}
+
+	executionID, err := s.executeAthenaQueryInternal(finalSQL, exec, ttl)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -305,22 +555,48 @@ func executeAthenaQuery(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"executionId": executionID})
 }
 
-func getQueryResults(c *gin.Context) {
-	executionID := c.Param("executionId")
+// Helper function to read the page of results requested by the page and size
+// parameters, sorted, filtered or projected over the result file when asked to.
+// Sizes above maxSize are lowered to it unless maxSize is 0.
+func (s *Server) readResultsPage(c *gin.Context, executionID string, maxSize int) (*QueryResults, error) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	size, _ := strconv.Atoi(c.DefaultQuery("size", "50"))
+	if page < 1 || size < 1 || page-1 > math.MaxInt32/size {
+		return nil, fmt.Errorf("%w: page and size must be positive", ErrInvalidResultQuery)
+	}
+	if maxSize > 0 && size > maxSize {
+		size = maxSize
+	}
+
+	if c.Query("sort") != "" || c.Query("columns") != "" || len(c.QueryArray("filter")) > 0 {
+		// Evaluated over the stored result file rather than Athena's paginated API.
+		// Sorting keeps every row up to the end of the page in memory.
+		if size > maxResultPageSize {
+			return nil, fmt.Errorf("%w: size must be between 1 and %d", ErrInvalidResultQuery, maxResultPageSize)
+		}
+		if page-1 > maxResultOffset/size {
+			return nil, fmt.Errorf("%w: pages can't start past row %d", ErrInvalidResultQuery, maxResultOffset)
+		}
+		return s.queryAthenaResults(c.Request.Context(), c, executionID, page, size)
+	}
+	return s.getAthenaResults(executionID, page, size)
+}
+
+func (s *Server) getQueryResults(c *gin.Context) {
+	executionID := c.Param("executionId")
 
-	results, err := getAthenaResults(executionID, page, size)
+	results, err := s.readResultsPage(c, executionID, 0)
+	if errors.Is(err, ErrInvalidResultQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Find the QueryRun record to get completion timestamp
-	ctx := context.Background()
-	collection := db.Collection("queryruns")
-	var queryRun QueryRun
-	err = collection.FindOne(ctx, bson.M{"executionId": executionID}).Decode(&queryRun)
+	queryRun, err := s.store.GetQueryRunByExecutionID(context.Background(), executionID)
 	if err == nil && queryRun.CompletedAt != nil {
 		results.CompletedAt = queryRun.CompletedAt
 	}
@@ -328,52 +604,94 @@ func getQueryResults(c *gin.Context) {
 	c.JSON(http.StatusOK, results)
 }
 
-func exportResults(c *gin.Context) {
+func (s *Server) exportResults(c *gin.Context) {
 	executionID := c.Param("executionId")
 
+	formatName := strings.ToLower(c.DefaultQuery("format", "csv"))
+	format, ok := exportFormats[formatName]
+	if !ok && formatName != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of csv, json, ndjson, parquet, xlsx"})
+		return
+	}
+
+	// Large CSVs can be downloaded from S3 directly instead of through the server
+	link := c.Query("link")
+	if link != "" && link != "redirect" && link != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "link must be redirect or json"})
+		return
+	}
+	if link != "" && formatName != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "download links are only available for CSV exports"})
+		return
+	}
+
 	// Find the QueryRun record to get completion timestamp
-	ctx := context.Background()
-	collection := db.Collection("queryruns")
-	var queryRun QueryRun
-	err := collection.FindOne(ctx, bson.M{"executionId": executionID}).Decode(&queryRun)
+	queryRun, err := s.store.GetQueryRunByExecutionID(context.Background(), executionID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Query run not found"})
 		return
 	}
 
 	// Get the results S3 URL and proxy the file
-	s3URL, err := getResultsS3URL(executionID)
+	s3URL, err := s.getResultsS3URL(executionID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Generate filename with date
-	var filename string
+	var dateStr string
 	if queryRun.CompletedAt != nil {
 		// Use completion date
-		dateStr := queryRun.CompletedAt.Format("2006-01-02_15-04-05")
-		filename = "query_results_" + dateStr + ".csv"
+		dateStr = queryRun.CompletedAt.Format("2006-01-02_15-04-05")
 	} else {
 		// Fallback to execution date
-		dateStr := queryRun.ExecutedAt.Format("2006-01-02_15-04-05")
-		filename = "query_results_" + dateStr + ".csv"
+		dateStr = queryRun.ExecutedAt.Format("2006-01-02_15-04-05")
 	}
 
-	// Set headers for CSV download
-	c.Header("Content-Type", "text/csv")
-	c.Header("Content-Disposition", "attachment; filename="+filename)
+	if formatName == "csv" {
+		filename := "query_results_" + dateStr + ".csv"
+		if link != "" && s.sendDownloadLink(c, link, executionID, s3URL, filename) {
+			return
+		}
+
+		// Set headers for CSV download
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment; filename="+filename)
 
-	// Proxy the S3 file to the client
-	err = proxyS3File(c, s3URL)
+		// Proxy the S3 file to the client
+		if err := s.proxyS3File(c, s3URL); err != nil {
+			abortDownload(c, executionID, err)
+		}
+		return
+	}
+
+	// Other formats are converted from the CSV using the column types of the results
+	columns, err := s.getResultColumns(executionID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if len(columns) == 0 && formatName == "parquet" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": errNoParquetColumns.Error()})
+		return
+	}
+
+	c.Header("Content-Type", format.ContentType)
+	c.Header("Content-Disposition", "attachment; filename=query_results_"+dateStr+"."+format.Extension)
+
+	if err := s.convertResults(c.Request.Context(), c.Writer, s3URL, columns, format); err != nil {
+		abortDownload(c, executionID, err)
+	}
 }
 
-func getAthenaCatalog(c *gin.Context) {
-	catalog, err := fetchAthenaCatalog()
+func (s *Server) getAthenaCatalog(c *gin.Context) {
+	cache, ok := s.requestCatalogCache(c)
+	if !ok {
+		return
+	}
+
+	catalog, err := cache.fetch(c.Query("refresh") == "true")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -383,59 +701,45 @@ func getAthenaCatalog(c *gin.Context) {
 }
 
 // Helper function to update query run status by checking Athena
-func updateQueryRunStatus(ctx context.Context, collection *mongo.Collection, run QueryRun) (QueryRun, error) {
+func (s *Server) updateQueryRunStatus(ctx context.Context, run QueryRun) (QueryRun, error) {
 	// Get current status from Athena
-	results, err := getAthenaResults(run.ExecutionID, 1, 1)
+	results, err := s.getAthenaResults(run.ExecutionID, 1, 1)
 	if err != nil {
 		return run, err
 	}
 
 	// Only update if status changed
 	if results.Status != run.Status {
-		now := time.Now()
-		update := bson.M{
-			"$set": bson.M{
-				"status": results.Status,
-			},
-		}
+		update := QueryRunUpdate{Status: &results.Status}
 
 		// Set completion time and error message if applicable
 		if results.Status == "SUCCEEDED" || results.Status == "FAILED" || results.Status == "CANCELLED" {
-			update["$set"].(bson.M)["completedAt"] = now
+			now := time.Now()
+			update.CompletedAt = &now
 
 			// Get S3 URL for successful queries
 			if results.Status == "SUCCEEDED" {
-				s3URL, err := getResultsS3URL(run.ExecutionID)
+				s3URL, reused, err := s.getResultsOutput(run.ExecutionID)
 				if err == nil {
-					update["$set"].(bson.M)["resultsS3Url"] = s3URL
+					update.ResultsS3URL = &s3URL
+					if reused {
+						update.FromCache = &reused
+					}
 				}
 			}
 
 			// Set error message for failed queries
 			if results.Status == "FAILED" && results.ErrorMessage != nil {
-				update["$set"].(bson.M)["errorMessage"] = *results.ErrorMessage
+				update.ErrorMessage = results.ErrorMessage
 			}
 		}
 
-		_, err := collection.UpdateOne(ctx, bson.M{"_id": run.ID}, update)
-		if err != nil {
+		if err := s.store.UpdateQueryRun(ctx, run.ID, update); err != nil {
 			return run, err
 		}
 
 		// Update the run object with new values
-		run.Status = results.Status
-		if results.Status == "SUCCEEDED" || results.Status == "FAILED" || results.Status == "CANCELLED" {
-			run.CompletedAt = &now
-		}
-		if results.Status == "SUCCEEDED" {
-			s3URL, err := getResultsS3URL(run.ExecutionID)
-			if err == nil {
-				run.ResultsS3URL = s3URL
-			}
-		}
-		if results.Status == "FAILED" && results.ErrorMessage != nil {
-			run.ErrorMessage = *results.ErrorMessage
-		}
+		applyQueryRunUpdate(&run, update)
 	}
 
 	return run, nil
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestExecutePollResults(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   fakeQuery
+		pageSize int64
+		page     string
+		polls    []string // Status of each poll of the results until the last
+		rows     [][]string
+		total    int64
+		errorMsg string
+	}{
+		{
+			name: "succeeds",
+			script: fakeQuery{
+				States:  []string{"QUEUED", "RUNNING", "SUCCEEDED"},
+				Columns: []string{"region", "total"},
+				Rows:    [][]string{{"eu", "1"}, {"us", "2"}},
+			},
+			page:  "page=1&size=50",
+			polls: []string{"QUEUED", "RUNNING", "SUCCEEDED"},
+			rows:  [][]string{{"eu", "1"}, {"us", "2"}},
+			total: 2,
+		},
+		{
+			name: "reads every Athena page",
+			script: fakeQuery{
+				Columns: []string{"n"},
+				Rows:    [][]string{{"1"}, {"2"}, {"3"}, {"4"}, {"5"}},
+			},
+			pageSize: 2,
+			page:     "page=2&size=2",
+			polls:    []string{"SUCCEEDED"},
+			rows:     [][]string{{"3"}, {"4"}},
+			total:    5,
+		},
+		{
+			name: "fails",
+			script: fakeQuery{
+				States: []string{"RUNNING", "FAILED"},
+				Reason: "TABLE_NOT_FOUND: line 1:15: Table awsdatacatalog.default.missing does not exist",
+			},
+			page:     "page=1&size=50",
+			polls:    []string{"RUNNING", "FAILED"},
+			errorMsg: "TABLE_NOT_FOUND",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.athena.Script("from scripted", tt.script)
+			if tt.pageSize > 0 {
+				ts.athena.SetPageSize(tt.pageSize)
+			}
+
+			started := doJSON[gin.H](t, ts, http.MethodPost, "/api/athena/execute",
+				gin.H{"sql": "SELECT * FROM scripted"}, http.StatusOK)
+			executionID, _ := started["executionId"].(string)
+			if executionID == "" {
+				t.Fatalf("no executionId in %v", started)
+			}
+
+			var results QueryResults
+			for _, status := range tt.polls {
+				results = doJSON[QueryResults](t, ts, http.MethodGet,
+					"/api/athena/results/"+executionID+"?"+tt.page, nil, http.StatusOK)
+				if results.Status != status {
+					t.Fatalf("status %s, want %s", results.Status, status)
+				}
+			}
+
+			if len(tt.rows) > 0 && !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("rows %v, want %v", results.Rows, tt.rows)
+			}
+			if len(tt.rows) == 0 && len(results.Rows) != 0 {
+				t.Errorf("rows %v, want none", results.Rows)
+			}
+			if results.Total != tt.total {
+				t.Errorf("total %d, want %d", results.Total, tt.total)
+			}
+			if tt.errorMsg != "" && (results.ErrorMessage == nil || !strings.Contains(*results.ErrorMessage, tt.errorMsg)) {
+				t.Errorf("error message %v, want it to contain %q", results.ErrorMessage, tt.errorMsg)
+			}
+		})
+	}
+}
+
+func TestQueryRunLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.Script("from orders", fakeQuery{
+		States:  []string{"QUEUED", "RUNNING", "SUCCEEDED"},
+		Columns: []string{"id"},
+		Rows:    [][]string{{"1"}, {"2"}, {"3"}},
+	})
+
+	query := createTestQuery(t, ts, "Orders", "SELECT id FROM orders WHERE region = '{{region}}'")
+	run := runTestQuery(t, ts, query, gin.H{"parameters": gin.H{"region": "eu"}})
+
+	if run.Status != "SUCCEEDED" {
+		t.Fatalf("status %s, want SUCCEEDED", run.Status)
+	}
+	if run.CompletedAt == nil {
+		t.Error("completedAt not set")
+	}
+	if !strings.HasPrefix(run.ResultsS3URL, "s3://"+testResultsBucket+"/") {
+		t.Errorf("resultsS3Url %q is not in the results bucket", run.ResultsS3URL)
+	}
+	if sql := aws.StringValue(ts.athena.executions[run.ExecutionID].input.QueryString); sql != "SELECT id FROM orders WHERE region = 'eu'" {
+		t.Errorf("Athena ran %q, want parameters substituted", sql)
+	}
+
+	results := doJSON[QueryResults](t, ts, http.MethodGet, "/api/athena/results/"+run.ExecutionID, nil, http.StatusOK)
+	if results.Total != 3 || results.CompletedAt == nil {
+		t.Errorf("results total %d, completedAt %v; want 3 rows of a completed run", results.Total, results.CompletedAt)
+	}
+
+	saved := doJSON[Query](t, ts, http.MethodGet, "/api/queries/"+query.ID.Hex(), nil, http.StatusOK)
+	if saved.LastRunAt == nil {
+		t.Error("lastRunAt of the query not set")
+	}
+}
+
+func TestPatchQueryValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string // Of the saved query when empty
+		body   string
+		status int
+		fields []string // Fields reported invalid
+		check  func(t *testing.T, query Query)
+	}{
+		{
+			name:   "renames",
+			body:   `{"name": "Renamed"}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, query Query) {
+				if query.Name != "Renamed" || query.SQL != "SELECT * FROM sales.orders" {
+					t.Errorf("got name %q and sql %q", query.Name, query.SQL)
+				}
+			},
+		},
+		{
+			name:   "null clears optional fields",
+			body:   `{"description": null, "tags": null, "folder": null}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, query Query) {
+				if query.Description != "" || len(query.Tags) != 0 || query.Folder != "" {
+					t.Errorf("got description %q, tags %v, folder %q", query.Description, query.Tags, query.Folder)
+				}
+			},
+		},
+		{
+			name:   "sql updates references",
+			body:   `{"sql": "SELECT id FROM sales.returns"}`,
+			status: http.StatusOK,
+			check: func(t *testing.T, query Query) {
+				if !reflect.DeepEqual(query.Tables, []string{"sales.returns"}) {
+					t.Errorf("tables %v, want [sales.returns]", query.Tables)
+				}
+			},
+		},
+		{name: "invalid JSON", body: `{"name":`, status: http.StatusBadRequest},
+		{name: "not an object", body: `null`, status: http.StatusBadRequest},
+		{name: "invalid ID", id: "nope", body: `{"name": "x"}`, status: http.StatusBadRequest},
+		{name: "unknown query", id: "000000000000000000000000", body: `{"name": "x"}`, status: http.StatusNotFound},
+		{name: "unknown field", body: `{"owner": "jane"}`, status: http.StatusUnprocessableEntity, fields: []string{"owner"}},
+		{name: "name removed", body: `{"name": null}`, status: http.StatusUnprocessableEntity, fields: []string{"name"}},
+		{name: "empty name", body: `{"name": "  "}`, status: http.StatusUnprocessableEntity, fields: []string{"name"}},
+		{name: "SQL removed", body: `{"sql": null}`, status: http.StatusUnprocessableEntity, fields: []string{"sql"}},
+		{name: "empty SQL", body: `{"sql": "\n "}`, status: http.StatusUnprocessableEntity, fields: []string{"sql"}},
+		{name: "wrong types", body: `{"sql": 1, "tags": "a"}`, status: http.StatusUnprocessableEntity, fields: []string{"sql", "tags"}},
+		{name: "negative TTL", body: `{"resultCacheTtl": -5}`, status: http.StatusUnprocessableEntity, fields: []string{"resultCacheTtl"}},
+		{name: "SQL nested too deep", body: `{"sql": "SELECT ` + strings.Repeat("(", maxSQLNesting+1) + `1"}`, status: http.StatusUnprocessableEntity, fields: []string{"sql"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			query := doJSON[Query](t, ts, http.MethodPost, "/api/queries", gin.H{
+				"name": "Orders", "sql": "SELECT * FROM sales.orders", "description": "All orders",
+				"folder": "sales", "tags": []string{"finance"},
+			}, http.StatusCreated)
+
+			id := tt.id
+			if id == "" {
+				id = query.ID.Hex()
+			}
+			rec := ts.do(t, http.MethodPatch, "/api/queries/"+id, tt.body, "Content-Type", "application/merge-patch+json")
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+
+			if len(tt.fields) > 0 {
+				var response struct {
+					Fields map[string]string `json:"fields"`
+				}
+				decodeBody(t, rec, &response)
+				for _, field := range tt.fields {
+					if response.Fields[field] == "" {
+						t.Errorf("field %s not reported in %v", field, response.Fields)
+					}
+				}
+				if len(response.Fields) != len(tt.fields) {
+					t.Errorf("fields %v, want only %v", response.Fields, tt.fields)
+				}
+			}
+
+			if tt.check != nil {
+				var patched Query
+				decodeBody(t, rec, &patched)
+				tt.check(t, patched)
+				tt.check(t, doJSON[Query](t, ts, http.MethodGet, "/api/queries/"+id, nil, http.StatusOK))
+			}
+		})
+	}
+}
+
+func TestSaveQuerySQLLimits(t *testing.T) {
+	ts := newTestServer(t)
+	query := createTestQuery(t, ts, "Orders", "SELECT * FROM sales.orders")
+	long := "SELECT '" + strings.Repeat("x", maxSQLTextBytes) + "'"
+	nested := "SELECT " + strings.Repeat("(", maxSQLNesting+1) + "1" + strings.Repeat(")", maxSQLNesting+1)
+
+	for _, sql := range []string{long, nested} {
+		doJSON[gin.H](t, ts, http.MethodPost, "/api/queries", gin.H{"name": "Too large", "sql": sql}, http.StatusBadRequest)
+		doJSON[gin.H](t, ts, http.MethodPut, "/api/queries/"+query.ID.Hex(), gin.H{"name": "Too large", "sql": sql}, http.StatusBadRequest)
+	}
+	if saved := doJSON[Query](t, ts, http.MethodGet, "/api/queries/"+query.ID.Hex(), nil, http.StatusOK); saved.SQL != query.SQL {
+		t.Errorf("saved SQL %.40q", saved.SQL)
+	}
+
+	// The largest SQL that is accepted parses quickly
+	deepest := "SELECT * FROM sales.orders WHERE " + strings.Repeat("(", maxSQLNesting) + "1" + strings.Repeat(")", maxSQLNesting)
+	widest := "SELECT * FROM sales.orders WHERE " + strings.Repeat("((1))AND", (maxSQLTextBytes-64)/8) + "1"
+	for _, sql := range []string{deepest, widest} {
+		start := time.Now()
+		saved := doJSON[Query](t, ts, http.MethodPost, "/api/queries", gin.H{"name": "Large", "sql": sql}, http.StatusCreated)
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("saving %d bytes took %v", len(sql), elapsed)
+		}
+		if !reflect.DeepEqual(saved.Tables, []string{"sales.orders"}) {
+			t.Errorf("tables %v", saved.Tables)
+		}
+	}
+}
+
+func TestAWSFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    func(ts *testServer)
+		request func(t *testing.T, ts *testServer, executionID string) (string, string)
+		status  int
+	}{
+		{
+			name: "Athena rejects the query",
+			fail: func(ts *testServer) {
+				ts.athena.FailNext("StartQueryExecution", awserr.New(athena.ErrCodeInvalidRequestException, "line 1:8: mismatched input", nil))
+			},
+			request: func(t *testing.T, ts *testServer, _ string) (string, string) {
+				return http.MethodPost, "/api/athena/execute"
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "Athena rejects a query run",
+			fail: func(ts *testServer) {
+				ts.athena.FailNext("StartQueryExecution", awserr.New(athena.ErrCodeTooManyRequestsException, "Rate exceeded", nil))
+			},
+			request: func(t *testing.T, ts *testServer, _ string) (string, string) {
+				query := createTestQuery(t, ts, "Orders", "SELECT 1")
+				return http.MethodPost, "/api/queries/" + query.ID.Hex() + "/runs"
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "execution can't be read",
+			fail: func(ts *testServer) {
+				ts.athena.FailNext("GetQueryExecution", awserr.New(athena.ErrCodeInternalServerException, "Internal error", nil))
+			},
+			request: func(t *testing.T, ts *testServer, executionID string) (string, string) {
+				return http.MethodGet, "/api/athena/results/" + executionID
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "results can't be read",
+			fail: func(ts *testServer) {
+				ts.athena.FailNext("GetQueryResults", awserr.New(athena.ErrCodeInternalServerException, "Internal error", nil))
+			},
+			request: func(t *testing.T, ts *testServer, executionID string) (string, string) {
+				return http.MethodGet, "/api/athena/results/" + executionID
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "result file is gone",
+			fail: func(ts *testServer) {
+				ts.s3.FailNext("GetObject", awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil))
+			},
+			request: func(t *testing.T, ts *testServer, executionID string) (string, string) {
+				return http.MethodGet, "/api/athena/export/" + executionID
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "S3 fails",
+			fail: func(ts *testServer) {
+				ts.s3.FailNext("GetObject", awserr.New("InternalError", "We encountered an internal error", nil))
+			},
+			request: func(t *testing.T, ts *testServer, executionID string) (string, string) {
+				return http.MethodGet, "/api/athena/export/" + executionID + "?format=json"
+			},
+			status: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.athena.Script("from orders", fakeQuery{Columns: []string{"id"}, Rows: [][]string{{"1"}}})
+			query := createTestQuery(t, ts, "Orders", "SELECT id FROM orders")
+			run := runTestQuery(t, ts, query, nil)
+
+			tt.fail(ts)
+			method, path := tt.request(t, ts, run.ExecutionID)
+			var body interface{}
+			if method == http.MethodPost {
+				body = gin.H{"sql": "SELECT id FROM orders", "noCache": true}
+			}
+
+			rec := ts.do(t, method, path, body)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			var response gin.H
+			decodeBody(t, rec, &response)
+			if response["error"] == nil {
+				t.Errorf("no error in %v", response)
+			}
+		})
+	}
+}
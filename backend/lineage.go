@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxLineageDepth = 20
+
+// Types of QueryLineage
+const (
+	lineageCTAS   = "ctas"
+	lineageView   = "view"
+	lineageInsert = "insert"
+	lineageMerge  = "merge"
+)
+
+// Helper function to deep copy references, so stores don't share slices with callers
+func copySQLReferences(refs sqlReferences) sqlReferences {
+	copied := sqlReferences{
+		Tables:    append([]string{}, refs.Tables...),
+		Databases: append([]string{}, refs.Databases...),
+		Columns:   append([]string{}, refs.Columns...),
+	}
+	if refs.Lineage != nil {
+		lineage := *refs.Lineage
+		lineage.Sources = append([]string{}, lineage.Sources...)
+		copied.Lineage = &lineage
+	}
+	return copied
+}
+
+// Helper function to return the references stored on a query, with empty
+// slices where a store returned none
+func queryReferences(query Query) sqlReferences {
+	return copySQLReferences(sqlReferences{
+		Tables:    query.Tables,
+		Databases: query.Databases,
+		Columns:   query.Columns,
+		Lineage:   query.Lineage,
+	})
+}
+
+// Helper function to resolve a table name referenced by a query in the catalog
+// and database the query runs in. The database is empty when the name is
+// unqualified and the query has no database.
+func queryTable(query Query, name string) (catalog, database, table string) {
+	catalog = query.Catalog
+	if catalog == "" {
+		catalog = defaultCatalogName
+	}
+	database = strings.ToLower(query.Database)
+
+	parts := strings.Split(strings.ToLower(name), ".")
+	table = parts[len(parts)-1]
+	switch len(parts) {
+	case 2:
+		database = parts[0]
+	case 3:
+		catalog, database = parts[0], parts[1]
+	}
+	return catalog, database, table
+}
+
+// Helper function to check whether a table name referenced by a query is the
+// given table. Names of an unknown database match a table of any database.
+func queryTableIs(query Query, name, catalog, database, table string) bool {
+	c, d, t := queryTable(query, name)
+	return t == strings.ToLower(table) && strings.EqualFold(c, catalog) &&
+		(d == "" || d == strings.ToLower(database))
+}
+
+// Helper function to identify a table in a lineage graph
+func lineageNodeID(catalog, database, table string) string {
+	id := table
+	if database != "" {
+		id = database + "." + id
+	}
+	if !strings.EqualFold(catalog, defaultCatalogName) {
+		id = catalog + "." + id
+	}
+	return strings.ToLower(id)
+}
+
+// Helper function to parse the SQL of every saved query again, so queries saved
+// before the parser learned about something carry it too. Only queries whose
+// references changed are written, and their update time is left alone. Queries
+// edited while this runs keep the references parsed when they were saved.
+func (s *Server) reparseQueries(ctx context.Context) error {
+	queries, _, _, err := s.store.ListQueries(ctx, QueryFilter{Trash: notTrashed},
+		pageRequest{Sort: querySortFields["name"], Limit: 0})
+	if err != nil {
+		return err
+	}
+
+	updated := 0
+	for _, query := range queries {
+		if sqlSizeError(query.SQL) != nil {
+			continue // Saved before saving checked the size, too costly to parse
+		}
+		refs := parseSQLReferences(query.SQL)
+		if reflect.DeepEqual(refs, queryReferences(query)) {
+			continue
+		}
+		update := QueryUpdate{References: &refs, IfSQL: &query.SQL}
+		if _, err := s.store.UpdateQuery(ctx, query.ID, update); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue // Trashed or edited in the meantime
+			}
+			return err
+		}
+		updated++
+	}
+
+	if updated > 0 {
+		log.Printf("Updated the table references of %d saved queries", updated)
+	}
+	return nil
+}
+
+// Helper function to build the lineage graph of all saved queries
+func (s *Server) lineageGraph(ctx context.Context) (LineageGraph, error) {
+	graph := LineageGraph{Nodes: []LineageNode{}, Edges: []LineageEdge{}}
+	queries, _, _, err := s.store.ListQueries(ctx, QueryFilter{Trash: notTrashed},
+		pageRequest{Sort: querySortFields["name"], Limit: 0})
+	if err != nil {
+		return graph, err
+	}
+
+	nodes := map[string]bool{}
+	addNode := func(query Query, name string) string {
+		catalog, database, table := queryTable(query, name)
+		id := lineageNodeID(catalog, database, table)
+		if !nodes[id] {
+			nodes[id] = true
+			graph.Nodes = append(graph.Nodes, LineageNode{ID: id, Catalog: catalog, Database: database, Table: table})
+		}
+		return id
+	}
+
+	for _, query := range queries {
+		if query.Lineage == nil {
+			continue
+		}
+		target := addNode(query, query.Lineage.Target)
+		for _, source := range query.Lineage.Sources {
+			graph.Edges = append(graph.Edges, LineageEdge{
+				Source:    addNode(query, source),
+				Target:    target,
+				Type:      query.Lineage.Type,
+				QueryID:   query.ID,
+				QueryName: query.Name,
+			})
+		}
+	}
+
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].ID < graph.Nodes[j].ID })
+	return graph, nil
+}
+
+// Helper function to keep the part of a graph reachable from the nodes of a
+// table, following edges upstream, downstream or both, up to depth edges away
+// (0 for no limit)
+func lineageSubgraph(graph LineageGraph, catalog, database, table, direction string, depth int) LineageGraph {
+	byID := map[string]LineageNode{}
+	var start []string
+	for _, node := range graph.Nodes {
+		byID[node.ID] = node
+		if node.Table == strings.ToLower(table) && strings.EqualFold(node.Catalog, catalog) &&
+			(node.Database == "" || node.Database == strings.ToLower(database)) {
+			start = append(start, node.ID)
+		}
+	}
+
+	keepNodes := map[string]bool{}
+	keepEdges := make([]bool, len(graph.Edges))
+	for _, id := range start {
+		keepNodes[id] = true
+	}
+
+	walk := func(upstream bool) {
+		visited := map[string]bool{}
+		frontier := append([]string{}, start...)
+		for _, id := range frontier {
+			visited[id] = true
+		}
+		for level := 0; len(frontier) > 0 && (depth == 0 || level < depth); level++ {
+			var next []string
+			for i, edge := range graph.Edges {
+				from, to := edge.Source, edge.Target
+				if upstream {
+					from, to = to, from
+				}
+				for _, id := range frontier {
+					if from != id {
+						continue
+					}
+					keepEdges[i] = true
+					keepNodes[to] = true
+					if !visited[to] {
+						visited[to] = true
+						next = append(next, to)
+					}
+				}
+			}
+			frontier = next
+		}
+	}
+	if direction != "downstream" {
+		walk(true)
+	}
+	if direction != "upstream" {
+		walk(false)
+	}
+
+	subgraph := LineageGraph{Nodes: []LineageNode{}, Edges: []LineageEdge{}}
+	for _, node := range graph.Nodes {
+		if keepNodes[node.ID] {
+			subgraph.Nodes = append(subgraph.Nodes, byID[node.ID])
+		}
+	}
+	for i, edge := range graph.Edges {
+		if keepEdges[i] {
+			subgraph.Edges = append(subgraph.Edges, edge)
+		}
+	}
+	return subgraph
+}
+
+// Helper function to respond with the lineage of a table, or of all tables
+// when table is empty
+func (s *Server) respondLineage(c *gin.Context, catalog, database, table string) {
+	direction := c.DefaultQuery("direction", "both")
+	if direction != "upstream" && direction != "downstream" && direction != "both" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "direction must be upstream, downstream or both"})
+		return
+	}
+	depth := 0
+	if raw := c.Query("depth"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 || parsed > maxLineageDepth {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "depth must be between 0 and " + strconv.Itoa(maxLineageDepth)})
+			return
+		}
+		depth = parsed
+	}
+
+	graph, err := s.lineageGraph(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if table != "" {
+		graph = lineageSubgraph(graph, catalog, database, table, direction, depth)
+	}
+
+	c.JSON(http.StatusOK, graph)
+}
+
+func (s *Server) getLineage(c *gin.Context) {
+	catalog := c.DefaultQuery("catalog", defaultCatalogName)
+	name := strings.ToLower(strings.TrimSpace(c.Query("table")))
+	if name == "" {
+		s.respondLineage(c, catalog, "", "")
+		return
+	}
+
+	parts := strings.Split(name, ".")
+	if len(parts) > 3 || strings.Contains("."+name+".", "..") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "table must be table, database.table or catalog.database.table"})
+		return
+	}
+	database := ""
+	switch len(parts) {
+	case 2:
+		database = parts[0]
+	case 3:
+		catalog, database = parts[0], parts[1]
+	}
+	s.respondLineage(c, catalog, database, parts[len(parts)-1])
+}
+
+func (s *Server) getTableLineage(c *gin.Context) {
+	cache, ok := s.requestCatalogCache(c)
+	if !ok {
+		return
+	}
+	s.respondLineage(c, cache.name, strings.ToLower(c.Param("db")), strings.ToLower(c.Param("table")))
+}
+
+func (s *Server) getTableQueries(c *gin.Context) {
+	cache, ok := s.requestCatalogCache(c)
+	if !ok {
+		return
+	}
+	database := strings.ToLower(c.Param("db"))
+	table := strings.ToLower(c.Param("table"))
+
+	queries, _, _, err := s.store.ListQueries(c.Request.Context(), QueryFilter{Trash: notTrashed},
+		pageRequest{Sort: querySortFields["name"], Limit: 0})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	usages := []TableUsage{}
+	for _, query := range queries {
+		names := map[string]bool{}
+		for _, name := range query.Tables {
+			if queryTableIs(query, name, cache.name, database, table) {
+				names[name] = true
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+
+		usage := TableUsage{
+			QueryID:   query.ID,
+			Name:      query.Name,
+			Folder:    query.Folder,
+			Reads:     query.Lineage == nil,
+			Columns:   []string{},
+			UpdatedAt: query.UpdatedAt,
+		}
+		if query.Lineage != nil {
+			usage.Writes = names[query.Lineage.Target]
+			for _, source := range query.Lineage.Sources {
+				usage.Reads = usage.Reads || names[source]
+			}
+		}
+		for _, column := range query.Columns {
+			if i := strings.LastIndex(column, "."); i > 0 && names[column[:i]] {
+				usage.Columns = append(usage.Columns, column[i+1:])
+			}
+		}
+		usages = append(usages, usage)
+	}
+
+	c.JSON(http.StatusOK, usages)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Helper function to save the queries of a small pipeline:
+// sales.orders -> sales.daily -> sales.monthly -> sales.report
+func createLineageTestQueries(t *testing.T, ts *testServer) map[string]Query {
+	t.Helper()
+	queries := map[string]Query{}
+	for name, sql := range map[string]string{
+		"Daily":   "CREATE TABLE sales.daily AS SELECT o.day, sum(o.amount) AS amount FROM sales.orders o GROUP BY o.day",
+		"Monthly": "INSERT INTO sales.monthly SELECT month(day), sum(amount) FROM sales.daily GROUP BY 1",
+		"Report":  "CREATE VIEW sales.report AS SELECT * FROM sales.monthly",
+		"Other":   "CREATE TABLE hr.daily AS SELECT * FROM hr.orders",
+	} {
+		queries[name] = createTestQuery(t, ts, name, sql)
+	}
+	return queries
+}
+
+// Helper function to list the nodes and edges of a graph as text
+func lineageNames(graph LineageGraph) ([]string, []string) {
+	nodes := []string{}
+	for _, node := range graph.Nodes {
+		nodes = append(nodes, node.ID)
+	}
+	edges := []string{}
+	for _, edge := range graph.Edges {
+		edges = append(edges, edge.Source+" -"+edge.Type+"-> "+edge.Target)
+	}
+	sort.Strings(edges)
+	return nodes, edges
+}
+
+func TestTableQueries(t *testing.T) {
+	ts := newTestServer(t)
+	queries := createLineageTestQueries(t, ts)
+	reader := doJSON[Query](t, ts, http.MethodPost, "/api/queries",
+		gin.H{"name": "Reader", "sql": "SELECT id, amount FROM orders WHERE amount > 0", "database": "sales", "folder": "Finance"}, http.StatusCreated)
+	trashed := createTestQuery(t, ts, "Trashed", "SELECT * FROM sales.orders")
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/queries/"+trashed.ID.Hex(), nil, http.StatusOK)
+
+	usages := doJSON[[]TableUsage](t, ts, http.MethodGet, "/api/catalog/sales/orders/queries", nil, http.StatusOK)
+	byName := map[string]TableUsage{}
+	for _, usage := range usages {
+		byName[usage.Name] = usage
+	}
+	if len(usages) != 2 {
+		t.Fatalf("usages %+v, want the daily and reader queries", usages)
+	}
+	if daily := byName["Daily"]; daily.QueryID != queries["Daily"].ID || !daily.Reads || daily.Writes ||
+		!reflect.DeepEqual(daily.Columns, []string{"day", "amount"}) {
+		t.Errorf("daily query %+v", daily)
+	}
+	if usage := byName["Reader"]; usage.QueryID != reader.ID || !usage.Reads || usage.Folder != "Finance" ||
+		!reflect.DeepEqual(usage.Columns, []string{"id", "amount"}) {
+		t.Errorf("reader query %+v", usage)
+	}
+
+	written := doJSON[[]TableUsage](t, ts, http.MethodGet, "/api/catalog/SALES/Daily/queries", nil, http.StatusOK)
+	names := map[string]bool{}
+	for _, usage := range written {
+		names[usage.Name] = usage.Writes && usage.Name == "Daily" || usage.Reads && usage.Name == "Monthly"
+	}
+	if len(written) != 2 || !names["Daily"] || !names["Monthly"] {
+		t.Errorf("usages of sales.daily %+v", written)
+	}
+
+	if unused := doJSON[[]TableUsage](t, ts, http.MethodGet, "/api/catalog/sales/refunds/queries", nil, http.StatusOK); len(unused) != 0 {
+		t.Errorf("usages %+v of an unused table", unused)
+	}
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/catalog/sales/orders/queries?catalog=nope", nil, http.StatusNotFound)
+}
+
+func TestLineage(t *testing.T) {
+	ts := newTestServer(t)
+	createLineageTestQueries(t, ts)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		nodes  []string
+		edges  []string
+	}{
+		{
+			name: "everything", path: "/api/lineage", status: http.StatusOK,
+			nodes: []string{"hr.daily", "hr.orders", "sales.daily", "sales.monthly", "sales.orders", "sales.report"},
+			edges: []string{"hr.orders -ctas-> hr.daily", "sales.daily -insert-> sales.monthly", "sales.monthly -view-> sales.report", "sales.orders -ctas-> sales.daily"},
+		},
+		{
+			name: "upstream", path: "/api/lineage?table=sales.monthly&direction=upstream", status: http.StatusOK,
+			nodes: []string{"sales.daily", "sales.monthly", "sales.orders"},
+			edges: []string{"sales.daily -insert-> sales.monthly", "sales.orders -ctas-> sales.daily"},
+		},
+		{
+			name: "downstream one level", path: "/api/lineage?table=sales.daily&direction=downstream&depth=1", status: http.StatusOK,
+			nodes: []string{"sales.daily", "sales.monthly"},
+			edges: []string{"sales.daily -insert-> sales.monthly"},
+		},
+		{
+			name: "of a table", path: "/api/catalog/sales/monthly/lineage?direction=downstream", status: http.StatusOK,
+			nodes: []string{"sales.monthly", "sales.report"},
+			edges: []string{"sales.monthly -view-> sales.report"},
+		},
+		{name: "unknown table", path: "/api/lineage?table=sales.refunds", status: http.StatusOK, nodes: []string{}, edges: []string{}},
+		{name: "other catalog", path: "/api/lineage?table=other.sales.daily", status: http.StatusOK, nodes: []string{}, edges: []string{}},
+		{name: "invalid direction", path: "/api/lineage?table=sales.daily&direction=sideways", status: http.StatusBadRequest},
+		{name: "invalid depth", path: "/api/lineage?table=sales.daily&depth=99", status: http.StatusBadRequest},
+		{name: "invalid table", path: "/api/lineage?table=sales..daily", status: http.StatusBadRequest},
+		{name: "unknown catalog", path: "/api/catalog/sales/daily/lineage?catalog=nope", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, tt.path, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var graph LineageGraph
+			decodeBody(t, rec, &graph)
+			nodes, edges := lineageNames(graph)
+			if !reflect.DeepEqual(nodes, tt.nodes) || !reflect.DeepEqual(edges, tt.edges) {
+				t.Errorf("nodes %q, edges %q; want %q, %q", nodes, edges, tt.nodes, tt.edges)
+			}
+		})
+	}
+}
+
+// editingStore edits a query right after listing it, like a user saving the
+// query while its SQL is parsed again
+type editingStore struct {
+	Store
+	sql string
+}
+
+func (s *editingStore) ListQueries(ctx context.Context, filter QueryFilter, page pageRequest) ([]Query, string, int64, error) {
+	queries, next, total, err := s.Store.ListQueries(ctx, filter, page)
+	for _, query := range queries {
+		refs := parseSQLReferences(s.sql)
+		if _, err := s.Store.UpdateQuery(ctx, query.ID, QueryUpdate{SQL: &s.sql, References: &refs}); err != nil {
+			return nil, "", 0, err
+		}
+	}
+	return queries, next, total, err
+}
+
+func TestReparseQueries(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	query := createTestQuery(t, ts, "Daily", "CREATE TABLE sales.daily AS SELECT * FROM sales.orders")
+	want := queryReferences(query)
+
+	// Queries saved before their references were parsed get them
+	if _, err := ts.store.UpdateQuery(ctx, query.ID, QueryUpdate{References: &sqlReferences{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.reparseQueries(ctx); err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err := ts.store.GetQuery(ctx, query.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := queryReferences(reparsed); !reflect.DeepEqual(got, want) {
+		t.Errorf("references %+v, want %+v", got, want)
+	}
+	if !reparsed.UpdatedAt.Equal(query.UpdatedAt) {
+		t.Errorf("updated at %v, want %v", reparsed.UpdatedAt, query.UpdatedAt)
+	}
+
+	// An edit while parsing isn't overwritten with the references of the old SQL
+	if _, err := ts.store.UpdateQuery(ctx, query.ID, QueryUpdate{References: &sqlReferences{}}); err != nil {
+		t.Fatal(err)
+	}
+	edited := "SELECT * FROM hr.employees"
+	ts.store = &editingStore{Store: ts.store, sql: edited}
+	if err := ts.reparseQueries(ctx); err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err = ts.store.GetQuery(ctx, query.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := copySQLReferences(parseSQLReferences(edited)); !reflect.DeepEqual(queryReferences(reparsed), want) {
+		t.Errorf("references %+v, want those of the edited SQL %+v", queryReferences(reparsed), want)
+	}
+
+	// SQL saved before its size was checked is left unparsed
+	ts.store = ts.store.(*editingStore).Store
+	deep := Query{Name: "Deep", SQL: "SELECT * FROM sales.orders WHERE " + strings.Repeat("(", maxSQLNesting+1) + "1" + strings.Repeat(")", maxSQLNesting+1)}
+	if err := ts.store.CreateQuery(ctx, &deep); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.reparseQueries(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if reparsed, err = ts.store.GetQuery(ctx, deep.ID); err != nil || len(reparsed.Tables) != 0 {
+		t.Errorf("tables %v of SQL nested too deep, %v", reparsed.Tables, err)
+	}
+}
@@ -6,104 +6,91 @@ import (
 	"net/http"
 	"os"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var mongoClient *mongo.Client
-var db *mongo.Database
-
 func main() {
 	// Load environment variables
 	godotenv.Load()
 
-	// Initialize MongoDB
-	if err := initMongoDB(); err != nil {
-		log.Fatal("Failed to connect to MongoDB:", err)
+	// Initialize storage
+	store, err := openStore(context.Background())
+	if err != nil {
+		log.Fatal("Failed to open storage:", err)
 	}
-	defer mongoClient.Disconnect(context.Background())
-
-	// Initialize Gin router
-	r := gin.Default()
-
-	// CORS middleware
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:3001"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-	}))
-
-	// Serve static files in production
-	r.Static("/static", "./frontend/dist")
-	r.StaticFile("/", "./frontend/dist/index.html")
-	r.StaticFile("/bolt.png", "./frontend/dist/assets/bolt.png")
-
-	// API routes
-	api := r.Group("/api")
-	{
-		api.GET("/health", healthCheck)
-
-		// Query routes
-		api.GET("/queries", getQueries)
-		api.POST("/queries", createQuery)
-		api.GET("/queries/:id", getQuery)
-		api.PUT("/queries/:id", updateQuery)
-		api.DELETE("/queries/:id", deleteQuery)
-
-		// Query run routes
-		api.GET("/queries/:id/runs", getQueryRuns)
-		api.POST("/queries/:id/runs", executeQuery)
-		api.DELETE("/query-runs/:id", deleteQueryRun)
-
-		// Athena routes
-		api.POST("/athena/execute", executeAthenaQuery)
-		api.GET("/athena/results/:executionId", getQueryResults)
-		api.GET("/athena/export/:executionId", exportResults)
-		api.GET("/athena/catalog", getAthenaCatalog)
+	defer store.Close(context.Background())
+
+	// Initialize AWS clients
+	athenaClient, s3Client, glueClient, resultsBucket, err := newAWSClients()
+	if err != nil {
+		log.Fatal("Failed to initialize AWS clients:", err)
 	}
 
-	// Fallback to serve React app for any non-API routes
-	r.NoRoute(func(c *gin.Context) {
-		c.File("./frontend/dist/index.html")
-	})
+	s := newServer(store, athenaClient, s3Client, glueClient, resultsBucket)
+	if workGroup := os.Getenv("ATHENA_WORKGROUP"); workGroup != "" {
+		s.athenaWorkGroup = workGroup
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	s.resultCacheTTL, err = loadResultCacheTTL()
+	if err != nil {
+		log.Fatal("Failed to configure result cache:", err)
 	}
 
-	log.Printf("Server starting on port %s", port)
-	r.Run(":" + port)
-}
+	s.presignedURLs, s.presignedURLExpiry, err = loadPresignConfig()
+	if err != nil {
+		log.Fatal("Failed to configure download links:", err)
+	}
 
-func initMongoDB() error {
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		mongoURI = "mongodb://localhost:27017/zeus"
+	s.dashboardConcurrency, err = loadDashboardConcurrency()
+	if err != nil {
+		log.Fatal("Failed to configure dashboards:", err)
 	}
 
-	clientOptions := options.Client().ApplyURI(mongoURI)
+	s.largeTableBytes, err = loadLargeTableBytes()
+	if err != nil {
+		log.Fatal("Failed to configure SQL lint:", err)
+	}
 
-	var err error
-	mongoClient, err = mongo.Connect(context.Background(), clientOptions)
+	s.shareSecret, err = loadShareSecret()
 	if err != nil {
-		return err
+		log.Fatal("Failed to configure share links:", err)
 	}
 
-	// Test the connection
-	err = mongoClient.Ping(context.Background(), nil)
+	s.trustedProxies, err = loadTrustedProxies()
 	if err != nil {
-		return err
+		log.Fatal("Failed to configure trusted proxies:", err)
+	}
+
+	// Start emptying the trash in the background
+	if err := s.startTrashPurger(); err != nil {
+		log.Fatal("Failed to start trash purger:", err)
+	}
+
+	// Keep the cached catalog fresh in the background
+	if err := s.startCatalogRefresher(); err != nil {
+		log.Fatal("Failed to start catalog refresh:", err)
+	}
+
+	// Record schema changes to the catalog in the background
+	if err := s.startSchemaTracker(); err != nil {
+		log.Fatal("Failed to start schema tracking:", err)
 	}
 
-	db = mongoClient.Database("zeus")
-	log.Println("Connected to MongoDB!")
-	return nil
+	// Parse saved queries again in the background, for those saved by an older parser
+	go func() {
+		if err := s.reparseQueries(context.Background()); err != nil {
+			log.Printf("Failed to parse saved queries: %v", err)
+		}
+	}()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	log.Printf("Server starting on port %s", port)
+	s.router().Run(":" + port)
 }
 
 func healthCheck(c *gin.Context) {
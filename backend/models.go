@@ -11,8 +11,24 @@ type Query struct {
 	Name        string             `bson:"name" json:"name"`
 	SQL         string             `bson:"sql" json:"sql"`
 	Description string             `bson:"description" json:"description"`
+	Folder      string             `bson:"folder" json:"folder"` // Slash separated path, "" is the root
+	Tags        []string           `bson:"tags" json:"tags"`
+	Tables      []string           `bson:"tables" json:"tables"`       // Tables referenced by SQL, kept for search
+	Databases   []string           `bson:"databases" json:"databases"` // Databases the tables are qualified with
+	Columns     []string           `bson:"columns" json:"columns"`     // Columns referenced by SQL, as table.column
+	Lineage     *QueryLineage      `bson:"lineage,omitempty" json:"lineage,omitempty"`
+	CreatedBy   string             `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
 	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
 	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+	LastRunAt   *time.Time         `bson:"lastRunAt,omitempty" json:"lastRunAt,omitempty"`
+	DeletedAt   *time.Time         `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy   string             `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+	// Seconds results of this query are reused for, overriding RESULT_CACHE_TTL (0 disables)
+	ResultCacheTTL *int `bson:"resultCacheTtl,omitempty" json:"resultCacheTtl,omitempty"`
+	// Where the query runs by default; unqualified table names resolve in Catalog and Database
+	Catalog   string `bson:"catalog,omitempty" json:"catalog,omitempty"`
+	Database  string `bson:"database,omitempty" json:"database,omitempty"`
+	WorkGroup string `bson:"workGroup,omitempty" json:"workGroup,omitempty"`
 }
 
 type QueryRun struct {
@@ -24,14 +40,128 @@ type QueryRun struct {
 	ResultsS3URL string             `bson:"resultsS3Url" json:"resultsS3Url"`
 	ErrorMessage string             `bson:"errorMessage,omitempty" json:"errorMessage,omitempty"`
 	Parameters   map[string]string  `bson:"parameters,omitempty" json:"parameters,omitempty"`
+	ExecutedBy   string             `bson:"executedBy,omitempty" json:"executedBy,omitempty"`
 	ExecutedAt   time.Time          `bson:"executedAt" json:"executedAt"`
 	CompletedAt  *time.Time         `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+	DeletedAt    *time.Time         `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy    string             `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+	CacheKey     string             `bson:"cacheKey,omitempty" json:"-"`                    // Only set on runs Athena executed
+	FromCache    bool               `bson:"fromCache,omitempty" json:"fromCache,omitempty"` // Results were reused from an earlier execution
+	Catalog      string             `bson:"catalog,omitempty" json:"catalog,omitempty"`
+	Database     string             `bson:"database,omitempty" json:"database,omitempty"`
+	WorkGroup    string             `bson:"workGroup,omitempty" json:"workGroup,omitempty"`
+}
+
+// DownloadLink records who was handed a presigned URL to the results of an execution
+type DownloadLink struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ExecutionID  string             `bson:"executionId" json:"executionId"`
+	ResultsS3URL string             `bson:"resultsS3Url" json:"resultsS3Url"`
+	RequestedBy  string             `bson:"requestedBy,omitempty" json:"requestedBy,omitempty"`
+	ClientIP     string             `bson:"clientIp" json:"clientIp"`
+	RequestedAt  time.Time          `bson:"requestedAt" json:"requestedAt"`
+	ExpiresAt    time.Time          `bson:"expiresAt" json:"expiresAt"`
+}
+
+// ShareLink grants access to the results of one run, without signing in, until
+// it expires or is revoked. Its token is derived from the ID and never stored.
+type ShareLink struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RunID        primitive.ObjectID `bson:"runId" json:"runId"`
+	Permission   string             `bson:"permission" json:"permission"` // view, or download which also allows viewing
+	PasswordHash string             `bson:"passwordHash,omitempty" json:"-"`
+	CreatedBy    string             `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt    time.Time          `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt    *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	RevokedBy    string             `bson:"revokedBy,omitempty" json:"revokedBy,omitempty"`
+	Token        string             `bson:"-" json:"token,omitempty"`
+	Protected    bool               `bson:"-" json:"passwordProtected"` // Set from PasswordHash when returned
+}
+
+// TableSchema is the last snapshot of the columns of a catalog table. Names
+// are lowercase, as Athena treats them.
+type TableSchema struct {
+	Catalog       string    `bson:"catalog" json:"catalog"`
+	Database      string    `bson:"database" json:"database"`
+	Table         string    `bson:"table" json:"table"`
+	Columns       []Column  `bson:"columns" json:"columns"`
+	PartitionKeys []Column  `bson:"partitionKeys,omitempty" json:"partitionKeys,omitempty"`
+	SnapshotAt    time.Time `bson:"snapshotAt" json:"snapshotAt"` // When the columns were first seen like this
+}
+
+// SchemaChange is a difference between two snapshots of a table
+type SchemaChange struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Catalog    string             `bson:"catalog" json:"catalog"`
+	Database   string             `bson:"database" json:"database"`
+	Table      string             `bson:"table" json:"table"`
+	Type       string             `bson:"type" json:"type"` // table_added, table_dropped, column_added, column_removed or column_retyped
+	Column     string             `bson:"column,omitempty" json:"column,omitempty"`
+	OldType    string             `bson:"oldType,omitempty" json:"oldType,omitempty"`
+	NewType    string             `bson:"newType,omitempty" json:"newType,omitempty"`
+	DetectedAt time.Time          `bson:"detectedAt" json:"detectedAt"`
+}
+
+// QuerySchemaChanges lists the changes to the tables of a saved query since its
+// last successful run
+type QuerySchemaChanges struct {
+	QueryID         primitive.ObjectID `json:"queryId"`
+	Name            string             `json:"name"`
+	LastSucceededAt *time.Time         `json:"lastSucceededAt,omitempty"`
+	Changes         []SchemaChange     `json:"changes"`
+}
+
+// QueryLineage is the table a query creates or writes and the tables it reads
+type QueryLineage struct {
+	Type    string   `bson:"type" json:"type"` // ctas, view, insert or merge
+	Target  string   `bson:"target" json:"target"`
+	Sources []string `bson:"sources" json:"sources"`
+}
+
+// TableUsage is a saved query that uses a table
+type TableUsage struct {
+	QueryID   primitive.ObjectID `json:"queryId"`
+	Name      string             `json:"name"`
+	Folder    string             `json:"folder"`
+	Reads     bool               `json:"reads"`
+	Writes    bool               `json:"writes"`  // Whether the query creates or writes the table
+	Columns   []string           `json:"columns"` // Columns of the table the query references
+	UpdatedAt time.Time          `json:"updatedAt"`
+}
+
+// LineageGraph links tables to the tables created or written from them
+type LineageGraph struct {
+	Nodes []LineageNode `json:"nodes"`
+	Edges []LineageEdge `json:"edges"`
+}
+
+type LineageNode struct {
+	ID       string `json:"id"` // database.table, prefixed by the catalog outside AwsDataCatalog
+	Catalog  string `json:"catalog"`
+	Database string `json:"database,omitempty"` // Unknown for unqualified tables of queries without a database
+	Table    string `json:"table"`
+}
+
+// LineageEdge is a saved query that writes one table from another
+type LineageEdge struct {
+	Source    string             `json:"source"`
+	Target    string             `json:"target"`
+	Type      string             `json:"type"`
+	QueryID   primitive.ObjectID `json:"queryId"`
+	QueryName string             `json:"queryName"`
 }
 
 type CreateQueryRequest struct {
-	Name        string `json:"name" binding:"required"`
-	SQL         string `json:"sql"`
-	Description string `json:"description"`
+	Name           string   `json:"name" binding:"required"`
+	SQL            string   `json:"sql"`
+	Description    string   `json:"description"`
+	Folder         string   `json:"folder"`
+	Tags           []string `json:"tags"`
+	ResultCacheTTL *int     `json:"resultCacheTtl"`
+	Catalog        string   `json:"catalog"`
+	Database       string   `json:"database"`
+	WorkGroup      string   `json:"workGroup"`
 }
 
 type UpdateQueryRequest struct {
@@ -40,9 +170,34 @@ type UpdateQueryRequest struct {
 	Description string `json:"description"`
 }
 
+type MoveQueryRequest struct {
+	Folder string `json:"folder"`
+}
+
+type TagsRequest struct {
+	Tags []string `json:"tags" binding:"required"`
+}
+
+type TagCount struct {
+	Tag   string `bson:"_id" json:"tag"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+type FolderCount struct {
+	Path  string `json:"path"`
+	Name  string `json:"name"`
+	Depth int    `json:"depth"`
+	Count int64  `json:"count"` // Queries in this folder and all of its subfolders
+}
+
 type ExecuteQueryRequest struct {
 	SQL        string            `json:"sql" binding:"required"`
 	Parameters map[string]string `json:"parameters,omitempty"`
+	NoCache    bool              `json:"noCache,omitempty"` // Always run the query instead of reusing recent results
+	// Override where the query runs, otherwise taken from the saved query or the defaults
+	Catalog   string `json:"catalog,omitempty"`
+	Database  string `json:"database,omitempty"`
+	WorkGroup string `json:"workGroup,omitempty"`
 }
 
 type QueryResults struct {
@@ -54,27 +209,398 @@ type QueryResults struct {
 	Status       string     `json:"status"` // QUEUED, RUNNING, SUCCEEDED, FAILED, CANCELLED
 	ErrorMessage *string    `json:"errorMessage,omitempty"`
 	CompletedAt  *time.Time `json:"completedAt,omitempty"`
+	// Rows before filtering when the request was sorted, filtered or projected
+	TotalUnfiltered int64 `json:"totalUnfiltered,omitempty"`
+	// Set on table previews, whose execution can be followed while it runs
+	ExecutionID string `json:"executionId,omitempty"`
+	SQL         string `json:"sql,omitempty"`
+	FromCache   bool   `json:"fromCache,omitempty"`
+}
+
+// TablePreviewRequest is the optional body of a table preview
+type TablePreviewRequest struct {
+	Limit     int    `json:"limit,omitempty"`     // Rows to sample, 100 by default
+	NoCache   bool   `json:"noCache,omitempty"`   // Run again instead of reusing a recent preview
+	WorkGroup string `json:"workGroup,omitempty"` // ATHENA_WORKGROUP by default
+}
+
+// RunDiff compares the results of two runs of a query on key columns. Keys and
+// values are listed in the order of Keys and Columns.
+type RunDiff struct {
+	From      primitive.ObjectID `json:"from"`
+	To        primitive.ObjectID `json:"to"`
+	Keys      []string           `json:"keys"`
+	Columns   []string           `json:"columns"` // Columns present in both runs
+	Added     []DiffRow          `json:"added"`
+	Removed   []DiffRow          `json:"removed"`
+	Changed   []DiffChange       `json:"changed"`
+	Summary   RunDiffSummary     `json:"summary"`
+	Truncated bool               `json:"truncated"` // Lists were cut at the limit; Summary counts everything
+}
+
+type DiffRow struct {
+	Key    []string `json:"key"`
+	Values []string `json:"values"`
+}
+
+type DiffChange struct {
+	Key   []string   `json:"key"`
+	Cells []DiffCell `json:"cells"`
+}
+
+type DiffCell struct {
+	Column string   `json:"column"`
+	Before string   `json:"before"`
+	After  string   `json:"after"`
+	Delta  *float64 `json:"delta,omitempty"` // After minus before, for numeric columns
+}
+
+type RunDiffSummary struct {
+	FromRows       int64    `json:"fromRows"`
+	ToRows         int64    `json:"toRows"`
+	Added          int64    `json:"added"`
+	Removed        int64    `json:"removed"`
+	Changed        int64    `json:"changed"`
+	Unchanged      int64    `json:"unchanged"`
+	DuplicateKeys  int64    `json:"duplicateKeys"` // Rows ignored because an earlier row had the same key
+	AddedColumns   []string `json:"addedColumns"`
+	RemovedColumns []string `json:"removedColumns"`
+}
+
+// ResultProfile summarizes every column of a result set. Results never change,
+// so a profile is computed once per execution and stored.
+type ResultProfile struct {
+	ExecutionID string          `bson:"_id" json:"executionId"`
+	Rows        int64           `bson:"rows" json:"rows"`
+	Columns     []ColumnProfile `bson:"columns" json:"columns"`
+	Sampled     bool            `bson:"sampled" json:"sampled"` // Percentiles and histograms come from a sample of the rows
+	ComputedAt  time.Time       `bson:"computedAt" json:"computedAt"`
+}
+
+type ColumnProfile struct {
+	Name           string             `bson:"name" json:"name"`
+	Type           string             `bson:"type" json:"type"`
+	Nulls          int64              `bson:"nulls" json:"nulls"`
+	Distinct       int64              `bson:"distinct" json:"distinct"`
+	DistinctCapped bool               `bson:"distinctCapped,omitempty" json:"distinctCapped,omitempty"` // Distinct is a lower bound
+	Min            *string            `bson:"min,omitempty" json:"min,omitempty"`
+	Max            *string            `bson:"max,omitempty" json:"max,omitempty"`
+	Mean           *float64           `bson:"mean,omitempty" json:"mean,omitempty"`
+	StdDev         *float64           `bson:"stdDev,omitempty" json:"stdDev,omitempty"`
+	Percentiles    map[string]float64 `bson:"percentiles,omitempty" json:"percentiles,omitempty"` // p5, p25, p50, p75 and p95
+	TopValues      []ValueCount       `bson:"topValues,omitempty" json:"topValues,omitempty"`
+	Histogram      []HistogramBin     `bson:"histogram,omitempty" json:"histogram,omitempty"`
+}
+
+type ValueCount struct {
+	Value string `bson:"value" json:"value"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// HistogramBin counts the values from Start up to End, formatted like the column
+type HistogramBin struct {
+	Start string `bson:"start" json:"start"`
+	End   string `bson:"end" json:"end"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// Visualization is a chart definition saved with a query and drawn from the
+// results of its runs
+type Visualization struct {
+	ID          primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	QueryID     primitive.ObjectID     `bson:"queryId" json:"queryId"`
+	Name        string                 `bson:"name" json:"name"`
+	Type        string                 `bson:"type" json:"type"` // line, bar, pie, scatter, pivot or counter
+	Columns     VisualizationColumns   `bson:"columns" json:"columns"`
+	Aggregation string                 `bson:"aggregation" json:"aggregation"`             // none, count, sum, avg, min or max
+	Options     map[string]interface{} `bson:"options,omitempty" json:"options,omitempty"` // Display settings, only read by the frontend
+	CreatedBy   string                 `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt   time.Time              `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time              `bson:"updatedAt" json:"updatedAt"`
+}
+
+// VisualizationColumns maps result columns onto a chart
+type VisualizationColumns struct {
+	X      string   `bson:"x,omitempty" json:"x,omitempty"`           // Categories, slices of a pie or rows of a pivot
+	Y      []string `bson:"y" json:"y"`                               // Values, one series each
+	Series string   `bson:"series,omitempty" json:"series,omitempty"` // Splits each value into a series per value of this column, or the columns of a pivot
+}
+
+type VisualizationRequest struct {
+	Name        string                 `json:"name" binding:"required"`
+	Type        string                 `json:"type" binding:"required"`
+	Columns     VisualizationColumns   `json:"columns"`
+	Aggregation string                 `json:"aggregation"`
+	Options     map[string]interface{} `json:"options"`
+}
+
+// ChartData is a visualization aggregated from the results of a run. Series
+// values line up with Labels; nil marks a label without values in the series.
+type ChartData struct {
+	VisualizationID primitive.ObjectID `json:"visualizationId"`
+	RunID           primitive.ObjectID `json:"runId"`
+	Type            string             `json:"type"`
+	Labels          []string           `json:"labels"`
+	Series          []ChartSeries      `json:"series"`
+	Value           *float64           `json:"value,omitempty"` // The number shown by counters
+	Rows            int64              `json:"rows"`            // Result rows read
+	Truncated       bool               `json:"truncated"`       // Labels or series past the limits were left out
+}
+
+type ChartSeries struct {
+	Name   string     `json:"name"`
+	Values []*float64 `json:"values"`
+}
+
+// Dashboard is a grid of widgets showing the results of saved queries. Its
+// parameters fill the {{param}} placeholders of the widget queries.
+type Dashboard struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Name        string               `bson:"name" json:"name"`
+	Description string               `bson:"description" json:"description"`
+	Parameters  []DashboardParameter `bson:"parameters" json:"parameters"`
+	Widgets     []DashboardWidget    `bson:"widgets" json:"widgets"`
+	CreatedBy   string               `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt   time.Time            `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time            `bson:"updatedAt" json:"updatedAt"`
+}
+
+type DashboardParameter struct {
+	Name    string `bson:"name" json:"name"`
+	Default string `bson:"default" json:"default"` // Used when a refresh doesn't give a value
+}
+
+type DashboardWidget struct {
+	ID              string              `bson:"id" json:"id"`
+	QueryID         primitive.ObjectID  `bson:"queryId" json:"queryId"`
+	VisualizationID *primitive.ObjectID `bson:"visualizationId,omitempty" json:"visualizationId,omitempty"` // Shows the results table when nil
+	Title           string              `bson:"title,omitempty" json:"title,omitempty"`
+	Layout          WidgetLayout        `bson:"layout" json:"layout"`
+	// Query placeholders filled from dashboard parameters of another name;
+	// placeholders named like a dashboard parameter are filled from it anyway
+	Parameters map[string]string `bson:"parameters,omitempty" json:"parameters,omitempty"`
+}
+
+// WidgetLayout places a widget on a 12 column grid
+type WidgetLayout struct {
+	X int `bson:"x" json:"x"`
+	Y int `bson:"y" json:"y"`
+	W int `bson:"w" json:"w"`
+	H int `bson:"h" json:"h"`
+}
+
+type DashboardRequest struct {
+	Name        string               `json:"name" binding:"required"`
+	Description string               `json:"description"`
+	Parameters  []DashboardParameter `json:"parameters"`
+	Widgets     []DashboardWidget    `json:"widgets"`
+}
+
+type RefreshDashboardRequest struct {
+	Parameters map[string]string `json:"parameters"` // Overrides the parameter defaults
+	NoCache    bool              `json:"noCache"`
+}
+
+// DashboardRuns lists the runs behind the widgets of a dashboard, in widget order
+type DashboardRuns struct {
+	DashboardID primitive.ObjectID `json:"dashboardId"`
+	Widgets     []WidgetRun        `json:"widgets"`
+}
+
+// WidgetRun is the run behind a widget, or why the widget couldn't be run
+type WidgetRun struct {
+	WidgetID string    `json:"widgetId"`
+	Run      *QueryRun `json:"run,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type CreateShareLinkRequest struct {
+	Permission string `json:"permission"` // view (the default) or download
+	ExpiresIn  int    `json:"expiresIn"`  // Seconds, 7 days when 0
+	Password   string `json:"password"`
+}
+
+// SharedRun is what a share link tells about its run: never the SQL or the query
+type SharedRun struct {
+	Permission        string     `json:"permission"`
+	PasswordProtected bool       `json:"passwordProtected"`
+	ExpiresAt         time.Time  `json:"expiresAt"`
+	ExecutedAt        time.Time  `json:"executedAt"`
+	CompletedAt       *time.Time `json:"completedAt,omitempty"`
+}
+
+type Highlight struct {
+	Field    string   `json:"field"`
+	Fragment string   `json:"fragment"`
+	Matches  [][2]int `json:"matches"` // Byte offsets of matched terms within Fragment
+}
+
+type SearchHit struct {
+	Type       string      `json:"type"` // query or run
+	Score      float64     `json:"score"`
+	Query      *Query      `json:"query,omitempty"`
+	Run        *QueryRun   `json:"run,omitempty"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+type SearchResponse struct {
+	Query string      `json:"query"`
+	Hits  []SearchHit `json:"hits"`
+}
+
+// CatalogSearchHit is a database, table or column matching a catalog search
+type CatalogSearchHit struct {
+	Type        string  `json:"type"` // database, table or column
+	Score       float64 `json:"score"`
+	Database    string  `json:"database"`
+	Table       string  `json:"table,omitempty"`
+	Column      string  `json:"column,omitempty"`
+	DataType    string  `json:"dataType,omitempty"`    // Of columns
+	Description string  `json:"description,omitempty"` // Database description or table and column comment
+}
+
+type CatalogSearchResponse struct {
+	Query   string             `json:"query"`
+	Catalog string             `json:"catalog"`
+	Hits    []CatalogSearchHit `json:"hits"`
+	Total   int                `json:"total"` // Matches before the limit
+}
+
+type AutocompleteRequest struct {
+	SQL      string `json:"sql"`
+	Cursor   *int   `json:"cursor,omitempty"`   // In characters, the end of the SQL by default
+	Catalog  string `json:"catalog,omitempty"`  // AwsDataCatalog by default
+	Database string `json:"database,omitempty"` // Where unqualified table names are looked up
+}
+
+type AutocompleteSuggestion struct {
+	Label      string  `json:"label"`
+	Type       string  `json:"type"`             // database, table or column
+	Detail     string  `json:"detail,omitempty"` // Table of a column and its type, or database of a table
+	InsertText string  `json:"insertText"`       // Quoted when the name needs it
+	Score      float64 `json:"score"`
+}
+
+type AutocompleteResponse struct {
+	Context     string                   `json:"context"`     // table, column or none
+	Prefix      string                   `json:"prefix"`      // Partial name before the cursor
+	ReplaceFrom int                      `json:"replaceFrom"` // Where the prefix starts, in characters
+	Suggestions []AutocompleteSuggestion `json:"suggestions"`
+}
+
+type SQLFormatRequest struct {
+	SQL         string `json:"sql"`
+	KeywordCase string `json:"keywordCase,omitempty"` // upper (default), lower or preserve
+	Indent      *int   `json:"indent,omitempty"`      // Spaces per level, 2 by default
+	UseTabs     bool   `json:"useTabs,omitempty"`     // Indent with a tab per level instead
+}
+
+type SQLFormatResponse struct {
+	SQL string `json:"sql"`
+}
+
+type SQLLintRequest struct {
+	SQL      string `json:"sql"`
+	Catalog  string `json:"catalog,omitempty"`  // AwsDataCatalog by default
+	Database string `json:"database,omitempty"` // Where unqualified table names are looked up
+}
+
+// SQLProblem is a problem lint found in SQL, and where
+type SQLProblem struct {
+	Rule      string `json:"rule"`
+	Severity  string `json:"severity"` // error or warning
+	Message   string `json:"message"`
+	Line      int    `json:"line"`      // From 1
+	Column    int    `json:"column"`    // From 1, in characters
+	EndLine   int    `json:"endLine"`   // Where the problem ends
+	EndColumn int    `json:"endColumn"` // Exclusive
+}
+
+type SQLLintResponse struct {
+	Problems  []SQLProblem `json:"problems"`
+	Truncated bool         `json:"truncated"` // More problems than maxLintProblems were found
+	// Why tables and columns weren't checked against the catalog
+	CatalogError string `json:"catalogError,omitempty"`
 }
 
 type CatalogTable struct {
-	Name        string   `json:"name"`
-	Type        string   `json:"type"`
-	Columns     []Column `json:"columns"`
-	Location    string   `json:"location,omitempty"`
-	InputFormat string   `json:"inputFormat,omitempty"`
+	Name           string            `json:"name"`
+	Type           string            `json:"type"`
+	Comment        string            `json:"comment,omitempty"`
+	Columns        []Column          `json:"columns"`
+	PartitionKeys  []Column          `json:"partitionKeys,omitempty"`
+	Location       string            `json:"location,omitempty"`
+	InputFormat    string            `json:"inputFormat,omitempty"`
+	OutputFormat   string            `json:"outputFormat,omitempty"`
+	SerDe          string            `json:"serde,omitempty"`          // Serialization library
+	Classification string            `json:"classification,omitempty"` // Data format, such as parquet or csv
+	Properties     map[string]string `json:"properties,omitempty"`     // Other table parameters
+	CreatedAt      *time.Time        `json:"createdAt,omitempty"`
+	UpdatedAt      *time.Time        `json:"updatedAt,omitempty"` // Last DDL change
+	Partitions     *TablePartitions  `json:"partitions,omitempty"`
 }
 
 type Column struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// TablePartitions summarizes the partitions of a table, as listed in Glue
+type TablePartitions struct {
+	Count     int         `json:"count"`
+	Truncated bool        `json:"truncated,omitempty"` // More partitions than were counted
+	Projected bool        `json:"projected,omitempty"` // Partition projection; none are stored in Glue
+	Recent    []Partition `json:"recent"`              // Most recently created first
+	LoadedAt  time.Time   `json:"loadedAt"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type Partition struct {
+	Values    []string   `json:"values"` // In the order of the partition keys
+	Location  string     `json:"location,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
 }
 
 type CatalogDatabase struct {
 	Name        string         `json:"name"`
 	Description string         `json:"description,omitempty"`
 	Tables      []CatalogTable `json:"tables"`
+	LoadedAt    *time.Time     `json:"loadedAt,omitempty"` // When the tables were last listed completely
+	Error       string         `json:"error,omitempty"`    // Why the last listing failed; Tables may be stale or partial
+}
+
+// CatalogDatabaseInfo describes a database without listing its tables
+type CatalogDatabaseInfo struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	TableCount  *int       `json:"tableCount,omitempty"` // Once the tables were loaded
+	LoadedAt    *time.Time `json:"loadedAt,omitempty"`
+	Error       string     `json:"error,omitempty"`
 }
 
 type AthenaCatalog struct {
+	Catalog   string            `json:"catalog"`
 	Databases []CatalogDatabase `json:"databases"`
+	ListedAt  time.Time         `json:"listedAt"` // When the databases were last listed
+}
+
+// DataCatalog is a data catalog registered with Athena
+type DataCatalog struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // GLUE, LAMBDA or HIVE
+}
+
+// WorkGroup is an Athena workgroup queries can run in
+type WorkGroup struct {
+	Name          string     `json:"name"`
+	State         string     `json:"state"` // ENABLED or DISABLED
+	Description   string     `json:"description,omitempty"`
+	EngineVersion string     `json:"engineVersion,omitempty"`
+	CreatedAt     *time.Time `json:"createdAt,omitempty"`
+}
+
+type WorkGroupList struct {
+	Default    string      `json:"default"` // Where queries without a workgroup run
+	WorkGroups []WorkGroup `json:"workGroups"`
 }
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultPageSize = 100
+const maxPageSize = 1000
+
+// A field that listings can be sorted on, as named in the ?sort= parameter
+type sortField struct {
+	Field  string // Document field
+	IsTime bool   // Whether values are timestamps (which may be missing) or strings
+}
+
+var querySortFields = map[string]sortField{
+	"updated": {Field: "updatedAt", IsTime: true},
+	"created": {Field: "createdAt", IsTime: true},
+	"name":    {Field: "name"},
+	"lastRun": {Field: "lastRunAt", IsTime: true},
+}
+
+var queryRunSortFields = map[string]sortField{
+	"executed":  {Field: "executedAt", IsTime: true},
+	"completed": {Field: "completedAt", IsTime: true},
+}
+
+var trashSortFields = map[string]sortField{
+	"deleted": {Field: "deletedAt", IsTime: true},
+}
+
+// Position after the last item of a page. Value is nil when the sort field is missing.
+type pageCursor struct {
+	Value interface{}
+	ID    primitive.ObjectID
+}
+
+type pageRequest struct {
+	SortParam string
+	Sort      sortField
+	Desc      bool
+	Limit     int
+	After     *pageCursor
+}
+
+type encodedCursor struct {
+	Value *string `json:"v"`
+	ID    string  `json:"id"`
+}
+
+func encodeCursor(cursor pageCursor) string {
+	encoded := encodedCursor{ID: cursor.ID.Hex()}
+	switch value := cursor.Value.(type) {
+	case time.Time:
+		s := value.UTC().Format(time.RFC3339Nano)
+		encoded.Value = &s
+	case string:
+		encoded.Value = &value
+	}
+
+	data, _ := json.Marshal(encoded)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string, field sortField) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var encoded encodedCursor
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	id, err := primitive.ObjectIDFromHex(encoded.ID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	cursor := &pageCursor{ID: id}
+	if encoded.Value != nil {
+		if field.IsTime {
+			t, err := time.Parse(time.RFC3339Nano, *encoded.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cursor")
+			}
+			cursor.Value = t
+		} else {
+			cursor.Value = *encoded.Value
+		}
+	}
+	return cursor, nil
+}
+
+// Helper function to read ?sort=&order=&limit=&cursor= into a page request.
+// The cursor is only valid for the sort and order it was issued with.
+func parsePageRequest(c *gin.Context, fields map[string]sortField, defaultSort string) (pageRequest, error) {
+	page := pageRequest{SortParam: c.DefaultQuery("sort", defaultSort)}
+
+	field, ok := fields[page.SortParam]
+	if !ok {
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return page, fmt.Errorf("sort must be one of %s", strings.Join(names, ", "))
+	}
+	page.Sort = field
+
+	switch c.DefaultQuery("order", "desc") {
+	case "desc":
+		page.Desc = true
+	case "asc":
+	default:
+		return page, fmt.Errorf("order must be asc or desc")
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 {
+		return page, fmt.Errorf("invalid limit")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	page.Limit = limit
+
+	if raw := c.Query("cursor"); raw != "" {
+		page.After, err = decodeCursor(raw, field)
+		if err != nil {
+			return page, err
+		}
+	}
+
+	return page, nil
+}
+
+// Helper function to advertise the total and the first/next pages (RFC 8288)
+func setPaginationHeaders(c *gin.Context, total int64, next string) {
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+
+	link := func(cursor string, rel string) string {
+		values := c.Request.URL.Query()
+		values.Del("cursor")
+		if cursor != "" {
+			values.Set("cursor", cursor)
+		}
+		u := *c.Request.URL
+		u.RawQuery = values.Encode()
+		return fmt.Sprintf("<%s>; rel=\"%s\"", u.RequestURI(), rel)
+	}
+
+	links := []string{link("", "first")}
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+		links = append(links, link(next, "next"))
+	}
+	c.Header("Link", strings.Join(links, ", "))
+}
+
+// Helper function to read an optional date range from two query parameters
+func parseDateRange(c *gin.Context, afterParam, beforeParam string) (*time.Time, *time.Time, error) {
+	var after, before *time.Time
+	if value := c.Query(afterParam); value != "" {
+		t, err := parseDateParam(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s date", afterParam)
+		}
+		after = &t
+	}
+	if value := c.Query(beforeParam); value != "" {
+		t, err := parseDateParam(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s date", beforeParam)
+		}
+		before = &t
+	}
+	return after, before, nil
+}
+
+// Helper function to read the sort value of a query for the next page cursor
+func queryCursorFunc(field sortField) func(Query) pageCursor {
+	return func(query Query) pageCursor {
+		cursor := pageCursor{ID: query.ID}
+		switch field.Field {
+		case "createdAt":
+			cursor.Value = query.CreatedAt
+		case "name":
+			cursor.Value = query.Name
+		case "lastRunAt":
+			if query.LastRunAt != nil {
+				cursor.Value = *query.LastRunAt
+			}
+		case "deletedAt":
+			if query.DeletedAt != nil {
+				cursor.Value = *query.DeletedAt
+			}
+		default:
+			cursor.Value = query.UpdatedAt
+		}
+		return cursor
+	}
+}
+
+// Helper function to read the sort value of a query run for the next page cursor
+func queryRunCursorFunc(field sortField) func(QueryRun) pageCursor {
+	return func(run QueryRun) pageCursor {
+		cursor := pageCursor{ID: run.ID}
+		switch field.Field {
+		case "completedAt":
+			if run.CompletedAt != nil {
+				cursor.Value = *run.CompletedAt
+			}
+		case "deletedAt":
+			if run.DeletedAt != nil {
+				cursor.Value = *run.DeletedAt
+			}
+		default:
+			cursor.Value = run.ExecutedAt
+		}
+		return cursor
+	}
+}
+
+// Helper function to read ?status= (repeatable or comma separated) for run listings
+func parseStatusFilter(c *gin.Context) ([]string, error) {
+	var statuses []string
+	for _, value := range c.QueryArray("status") {
+		for _, status := range strings.Split(value, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			switch status {
+			case "":
+				continue
+			case "QUEUED", "RUNNING", "SUCCEEDED", "FAILED", "CANCELLED":
+				statuses = append(statuses, status)
+			default:
+				return nil, fmt.Errorf("unknown status %q", status)
+			}
+		}
+	}
+	return statuses, nil
+}
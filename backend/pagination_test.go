@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestQueryListPagination(t *testing.T) {
+	ts := newTestServer(t)
+	for _, name := range []string{"delta", "alpha", "echo", "charlie", "bravo"} {
+		createTestQuery(t, ts, name, "SELECT 1")
+	}
+
+	tests := []struct {
+		name   string
+		params string
+		want   []string
+	}{
+		{name: "by name ascending", params: "sort=name&order=asc&limit=2", want: []string{"alpha", "bravo", "charlie", "delta", "echo"}},
+		{name: "by name descending", params: "sort=name&order=desc&limit=3", want: []string{"echo", "delta", "charlie", "bravo", "alpha"}},
+		{name: "most recently created first", params: "sort=created&limit=4", want: []string{"bravo", "charlie", "echo", "alpha", "delta"}},
+		{name: "one page", params: "sort=name&order=asc", want: []string{"alpha", "bravo", "charlie", "delta", "echo"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			path := "/api/queries?" + tt.params
+			for pages := 0; path != ""; pages++ {
+				if pages > len(tt.want) {
+					t.Fatalf("still paging after %d pages", pages)
+				}
+
+				rec := ts.do(t, http.MethodGet, path, nil)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+				}
+				if total := rec.Header().Get("X-Total-Count"); total != "5" {
+					t.Errorf("X-Total-Count %q, want 5", total)
+				}
+
+				var page []Query
+				decodeBody(t, rec, &page)
+				for _, query := range page {
+					names = append(names, query.Name)
+				}
+
+				path = ""
+				if cursor := rec.Header().Get("X-Next-Cursor"); cursor != "" {
+					if !strings.Contains(rec.Header().Get("Link"), `rel="next"`) {
+						t.Errorf("Link %q has no next page", rec.Header().Get("Link"))
+					}
+					path = "/api/queries?" + tt.params + "&cursor=" + url.QueryEscape(cursor)
+				}
+			}
+
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("queries %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryListInvalidParams(t *testing.T) {
+	ts := newTestServer(t)
+	createTestQuery(t, ts, "alpha", "SELECT 1")
+
+	for _, params := range []string{
+		"sort=size",
+		"order=up",
+		"limit=0",
+		"limit=many",
+		"cursor=not-a-cursor",
+		"createdAfter=yesterday",
+	} {
+		t.Run(params, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, "/api/queries?"+params, nil)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestQueryRunListPagination(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.Script("from failing", fakeQuery{States: []string{"FAILED"}, Reason: "boom"})
+	query := createTestQuery(t, ts, "Orders", "SELECT 1")
+
+	var want []string
+	for i, sql := range []string{"SELECT 1", "SELECT 2 FROM failing", "SELECT 3", "SELECT 4 FROM failing", "SELECT 5"} {
+		run := runTestQuery(t, ts, query, gin.H{"sql": sql})
+		if i%2 == 0 {
+			want = append([]string{run.ID.Hex()}, want...)
+		}
+		time.Sleep(time.Millisecond) // Keep execution times apart
+	}
+
+	var got []string
+	path := "/api/queries/" + query.ID.Hex() + "/runs?status=SUCCEEDED&limit=2"
+	for path != "" {
+		rec := ts.do(t, http.MethodGet, path, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		if total := rec.Header().Get("X-Total-Count"); total != "3" {
+			t.Errorf("X-Total-Count %q, want 3", total)
+		}
+
+		var runs []QueryRun
+		decodeBody(t, rec, &runs)
+		for _, run := range runs {
+			got = append(got, run.ID.Hex())
+		}
+
+		path = ""
+		if cursor := rec.Header().Get("X-Next-Cursor"); cursor != "" {
+			path = "/api/queries/" + query.ID.Hex() + "/runs?status=SUCCEEDED&limit=2&cursor=" + url.QueryEscape(cursor)
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("runs %v, want the succeeded runs newest first %v", got, want)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/glue"
+)
+
+// Largest page Glue GetPartitions returns
+const partitionPageSize = 1000
+
+// Most partitions counted for a table; Truncated is set past it
+const maxPartitionCount = 100000
+
+// How many of the most recently created partitions a summary keeps
+const recentPartitionCount = 10
+
+// Helper function to key the partition summary of a table
+func partitionKey(database, table string) string {
+	return database + "/" + table
+}
+
+// Helper function to return the partition summary of a table, listing its
+// partitions in Glue unless they are cached; refresh lists them again
+// regardless. Unpartitioned tables and catalogs other than AwsDataCatalog
+// have none.
+func (cache *catalogCache) tablePartitions(database string, table CatalogTable, refresh bool) *TablePartitions {
+	if len(table.PartitionKeys) == 0 || cache.glue == nil {
+		return nil
+	}
+	if table.Properties["projection.enabled"] == "true" {
+		// Athena computes the partitions from the projection settings in Properties
+		return &TablePartitions{Projected: true, Recent: []Partition{}, LoadedAt: time.Now()}
+	}
+
+	key := partitionKey(database, table.Name)
+	if !refresh {
+		cache.mu.RLock()
+		partitions, ok := cache.partitions[key]
+		cache.mu.RUnlock()
+		if ok {
+			return partitions
+		}
+	}
+
+	result, _, _ := cache.loads.Do("partitions:"+key, func() (interface{}, error) {
+		partitions, err := cache.listPartitions(database, table.Name)
+		if err != nil {
+			// Failures aren't cached, so the next request tries again. Like
+			// databases, the summary of the last complete listing is kept.
+			log.Printf("Failed to list partitions of %s.%s: %v", database, table.Name, err)
+			cache.mu.RLock()
+			if cached, ok := cache.partitions[key]; ok {
+				stale := *cached
+				partitions = &stale
+			}
+			cache.mu.RUnlock()
+			partitions.Error = err.Error()
+			return partitions, nil
+		}
+
+		cache.mu.Lock()
+		if cache.partitions == nil {
+			cache.partitions = map[string]*TablePartitions{}
+		}
+		cache.partitions[key] = partitions
+		cache.mu.Unlock()
+		return partitions, nil
+	})
+	return result.(*TablePartitions)
+}
+
+// Helper function to count the partitions of a table in Glue, following
+// NextToken, and keep the most recently created ones. On failure the
+// partitions counted before it are returned with the error.
+func (cache *catalogCache) listPartitions(database, table string) (*TablePartitions, error) {
+	input := &glue.GetPartitionsInput{
+		DatabaseName:        aws.String(database),
+		TableName:           aws.String(table),
+		ExcludeColumnSchema: aws.Bool(true),
+		MaxResults:          aws.Int64(partitionPageSize),
+	}
+
+	partitions := &TablePartitions{Recent: []Partition{}, LoadedAt: time.Now()}
+	for {
+		output, err := cache.glue.GetPartitions(input)
+		if err != nil {
+			return partitions, fmt.Errorf("failed to list partitions after %d partitions: %v", partitions.Count, err)
+		}
+
+		for _, partition := range output.Partitions {
+			partitions.Recent = append(partitions.Recent, catalogPartition(partition))
+		}
+		partitions.Count += len(output.Partitions)
+		sortPartitions(partitions.Recent)
+		if len(partitions.Recent) > recentPartitionCount {
+			partitions.Recent = partitions.Recent[:recentPartitionCount]
+		}
+
+		if aws.StringValue(output.NextToken) == "" {
+			return partitions, nil
+		}
+		if partitions.Count >= maxPartitionCount {
+			partitions.Truncated = true
+			return partitions, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// Helper function to convert a Glue partition
+func catalogPartition(partition *glue.Partition) Partition {
+	result := Partition{
+		Values:    aws.StringValueSlice(partition.Values),
+		CreatedAt: partition.CreationTime,
+	}
+	if partition.StorageDescriptor != nil {
+		result.Location = aws.StringValue(partition.StorageDescriptor.Location)
+	}
+	return result
+}
+
+// Helper function to order partitions newest first. Partitions created at the
+// same time, such as by one MSCK REPAIR TABLE, are ordered by their values.
+func sortPartitions(partitions []Partition) {
+	sort.SliceStable(partitions, func(i, j int) bool {
+		a, b := partitions[i].CreatedAt, partitions[j].CreatedAt
+		if a != nil && b != nil && !a.Equal(*b) {
+			return a.After(*b)
+		}
+		if (a == nil) != (b == nil) {
+			return a != nil
+		}
+		return strings.Join(partitions[i].Values, "/") > strings.Join(partitions[j].Values, "/")
+	})
+}
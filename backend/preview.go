@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+const defaultPreviewLimit = 100
+
+const maxPreviewLimit = 1000
+
+// How long a preview request waits for its query before answering with its status
+const previewTimeout = 30 * time.Second
+
+const previewPollInterval = 500 * time.Millisecond
+
+// Partition value Hive stores for NULL partition keys
+const hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// Partition key types that can be cast to from a string literal
+var partitionTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*(\([0-9, ]+\))?$`)
+
+// tablePreviews remembers the executions of recent previews. Previews aren't
+// runs of a saved query, so they can't be found through the query run cache.
+type tablePreviews struct {
+	mu    sync.Mutex
+	byKey map[string]tablePreview // By result cache key
+
+	// Previews being started, so concurrent requests share one execution
+	starts singleflight.Group
+}
+
+type tablePreview struct {
+	executionID string
+	startedAt   time.Time
+}
+
+// Helper function to quote an identifier for Athena
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// Helper function to compare a partition key with one of its values. Partition
+// values are strings in Glue, so other types are cast to the type of the key.
+func partitionCondition(key Column, value string) string {
+	if value == hiveDefaultPartition {
+		return quoteIdentifier(key.Name) + " IS NULL"
+	}
+
+	literal := "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	keyType := strings.ToLower(key.Type)
+	if keyType != "string" && !strings.HasPrefix(keyType, "varchar") && !strings.HasPrefix(keyType, "char") &&
+		partitionTypePattern.MatchString(keyType) {
+		literal = "CAST(" + literal + " AS " + keyType + ")"
+	}
+	return quoteIdentifier(key.Name) + " = " + literal
+}
+
+// Helper function to build the SQL of a table preview, limited to one partition
+// when partition is set
+func tablePreviewSQL(catalog, database string, table CatalogTable, partition *Partition, limit int) string {
+	sql := "SELECT * FROM " + quoteIdentifier(catalog) + "." + quoteIdentifier(database) + "." + quoteIdentifier(table.Name)
+
+	if partition != nil {
+		var conditions []string
+		for i, key := range table.PartitionKeys {
+			if i < len(partition.Values) {
+				conditions = append(conditions, partitionCondition(key, partition.Values[i]))
+			}
+		}
+		if len(conditions) > 0 {
+			sql += " WHERE " + strings.Join(conditions, " AND ")
+		}
+	}
+
+	return fmt.Sprintf("%s LIMIT %d", sql, limit)
+}
+
+// Helper function to start a preview, or reuse one started within ttl. The
+// returned bool reports whether the preview was reused.
+func (s *Server) startTablePreview(sql string, exec executionContext, ttl time.Duration) (string, bool, error) {
+	key := resultCacheKey(resultCacheEngine(exec), sql, nil)
+
+	if ttl > 0 {
+		s.previews.mu.Lock()
+		preview, ok := s.previews.byKey[key]
+		s.previews.mu.Unlock()
+		if ok && time.Since(preview.startedAt) < ttl {
+			return preview.executionID, true, nil
+		}
+	}
+
+	result, err, shared := s.previews.starts.Do(key, func() (interface{}, error) {
+		executionID, err := s.executeAthenaQueryInternal(sql, exec, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if ttl <= 0 {
+			return executionID, nil
+		}
+
+		s.previews.mu.Lock()
+		defer s.previews.mu.Unlock()
+
+		now := time.Now()
+		if s.previews.byKey == nil {
+			s.previews.byKey = map[string]tablePreview{}
+		}
+		for k, preview := range s.previews.byKey {
+			if now.Sub(preview.startedAt) >= ttl {
+				delete(s.previews.byKey, k)
+			}
+		}
+		s.previews.byKey[key] = tablePreview{executionID: executionID, startedAt: now}
+		return executionID, nil
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return result.(string), shared, nil
+}
+
+// Helper function to forget a preview that failed, so the next one runs again
+func (s *Server) forgetTablePreview(executionID string) {
+	s.previews.mu.Lock()
+	defer s.previews.mu.Unlock()
+
+	for key, preview := range s.previews.byKey {
+		if preview.executionID == executionID {
+			delete(s.previews.byKey, key)
+		}
+	}
+}
+
+// Helper function to poll a preview until it finished or previewTimeout passed
+func (s *Server) waitForPreview(ctx context.Context, executionID string, limit int) (*QueryResults, error) {
+	deadline := time.Now().Add(previewTimeout)
+	for {
+		results, err := s.getAthenaResults(executionID, 1, limit)
+		if err != nil {
+			return nil, err
+		}
+		if results.Status != "QUEUED" && results.Status != "RUNNING" {
+			return results, nil
+		}
+		if time.Now().After(deadline) {
+			return results, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(previewPollInterval):
+		}
+	}
+}
+
+func (s *Server) previewTable(c *gin.Context) {
+	var req TablePreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultPreviewLimit
+	}
+	if req.Limit < 1 || req.Limit > maxPreviewLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPreviewLimit)})
+		return
+	}
+
+	cache, db, table, ok := s.requestCatalogTable(c)
+	if !ok {
+		return
+	}
+
+	// Partitioned tables are sampled from their latest partition, so the
+	// preview doesn't list every partition of the table
+	var latest *Partition
+	if partitions := cache.tablePartitions(db.Name, table, false); partitions != nil && len(partitions.Recent) > 0 {
+		latest = &partitions.Recent[0]
+	}
+	sql := tablePreviewSQL(cache.name, db.Name, table, latest, req.Limit)
+
+	exec := s.resolveExecutionContext(nil, executionContext{
+		Catalog:   cache.name,
+		Database:  db.Name,
+		WorkGroup: strings.TrimSpace(req.WorkGroup),
+	})
+	ttl := s.resultCacheTTL
+	if req.NoCache {
+		ttl = 0
+	}
+
+	executionID, fromCache, err := s.startTablePreview(sql, exec, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := s.waitForPreview(c.Request.Context(), executionID, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if results.Columns == nil {
+		results.Columns = []string{}
+	}
+	if results.Rows == nil {
+		results.Rows = [][]string{}
+	}
+	results.ExecutionID = executionID
+	results.SQL = sql
+	results.FromCache = fromCache
+
+	switch results.Status {
+	case "SUCCEEDED":
+		c.JSON(http.StatusOK, results)
+	case "QUEUED", "RUNNING":
+		// Still running, follow it through the results of the execution
+		c.JSON(http.StatusAccepted, results)
+	default:
+		// Failed and cancelled previews are errors, with the reason Athena gave
+		s.forgetTablePreview(executionID)
+		message := "Preview " + strings.ToLower(results.Status)
+		if results.ErrorMessage != nil && *results.ErrorMessage != "" {
+			message = *results.ErrorMessage
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message, "status": results.Status, "executionId": executionID})
+	}
+}
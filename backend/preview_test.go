@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/gin-gonic/gin"
+)
+
+func TestTablePreviewSQL(t *testing.T) {
+	table := CatalogTable{Name: `my"table`, PartitionKeys: []Column{
+		{Name: "dt", Type: "string"},
+		{Name: "year", Type: "int"},
+		{Name: "price", Type: "decimal(10, 2)"},
+		{Name: "tags", Type: "array<string>"},
+	}}
+
+	tests := []struct {
+		name      string
+		partition *Partition
+		want      string
+	}{
+		{name: "whole table", want: `SELECT * FROM "AwsDataCatalog"."sales"."my""table" LIMIT 10`},
+		{
+			name:      "partition",
+			partition: &Partition{Values: []string{"it's", "2024", "9.99", "a"}},
+			want: `SELECT * FROM "AwsDataCatalog"."sales"."my""table" WHERE "dt" = 'it''s' AND "year" = CAST('2024' AS int)` +
+				` AND "price" = CAST('9.99' AS decimal(10, 2)) AND "tags" = 'a' LIMIT 10`,
+		},
+		{
+			name:      "null partition",
+			partition: &Partition{Values: []string{hiveDefaultPartition}},
+			want:      `SELECT * FROM "AwsDataCatalog"."sales"."my""table" WHERE "dt" IS NULL LIMIT 10`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tablePreviewSQL(defaultCatalogName, "sales", table, tt.partition, 10); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTablePreview(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.AddTable("sales", testTable("orders", "id:bigint", "amount:double"))
+	ts.athena.Script(`"sales"."orders"`, fakeQuery{
+		Columns: []string{"id", "amount"},
+		Types:   []string{"bigint", "double"},
+		Rows:    [][]string{{"1", "9.5"}, {"2", "12"}, {"3", "7.25"}},
+	})
+	path := "/api/catalog/sales/orders/preview"
+
+	preview := doJSON[QueryResults](t, ts, http.MethodPost, path, nil, http.StatusOK)
+	if preview.Status != "SUCCEEDED" || len(preview.Rows) != 3 || preview.Columns[1] != "amount" || preview.FromCache {
+		t.Errorf("preview %+v", preview)
+	}
+	if want := `SELECT * FROM "AwsDataCatalog"."sales"."orders" LIMIT 100`; preview.SQL != want {
+		t.Errorf("SQL %s, want %s", preview.SQL, want)
+	}
+	input := ts.athena.executions[preview.ExecutionID].input
+	if aws.StringValue(input.QueryExecutionContext.Database) != "sales" || aws.StringValue(input.QueryExecutionContext.Catalog) != defaultCatalogName {
+		t.Errorf("ran in %v", input.QueryExecutionContext)
+	}
+
+	// Recent previews are reused unless asked not to
+	reused := doJSON[QueryResults](t, ts, http.MethodPost, path, gin.H{}, http.StatusOK)
+	if reused.ExecutionID != preview.ExecutionID || !reused.FromCache {
+		t.Errorf("preview ran again as %s, want %s reused", reused.ExecutionID, preview.ExecutionID)
+	}
+	fresh := doJSON[QueryResults](t, ts, http.MethodPost, path, gin.H{"noCache": true}, http.StatusOK)
+	if fresh.ExecutionID == preview.ExecutionID || fresh.FromCache {
+		t.Error("preview was reused with noCache")
+	}
+
+	limited := doJSON[QueryResults](t, ts, http.MethodPost, path, gin.H{"limit": 2, "workGroup": "analysts"}, http.StatusOK)
+	if len(limited.Rows) != 2 || !strings.HasSuffix(limited.SQL, "LIMIT 2") {
+		t.Errorf("limited preview %+v", limited)
+	}
+	if workGroup := aws.StringValue(ts.athena.executions[limited.ExecutionID].input.WorkGroup); workGroup != "analysts" {
+		t.Errorf("ran in workgroup %q, want analysts", workGroup)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{name: "limit too large", path: path, body: gin.H{"limit": maxPreviewLimit + 1}, status: http.StatusBadRequest},
+		{name: "negative limit", path: path, body: gin.H{"limit": -1}, status: http.StatusBadRequest},
+		{name: "invalid body", path: path, body: "{", status: http.StatusBadRequest},
+		{name: "unknown table", path: "/api/catalog/sales/refunds/preview", status: http.StatusNotFound},
+		{name: "unknown database", path: "/api/catalog/hr/orders/preview", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doJSON[gin.H](t, ts, http.MethodPost, tt.path, tt.body, tt.status)
+		})
+	}
+}
+
+func TestTablePreviewPartitioned(t *testing.T) {
+	ts := newTestServer(t)
+	table := testTable("events", "id:bigint")
+	table.PartitionKeys = []*athena.Column{{Name: aws.String("dt"), Type: aws.String("date")}}
+	ts.athena.AddTable("logs", table)
+	ts.glue.AddPartition("logs", "events", []string{"2024-05-01"}, time.Now().Add(-time.Hour))
+	ts.glue.AddPartition("logs", "events", []string{"2024-05-02"}, time.Now())
+
+	preview := doJSON[QueryResults](t, ts, http.MethodPost, "/api/catalog/logs/events/preview", nil, http.StatusOK)
+	if want := `WHERE "dt" = CAST('2024-05-02' AS date) LIMIT 100`; !strings.HasSuffix(preview.SQL, want) {
+		t.Errorf("SQL %s, want it limited to the latest partition", preview.SQL)
+	}
+	if preview.Columns == nil || preview.Rows == nil {
+		t.Errorf("empty preview %+v, want empty lists", preview)
+	}
+}
+
+func TestTablePreviewFailures(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.AddTable("sales", testTable("orders", "id:bigint"))
+	ts.athena.Script(`"sales"."orders"`, fakeQuery{States: []string{"FAILED"}, Reason: "Access denied"})
+	path := "/api/catalog/sales/orders/preview"
+
+	failed := doJSON[gin.H](t, ts, http.MethodPost, path, nil, http.StatusInternalServerError)
+	if failed["error"] != "Access denied" || failed["status"] != "FAILED" || failed["executionId"] == nil {
+		t.Errorf("preview %+v", failed)
+	}
+
+	// Failed previews aren't reused
+	again := doJSON[gin.H](t, ts, http.MethodPost, path, nil, http.StatusInternalServerError)
+	if again["executionId"] == failed["executionId"] {
+		t.Errorf("failed preview %s was reused", failed["executionId"])
+	}
+
+	ts.athena.Script(`"sales"."orders"`, fakeQuery{States: []string{"CANCELLED"}})
+	cancelled := doJSON[gin.H](t, ts, http.MethodPost, path, gin.H{"noCache": true}, http.StatusInternalServerError)
+	if cancelled["error"] != "Preview cancelled" || cancelled["status"] != "CANCELLED" {
+		t.Errorf("preview %+v", cancelled)
+	}
+
+	ts.athena.FailNext("StartQueryExecution", awserr.New(athena.ErrCodeInternalServerException, "Internal error", nil))
+	doJSON[gin.H](t, ts, http.MethodPost, path, gin.H{"noCache": true}, http.StatusInternalServerError)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Values per column kept for percentiles and histograms
+const profileSampleSize = 10000
+
+// Distinct values counted per column before the count becomes a lower bound
+const profileDistinctLimit = 100000
+
+const profileTopValues = 10
+const profileHistogramBins = 10
+
+// ErrResultsNotReady is returned for executions that haven't succeeded (yet)
+var ErrResultsNotReady = errors.New("query results are not available")
+
+var profilePercentiles = []struct {
+	name     string
+	quantile float64
+}{{"p5", 0.05}, {"p25", 0.25}, {"p50", 0.5}, {"p75", 0.75}, {"p95", 0.95}}
+
+// columnProfiler accumulates the profile of one column while the results stream by
+type columnProfiler struct {
+	column  resultColumn
+	kind    string // number, time, bool or text
+	nulls   int64
+	counts  map[string]int64
+	capped  bool
+	min     interface{}
+	max     interface{}
+	minText string
+	maxText string
+
+	// Running mean and variance of numeric values (Welford's algorithm)
+	n    int64
+	mean float64
+	m2   float64
+
+	// Reservoir sample of numbers, or of Unix milliseconds for dates and timestamps
+	sample []float64
+	seen   int64
+	rng    *rand.Rand
+}
+
+// Helper function to pick which statistics suit an Athena type
+func profileKind(columnType string) string {
+	switch athenaBaseType(columnType) {
+	case "tinyint", "smallint", "integer", "int", "bigint", "real", "float", "double", "decimal":
+		return "number"
+	case "date", "timestamp":
+		return "time"
+	case "boolean":
+		return "bool"
+	}
+	return "text"
+}
+
+func newColumnProfiler(column resultColumn, seed int64) *columnProfiler {
+	return &columnProfiler{
+		column: column,
+		kind:   profileKind(column.Type),
+		counts: map[string]int64{},
+		rng:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Helper function to keep a value in the reservoir sample with the right probability
+func (p *columnProfiler) offer(value float64) {
+	p.seen++
+	if len(p.sample) < profileSampleSize {
+		p.sample = append(p.sample, value)
+	} else if i := p.rng.Int63n(p.seen); i < profileSampleSize {
+		p.sample[i] = value
+	}
+}
+
+// Helper function to add a raw field. Athena writes NULL as an empty field, and
+// empty strings can't be told apart from it.
+func (p *columnProfiler) add(raw string) {
+	if raw == "" {
+		p.nulls++
+		return
+	}
+
+	if _, ok := p.counts[raw]; ok || len(p.counts) < profileDistinctLimit {
+		p.counts[raw]++
+	} else {
+		p.capped = true
+	}
+
+	value := typedValue(p.column.Type, raw)
+	if p.min == nil || compareResultValues(value, p.min) < 0 {
+		p.min, p.minText = value, raw
+	}
+	if p.max == nil || compareResultValues(value, p.max) > 0 {
+		p.max, p.maxText = value, raw
+	}
+
+	switch p.kind {
+	case "number":
+		if x, ok := numericValue(value); ok && !math.IsNaN(x) && !math.IsInf(x, 0) {
+			p.n++
+			delta := x - p.mean
+			p.mean += delta / float64(p.n)
+			p.m2 += delta * (x - p.mean)
+			p.offer(x)
+		}
+	case "time":
+		if t, ok := value.(time.Time); ok {
+			p.offer(float64(t.UnixMilli()))
+		}
+	}
+}
+
+// Helper function to format a histogram edge like the values of the column
+func (p *columnProfiler) formatEdge(value float64) string {
+	if p.kind != "time" {
+		return strconv.FormatFloat(value, 'g', 10, 64) // Hides float error like 7.8999999999999995
+	}
+	t := time.UnixMilli(int64(math.Round(value))).UTC()
+	if athenaBaseType(p.column.Type) == "date" {
+		return t.Format("2006-01-02")
+	}
+	return t.Format(athenaTimestampLayout)
+}
+
+// Helper function to read a quantile of sorted values with linear interpolation
+func quantile(sorted []float64, q float64) float64 {
+	position := q * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower))
+}
+
+// Helper function to bin the sample between its smallest and largest value,
+// scaling counts up to every value when the sample doesn't hold them all
+func (p *columnProfiler) histogram(sorted []float64) []HistogramBin {
+	low, high := sorted[0], sorted[len(sorted)-1]
+	scale := float64(p.seen) / float64(len(sorted))
+
+	if low == high {
+		return []HistogramBin{{Start: p.formatEdge(low), End: p.formatEdge(high), Count: p.seen}}
+	}
+
+	counts := make([]float64, profileHistogramBins)
+	width := (high - low) / profileHistogramBins
+	for _, value := range sorted {
+		bin := int((value - low) / width)
+		if bin >= profileHistogramBins {
+			bin = profileHistogramBins - 1 // The largest value closes the last bin
+		}
+		counts[bin]++
+	}
+
+	bins := make([]HistogramBin, profileHistogramBins)
+	for i := range bins {
+		bins[i] = HistogramBin{
+			Start: p.formatEdge(low + width*float64(i)),
+			End:   p.formatEdge(low + width*float64(i+1)),
+			Count: int64(math.Round(counts[i] * scale)),
+		}
+	}
+	bins[len(bins)-1].End = p.formatEdge(high)
+	return bins
+}
+
+// Helper function to turn what was accumulated into the profile of the column
+func (p *columnProfiler) finish() ColumnProfile {
+	profile := ColumnProfile{
+		Name:           p.column.Name,
+		Type:           p.column.Type,
+		Nulls:          p.nulls,
+		Distinct:       int64(len(p.counts)),
+		DistinctCapped: p.capped,
+	}
+
+	if p.min != nil {
+		profile.Min, profile.Max = &p.minText, &p.maxText
+	}
+
+	if p.kind == "number" && p.n > 0 {
+		mean := p.mean
+		profile.Mean = &mean
+		if p.n > 1 {
+			stdDev := math.Sqrt(p.m2 / float64(p.n-1))
+			profile.StdDev = &stdDev
+		}
+	}
+
+	if len(p.sample) > 0 {
+		sorted := append([]float64{}, p.sample...)
+		sort.Float64s(sorted)
+		if p.kind == "number" {
+			profile.Percentiles = map[string]float64{}
+			for _, percentile := range profilePercentiles {
+				profile.Percentiles[percentile.name] = quantile(sorted, percentile.quantile)
+			}
+		}
+		profile.Histogram = p.histogram(sorted)
+	}
+
+	if p.kind == "text" || p.kind == "bool" {
+		for value, count := range p.counts {
+			profile.TopValues = append(profile.TopValues, ValueCount{Value: value, Count: count})
+		}
+		sort.Slice(profile.TopValues, func(i, j int) bool {
+			a, b := profile.TopValues[i], profile.TopValues[j]
+			return a.Count > b.Count || a.Count == b.Count && a.Value < b.Value
+		})
+		if len(profile.TopValues) > profileTopValues {
+			profile.TopValues = profile.TopValues[:profileTopValues]
+		}
+	}
+
+	return profile
+}
+
+// Helper function to profile the results of a succeeded execution in one pass
+// over its result file
+func (s *Server) computeResultProfile(ctx context.Context, executionID string) (*ResultProfile, error) {
+	status, _, s3URL, err := s.getExecutionOutput(executionID)
+	if err != nil {
+		return nil, err
+	}
+	if status != "SUCCEEDED" {
+		return nil, fmt.Errorf("%w: the query is %s", ErrResultsNotReady, status)
+	}
+
+	columns, err := s.getResultColumns(executionID)
+	if err != nil {
+		return nil, err
+	}
+
+	profilers := make([]*columnProfiler, len(columns))
+	for i, column := range columns {
+		profilers[i] = newColumnProfiler(column, int64(i))
+	}
+
+	var rows int64
+	err = s.scanResultFile(ctx, s3URL, len(columns), func(record []string) error {
+		rows++
+		for i, profiler := range profilers {
+			profiler.add(record[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &ResultProfile{
+		ExecutionID: executionID,
+		Rows:        rows,
+		Columns:     make([]ColumnProfile, len(columns)),
+		ComputedAt:  time.Now(),
+	}
+	for i, profiler := range profilers {
+		profile.Columns[i] = profiler.finish()
+		profile.Sampled = profile.Sampled || profiler.seen > int64(len(profiler.sample))
+	}
+	return profile, nil
+}
+
+func (s *Server) getResultProfile(c *gin.Context) {
+	executionID := c.Param("executionId")
+
+	profile, err := s.store.GetResultProfile(c.Request.Context(), executionID)
+	if err == nil {
+		c.JSON(http.StatusOK, profile)
+		return
+	}
+	if err != ErrNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Concurrent requests for the same results share one computation, which
+	// isn't tied to the request that happened to start it
+	result, err, _ := s.profiles.Do(executionID, func() (interface{}, error) {
+		profile, err := s.computeResultProfile(context.Background(), executionID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.store.SaveResultProfile(context.Background(), profile); err != nil {
+			return nil, err
+		}
+		return profile, nil
+	})
+	if errors.Is(err, ErrResultsNotReady) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(s3ErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
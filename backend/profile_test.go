@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+var profileTestScript = fakeQuery{
+	Columns: []string{"amount", "country", "day", "active"},
+	Types:   []string{"bigint", "varchar", "date", "boolean"},
+	Rows: [][]string{
+		{"1", "US", "2024-01-01", "true"},
+		{"2", "US", "2024-01-11", "false"},
+		{"3", "CA", "", "true"},
+		{"4", "", "2024-01-06", "true"},
+		{"", "DE", "2024-01-01", ""},
+	},
+}
+
+func TestResultProfile(t *testing.T) {
+	ts := newTestServer(t)
+	executionID := runScriptedQuery(t, ts, profileTestScript)
+	path := "/api/athena/results/" + executionID + "/profile"
+
+	profile := doJSON[ResultProfile](t, ts, http.MethodGet, path, nil, http.StatusOK)
+	if profile.ExecutionID != executionID || profile.Rows != 5 || profile.Sampled || len(profile.Columns) != 4 {
+		t.Fatalf("profile %+v", profile)
+	}
+
+	amount := profile.Columns[0]
+	if amount.Nulls != 1 || amount.Distinct != 4 || *amount.Min != "1" || *amount.Max != "4" {
+		t.Errorf("amount %+v", amount)
+	}
+	if amount.Mean == nil || *amount.Mean != 2.5 || amount.StdDev == nil || math.Abs(*amount.StdDev-math.Sqrt(5.0/3)) > 1e-9 {
+		t.Errorf("amount mean %v, standard deviation %v", amount.Mean, amount.StdDev)
+	}
+	if amount.Percentiles["p50"] != 2.5 || amount.Percentiles["p5"] != 1.15 {
+		t.Errorf("amount percentiles %v", amount.Percentiles)
+	}
+	if len(amount.Histogram) != profileHistogramBins || amount.Histogram[0].Start != "1" || amount.Histogram[profileHistogramBins-1].End != "4" {
+		t.Errorf("amount histogram %v", amount.Histogram)
+	}
+	var binned int64
+	for _, bin := range amount.Histogram {
+		binned += bin.Count
+	}
+	if binned != 4 {
+		t.Errorf("histogram holds %d values, want 4", binned)
+	}
+	if amount.TopValues != nil {
+		t.Errorf("numbers got top values %v", amount.TopValues)
+	}
+
+	country := profile.Columns[1]
+	if want := []ValueCount{{"US", 2}, {"CA", 1}, {"DE", 1}}; !reflect.DeepEqual(country.TopValues, want) {
+		t.Errorf("country top values %v, want %v", country.TopValues, want)
+	}
+	if country.Mean != nil || country.Histogram != nil {
+		t.Errorf("text got numeric statistics: %+v", country)
+	}
+
+	day := profile.Columns[2]
+	if *day.Min != "2024-01-01" || *day.Max != "2024-01-11" || day.Histogram[0].Start != "2024-01-01" || day.Histogram[1].Start != "2024-01-02" {
+		t.Errorf("day %+v", day)
+	}
+	if day.Percentiles != nil {
+		t.Errorf("dates got percentiles %v", day.Percentiles)
+	}
+
+	active := profile.Columns[3]
+	if want := []ValueCount{{"true", 3}, {"false", 1}}; !reflect.DeepEqual(active.TopValues, want) {
+		t.Errorf("active top values %v, want %v", active.TopValues, want)
+	}
+
+	// The stored profile answers later requests, even when the results can't be read
+	ts.s3.FailNext("GetObject", awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil))
+	again := doJSON[ResultProfile](t, ts, http.MethodGet, path, nil, http.StatusOK)
+	if !again.ComputedAt.Equal(profile.ComputedAt) {
+		t.Errorf("profile computed again at %v", again.ComputedAt)
+	}
+}
+
+func TestResultProfileFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		script fakeQuery
+		fail   error
+		status int
+	}{
+		{name: "failed query", script: fakeQuery{States: []string{"FAILED"}, Reason: "boom"}, status: http.StatusConflict},
+		{name: "missing result file", script: profileTestScript, fail: awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil), status: http.StatusNotFound},
+		{name: "S3 error", script: profileTestScript, fail: awserr.New("InternalError", "We encountered an internal error", nil), status: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.athena.Script("from scripted", tt.script)
+			query := createTestQuery(t, ts, "Scripted", "SELECT * FROM scripted")
+			run := runTestQuery(t, ts, query, nil)
+			if tt.fail != nil {
+				ts.s3.FailNext("GetObject", tt.fail)
+			}
+
+			path := "/api/athena/results/" + run.ExecutionID + "/profile"
+			doJSON[gin.H](t, ts, http.MethodGet, path, nil, tt.status)
+
+			// Failures aren't stored
+			if tt.fail != nil {
+				doJSON[ResultProfile](t, ts, http.MethodGet, path, nil, http.StatusOK)
+			}
+		})
+	}
+}
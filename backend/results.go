@@ -0,0 +1,417 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/gin-gonic/gin"
+)
+
+// Largest page the results endpoint returns when sorting or filtering
+const maxResultPageSize = 1000
+
+// Furthest row a sorted or filtered page may start at
+const maxResultOffset = 100000
+
+// ErrInvalidResultQuery is returned for sort, filter or columns parameters that
+// don't fit the result set
+var ErrInvalidResultQuery = errors.New("invalid results query")
+
+// Operators of the filter parameter of /api/athena/results
+var resultFilterOperators = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "lte": true, "gt": true, "gte": true,
+	"contains": true, "startswith": true, "in": true, "null": true, "notnull": true,
+}
+
+type resultFilter struct {
+	column   int
+	operator string
+	values   []interface{} // Typed like the column
+	text     string        // Lowercased value for contains and startswith
+}
+
+type resultSortKey struct {
+	column int
+	desc   bool
+}
+
+// resultQuery is the sort, filter and column projection requested on a result set
+type resultQuery struct {
+	columns []int // Indexes of the returned columns, in order
+	filters []resultFilter
+	sort    []resultSortKey
+}
+
+// Helper function to find the first column of a name, -1 when missing
+func findResultColumn(columns []resultColumn, name string) int {
+	for i, column := range columns {
+		if column.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Helper function to find a result column requested by name
+func resultColumnIndex(columns []resultColumn, name string) (int, error) {
+	if i := findResultColumn(columns, name); i >= 0 {
+		return i, nil
+	}
+	return 0, fmt.Errorf("unknown column %q", name)
+}
+
+// Helper function to parse the columns, filter and sort parameters of a results
+// request. Filters are given as column:operator:value (the value is a comma
+// separated list for "in" and omitted for "null" and "notnull") and sort as a
+// comma separated list of columns, each prefixed with "-" for descending order.
+func parseResultQuery(c *gin.Context, columns []resultColumn) (*resultQuery, error) {
+	query := &resultQuery{}
+
+	if names := c.Query("columns"); names != "" {
+		for _, name := range strings.Split(names, ",") {
+			i, err := resultColumnIndex(columns, strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			query.columns = append(query.columns, i)
+		}
+	} else {
+		for i := range columns {
+			query.columns = append(query.columns, i)
+		}
+	}
+
+	for _, raw := range c.QueryArray("filter") {
+		parts := strings.SplitN(raw, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid filter %q, expected column:operator:value", raw)
+		}
+
+		i, err := resultColumnIndex(columns, parts[0])
+		if err != nil {
+			return nil, err
+		}
+		filter := resultFilter{column: i, operator: strings.ToLower(parts[1])}
+		if !resultFilterOperators[filter.operator] {
+			return nil, fmt.Errorf("invalid filter operator %q", parts[1])
+		}
+
+		value := ""
+		if len(parts) == 3 {
+			value = parts[2]
+		}
+		switch filter.operator {
+		case "null", "notnull":
+		case "contains", "startswith":
+			filter.text = strings.ToLower(value)
+		case "in":
+			for _, item := range strings.Split(value, ",") {
+				filter.values = append(filter.values, typedValue(columns[i].Type, item))
+			}
+		default:
+			filter.values = []interface{}{typedValue(columns[i].Type, value)}
+		}
+		query.filters = append(query.filters, filter)
+	}
+
+	if names := c.Query("sort"); names != "" {
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			key := resultSortKey{desc: strings.HasPrefix(name, "-")}
+			i, err := resultColumnIndex(columns, strings.TrimPrefix(name, "-"))
+			if err != nil {
+				return nil, err
+			}
+			key.column = i
+			query.sort = append(query.sort, key)
+		}
+	}
+
+	return query, nil
+}
+
+// Helper function to read a number of any numeric result type as float64
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Helper function to order two typed result values. NULLs come first, and
+// values of different types (such as unparsable numbers) compare as text.
+func compareResultValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	if x, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := numericValue(a); ok {
+		if y, ok := numericValue(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	switch x := a.(type) {
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0
+			case !x:
+				return -1
+			}
+			return 1
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y)
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// Helper function to check a typed row against the filters of a query
+func (q *resultQuery) match(values []interface{}, record []string) bool {
+	for _, filter := range q.filters {
+		value := values[filter.column]
+
+		var ok bool
+		switch filter.operator {
+		case "null":
+			ok = value == nil
+		case "notnull":
+			ok = value != nil
+		case "contains":
+			ok = strings.Contains(strings.ToLower(record[filter.column]), filter.text)
+		case "startswith":
+			ok = strings.HasPrefix(strings.ToLower(record[filter.column]), filter.text)
+		case "in":
+			for _, candidate := range filter.values {
+				if value != nil && compareResultValues(value, candidate) == 0 {
+					ok = true
+					break
+				}
+			}
+		default:
+			// Comparisons never match NULL, as in SQL
+			if value == nil || filter.values[0] == nil {
+				return false
+			}
+			cmp := compareResultValues(value, filter.values[0])
+			switch filter.operator {
+			case "eq":
+				ok = cmp == 0
+			case "ne":
+				ok = cmp != 0
+			case "lt":
+				ok = cmp < 0
+			case "lte":
+				ok = cmp <= 0
+			case "gt":
+				ok = cmp > 0
+			case "gte":
+				ok = cmp >= 0
+			}
+		}
+
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedResultRow is a projected row with the typed values it is sorted on
+type sortedResultRow struct {
+	index int // Position in the result file, which breaks ties
+	keys  []interface{}
+	cells []string
+}
+
+// resultRowHeap keeps the first rows in sort order, with the last of them on top
+type resultRowHeap struct {
+	rows  []sortedResultRow
+	query *resultQuery
+}
+
+func (h *resultRowHeap) less(a, b sortedResultRow) bool {
+	for i, key := range h.query.sort {
+		cmp := compareResultValues(a.keys[i], b.keys[i])
+		if key.desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return a.index < b.index
+}
+
+func (h *resultRowHeap) Len() int           { return len(h.rows) }
+func (h *resultRowHeap) Less(i, j int) bool { return h.less(h.rows[j], h.rows[i]) }
+func (h *resultRowHeap) Swap(i, j int)      { h.rows[i], h.rows[j] = h.rows[j], h.rows[i] }
+func (h *resultRowHeap) Push(x interface{}) { h.rows = append(h.rows, x.(sortedResultRow)) }
+func (h *resultRowHeap) Pop() interface{} {
+	row := h.rows[len(h.rows)-1]
+	h.rows = h.rows[:len(h.rows)-1]
+	return row
+}
+
+// Helper function to get the state of an execution, why it failed, and once it
+// succeeded the location of its result file
+func (s *Server) getExecutionOutput(executionID string) (string, string, string, error) {
+	execution, err := s.athena.GetQueryExecution(&athena.GetQueryExecutionInput{
+		QueryExecutionId: aws.String(executionID),
+	})
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to get query execution: %v", err)
+	}
+
+	status := aws.StringValue(execution.QueryExecution.Status.State)
+	reason := aws.StringValue(execution.QueryExecution.Status.StateChangeReason)
+	if status != "SUCCEEDED" {
+		return status, reason, "", nil
+	}
+
+	if execution.QueryExecution.ResultConfiguration == nil || execution.QueryExecution.ResultConfiguration.OutputLocation == nil {
+		return "", "", "", fmt.Errorf("no output location found")
+	}
+	return status, reason, *execution.QueryExecution.ResultConfiguration.OutputLocation, nil
+}
+
+// Helper function to answer a results request with sort, filter or columns by
+// scanning the result file of the execution instead of rerunning it. Only the
+// rows up to the requested page are kept in memory.
+func (s *Server) queryAthenaResults(ctx context.Context, c *gin.Context, executionID string, page, size int) (*QueryResults, error) {
+	status, reason, s3URL, err := s.getExecutionOutput(executionID)
+	if err != nil {
+		return nil, err
+	}
+	if status != "SUCCEEDED" {
+		results := &QueryResults{Columns: []string{}, Rows: [][]string{}, Page: page, Size: size, Status: status}
+		if reason != "" {
+			results.ErrorMessage = &reason
+		}
+		return results, nil
+	}
+
+	columns, err := s.getResultColumns(executionID)
+	if err != nil {
+		return nil, err
+	}
+	query, err := parseResultQuery(c, columns)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResultQuery, err)
+	}
+
+	offset := (page - 1) * size
+	keep := offset + size
+	rows := &resultRowHeap{query: query}
+	var total, unfiltered int64
+
+	values := make([]interface{}, len(columns))
+	err = s.scanResultFile(ctx, s3URL, len(columns), func(record []string) error {
+		index := int(unfiltered)
+		unfiltered++
+
+		for i, column := range columns {
+			values[i] = typedValue(column.Type, record[i])
+		}
+		if !query.match(values, record) {
+			return nil
+		}
+		total++
+
+		// Without sort only the rows of the page itself are needed
+		if len(query.sort) == 0 && (total <= int64(offset) || total > int64(keep)) {
+			return nil
+		}
+
+		row := sortedResultRow{index: index, keys: make([]interface{}, len(query.sort))}
+		for i, key := range query.sort {
+			row.keys[i] = values[key.column]
+		}
+		if len(query.sort) > 0 && rows.Len() == keep {
+			if !rows.less(row, rows.rows[0]) {
+				return nil
+			}
+			heap.Pop(rows)
+		}
+
+		row.cells = make([]string, len(query.columns))
+		for i, column := range query.columns {
+			row.cells[i] = record[column]
+		}
+		heap.Push(rows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := rows.rows
+	sort.Slice(sorted, func(i, j int) bool { return rows.less(sorted[i], sorted[j]) })
+	if len(query.sort) > 0 {
+		if offset > len(sorted) {
+			offset = len(sorted)
+		}
+		sorted = sorted[offset:]
+	}
+
+	results := &QueryResults{
+		Columns:         make([]string, len(query.columns)),
+		Rows:            make([][]string, len(sorted)),
+		Total:           total,
+		TotalUnfiltered: unfiltered,
+		Page:            page,
+		Size:            size,
+		Status:          status,
+	}
+	for i, column := range query.columns {
+		results.Columns[i] = columns[column].Name
+	}
+	for i, row := range sorted {
+		results.Rows[i] = row.cells
+	}
+	return results, nil
+}
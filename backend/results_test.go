@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+var resultsTestScript = fakeQuery{
+	Columns: []string{"country", "revenue", "signup"},
+	Types:   []string{"varchar", "bigint", "date"},
+	Rows: [][]string{
+		{"US", "1200", "2024-03-01"},
+		{"CA", "800", "2024-01-15"},
+		{"DE", "", "2024-02-10"},
+		{"US", "150", "2023-12-31"},
+		{"FR", "9000", ""},
+		{"CA", "1000", "2024-02-01"},
+	},
+}
+
+func TestQueryResults(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  url.Values
+		columns []string
+		rows    [][]string
+		total   int64
+	}{
+		{
+			name:    "sorts numerically, descending",
+			params:  url.Values{"sort": {"-revenue"}, "columns": {"country,revenue"}},
+			columns: []string{"country", "revenue"},
+			rows:    [][]string{{"FR", "9000"}, {"US", "1200"}, {"CA", "1000"}, {"CA", "800"}, {"US", "150"}, {"DE", ""}},
+			total:   6,
+		},
+		{
+			name:    "sorts on several columns",
+			params:  url.Values{"sort": {"country,-revenue"}, "columns": {"country,revenue"}},
+			columns: []string{"country", "revenue"},
+			rows:    [][]string{{"CA", "1000"}, {"CA", "800"}, {"DE", ""}, {"FR", "9000"}, {"US", "1200"}, {"US", "150"}},
+			total:   6,
+		},
+		{
+			name:    "pages sorted rows, NULLs first",
+			params:  url.Values{"sort": {"signup"}, "columns": {"signup"}, "page": {"2"}, "size": {"2"}},
+			columns: []string{"signup"},
+			rows:    [][]string{{"2024-01-15"}, {"2024-02-01"}},
+			total:   6,
+		},
+		{
+			name:    "filters",
+			params:  url.Values{"filter": {"country:in:US,CA", "revenue:gte:1000"}, "columns": {"country"}},
+			columns: []string{"country"},
+			rows:    [][]string{{"US"}, {"CA"}},
+			total:   2,
+		},
+		{
+			name:    "filters nulls",
+			params:  url.Values{"filter": {"revenue:null"}},
+			columns: []string{"country", "revenue", "signup"},
+			rows:    [][]string{{"DE", "", "2024-02-10"}},
+			total:   1,
+		},
+		{
+			name:    "filters text",
+			params:  url.Values{"filter": {"country:startswith:c"}, "columns": {"revenue"}, "page": {"1"}, "size": {"1"}},
+			columns: []string{"revenue"},
+			rows:    [][]string{{"800"}},
+			total:   2,
+		},
+		{
+			name:    "page past the rows",
+			params:  url.Values{"sort": {"country"}, "page": {"5"}, "size": {"10"}},
+			columns: []string{"country", "revenue", "signup"},
+			rows:    [][]string{},
+			total:   6,
+		},
+	}
+
+	ts := newTestServer(t)
+	executionID := runScriptedQuery(t, ts, resultsTestScript)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := doJSON[QueryResults](t, ts, http.MethodGet,
+				"/api/athena/results/"+executionID+"?"+tt.params.Encode(), nil, http.StatusOK)
+
+			if !reflect.DeepEqual(results.Columns, tt.columns) {
+				t.Errorf("columns %v, want %v", results.Columns, tt.columns)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("rows %v, want %v", results.Rows, tt.rows)
+			}
+			if results.Total != tt.total || results.TotalUnfiltered != 6 {
+				t.Errorf("total %d of %d, want %d of 6", results.Total, results.TotalUnfiltered, tt.total)
+			}
+		})
+	}
+}
+
+func TestQueryResultsInvalidParams(t *testing.T) {
+	ts := newTestServer(t)
+	executionID := runScriptedQuery(t, ts, resultsTestScript)
+
+	lastPage := strconv.Itoa(maxResultOffset/100 + 1)
+	tests := []struct {
+		name   string
+		params url.Values
+		status int
+	}{
+		{name: "unknown sort column", params: url.Values{"sort": {"city"}}, status: http.StatusBadRequest},
+		{name: "unknown projected column", params: url.Values{"columns": {"country,city"}}, status: http.StatusBadRequest},
+		{name: "unknown operator", params: url.Values{"filter": {"revenue:between:1"}}, status: http.StatusBadRequest},
+		{name: "filter without operator", params: url.Values{"filter": {"revenue"}}, status: http.StatusBadRequest},
+		{name: "page 0", params: url.Values{"page": {"0"}}, status: http.StatusBadRequest},
+		{name: "negative size", params: url.Values{"size": {"-1"}}, status: http.StatusBadRequest},
+		{name: "overflowing page", params: url.Values{"page": {"9223372036854775807"}, "size": {"2"}}, status: http.StatusBadRequest},
+		{name: "sorted page too large", params: url.Values{"sort": {"country"}, "size": {"1001"}}, status: http.StatusBadRequest},
+		{name: "last sorted page", params: url.Values{"sort": {"country"}, "page": {lastPage}, "size": {"100"}}, status: http.StatusOK},
+		{name: "sorted page too far", params: url.Values{"sort": {"country"}, "page": {lastPage}, "size": {"101"}}, status: http.StatusBadRequest},
+		{name: "sorted page far past the limit", params: url.Values{"sort": {"country"}, "page": {"2000000000"}, "size": {"1000"}}, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, "/api/athena/results/"+executionID+"?"+tt.params.Encode(), nil)
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
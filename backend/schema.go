@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultSchemaSnapshotInterval = time.Hour
+
+const defaultSchemaChangeLimit = 100
+
+const maxSchemaChangeLimit = 1000
+
+// Types of SchemaChange
+const (
+	schemaTableAdded    = "table_added"
+	schemaTableDropped  = "table_dropped"
+	schemaColumnAdded   = "column_added"
+	schemaColumnRemoved = "column_removed"
+	schemaColumnRetyped = "column_retyped"
+)
+
+// Client for SCHEMA_CHANGE_WEBHOOK_URL, so a slow endpoint doesn't hold up snapshots
+var schemaWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Body posted to SCHEMA_CHANGE_WEBHOOK_URL when a snapshot finds changes
+type schemaChangeNotification struct {
+	Changes         []SchemaChange       `json:"changes"`
+	AffectedQueries []QuerySchemaChanges `json:"affectedQueries"`
+}
+
+// Helper function to read SCHEMA_SNAPSHOT_INTERVAL (0 disables tracking) and
+// SCHEMA_CHANGE_WEBHOOK_URL and start snapshotting the catalog in the background
+func (s *Server) startSchemaTracker() error {
+	interval := defaultSchemaSnapshotInterval
+	if value := os.Getenv("SCHEMA_SNAPSHOT_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("invalid SCHEMA_SNAPSHOT_INTERVAL: %s", value)
+		}
+		interval = parsed
+	}
+
+	if value := os.Getenv("SCHEMA_CHANGE_WEBHOOK_URL"); value != "" {
+		parsed, err := url.Parse(value)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid SCHEMA_CHANGE_WEBHOOK_URL: %s", value)
+		}
+		s.schemaWebhookURL = value
+	}
+
+	if interval == 0 {
+		log.Println("Schema change tracking is disabled")
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.snapshotSchemas(context.Background())
+			<-ticker.C
+		}
+	}()
+
+	return nil
+}
+
+// Helper function to snapshot AwsDataCatalog and every other catalog that was
+// browsed, record how their tables changed and notify about the changes
+func (s *Server) snapshotSchemas(ctx context.Context) []SchemaChange {
+	names := []string{defaultCatalogName}
+	s.catalogs.mu.Lock()
+	for name := range s.catalogs.byName {
+		if name != defaultCatalogName {
+			names = append(names, name)
+		}
+	}
+	s.catalogs.mu.Unlock()
+
+	changes := []SchemaChange{}
+	for _, name := range names {
+		cache, err := s.catalogCache(name, false)
+		if err != nil {
+			log.Printf("Failed to snapshot catalog %s: %v", name, err)
+			continue
+		}
+		catalog, err := cache.fetch(true)
+		if err != nil {
+			log.Printf("Failed to snapshot catalog %s: %v", name, err)
+			continue
+		}
+
+		catalogChanges, err := s.snapshotCatalog(ctx, catalog, time.Now())
+		if err != nil {
+			log.Printf("Failed to snapshot catalog %s: %v", name, err)
+			continue
+		}
+		changes = append(changes, catalogChanges...)
+	}
+
+	if len(changes) > 0 {
+		s.notifySchemaChanges(ctx, changes)
+	}
+	return changes
+}
+
+// Helper function to compare a catalog with its last snapshot, store the
+// differences and replace the snapshot. Databases whose tables couldn't be
+// listed completely are left alone, so a failed listing doesn't look like
+// dropped tables. The first snapshot of a database is only a baseline.
+func (s *Server) snapshotCatalog(ctx context.Context, catalog *AthenaCatalog, now time.Time) ([]SchemaChange, error) {
+	previous, err := s.store.ListTableSchemas(ctx, catalog.Catalog)
+	if err != nil {
+		return nil, err
+	}
+	byDatabase := map[string]map[string]TableSchema{}
+	for _, schema := range previous {
+		if byDatabase[schema.Database] == nil {
+			byDatabase[schema.Database] = map[string]TableSchema{}
+		}
+		byDatabase[schema.Database][schema.Table] = schema
+	}
+
+	changes := []SchemaChange{}
+	var saved, dropped []TableSchema
+	dropTables := func(tables map[string]TableSchema) {
+		for _, schema := range tables {
+			changes = append(changes, schemaChange(schema, schemaTableDropped, now))
+			dropped = append(dropped, schema)
+		}
+	}
+
+	listed := map[string]bool{}
+	for _, db := range catalog.Databases {
+		database := strings.ToLower(db.Name)
+		listed[database] = true
+		if db.Error != "" || db.LoadedAt == nil {
+			continue
+		}
+
+		// Known tables are removed from snapshots as they're found, so whether
+		// this is the first snapshot is decided before
+		snapshots := byDatabase[database]
+		baseline := len(snapshots) == 0
+		for _, table := range db.Tables {
+			schema := TableSchema{
+				Catalog:       catalog.Catalog,
+				Database:      database,
+				Table:         strings.ToLower(table.Name),
+				Columns:       table.Columns,
+				PartitionKeys: table.PartitionKeys,
+				SnapshotAt:    now,
+			}
+			if schema.Columns == nil {
+				schema.Columns = []Column{}
+			}
+
+			old, ok := snapshots[schema.Table]
+			if !ok {
+				if !baseline {
+					changes = append(changes, schemaChange(schema, schemaTableAdded, now))
+				}
+				saved = append(saved, schema)
+				continue
+			}
+			delete(snapshots, schema.Table)
+
+			if columnChanges := diffColumns(old, schema, now); len(columnChanges) > 0 {
+				changes = append(changes, columnChanges...)
+				saved = append(saved, schema)
+			}
+		}
+		dropTables(snapshots)
+	}
+	for database, snapshots := range byDatabase {
+		if !listed[database] {
+			dropTables(snapshots)
+		}
+	}
+
+	if err := s.store.CreateSchemaChanges(ctx, changes); err != nil {
+		return nil, err
+	}
+	if err := s.store.SaveTableSchemas(ctx, saved); err != nil {
+		return nil, err
+	}
+	if err := s.store.DeleteTableSchemas(ctx, dropped); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func schemaChange(schema TableSchema, changeType string, detectedAt time.Time) SchemaChange {
+	return SchemaChange{
+		Catalog:    schema.Catalog,
+		Database:   schema.Database,
+		Table:      schema.Table,
+		Type:       changeType,
+		DetectedAt: detectedAt,
+	}
+}
+
+// Helper function to list the columns and partition keys that were added,
+// removed or retyped between two snapshots of a table. Names and types are
+// compared ignoring case, like Athena does.
+func diffColumns(old, current TableSchema, detectedAt time.Time) []SchemaChange {
+	columnsOf := func(schema TableSchema) []Column {
+		return append(append([]Column{}, schema.Columns...), schema.PartitionKeys...)
+	}
+	oldColumns, currentColumns := columnsOf(old), columnsOf(current)
+
+	currentTypes := map[string]string{}
+	for _, column := range currentColumns {
+		currentTypes[strings.ToLower(column.Name)] = column.Type
+	}
+
+	var changes []SchemaChange
+	oldTypes := map[string]bool{}
+	for _, column := range oldColumns {
+		name := strings.ToLower(column.Name)
+		oldTypes[name] = true
+
+		newType, ok := currentTypes[name]
+		switch {
+		case !ok:
+			change := schemaChange(current, schemaColumnRemoved, detectedAt)
+			change.Column, change.OldType = name, column.Type
+			changes = append(changes, change)
+		case !strings.EqualFold(newType, column.Type):
+			change := schemaChange(current, schemaColumnRetyped, detectedAt)
+			change.Column, change.OldType, change.NewType = name, column.Type, newType
+			changes = append(changes, change)
+		}
+	}
+	for _, column := range currentColumns {
+		name := strings.ToLower(column.Name)
+		if !oldTypes[name] {
+			change := schemaChange(current, schemaColumnAdded, detectedAt)
+			change.Column, change.NewType = name, column.Type
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// Helper function to log schema changes and post them, with the saved queries
+// they affect, to SCHEMA_CHANGE_WEBHOOK_URL
+func (s *Server) notifySchemaChanges(ctx context.Context, changes []SchemaChange) {
+	for _, change := range changes {
+		name := change.Catalog + "." + change.Database + "." + change.Table
+		if change.Column != "" {
+			name += "." + change.Column
+		}
+		message := "Schema change: " + change.Type + " " + name
+		if types := strings.TrimSpace(change.OldType + " " + change.NewType); types != "" {
+			message += " " + types
+		}
+		log.Println(message)
+	}
+
+	if s.schemaWebhookURL == "" {
+		return
+	}
+
+	affected, err := s.affectedQueries(ctx, changes)
+	if err != nil {
+		log.Printf("Failed to find the queries affected by schema changes: %v", err)
+		affected = []QuerySchemaChanges{}
+	}
+	body, err := json.Marshal(schemaChangeNotification{Changes: changes, AffectedQueries: affected})
+	if err != nil {
+		log.Printf("Failed to encode schema changes: %v", err)
+		return
+	}
+
+	resp, err := schemaWebhookClient.Post(s.schemaWebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to post schema changes: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Failed to post schema changes: webhook responded %s", resp.Status)
+	}
+}
+
+// Helper function to check whether a change is to one of the tables a query
+// references
+func queryReferencesTable(query Query, change SchemaChange) bool {
+	for _, table := range query.Tables {
+		if queryTableIs(query, table, change.Catalog, change.Database, change.Table) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to return when a query last ran successfully, or nil
+func (s *Server) lastSucceededAt(ctx context.Context, queryID primitive.ObjectID) (*time.Time, error) {
+	runs, _, _, err := s.store.ListQueryRuns(ctx, QueryRunFilter{QueryID: &queryID, Statuses: []string{"SUCCEEDED"}},
+		pageRequest{Sort: queryRunSortFields["executed"], Desc: true, Limit: 1})
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return &runs[0].ExecutedAt, nil
+}
+
+// Helper function to return the changes to the tables of a query detected
+// after since
+func queryChangesSince(query Query, changes []SchemaChange, since time.Time) []SchemaChange {
+	matched := []SchemaChange{}
+	for _, change := range changes {
+		if change.DetectedAt.After(since) && queryReferencesTable(query, change) {
+			matched = append(matched, change)
+		}
+	}
+	return matched
+}
+
+// Helper function to find the saved queries that reference a table changed
+// after their last successful run. Queries that never succeeded aren't listed.
+func (s *Server) affectedQueries(ctx context.Context, changes []SchemaChange) ([]QuerySchemaChanges, error) {
+	affected := []QuerySchemaChanges{}
+	if len(changes) == 0 {
+		return affected, nil
+	}
+
+	queries, _, _, err := s.store.ListQueries(ctx, QueryFilter{Trash: notTrashed},
+		pageRequest{Sort: querySortFields["name"], Limit: 0})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, query := range queries {
+		if len(queryChangesSince(query, changes, time.Time{})) == 0 {
+			continue
+		}
+
+		lastSucceededAt, err := s.lastSucceededAt(ctx, query.ID)
+		if err != nil {
+			return nil, err
+		}
+		if lastSucceededAt == nil {
+			continue
+		}
+		if matched := queryChangesSince(query, changes, *lastSucceededAt); len(matched) > 0 {
+			affected = append(affected, QuerySchemaChanges{
+				QueryID:         query.ID,
+				Name:            query.Name,
+				LastSucceededAt: lastSucceededAt,
+				Changes:         matched,
+			})
+		}
+	}
+	return affected, nil
+}
+
+// Helper function to read the limit of a schema change listing. It responds
+// itself and returns false when the limit is invalid.
+func schemaChangeLimit(c *gin.Context) (int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSchemaChangeLimit)))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return 0, false
+	}
+	return min(limit, maxSchemaChangeLimit), true
+}
+
+// Helper function to read the since parameter of a request. It responds
+// itself and returns false when the date is invalid.
+func schemaChangeSince(c *gin.Context) (*time.Time, bool) {
+	value := c.Query("since")
+	if value == "" {
+		return nil, true
+	}
+	since, err := parseDateParam(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since date"})
+		return nil, false
+	}
+	return &since, true
+}
+
+func (s *Server) getSchemaChanges(c *gin.Context) {
+	limit, ok := schemaChangeLimit(c)
+	if !ok {
+		return
+	}
+	since, ok := schemaChangeSince(c)
+	if !ok {
+		return
+	}
+
+	filter := SchemaChangeFilter{
+		Catalog:  c.Query("catalog"),
+		Database: strings.ToLower(c.Query("database")),
+		Table:    strings.ToLower(c.Query("table")),
+		Since:    since,
+	}
+	changes, err := s.store.ListSchemaChanges(c.Request.Context(), filter, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, changes)
+}
+
+// getTableSchemaChanges lists the changes to a table, which may have been dropped since
+func (s *Server) getTableSchemaChanges(c *gin.Context) {
+	limit, ok := schemaChangeLimit(c)
+	if !ok {
+		return
+	}
+	since, ok := schemaChangeSince(c)
+	if !ok {
+		return
+	}
+	cache, ok := s.requestCatalogCache(c)
+	if !ok {
+		return
+	}
+
+	filter := SchemaChangeFilter{
+		Catalog:  cache.name,
+		Database: strings.ToLower(c.Param("db")),
+		Table:    strings.ToLower(c.Param("table")),
+		Since:    since,
+	}
+	changes, err := s.store.ListSchemaChanges(c.Request.Context(), filter, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, changes)
+}
+
+func (s *Server) getQuerySchemaChanges(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	query, err := s.store.GetQuery(ctx, id)
+	if err != nil || query.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query not found"})
+		return
+	}
+
+	result := QuerySchemaChanges{QueryID: query.ID, Name: query.Name, Changes: []SchemaChange{}}
+	result.LastSucceededAt, err = s.lastSucceededAt(ctx, query.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if result.LastSucceededAt == nil {
+		// Without a successful run there is nothing the changes could have broken
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	changes, err := s.store.ListSchemaChanges(ctx, SchemaChangeFilter{Since: result.LastSucceededAt}, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	result.Changes = queryChangesSince(query, changes, *result.LastSucceededAt)
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) getAffectedQueries(c *gin.Context) {
+	since, ok := schemaChangeSince(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	changes, err := s.store.ListSchemaChanges(ctx, SchemaChangeFilter{Since: since}, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	affected, err := s.affectedQueries(ctx, changes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, affected)
+}
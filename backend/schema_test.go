@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/gin-gonic/gin"
+)
+
+// Helper function to describe schema changes as type table.column old new
+func changeNames(changes []SchemaChange) []string {
+	names := make([]string, len(changes))
+	for i, change := range changes {
+		names[i] = change.Type + " " + change.Database + "." + change.Table
+		if change.Column != "" {
+			names[i] += "." + change.Column + " " + change.OldType + " " + change.NewType
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Helper function to snapshot the catalog and check the changes found
+func snapshotTestSchemas(t *testing.T, ts *testServer, want ...string) {
+	t.Helper()
+	got := changeNames(ts.snapshotSchemas(context.Background()))
+	sort.Strings(want)
+	if len(got) != len(want) || len(want) > 0 && !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes %q, want %q", got, want)
+	}
+}
+
+// Helper function to change the tables of a database of the fake Athena
+func setTestTables(ts *testServer, database string, tables ...*athena.TableMetadata) {
+	ts.athena.mu.Lock()
+	defer ts.athena.mu.Unlock()
+	ts.athena.databases[database] = tables
+}
+
+func TestSnapshotSchemas(t *testing.T) {
+	ts := newTestServer(t)
+	orders := testTable("orders", "id:bigint", "amount:double")
+	setTestTables(ts, "sales", orders)
+
+	// The first snapshot is only a baseline
+	snapshotTestSchemas(t, ts)
+	snapshotTestSchemas(t, ts)
+
+	// Tables added after one that was already known
+	returns, orders2 := testTable("returns", "id:bigint"), testTable("orders2", "id:bigint")
+	setTestTables(ts, "sales", orders, returns, orders2)
+	snapshotTestSchemas(t, ts, "table_added sales.returns", "table_added sales.orders2")
+
+	setTestTables(ts, "sales", testTable("ORDERS", "ID:BIGINT", "amount:decimal(10,2)", "customer_id:bigint"), returns, orders2)
+	orders = ts.athena.databases["sales"][0]
+	orders.PartitionKeys = []*athena.Column{{Name: aws.String("dt"), Type: aws.String("string")}}
+	snapshotTestSchemas(t, ts,
+		"column_retyped sales.orders.amount double decimal(10,2)",
+		"column_added sales.orders.customer_id  bigint",
+		"column_added sales.orders.dt  string",
+	)
+
+	setTestTables(ts, "sales", testTable("orders", "id:bigint", "customer_id:bigint"), returns)
+	ts.athena.databases["sales"][0].PartitionKeys = orders.PartitionKeys
+	snapshotTestSchemas(t, ts, "column_removed sales.orders.amount decimal(10,2) ", "table_dropped sales.orders2")
+
+	// A failed listing doesn't look like dropped tables, and new databases start a baseline
+	ts.athena.FailNext("ListTableMetadata", awserr.New(athena.ErrCodeInternalServerException, "Internal error", nil))
+	snapshotTestSchemas(t, ts)
+	setTestTables(ts, "marketing", testTable("campaigns", "id:bigint"))
+	snapshotTestSchemas(t, ts)
+
+	// Dropped databases drop their tables
+	ts.athena.mu.Lock()
+	delete(ts.athena.databases, "marketing")
+	ts.athena.mu.Unlock()
+	snapshotTestSchemas(t, ts, "table_dropped marketing.campaigns")
+}
+
+func TestSchemaChanges(t *testing.T) {
+	ts := newTestServer(t)
+	orders := testTable("orders", "id:bigint", "amount:double")
+	setTestTables(ts, "sales", orders, testTable("returns", "id:bigint"))
+	snapshotTestSchemas(t, ts)
+
+	ordersQuery := createTestQuery(t, ts, "Orders", "SELECT id, amount FROM sales.orders")
+	returnsQuery := createTestQuery(t, ts, "Returns", "SELECT * FROM sales.returns")
+	neverRan := createTestQuery(t, ts, "Never ran", "SELECT * FROM sales.orders")
+	runTestQuery(t, ts, ordersQuery, nil)
+	runTestQuery(t, ts, returnsQuery, nil)
+
+	setTestTables(ts, "sales", testTable("orders", "id:bigint", "amount:decimal(10,2)"))
+	snapshotTestSchemas(t, ts, "column_retyped sales.orders.amount double decimal(10,2)", "table_dropped sales.returns")
+
+	// Running a query again acknowledges the changes before
+	runTestQuery(t, ts, returnsQuery, nil)
+	setTestTables(ts, "sales", testTable("orders", "id:bigint", "amount:decimal(10,2)", "note:varchar"))
+	snapshotTestSchemas(t, ts, "column_added sales.orders.note  varchar")
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		want   []string
+	}{
+		{name: "all", path: "/api/schema-changes", status: http.StatusOK, want: []string{
+			"column_added sales.orders.note  varchar", "column_retyped sales.orders.amount double decimal(10,2)", "table_dropped sales.returns",
+		}},
+		{name: "by table", path: "/api/schema-changes?database=SALES&table=Orders", status: http.StatusOK, want: []string{
+			"column_added sales.orders.note  varchar", "column_retyped sales.orders.amount double decimal(10,2)",
+		}},
+		{name: "limit", path: "/api/schema-changes?limit=1", status: http.StatusOK, want: []string{"column_added sales.orders.note  varchar"}},
+		{name: "since", path: "/api/schema-changes?since=2999-01-01", status: http.StatusOK, want: []string{}},
+		{name: "of a table", path: "/api/catalog/sales/orders/changes", status: http.StatusOK, want: []string{
+			"column_added sales.orders.note  varchar", "column_retyped sales.orders.amount double decimal(10,2)",
+		}},
+		{name: "of a dropped table", path: "/api/catalog/sales/returns/changes", status: http.StatusOK, want: []string{"table_dropped sales.returns"}},
+		{name: "invalid limit", path: "/api/schema-changes?limit=0", status: http.StatusBadRequest},
+		{name: "invalid since", path: "/api/schema-changes?since=yesterday", status: http.StatusBadRequest},
+		{name: "unknown catalog", path: "/api/catalog/sales/orders/changes?catalog=nope", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, tt.path, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var changes []SchemaChange
+			decodeBody(t, rec, &changes)
+			if got := changeNames(changes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Only changes since a query last succeeded affect it
+	affected := doJSON[[]QuerySchemaChanges](t, ts, http.MethodGet, "/api/schema-changes/affected-queries", nil, http.StatusOK)
+	if len(affected) != 1 || affected[0].QueryID != ordersQuery.ID || affected[0].LastSucceededAt == nil || len(affected[0].Changes) != 2 {
+		t.Errorf("affected queries %+v, want only the orders query with 2 changes", affected)
+	}
+
+	tests = []struct {
+		name   string
+		path   string
+		status int
+		want   []string
+	}{
+		{name: "changed since it ran", path: "/api/queries/" + ordersQuery.ID.Hex() + "/schema-changes", status: http.StatusOK, want: []string{
+			"column_added sales.orders.note  varchar", "column_retyped sales.orders.amount double decimal(10,2)",
+		}},
+		{name: "ran since it changed", path: "/api/queries/" + returnsQuery.ID.Hex() + "/schema-changes", status: http.StatusOK, want: []string{}},
+		{name: "never ran", path: "/api/queries/" + neverRan.ID.Hex() + "/schema-changes", status: http.StatusOK, want: []string{}},
+		{name: "unknown query", path: "/api/queries/0123456789abcdef01234567/schema-changes", status: http.StatusNotFound},
+		{name: "invalid query ID", path: "/api/queries/nope/schema-changes", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, tt.path, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var result QuerySchemaChanges
+			decodeBody(t, rec, &result)
+			if got := changeNames(result.Changes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/schema-changes/affected-queries?since=yesterday", nil, http.StatusBadRequest)
+}
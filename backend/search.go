@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultSearchLimit = 20
+const maxSearchLimit = 100
+const highlightContext = 60
+
+// How much a match in each field of a query counts towards its search score
+var querySearchWeights = map[string]float64{"name": 10, "tables": 5, "description": 3, "sql": 1}
+
+// Words that carry no meaning in a search such as "which query computes weekly active users?"
+var searchStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "by": true, "does": true, "for": true,
+	"how": true, "in": true, "is": true, "of": true, "on": true, "or": true, "query": true,
+	"the": true, "to": true, "what": true, "which": true, "who": true, "with": true,
+}
+
+// Helper function to split a search into meaningful lowercase terms
+func searchTerms(q string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !(r == '_' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > utf8.RuneSelf)
+	}) {
+		if len(word) < 2 || searchStopWords[word] {
+			continue
+		}
+		terms = append(terms, stemSearchTerm(word))
+	}
+	return terms
+}
+
+// Helper function to reduce a word to a crude stem so "computes" also highlights "compute"
+func stemSearchTerm(word string) string {
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if len(word) > len(suffix)+3 && strings.HasSuffix(word, suffix) {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+// Helper function to build highlighted fragments of a field for the given terms
+func highlightField(field, text string, terms []string) *Highlight {
+	if text == "" || len(terms) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	pattern := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\w*`)
+
+	matches := pattern.FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	// Center the fragment on the first match and keep it on rune boundaries
+	start := matches[0][0] - highlightContext
+	if start < 0 {
+		start = 0
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	end := matches[0][1] + highlightContext*2
+	if end > len(text) {
+		end = len(text)
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	highlight := &Highlight{Field: field, Fragment: text[start:end], Matches: [][2]int{}}
+	for _, match := range matches {
+		if match[0] >= start && match[1] <= end {
+			highlight.Matches = append(highlight.Matches, [2]int{match[0] - start, match[1] - start})
+		}
+	}
+	return highlight
+}
+
+// Helper function to collect highlights over several fields in order
+func buildHighlights(terms []string, fields ...[2]string) []Highlight {
+	highlights := []Highlight{}
+	for _, field := range fields {
+		if highlight := highlightField(field[0], field[1], terms); highlight != nil {
+			highlights = append(highlights, *highlight)
+		}
+	}
+	return highlights
+}
+
+// Helper function to scale the scores of hits from one source so the best is 1.
+// Stores score queries and runs differently, so raw scores can't be compared.
+func normalizeSearchScores(hits []SearchHit) []SearchHit {
+	best := 0.0
+	for _, hit := range hits {
+		best = max(best, hit.Score)
+	}
+	if best <= 0 {
+		return hits
+	}
+	for i := range hits {
+		hits[i].Score /= best
+	}
+	return hits
+}
+
+// Helper function to rank search hits by score and keep the best
+func topSearchHits(hits []SearchHit, limit int) []SearchHit {
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// Helper function to read ?scope= as a comma separated set of queries and runs
+func parseSearchScope(value string) (map[string]bool, error) {
+	scope := map[string]bool{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != "queries" && part != "runs" {
+			return nil, fmt.Errorf("scope must be a comma separated list of queries and runs")
+		}
+		scope[part] = true
+	}
+	return scope, nil
+}
+
+// Helper function to parse a date filter given either as RFC 3339 or as YYYY-MM-DD
+func parseDateParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func (s *Server) search(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSearchLimit)))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	filter := SearchFilter{Author: c.Query("author")}
+
+	filter.RanAfter, filter.RanBefore, err = parseDateRange(c, "ranAfter", "ranBefore")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if rawTags := c.QueryArray("tag"); len(rawTags) > 0 {
+		filter.Tags, err = normalizeTags(rawTags)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	scope, err := parseSearchScope(c.DefaultQuery("scope", "queries,runs"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	terms := searchTerms(q)
+	hits := []SearchHit{}
+
+	if scope["queries"] {
+		queryHits, err := s.store.SearchQueries(ctx, q, filter, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, hit := range normalizeSearchScores(queryHits) {
+			hit.Highlights = buildHighlights(terms,
+				[2]string{"name", hit.Query.Name},
+				[2]string{"description", hit.Query.Description},
+				[2]string{"tables", strings.Join(hit.Query.Tables, ", ")},
+				[2]string{"sql", hit.Query.SQL},
+			)
+			hits = append(hits, hit)
+		}
+	}
+
+	if scope["runs"] {
+		runHits, err := s.store.SearchQueryRuns(ctx, q, filter, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, hit := range normalizeSearchScores(runHits) {
+			hit.Highlights = buildHighlights(terms, [2]string{"sql", hit.Run.SQL})
+			hits = append(hits, hit)
+		}
+	}
+
+	c.JSON(http.StatusOK, SearchResponse{Query: q, Hits: topSearchHits(hits, limit)})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{q: "Which query computes the weekly active users?", want: []string{"comput", "weekly", "active", "user"}},
+		{q: "orders_by_day, CACHED", want: []string{"orders_by_day", "cach"}},
+		{q: "running totals", want: []string{"runn", "total"}},
+		{q: "a is of x", want: nil},
+		{q: "bus gas", want: []string{"bus", "gas"}},
+	}
+
+	for _, tt := range tests {
+		if got := searchTerms(tt.q); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("searchTerms(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightField(t *testing.T) {
+	highlight := highlightField("name", "Weekly active users by Week", []string{"week", "user"})
+	want := &Highlight{Field: "name", Fragment: "Weekly active users by Week", Matches: [][2]int{{0, 6}, {14, 19}, {23, 27}}}
+	if !reflect.DeepEqual(highlight, want) {
+		t.Errorf("highlight %+v, want %+v", highlight, want)
+	}
+
+	if highlight := highlightField("sql", "SELECT 1", []string{"week"}); highlight != nil {
+		t.Errorf("highlight %+v without a match", highlight)
+	}
+	if highlight := highlightField("sql", "reweekly", []string{"week"}); highlight != nil {
+		t.Errorf("highlight %+v inside a word", highlight)
+	}
+
+	// Long fields are cut around the first match, on rune boundaries
+	text := strings.Repeat("é", 100) + " weekly " + strings.Repeat("x", 300)
+	highlight = highlightField("sql", text, []string{"weekly"})
+	if highlight == nil || len(highlight.Matches) != 1 {
+		t.Fatalf("highlight %+v", highlight)
+	}
+	match := highlight.Fragment[highlight.Matches[0][0]:highlight.Matches[0][1]]
+	if match != "weekly" || !strings.HasPrefix(highlight.Fragment, "é") || len(highlight.Fragment) > highlightContext*3+len(" weekly ") {
+		t.Errorf("fragment %q matches %q", highlight.Fragment, match)
+	}
+}
+
+func TestNormalizeSearchScores(t *testing.T) {
+	hits := normalizeSearchScores([]SearchHit{{Score: 4}, {Score: 10}, {Score: 1}})
+	if hits[0].Score != 0.4 || hits[1].Score != 1 || hits[2].Score != 0.1 {
+		t.Errorf("scores %+v", hits)
+	}
+	if hits := normalizeSearchScores([]SearchHit{{Score: 0}}); hits[0].Score != 0 {
+		t.Errorf("scores %+v without a positive one", hits)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ts := newTestServer(t)
+	revenue := createTestQuery(t, ts, "Revenue", "SELECT sum(amount) FROM sales.orders")
+	weekly := createTestQuery(t, ts, "Weekly active users", "SELECT count(*) FROM events.sessions WHERE kind = 'weekly'")
+	runTestQuery(t, ts, revenue, nil)
+
+	// Stop words are ignored and terms match their stems
+	response := doJSON[SearchResponse](t, ts, http.MethodGet, "/api/search?q=which+query+computes+the+weekly+users", nil, http.StatusOK)
+	if len(response.Hits) != 1 || response.Hits[0].Query == nil || response.Hits[0].Query.ID != weekly.ID {
+		t.Fatalf("hits %+v, want the weekly query", response.Hits)
+	}
+	want := []Highlight{
+		{Field: "name", Fragment: "Weekly active users", Matches: [][2]int{{0, 6}, {14, 19}}},
+		{Field: "sql", Fragment: weekly.SQL, Matches: [][2]int{{51, 57}}},
+	}
+	if !reflect.DeepEqual(response.Hits[0].Highlights, want) {
+		t.Errorf("highlights %+v, want %+v", response.Hits[0].Highlights, want)
+	}
+
+	// Queries and runs score differently, so the best of each scores 1
+	response = doJSON[SearchResponse](t, ts, http.MethodGet, "/api/search?q=revenue+amount", nil, http.StatusOK)
+	if len(response.Hits) != 2 {
+		t.Fatalf("hits %+v, want the revenue query and its run", response.Hits)
+	}
+	for _, hit := range response.Hits {
+		if hit.Score != 1 {
+			t.Errorf("%s hit scored %v", hit.Type, hit.Score)
+		}
+	}
+
+	tests := []struct {
+		scope  string
+		types  []string
+		status int
+	}{
+		{scope: "queries", types: []string{"query"}, status: http.StatusOK},
+		{scope: "runs", types: []string{"run"}, status: http.StatusOK},
+		{scope: "queries,+runs", types: []string{"query", "run"}, status: http.StatusOK},
+		{scope: "run", status: http.StatusBadRequest},
+		{scope: "queries,dashboards", status: http.StatusBadRequest},
+		{scope: "", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run("scope "+tt.scope, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, "/api/search?q=amount&scope="+tt.scope, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var response SearchResponse
+			decodeBody(t, rec, &response)
+			types := []string{}
+			for _, hit := range response.Hits {
+				types = append(types, hit.Type)
+			}
+			if !reflect.DeepEqual(types, tt.types) {
+				t.Errorf("hits of types %v, want %v", types, tt.types)
+			}
+		})
+	}
+
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/search", nil, http.StatusBadRequest)
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/search?q=amount&limit=0", nil, http.StatusBadRequest)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/athena/athenaiface"
+	"github.com/aws/aws-sdk-go/service/glue/glueiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+// Server holds the dependencies of the HTTP handlers. Tests construct one
+// with newMemoryStore, newFakeAthena and newFakeGlue instead of MongoDB and AWS.
+type Server struct {
+	store         Store
+	athena        athenaiface.AthenaAPI
+	s3            s3iface.S3API
+	glue          glueiface.GlueAPI
+	resultsBucket string
+
+	// Workgroup statements run in unless their query picks another
+	athenaWorkGroup string
+
+	// How long results are reused for queries without their own TTL
+	resultCacheTTL time.Duration
+
+	// Whether exports may hand out presigned S3 URLs, and for how long they are valid
+	presignedURLs      bool
+	presignedURLExpiry time.Duration
+
+	// Most widget queries a dashboard refresh starts at once
+	dashboardConcurrency int
+
+	// Size from which lint flags reading every column of a table
+	largeTableBytes int64
+
+	// Result profiles being computed, by execution ID
+	profiles singleflight.Group
+
+	// Data catalogs, with the databases and tables of those that were browsed
+	catalogs catalogCaches
+
+	// Recent table previews, for reusing them
+	previews tablePreviews
+
+	// Key share link tokens are signed with
+	shareSecret []byte
+
+	// Recent password attempts on share links, for throttling them
+	sharePasswords sharePasswordAttempts
+
+	// Proxies whose X-Forwarded-For header names the client; none when empty
+	trustedProxies []string
+
+	// Where schema changes are posted; empty to only log them
+	schemaWebhookURL string
+}
+
+func newServer(store Store, athenaClient athenaiface.AthenaAPI, s3Client s3iface.S3API, glueClient glueiface.GlueAPI, resultsBucket string) *Server {
+	// Replaced by SHARE_LINK_SECRET in main; a failure leaves share links unusable
+	shareSecret, err := randomShareSecret()
+	if err != nil {
+		log.Printf("Share links disabled: %v", err)
+	}
+
+	return &Server{
+		store:         store,
+		athena:        athenaClient,
+		s3:            s3Client,
+		glue:          glueClient,
+		resultsBucket: resultsBucket,
+
+		athenaWorkGroup: defaultAthenaWorkGroup,
+
+		resultCacheTTL: defaultResultCacheTTL,
+
+		presignedURLs:      true,
+		presignedURLExpiry: defaultPresignedURLExpiry,
+
+		dashboardConcurrency: defaultDashboardConcurrency,
+
+		largeTableBytes: defaultLargeTableBytes,
+
+		shareSecret: shareSecret,
+	}
+}
+
+// Helper function to read TRUSTED_PROXIES, the comma separated IPs and CIDR
+// ranges of the proxies in front of the server. Client IPs are only taken from
+// X-Forwarded-For when a trusted proxy sent it, so clients can't pick their own.
+func loadTrustedProxies() ([]string, error) {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %s", proxy)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// Helper function to build the Gin router with every route of the server
+func (s *Server) router() *gin.Engine {
+	r := gin.Default()
+	if err := r.SetTrustedProxies(s.trustedProxies); err != nil {
+		log.Printf("Ignoring trusted proxies: %v", err)
+	}
+
+	// CORS middleware
+	r.Use(cors.New(cors.Config{
+		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:3001"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", sharePasswordHeader},
+		ExposeHeaders:    []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Content-Disposition", "X-Total-Count", "X-Next-Cursor", "Link"},
+		AllowCredentials: true,
+	}))
+
+	// Serve static files in production
+	r.Static("/static", "./frontend/dist")
+	r.StaticFile("/", "./frontend/dist/index.html")
+	r.StaticFile("/bolt.png", "./frontend/dist/assets/bolt.png")
+
+	// API routes
+	api := r.Group("/api")
+	{
+		api.GET("/health", healthCheck)
+
+		// Query routes
+		api.GET("/queries", s.getQueries)
+		api.POST("/queries", s.createQuery)
+		api.GET("/queries/:id", s.getQuery)
+		api.PUT("/queries/:id", s.updateQuery)
+		api.PATCH("/queries/:id", s.patchQuery)
+		api.DELETE("/queries/:id", s.deleteQuery)
+
+		// Folder and tag routes
+		api.PUT("/queries/:id/folder", s.moveQuery)
+		api.PUT("/queries/:id/tags", s.setQueryTags)
+		api.POST("/queries/:id/tags", s.addQueryTags)
+		api.DELETE("/queries/:id/tags/:tag", s.removeQueryTag)
+		api.GET("/folders", s.getFolders)
+		api.GET("/tags", s.getTags)
+
+		// Search routes
+		api.GET("/search", s.search)
+
+		// Query run routes
+		api.GET("/queries/:id/runs", s.getQueryRuns)
+		api.POST("/queries/:id/runs", s.executeQuery)
+		api.DELETE("/query-runs/:id", s.deleteQueryRun)
+		api.GET("/query-runs/:id/diff/:otherId", s.diffQueryRuns)
+
+		// Share link routes
+		api.GET("/query-runs/:id/shares", s.getShareLinks)
+		api.POST("/query-runs/:id/shares", s.createShareLink)
+		api.DELETE("/shares/:id", s.revokeShareLink)
+
+		// Public routes, which the authenticating proxy must let through
+		api.GET("/public/shares/:token", s.getSharedRun)
+		api.GET("/public/shares/:token/results", s.getSharedResults)
+		api.GET("/public/shares/:token/download", s.downloadSharedResults)
+
+		// Visualization routes
+		api.GET("/queries/:id/visualizations", s.getVisualizations)
+		api.POST("/queries/:id/visualizations", s.createVisualization)
+		api.GET("/visualizations/:id", s.getVisualization)
+		api.PUT("/visualizations/:id", s.updateVisualization)
+		api.DELETE("/visualizations/:id", s.deleteVisualization)
+		api.GET("/visualizations/:id/data", s.getVisualizationData)
+
+		// Dashboard routes
+		api.GET("/dashboards", s.getDashboards)
+		api.POST("/dashboards", s.createDashboard)
+		api.GET("/dashboards/:id", s.getDashboard)
+		api.PUT("/dashboards/:id", s.updateDashboard)
+		api.DELETE("/dashboards/:id", s.deleteDashboard)
+		api.POST("/dashboards/:id/refresh", s.refreshDashboard)
+		api.GET("/dashboards/:id/runs", s.getDashboardRuns)
+
+		// Trash routes
+		api.GET("/trash/queries", s.getTrashedQueries)
+		api.POST("/trash/queries/:id/restore", s.restoreQuery)
+		api.DELETE("/trash/queries/:id", s.purgeTrashedQuery)
+		api.GET("/trash/query-runs", s.getTrashedQueryRuns)
+		api.POST("/trash/query-runs/:id/restore", s.restoreQueryRun)
+		api.DELETE("/trash/query-runs/:id", s.purgeTrashedQueryRun)
+
+		// Athena routes
+		api.POST("/athena/execute", s.executeAthenaQuery)
+		api.GET("/athena/results/:executionId", s.getQueryResults)
+		api.GET("/athena/results/:executionId/profile", s.getResultProfile)
+		api.GET("/athena/export/:executionId", s.exportResults)
+		api.GET("/athena/export/:executionId/links", s.getDownloadLinks)
+		api.GET("/athena/catalog", s.getAthenaCatalog)
+
+		// Catalog routes, for AwsDataCatalog unless ?catalog= names another
+		api.GET("/catalogs", s.getDataCatalogs)
+		api.GET("/workgroups", s.getWorkGroups)
+		api.GET("/catalog", s.getCatalogDatabases)
+		api.GET("/catalog/search", s.searchCatalog)
+		api.POST("/catalog/autocomplete", s.autocompleteSQL)
+		api.GET("/catalog/:db", s.getCatalogDatabase)
+		api.GET("/catalog/:db/:table", s.getCatalogTable)
+		api.POST("/catalog/:db/:table/preview", s.previewTable)
+		api.GET("/catalog/:db/:table/changes", s.getTableSchemaChanges)
+		api.GET("/catalog/:db/:table/queries", s.getTableQueries)
+		api.GET("/catalog/:db/:table/lineage", s.getTableLineage)
+
+		// Schema change tracking
+		api.GET("/schema-changes", s.getSchemaChanges)
+		api.GET("/schema-changes/affected-queries", s.getAffectedQueries)
+		api.GET("/queries/:id/schema-changes", s.getQuerySchemaChanges)
+
+		// Lineage of tables created and written by saved queries
+		api.GET("/lineage", s.getLineage)
+
+		// SQL editing
+		api.POST("/sql/format", s.formatSQL)
+		api.POST("/sql/lint", s.lintSQL)
+	}
+
+	// Fallback to serve React app for any non-API routes
+	r.NoRoute(func(c *gin.Context) {
+		c.File("./frontend/dist/index.html")
+	})
+
+	return r
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testResultsBucket = "zeus-test-results"
+
+// The store test servers are backed by; every test runs against each of them
+var testStore = "memory"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	for _, testStore = range []string{"memory", "sqlite"} {
+		if code := m.Run(); code != 0 {
+			fmt.Fprintf(os.Stderr, "Tests failed against the %s store\n", testStore)
+			os.Exit(code)
+		}
+	}
+	os.Exit(0)
+}
+
+// Helper function to create an empty store of the kind the tests run against
+func newTestStore(t *testing.T) Store {
+	t.Helper()
+	if testStore == "memory" {
+		return newMemoryStore()
+	}
+
+	store, err := newSQLStore(context.Background(), testStore, filepath.Join(t.TempDir(), "zeus.db"))
+	if err != nil {
+		t.Fatalf("open %s store: %v", testStore, err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) })
+	return store
+}
+
+// testServer is a Server backed by the test store and the AWS fakes,
+// with the router the handlers are reached through
+type testServer struct {
+	*Server
+	athena *fakeAthena
+	s3     *fakeS3
+	glue   *fakeGlue
+	router *gin.Engine
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	s3Client := newFakeS3()
+	athenaClient := newFakeAthena(s3Client)
+	glueClient := newFakeGlue()
+	s := newServer(newTestStore(t), athenaClient, s3Client, glueClient, testResultsBucket)
+
+	return &testServer{Server: s, athena: athenaClient, s3: s3Client, glue: glueClient, router: s.router()}
+}
+
+// Helper function to send a request through the router. body is sent as is
+// when it is a string and encoded as JSON otherwise; headers are name, value pairs.
+func (ts *testServer) do(t *testing.T, method, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(body)
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("encode request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	rec := httptest.NewRecorder()
+	ts.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// Helper function to send a request and decode the JSON response, failing the
+// test unless it has the expected status
+func doJSON[T any](t *testing.T, ts *testServer, method, path string, body interface{}, status int, headers ...string) T {
+	t.Helper()
+
+	rec := ts.do(t, method, path, body, headers...)
+	if rec.Code != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, path, rec.Code, status, rec.Body.String())
+	}
+
+	var value T
+	decodeBody(t, rec, &value)
+	return value
+}
+
+// Helper function to decode a JSON response
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, value interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), value); err != nil {
+		t.Fatalf("decode response: %v: %s", err, rec.Body.String())
+	}
+}
+
+// Helper function to save a query through the API
+func createTestQuery(t *testing.T, ts *testServer, name, sql string) Query {
+	t.Helper()
+	return doJSON[Query](t, ts, http.MethodPost, "/api/queries", gin.H{"name": name, "sql": sql}, http.StatusCreated)
+}
+
+// Helper function to run a saved query through the API and poll its run until
+// Athena reports a final state
+func runTestQuery(t *testing.T, ts *testServer, query Query, body gin.H) QueryRun {
+	t.Helper()
+
+	if body == nil {
+		body = gin.H{}
+	}
+	if _, ok := body["sql"]; !ok {
+		body["sql"] = query.SQL
+	}
+	run := doJSON[QueryRun](t, ts, http.MethodPost, "/api/queries/"+query.ID.Hex()+"/runs", body, http.StatusCreated)
+
+	for polls := 0; ; polls++ {
+		switch run.Status {
+		case "SUCCEEDED", "FAILED", "CANCELLED":
+			return run
+		}
+		if polls == 20 {
+			t.Fatalf("run %s still %s after %d polls", run.ID.Hex(), run.Status, polls)
+		}
+
+		runs := doJSON[[]QueryRun](t, ts, http.MethodGet, "/api/queries/"+query.ID.Hex()+"/runs", nil, http.StatusOK)
+		for _, listed := range runs {
+			if listed.ID == run.ID {
+				run = listed
+			}
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const defaultShareLinkExpiry = 7 * 24 * time.Hour
+
+const maxShareLinkExpiry = 90 * 24 * time.Hour
+
+// Shortest SHARE_LINK_SECRET accepted, in bytes
+const minShareSecretLength = 32
+
+// Header a viewer sends the password of a protected share link in
+const sharePasswordHeader = "X-Share-Password"
+
+// Password attempts a client gets on a share link within sharePasswordWindow,
+// counted from its first failed one
+const maxSharePasswordAttempts = 5
+const sharePasswordWindow = 15 * time.Minute
+
+// sharePasswordAttempts counts the password attempts of clients on share links,
+// so passwords can't be guessed as fast as bcrypt allows. Clients are told
+// apart by IP, so a client guessing doesn't lock the recipients of the link
+// out. Counts are kept in memory and start over when the server restarts.
+type sharePasswordAttempts struct {
+	mu       sync.Mutex
+	byClient map[sharePasswordClient]sharePasswordWindowCount
+}
+
+type sharePasswordClient struct {
+	link primitive.ObjectID
+	ip   string
+}
+
+type sharePasswordWindowCount struct {
+	attempts int
+	since    time.Time
+}
+
+// Helper function to count a password attempt of a client on a link before
+// checking it. Returns how long until the client may try again, 0 when this
+// attempt may go ahead.
+func (a *sharePasswordAttempts) take(id primitive.ObjectID, ip string, now time.Time) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.byClient == nil {
+		a.byClient = map[sharePasswordClient]sharePasswordWindowCount{}
+	}
+	client := sharePasswordClient{link: id, ip: ip}
+	count, ok := a.byClient[client]
+	if !ok || now.Sub(count.since) >= sharePasswordWindow {
+		// Windows that are over no longer limit anything
+		for other, otherCount := range a.byClient {
+			if now.Sub(otherCount.since) >= sharePasswordWindow {
+				delete(a.byClient, other)
+			}
+		}
+		count = sharePasswordWindowCount{since: now}
+	}
+	if count.attempts >= maxSharePasswordAttempts {
+		return count.since.Add(sharePasswordWindow).Sub(now)
+	}
+	count.attempts++
+	a.byClient[client] = count
+	return 0
+}
+
+// Helper function to forget the attempts of a client on a link once it gave the password
+func (a *sharePasswordAttempts) reset(id primitive.ObjectID, ip string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.byClient, sharePasswordClient{link: id, ip: ip})
+}
+
+// Helper function to read SHARE_LINK_SECRET, which signs share tokens. It is
+// required: a generated secret would break the links handed out whenever the
+// server restarts, and between replicas.
+func loadShareSecret() ([]byte, error) {
+	value := os.Getenv("SHARE_LINK_SECRET")
+	if value == "" {
+		return nil, fmt.Errorf("SHARE_LINK_SECRET is required")
+	}
+	if len(value) < minShareSecretLength {
+		return nil, fmt.Errorf("invalid SHARE_LINK_SECRET: must be at least %d characters", minShareSecretLength)
+	}
+	return []byte(value), nil
+}
+
+func randomShareSecret() ([]byte, error) {
+	secret := make([]byte, minShareSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate share link secret: %v", err)
+	}
+	return secret, nil
+}
+
+// Helper function to sign a share link ID
+func (s *Server) shareSignature(id primitive.ObjectID) string {
+	mac := hmac.New(sha256.New, s.shareSecret)
+	mac.Write(id[:])
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Helper function to build the token of a share link: its ID and the signature
+// of the ID, so tokens can't be guessed from the IDs of other links
+func (s *Server) shareToken(id primitive.ObjectID) string {
+	return id.Hex() + "." + s.shareSignature(id)
+}
+
+// Helper function to verify a share token and return the ID of its link
+func (s *Server) parseShareToken(token string) (primitive.ObjectID, bool) {
+	hexID, signature, found := strings.Cut(token, ".")
+	if !found {
+		return primitive.NilObjectID, false
+	}
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.shareSignature(id))) {
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// Helper function to prepare a share link for its owners, with its token
+func (s *Server) shareLinkResponse(link ShareLink) ShareLink {
+	link.Token = s.shareToken(link.ID)
+	link.Protected = link.PasswordHash != ""
+	return link
+}
+
+// Helper function to load the run of a share link request, refreshing its status
+// if it hasn't finished yet
+func (s *Server) loadShareRun(ctx context.Context, c *gin.Context) (QueryRun, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query run ID"})
+		return QueryRun{}, false
+	}
+
+	run, err := s.store.GetQueryRun(ctx, id)
+	if err == nil && run.DeletedAt != nil {
+		err = ErrNotFound
+	}
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query run not found"})
+		return QueryRun{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return QueryRun{}, false
+	}
+
+	if run.Status == "QUEUED" || run.Status == "RUNNING" {
+		if run, err = s.updateQueryRunStatus(ctx, run); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return QueryRun{}, false
+		}
+	}
+	return run, true
+}
+
+func (s *Server) createShareLink(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req CreateShareLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Permission == "" {
+		req.Permission = "view"
+	}
+	if req.Permission != "view" && req.Permission != "download" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "permission must be view or download"})
+		return
+	}
+
+	expiry := defaultShareLinkExpiry
+	if req.ExpiresIn < 0 || time.Duration(req.ExpiresIn)*time.Second > maxShareLinkExpiry {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expiresIn must be between 1 and %d seconds", int(maxShareLinkExpiry.Seconds()))})
+		return
+	}
+	if req.ExpiresIn > 0 {
+		expiry = time.Duration(req.ExpiresIn) * time.Second
+	}
+
+	run, ok := s.loadShareRun(ctx, c)
+	if !ok {
+		return
+	}
+	if run.Status != "SUCCEEDED" || run.ResultsS3URL == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only the results of succeeded query runs can be shared"})
+		return
+	}
+
+	now := time.Now()
+	link := ShareLink{
+		RunID:      run.ID,
+		Permission: req.Permission,
+		CreatedBy:  requestUser(c),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(expiry),
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password: " + err.Error()})
+			return
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	if err := s.store.CreateShareLink(ctx, &link); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, s.shareLinkResponse(link))
+}
+
+func (s *Server) getShareLinks(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	run, ok := s.loadShareRun(ctx, c)
+	if !ok {
+		return
+	}
+
+	links, err := s.store.ListShareLinks(ctx, run.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range links {
+		links[i] = s.shareLinkResponse(links[i])
+	}
+
+	c.JSON(http.StatusOK, links)
+}
+
+func (s *Server) revokeShareLink(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share link ID"})
+		return
+	}
+
+	link, err := s.store.RevokeShareLink(c.Request.Context(), id, requestUser(c), time.Now())
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, s.shareLinkResponse(link))
+}
+
+// Public share handlers. They are reached without signing in, so they only
+// answer with the results of the shared run and never with its SQL or query.
+
+var (
+	errShareNotFound    = errors.New("Share link not found")
+	errShareExpired     = errors.New("Share link has expired")
+	errShareRevoked     = errors.New("Share link has been revoked")
+	errSharePassword    = errors.New("Share link requires a password")
+	errShareBadPassword = errors.New("Incorrect share link password")
+	errShareForbidden   = errors.New("Share link does not allow downloads")
+	errShareThrottled   = errors.New("Too many share link password attempts, try again later")
+)
+
+// Helper function to map a share link error to its HTTP status
+func shareErrorStatus(err error) int {
+	switch err {
+	case errShareNotFound:
+		return http.StatusNotFound
+	case errShareExpired, errShareRevoked:
+		return http.StatusGone
+	case errSharePassword, errShareBadPassword:
+		return http.StatusUnauthorized
+	case errShareForbidden:
+		return http.StatusForbidden
+	case errShareThrottled:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// Helper function to resolve the token of a public request to a live share link
+// and its run. The password is only checked when checkPassword is set, and at
+// most maxSharePasswordAttempts times per link within sharePasswordWindow.
+func (s *Server) resolveShare(ctx context.Context, c *gin.Context, checkPassword bool) (ShareLink, QueryRun, error) {
+	id, ok := s.parseShareToken(c.Param("token"))
+	if !ok {
+		return ShareLink{}, QueryRun{}, errShareNotFound
+	}
+
+	link, err := s.store.GetShareLink(ctx, id)
+	if err == ErrNotFound {
+		return ShareLink{}, QueryRun{}, errShareNotFound
+	}
+	if err != nil {
+		return ShareLink{}, QueryRun{}, err
+	}
+	if link.RevokedAt != nil {
+		return ShareLink{}, QueryRun{}, errShareRevoked
+	}
+	if !time.Now().Before(link.ExpiresAt) {
+		return ShareLink{}, QueryRun{}, errShareExpired
+	}
+
+	if checkPassword && link.PasswordHash != "" {
+		password := c.GetHeader(sharePasswordHeader)
+		if password == "" {
+			return ShareLink{}, QueryRun{}, errSharePassword
+		}
+		if wait := s.sharePasswords.take(link.ID, c.ClientIP(), time.Now()); wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			return ShareLink{}, QueryRun{}, errShareThrottled
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			return ShareLink{}, QueryRun{}, errShareBadPassword
+		}
+		s.sharePasswords.reset(link.ID, c.ClientIP())
+	}
+
+	// Runs in the trash are as gone as purged ones for their viewers
+	run, err := s.store.GetQueryRun(ctx, link.RunID)
+	if err == ErrNotFound || (err == nil && run.DeletedAt != nil) {
+		return ShareLink{}, QueryRun{}, errShareNotFound
+	}
+	if err != nil {
+		return ShareLink{}, QueryRun{}, err
+	}
+	return link, run, nil
+}
+
+func (s *Server) getSharedRun(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	link, run, err := s.resolveShare(c.Request.Context(), c, false)
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SharedRun{
+		Permission:        link.Permission,
+		PasswordProtected: link.PasswordHash != "",
+		ExpiresAt:         link.ExpiresAt,
+		ExecutedAt:        run.ExecutedAt,
+		CompletedAt:       run.CompletedAt,
+	})
+}
+
+func (s *Server) getSharedResults(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	_, run, err := s.resolveShare(c.Request.Context(), c, true)
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	// Viewers get pages of the size results can be sorted in, whatever they ask for
+	results, err := s.readResultsPage(c, run.ExecutionID, maxResultPageSize)
+	if errors.Is(err, ErrInvalidResultQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	results.CompletedAt = run.CompletedAt
+
+	c.JSON(http.StatusOK, results)
+}
+
+func (s *Server) downloadSharedResults(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	link, run, err := s.resolveShare(c.Request.Context(), c, true)
+	if err == nil && link.Permission != "download" {
+		err = errShareForbidden
+	}
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	dateStr := run.ExecutedAt.Format("2006-01-02_15-04-05")
+	if run.CompletedAt != nil {
+		dateStr = run.CompletedAt.Format("2006-01-02_15-04-05")
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=shared_results_"+dateStr+".csv")
+
+	if err := s.proxyS3File(c, run.ResultsS3URL); err != nil {
+		abortDownload(c, run.ExecutionID, err)
+	}
+}
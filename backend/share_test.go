@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Helper function to run a query and share its results
+func createTestShare(t *testing.T, ts *testServer, body gin.H) (QueryRun, ShareLink) {
+	t.Helper()
+	ts.athena.Script("from shared", exportTestScript)
+	query := createTestQuery(t, ts, "Shared", "SELECT * FROM shared")
+	run := runTestQuery(t, ts, query, nil)
+	link := doJSON[ShareLink](t, ts, http.MethodPost, "/api/query-runs/"+run.ID.Hex()+"/shares", body, http.StatusCreated)
+	return run, link
+}
+
+func TestCreateShareLink(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.Script("from failing", fakeQuery{States: []string{"FAILED"}, Reason: "boom"})
+	run, _ := createTestShare(t, ts, nil)
+	failed := runTestQuery(t, ts, createTestQuery(t, ts, "Failing", "SELECT * FROM failing"), nil)
+	path := "/api/query-runs/" + run.ID.Hex() + "/shares"
+
+	tests := []struct {
+		name       string
+		path       string
+		body       gin.H
+		status     int
+		permission string
+		expiry     time.Duration
+		protected  bool
+	}{
+		{name: "defaults", body: gin.H{}, status: http.StatusCreated, permission: "view", expiry: defaultShareLinkExpiry},
+		{name: "download for a day", body: gin.H{"permission": "download", "expiresIn": 86400}, status: http.StatusCreated, permission: "download", expiry: 24 * time.Hour},
+		{name: "password", body: gin.H{"password": "secret"}, status: http.StatusCreated, permission: "view", expiry: defaultShareLinkExpiry, protected: true},
+		{name: "unknown permission", body: gin.H{"permission": "edit"}, status: http.StatusBadRequest},
+		{name: "negative expiry", body: gin.H{"expiresIn": -1}, status: http.StatusBadRequest},
+		{name: "expiry too long", body: gin.H{"expiresIn": int(maxShareLinkExpiry.Seconds()) + 1}, status: http.StatusBadRequest},
+		{name: "failed run", path: "/api/query-runs/" + failed.ID.Hex() + "/shares", body: gin.H{}, status: http.StatusConflict},
+		{name: "unknown run", path: "/api/query-runs/0123456789abcdef01234567/shares", body: gin.H{}, status: http.StatusNotFound},
+		{name: "invalid run ID", path: "/api/query-runs/nope/shares", body: gin.H{}, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.path == "" {
+				tt.path = path
+			}
+			rec := ts.do(t, http.MethodPost, tt.path, tt.body, "X-Forwarded-User", "jane")
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if rec.Code != http.StatusCreated {
+				return
+			}
+
+			var link ShareLink
+			decodeBody(t, rec, &link)
+			if link.Permission != tt.permission || link.Protected != tt.protected || link.CreatedBy != "jane" || link.Token == "" {
+				t.Errorf("link %+v", link)
+			}
+			if expiry := link.ExpiresAt.Sub(link.CreatedAt); expiry != tt.expiry {
+				t.Errorf("expires after %v, want %v", expiry, tt.expiry)
+			}
+			if strings.Contains(rec.Body.String(), "passwordHash") || strings.Contains(rec.Body.String(), "$2a$") {
+				t.Error("the password hash was returned")
+			}
+		})
+	}
+
+	links := doJSON[[]ShareLink](t, ts, http.MethodGet, path, nil, http.StatusOK)
+	if len(links) != 4 || links[0].Token == "" {
+		t.Errorf("links %+v, want 4 with tokens", links)
+	}
+}
+
+func TestSharedResults(t *testing.T) {
+	ts := newTestServer(t)
+	run, view := createTestShare(t, ts, nil)
+	download := doJSON[ShareLink](t, ts, http.MethodPost, "/api/query-runs/"+run.ID.Hex()+"/shares", gin.H{"permission": "download"}, http.StatusCreated)
+	public := "/api/public/shares/"
+
+	shared := doJSON[SharedRun](t, ts, http.MethodGet, public+view.Token, nil, http.StatusOK)
+	if shared.Permission != "view" || shared.PasswordProtected || shared.CompletedAt == nil {
+		t.Errorf("shared run %+v", shared)
+	}
+	rec := ts.do(t, http.MethodGet, public+view.Token, nil)
+	if strings.Contains(rec.Body.String(), "SELECT") || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("shared run %s with Cache-Control %q", rec.Body.String(), rec.Header().Get("Cache-Control"))
+	}
+
+	results := doJSON[QueryResults](t, ts, http.MethodGet, public+view.Token+"/results?sort=-name", nil, http.StatusOK)
+	if results.Total != 2 || results.Rows[0][0] != "Bob" || results.CompletedAt == nil {
+		t.Errorf("results %+v", results)
+	}
+
+	doJSON[gin.H](t, ts, http.MethodGet, public+view.Token+"/download", nil, http.StatusForbidden)
+	rec = ts.do(t, http.MethodGet, public+download.Token+"/download", nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "name,orders") {
+		t.Errorf("download %d: %s", rec.Code, rec.Body.String())
+	}
+
+	tampered := view.Token[:len(view.Token)-2] + "xx"
+	doJSON[gin.H](t, ts, http.MethodGet, public+tampered, nil, http.StatusNotFound)
+	doJSON[gin.H](t, ts, http.MethodGet, public+"nope", nil, http.StatusNotFound)
+
+	revoked := doJSON[ShareLink](t, ts, http.MethodDelete, "/api/shares/"+view.ID.Hex(), nil, http.StatusOK, "X-Forwarded-User", "jane")
+	if revoked.RevokedAt == nil || revoked.RevokedBy != "jane" {
+		t.Errorf("revoked link %+v", revoked)
+	}
+	doJSON[gin.H](t, ts, http.MethodGet, public+view.Token+"/results", nil, http.StatusGone)
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/shares/0123456789abcdef01234567", nil, http.StatusNotFound)
+
+	expired := ShareLink{RunID: run.ID, Permission: "view", CreatedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(-time.Hour)}
+	if err := ts.store.CreateShareLink(context.Background(), &expired); err != nil {
+		t.Fatal(err)
+	}
+	doJSON[gin.H](t, ts, http.MethodGet, public+ts.shareToken(expired.ID), nil, http.StatusGone)
+
+	// Links die with their run
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/query-runs/"+run.ID.Hex(), nil, http.StatusOK)
+	doJSON[gin.H](t, ts, http.MethodGet, public+download.Token, nil, http.StatusNotFound)
+}
+
+func TestSharedResultsPageSize(t *testing.T) {
+	ts := newTestServer(t)
+	script := fakeQuery{Columns: []string{"n"}, Types: []string{"bigint"}}
+	for i := 0; i < maxResultPageSize+200; i++ {
+		script.Rows = append(script.Rows, []string{strconv.Itoa(i)})
+	}
+	ts.athena.Script("from numbers", script)
+	run := runTestQuery(t, ts, createTestQuery(t, ts, "Numbers", "SELECT * FROM numbers"), nil)
+	link := doJSON[ShareLink](t, ts, http.MethodPost, "/api/query-runs/"+run.ID.Hex()+"/shares", nil, http.StatusCreated)
+
+	for _, params := range []string{"size=100000", "size=5000&sort=-n"} {
+		results := doJSON[QueryResults](t, ts, http.MethodGet, "/api/public/shares/"+link.Token+"/results?"+params, nil, http.StatusOK)
+		if len(results.Rows) != maxResultPageSize || results.Size != maxResultPageSize {
+			t.Errorf("%s: %d rows in a page of %d, want %d", params, len(results.Rows), results.Size, maxResultPageSize)
+		}
+	}
+
+	// Signed in users still get what they ask for
+	results := doJSON[QueryResults](t, ts, http.MethodGet, "/api/athena/results/"+run.ExecutionID+"?size=100000", nil, http.StatusOK)
+	if len(results.Rows) != maxResultPageSize+200 {
+		t.Errorf("%d rows, want all %d", len(results.Rows), maxResultPageSize+200)
+	}
+}
+
+func TestSharePasswordThrottling(t *testing.T) {
+	ts := newTestServer(t)
+	run, link := createTestShare(t, ts, gin.H{"password": "secret", "permission": "download"})
+	other := doJSON[ShareLink](t, ts, http.MethodPost, "/api/query-runs/"+run.ID.Hex()+"/shares", gin.H{"password": "other"}, http.StatusCreated)
+	results := "/api/public/shares/" + link.Token + "/results"
+
+	// Reading the link itself doesn't take the password
+	shared := doJSON[SharedRun](t, ts, http.MethodGet, "/api/public/shares/"+link.Token, nil, http.StatusOK)
+	if !shared.PasswordProtected {
+		t.Error("the link isn't reported as protected")
+	}
+	doJSON[gin.H](t, ts, http.MethodGet, results, nil, http.StatusUnauthorized)
+
+	// The right password resets the count
+	for i := 0; i < maxSharePasswordAttempts-1; i++ {
+		doJSON[gin.H](t, ts, http.MethodGet, results, nil, http.StatusUnauthorized, sharePasswordHeader, "guess")
+	}
+	doJSON[QueryResults](t, ts, http.MethodGet, results, nil, http.StatusOK, sharePasswordHeader, "secret")
+
+	for i := 0; i < maxSharePasswordAttempts; i++ {
+		doJSON[gin.H](t, ts, http.MethodGet, results, nil, http.StatusUnauthorized, sharePasswordHeader, "guess")
+	}
+	for _, path := range []string{results, "/api/public/shares/" + link.Token + "/download"} {
+		rec := ts.do(t, http.MethodGet, path, nil, sharePasswordHeader, "secret")
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("%s: status %d, want %d: %s", path, rec.Code, http.StatusTooManyRequests, rec.Body.String())
+		}
+		if wait, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || wait <= 0 || wait > int(sharePasswordWindow.Seconds()) {
+			t.Errorf("Retry-After %q", rec.Header().Get("Retry-After"))
+		}
+	}
+
+	// Other links keep their own count
+	doJSON[QueryResults](t, ts, http.MethodGet, "/api/public/shares/"+other.Token+"/results", nil, http.StatusOK, sharePasswordHeader, "other")
+
+	// Clients can't name themselves, only trusted proxies name them
+	doJSON[gin.H](t, ts, http.MethodGet, results, nil, http.StatusTooManyRequests, sharePasswordHeader, "secret", "X-Forwarded-For", "198.51.100.7")
+	ts.trustedProxies = []string{"192.0.2.0/24"}
+	ts.router = ts.Server.router()
+	doJSON[QueryResults](t, ts, http.MethodGet, results, nil, http.StatusOK, sharePasswordHeader, "secret", "X-Forwarded-For", "198.51.100.7")
+	doJSON[gin.H](t, ts, http.MethodGet, results, nil, http.StatusTooManyRequests, sharePasswordHeader, "secret")
+
+	// Attempts are taken again once the window is over
+	var attempts sharePasswordAttempts
+	now := time.Now()
+	for i := 0; i < maxSharePasswordAttempts; i++ {
+		if wait := attempts.take(link.ID, "198.51.100.7", now); wait != 0 {
+			t.Fatalf("attempt %d throttled for %v", i+1, wait)
+		}
+	}
+	if wait := attempts.take(link.ID, "198.51.100.7", now.Add(time.Minute)); wait != sharePasswordWindow-time.Minute {
+		t.Errorf("throttled for %v, want %v", wait, sharePasswordWindow-time.Minute)
+	}
+	if wait := attempts.take(link.ID, "203.0.113.9", now.Add(time.Minute)); wait != 0 {
+		t.Errorf("another client throttled for %v", wait)
+	}
+	if wait := attempts.take(link.ID, "198.51.100.7", now.Add(sharePasswordWindow)); wait != 0 {
+		t.Errorf("throttled for %v after the window", wait)
+	}
+}
+
+func TestLoadShareSecret(t *testing.T) {
+	tests := []struct {
+		secret string
+		err    bool
+	}{
+		{secret: "", err: true},
+		{secret: strings.Repeat("x", minShareSecretLength-1), err: true},
+		{secret: strings.Repeat("x", minShareSecretLength)},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("SHARE_LINK_SECRET", tt.secret)
+		secret, err := loadShareSecret()
+		if (err != nil) != tt.err || err == nil && string(secret) != tt.secret {
+			t.Errorf("SHARE_LINK_SECRET=%q: got %q, %v", tt.secret, secret, err)
+		}
+	}
+}
+
+func TestLoadTrustedProxies(t *testing.T) {
+	tests := []struct {
+		proxies string
+		want    []string
+		err     bool
+	}{
+		{proxies: ""},
+		{proxies: "10.0.0.0/8, 192.0.2.1,", want: []string{"10.0.0.0/8", "192.0.2.1"}},
+		{proxies: "::1", want: []string{"::1"}},
+		{proxies: "proxy.internal", err: true},
+		{proxies: "10.0.0.0/33", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("TRUSTED_PROXIES", tt.proxies)
+		proxies, err := loadTrustedProxies()
+		if (err != nil) != tt.err || !reflect.DeepEqual(proxies, tt.want) {
+			t.Errorf("TRUSTED_PROXIES=%q: got %q, %v", tt.proxies, proxies, err)
+		}
+	}
+}
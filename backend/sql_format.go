@@ -0,0 +1,545 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultSQLIndent = 2
+
+const maxSQLIndent = 8
+
+// Athena rejects longer statements, so longer SQL isn't formatted or linted
+const maxSQLTextBytes = 262144
+
+// Parentheses nested deeper than this aren't formatted or linted, as the
+// parser and the formatter keep state for every level
+const maxSQLNesting = 100
+
+// Keywords that are called like functions, so no space goes before their parenthesis
+var sqlFunctionKeywords = map[string]bool{
+	"CAST": true, "TRY_CAST": true, "EXTRACT": true, "TRIM": true, "NORMALIZE": true, "GROUPING": true,
+	"UNNEST": true, "ROW": true, "IF": true, "CUBE": true, "ROLLUP": true, "LEFT": true, "RIGHT": true,
+	"REPLACE": true, "ARRAY": true,
+}
+
+// Clauses that start on a line of their own
+var sqlLineClauses = map[string]bool{
+	"WHERE": true, "HAVING": true, "LIMIT": true, "OFFSET": true, "FETCH": true, "WINDOW": true,
+	"VALUES": true, "SET": true,
+}
+
+// Words that can precede JOIN
+var sqlJoinModifiers = map[string]bool{
+	"LEFT": true, "RIGHT": true, "FULL": true, "INNER": true, "CROSS": true, "NATURAL": true, "OUTER": true,
+}
+
+// sqlFormatFrame is a part of a statement in parentheses or brackets, or the
+// statement itself
+type sqlFormatFrame struct {
+	query     bool   // Whether a query is in it, rather than an expression or a list
+	base      int    // Indentation of the clauses of the query
+	openLevel int    // Indentation of the line the parenthesis opened on
+	clause    string // Clause being formatted, lowercase
+	written   int    // Tokens written in the frame
+	between   int    // BETWEEN waiting for their AND
+	cases     int    // CASE waiting for their END
+}
+
+// sqlFormatter lays out the tokens of SQL one after the other
+type sqlFormatter struct {
+	tokens      []sqlToken
+	keywordCase string
+	indent      string
+
+	out       []byte
+	lineStart int  // Where the current line starts in out
+	lineLevel int  // Indentation of the current line
+	lineEmpty bool // Whether only indentation was written on the current line
+
+	frames     []*sqlFormatFrame
+	prev       *sqlToken // Last token written
+	unary      bool      // Whether the last token written is a unary sign
+	breakNext  int       // Indentation of the line the next token starts, -1 for none
+	breakAfter bool      // Whether the next token goes on a new line, after a line comment
+	statement  bool      // Whether a statement ended, so the next starts after a blank line
+	trailing   int       // Where a -- comment that ends the current line starts in out, -1 for none
+}
+
+// Helper function to lay SQL out with clauses on lines of their own, select
+// lists one expression per line and subqueries indented. keywordCase is upper,
+// lower or preserve; comments are kept.
+func formatSQL(sql, keywordCase, indent string) string {
+	f := &sqlFormatter{
+		tokens:      tokenizeSQL(sql),
+		keywordCase: keywordCase,
+		indent:      indent,
+		lineEmpty:   true,
+		frames:      []*sqlFormatFrame{{query: true}},
+		breakNext:   -1,
+		trailing:    -1,
+	}
+	for i := 0; i < len(f.tokens); i = f.token(i) + 1 {
+	}
+	return strings.TrimRight(string(f.out), " \t\n")
+}
+
+func (f *sqlFormatter) frame() *sqlFormatFrame {
+	return f.frames[len(f.frames)-1]
+}
+
+// Helper function to start a new line indented by level, or to indent the
+// current one when nothing was written on it yet
+func (f *sqlFormatter) newline(level int) {
+	if len(f.out) == 0 {
+		return
+	}
+	if f.lineEmpty {
+		f.out = f.out[:f.lineStart]
+	} else {
+		for len(f.out) > 0 && f.out[len(f.out)-1] == ' ' {
+			f.out = f.out[:len(f.out)-1]
+		}
+		f.out = append(f.out, '\n')
+	}
+	f.lineStart = len(f.out)
+	f.out = append(f.out, strings.Repeat(f.indent, level)...)
+	f.lineLevel = level
+	f.lineEmpty = true
+}
+
+// Helper function to return the text of the token at i in the keyword case
+func (f *sqlFormatter) text(i int) string {
+	token := f.tokens[i]
+	if !token.isKeyword() && !f.literalWord(i) {
+		return token.Text
+	}
+	switch f.keywordCase {
+	case "lower":
+		return strings.ToLower(token.Text)
+	case "preserve":
+		return token.Text
+	}
+	return strings.ToUpper(token.Text)
+}
+
+// Helper function to return the uppercase word at i, or "" when there is none
+func (f *sqlFormatter) word(i int) string {
+	if i < 0 || i >= len(f.tokens) || f.tokens[i].Kind != sqlWord {
+		return ""
+	}
+	return strings.ToUpper(f.tokens[i].Text)
+}
+
+// Helper function to tell whether the word at i is part of the syntax of a
+// literal, so it is cased like keywords: the type of a typed literal such as
+// DATE '2024-01-01', or a unit of an INTERVAL literal
+func (f *sqlFormatter) literalWord(i int) bool {
+	if f.tokens[i].Kind != sqlWord {
+		return false
+	}
+	if next := f.following(i); next < len(f.tokens) && f.tokens[next].Kind == sqlString {
+		prev := f.preceding(i)
+		return prev < 0 || f.tokens[prev].Kind != sqlSymbol || f.tokens[prev].Text != "."
+	}
+	if !sqlIntervalUnits[f.word(i)] {
+		return false
+	}
+
+	// Such as INTERVAL '1' DAY or INTERVAL -'1-2' YEAR TO MONTH
+	j := f.preceding(i)
+	if f.word(j) == "TO" {
+		if j = f.preceding(j); !sqlIntervalUnits[f.word(j)] {
+			return false
+		}
+		j = f.preceding(j)
+	}
+	if j < 0 || f.tokens[j].Kind != sqlString {
+		return false
+	}
+	j = f.preceding(j)
+	if j >= 0 && f.tokens[j].Kind == sqlSymbol && (f.tokens[j].Text == "-" || f.tokens[j].Text == "+") {
+		j = f.preceding(j)
+	}
+	return f.word(j) == "INTERVAL"
+}
+
+// Helper function to find the next token after i that isn't a comment
+func (f *sqlFormatter) following(i int) int {
+	for i++; i < len(f.tokens) && f.tokens[i].Kind == sqlComment; i++ {
+	}
+	return i
+}
+
+// Helper function to find the token before i that isn't a comment, -1 for none
+func (f *sqlFormatter) preceding(i int) int {
+	for i--; i >= 0 && f.tokens[i].Kind == sqlComment; i-- {
+	}
+	return i
+}
+
+// Helper function to tell whether a space goes between the last token written and the one at i
+func (f *sqlFormatter) spaceBefore(i int) bool {
+	if f.lineEmpty || f.prev == nil {
+		return false
+	}
+	token, prev := f.tokens[i], *f.prev
+	if token.Kind == sqlSymbol && strings.Contains("),;.]", token.Text) {
+		return false
+	}
+	if prev.Kind == sqlSymbol && (prev.Text == "(" || prev.Text == "[" || prev.Text == ".") || f.unary {
+		return false
+	}
+	if token.Kind == sqlSymbol && (token.Text == "(" || token.Text == "[") {
+		switch prev.Kind {
+		case sqlQuoted, sqlPlaceholder:
+			return f.namesTable(i - 1)
+		case sqlWord:
+			if f.namesTable(i - 1) {
+				return true
+			}
+			return prev.isKeyword() && !sqlFunctionKeywords[strings.ToUpper(prev.Text)]
+		case sqlSymbol:
+			return token.Text == "(" && prev.Text != ")" && prev.Text != "]" ||
+				token.Text == "[" && prev.Text != ")" && prev.Text != "]"
+		}
+	}
+	return true
+}
+
+// Helper function to tell whether the name that ends at i is that of a table
+// being created or inserted into, which a column list follows
+func (f *sqlFormatter) namesTable(i int) bool {
+	for i >= 2 && f.tokens[i-1].Kind == sqlSymbol && f.tokens[i-1].Text == "." {
+		i -= 2
+	}
+	switch f.word(i - 1) {
+	case "INTO", "TABLE", "VIEW", "EXISTS":
+		return true
+	}
+	return false
+}
+
+// Helper function to tell whether a + or - at i is a sign rather than an operator
+func (f *sqlFormatter) isSign(i int) bool {
+	if f.prev == nil {
+		return true
+	}
+	prev := *f.prev
+	switch prev.Kind {
+	case sqlSymbol:
+		return prev.Text != ")" && prev.Text != "]"
+	case sqlWord:
+		word := strings.ToUpper(prev.Text)
+		return prev.isKeyword() && word != "END" && word != "NULL" && word != "TRUE" && word != "FALSE" &&
+			!strings.HasPrefix(word, "CURRENT_") && !strings.HasPrefix(word, "LOCALTIME")
+	}
+	return false
+}
+
+func (f *sqlFormatter) write(i int) {
+	token := f.tokens[i]
+	if f.spaceBefore(i) {
+		f.out = append(f.out, ' ')
+	}
+	f.out = append(f.out, f.text(i)...)
+	f.lineEmpty = false
+	f.unary = token.Kind == sqlSymbol && (token.Text == "-" || token.Text == "+") && f.isSign(i)
+	f.prev = &f.tokens[i]
+	f.frame().written++
+	f.trailing = -1
+}
+
+// Helper function to format the token at i, returning the last token it wrote
+func (f *sqlFormatter) token(i int) int {
+	token := f.tokens[i]
+	frame := f.frame()
+	word := f.word(i)
+
+	if token.Kind == sqlComment {
+		f.comment(i)
+		return i
+	}
+
+	if f.statement {
+		f.statement = false
+		f.newline(0)
+		f.out = append(f.out[:f.lineStart], '\n')
+		f.lineStart++
+	}
+
+	// Where the token starts a line
+	broke := false
+	lineBreak := func(level int) {
+		f.newline(level)
+		broke = true
+	}
+	if frame.query {
+		switch {
+		case word == "SELECT" || word == "WITH" && frame.written == 0:
+			lineBreak(frame.base)
+			frame.clause = strings.ToLower(word)
+		case word == "FROM" && frame.clause == "select" &&
+			!(f.word(i-1) == "DISTINCT" && (f.word(i-2) == "IS" || f.word(i-2) == "NOT")):
+			lineBreak(frame.base)
+			frame.clause = "from"
+		case sqlLineClauses[word] && frame.written > 0:
+			lineBreak(frame.base)
+			frame.clause = strings.ToLower(word)
+		case (word == "GROUP" || word == "ORDER") && f.word(f.following(i)) == "BY":
+			lineBreak(frame.base)
+			frame.clause = strings.ToLower(word)
+		case word == "UNION" || word == "INTERSECT" || word == "EXCEPT":
+			lineBreak(frame.base)
+			frame.clause = ""
+		case word == "JOIN" && !sqlJoinModifiers[f.word(i-1)],
+			sqlJoinModifiers[word] && !sqlJoinModifiers[f.word(i-1)] && f.joinFollows(i):
+			lineBreak(frame.base)
+			frame.clause = "from"
+		case word == "ON" && frame.clause == "from":
+			frame.clause = "on"
+		case word == "WHEN" && frame.cases == 0 && frame.written > 0:
+			// A clause of MERGE
+			lineBreak(frame.base)
+		case (word == "AND" || word == "OR") && (frame.clause == "where" || frame.clause == "having" || frame.clause == "on"):
+			if word == "AND" && frame.between > 0 {
+				frame.between--
+			} else {
+				lineBreak(frame.base + 1)
+			}
+		}
+	}
+	switch word {
+	case "BETWEEN":
+		frame.between++
+	case "CASE":
+		frame.cases++
+	case "END":
+		if frame.cases > 0 {
+			frame.cases--
+		}
+	}
+
+	if token.Kind == sqlSymbol && token.Text == "," && f.trailing >= 0 {
+		// Written before the comment that ends the line
+	} else if !broke {
+		switch {
+		case f.breakNext >= 0:
+			f.newline(f.breakNext)
+		case f.breakAfter:
+			f.newline(f.lineLevel)
+		}
+	}
+	f.breakNext = -1
+	f.breakAfter = false
+
+	switch {
+	case token.Kind == sqlSymbol && (token.Text == "(" || token.Text == "["):
+		// Commas in brackets, like those in parentheses, don't break lines
+		query := token.Text == "(" && f.isQueryStart(f.following(i))
+		level := f.lineLevel
+		f.write(i)
+		opened := &sqlFormatFrame{query: query, base: frame.base, openLevel: level}
+		if query {
+			opened.base = level + 1
+			f.breakNext = opened.base
+		}
+		f.frames = append(f.frames, opened)
+
+	case token.Kind == sqlSymbol && (token.Text == ")" || token.Text == "]"):
+		if len(f.frames) > 1 {
+			f.frames = f.frames[:len(f.frames)-1]
+			if frame.query {
+				f.newline(frame.openLevel)
+			}
+		}
+		f.write(i)
+
+	case token.Kind == sqlSymbol && token.Text == ";":
+		f.write(i)
+		f.frames = []*sqlFormatFrame{{query: true}}
+		f.statement = f.following(i) < len(f.tokens)
+
+	case token.Kind == sqlSymbol && token.Text == "," && frame.query:
+		f.comma(i)
+		switch frame.clause {
+		case "select":
+			f.breakNext = frame.base + 1
+		case "with":
+			f.breakNext = frame.base
+		}
+
+	case word == "SELECT" && frame.query:
+		f.write(i)
+		// DISTINCT and ALL stay with SELECT; the select list starts on the next line
+		for next := f.following(i); f.word(next) == "DISTINCT" || f.word(next) == "ALL"; next = f.following(next) {
+			f.flushComments(i, next)
+			f.write(next)
+			i = next
+		}
+		f.breakNext = frame.base + 1
+
+	case (word == "UNION" || word == "INTERSECT" || word == "EXCEPT") && frame.query:
+		f.write(i)
+		if next := f.following(i); f.word(next) == "ALL" || f.word(next) == "DISTINCT" {
+			f.flushComments(i, next)
+			f.write(next)
+			i = next
+		}
+		f.breakNext = frame.base
+
+	case token.Kind == sqlSymbol && token.Text == ",":
+		f.comma(i)
+
+	default:
+		f.write(i)
+	}
+	return i
+}
+
+// Helper function to write a comma, before the -- comment ending the line if there is one
+func (f *sqlFormatter) comma(i int) {
+	if f.trailing < 0 {
+		f.write(i)
+		return
+	}
+	f.out = append(f.out[:f.trailing], append([]byte(","), f.out[f.trailing:]...)...)
+	f.frame().written++
+	f.trailing = -1
+	f.breakAfter = true
+}
+
+// Helper function to write the comments between two tokens that are written together
+func (f *sqlFormatter) flushComments(from, to int) {
+	for j := from + 1; j < to; j++ {
+		f.comment(j)
+	}
+}
+
+// Helper function to format the comment at i, keeping it on a line of its own
+// when it was, and ending the line after -- comments
+func (f *sqlFormatter) comment(i int) {
+	token := f.tokens[i]
+	if f.statement && (f.prev == nil || f.prev.Line != token.Line) {
+		f.statement = false
+		f.newline(0)
+		f.out = append(f.out[:f.lineStart], '\n')
+		f.lineStart++
+	}
+
+	ownLine := f.prev == nil || f.prev.Line < token.Line
+	switch {
+	case ownLine && f.breakNext >= 0:
+		f.newline(f.breakNext)
+		f.breakNext = -1
+	case ownLine || f.breakAfter:
+		f.newline(f.lineLevel)
+	}
+	f.breakAfter = false
+
+	f.trailing = -1
+	trailing := -1
+	if !f.lineEmpty {
+		trailing = len(f.out)
+		f.out = append(f.out, ' ')
+	}
+	f.out = append(f.out, strings.TrimRight(token.Text, " \t\r")...)
+	f.lineEmpty = false
+	f.unary = false
+	prev := token
+	prev.Line += strings.Count(token.Text, "\n")
+	f.prev = &prev
+
+	if strings.HasPrefix(token.Text, "--") {
+		f.breakAfter = true
+		f.trailing = trailing
+	} else if next := i + 1; next < len(f.tokens) && f.tokens[next].Line > prev.Line {
+		f.breakAfter = true
+	}
+}
+
+// Helper function to tell whether the join modifiers at i are followed by JOIN
+func (f *sqlFormatter) joinFollows(i int) bool {
+	for ; i < len(f.tokens) && sqlJoinModifiers[f.word(i)]; i = f.following(i) {
+	}
+	return f.word(i) == "JOIN"
+}
+
+func (f *sqlFormatter) isQueryStart(i int) bool {
+	word := f.word(i)
+	if word == "SELECT" || word == "WITH" || word == "VALUES" {
+		return true
+	}
+	return i < len(f.tokens) && f.tokens[i].Kind == sqlSymbol && f.tokens[i].Text == "(" && f.isQueryStart(f.following(i))
+}
+
+// Helper function to check that SQL is short and shallow enough to parse,
+// format or lint, as the parser slows down with every level of nesting
+func sqlSizeError(sql string) error {
+	if len(sql) > maxSQLTextBytes {
+		return fmt.Errorf("sql must be at most %d bytes", maxSQLTextBytes)
+	}
+
+	depth := 0
+	for _, token := range tokenizeSQL(sql) {
+		if token.Kind != sqlSymbol {
+			continue
+		}
+		switch token.Text {
+		case "(", "[":
+			if depth++; depth > maxSQLNesting {
+				return fmt.Errorf("sql must nest at most %d parentheses", maxSQLNesting)
+			}
+		case ")", "]":
+			depth--
+		}
+	}
+	return nil
+}
+
+// Helper function to check the size of SQL to format or lint. It responds
+// itself and returns false when the SQL is too large.
+func checkSQLSize(c *gin.Context, sql string) bool {
+	if err := sqlSizeError(sql); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+func (s *Server) formatSQL(c *gin.Context) {
+	var req SQLFormatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkSQLSize(c, req.SQL) {
+		return
+	}
+
+	keywordCase := strings.ToLower(req.KeywordCase)
+	if keywordCase == "" {
+		keywordCase = "upper"
+	}
+	if keywordCase != "upper" && keywordCase != "lower" && keywordCase != "preserve" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keywordCase must be upper, lower or preserve"})
+		return
+	}
+
+	indent := strings.Repeat(" ", defaultSQLIndent)
+	if req.Indent != nil {
+		if *req.Indent < 0 || *req.Indent > maxSQLIndent {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "indent must be between 0 and 8"})
+			return
+		}
+		indent = strings.Repeat(" ", *req.Indent)
+	}
+	if req.UseTabs {
+		indent = "\t"
+	}
+
+	c.JSON(http.StatusOK, SQLFormatResponse{SQL: formatSQL(req.SQL, keywordCase, indent)})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFormatSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		body gin.H
+		want string
+	}{
+		{
+			name: "clauses and select lists",
+			body: gin.H{"sql": "select a, b from t where x = 1 and y > 2 order by a limit 10"},
+			want: "SELECT\n  a,\n  b\nFROM t\nWHERE x = 1\n  AND y > 2\nORDER BY a\nLIMIT 10",
+		},
+		{
+			name: "lowercase keywords with tabs",
+			body: gin.H{"sql": "SELECT a FROM t WHERE x = 1", "keywordCase": "lower", "useTabs": true},
+			want: "select\n\ta\nfrom t\nwhere x = 1",
+		},
+		{
+			name: "subquery",
+			body: gin.H{"sql": "SELECT * FROM (SELECT a FROM t) s", "indent": 4},
+			want: "SELECT\n    *\nFROM (\n    SELECT\n        a\n    FROM t\n) s",
+		},
+		{
+			name: "comments and placeholders",
+			body: gin.H{"sql": "select a -- the key   \nfrom t where d = '{{day}}'", "keywordCase": "preserve"},
+			want: "select\n  a -- the key\nfrom t\nwhere d = '{{day}}'",
+		},
+		{
+			name: "array subscripts",
+			body: gin.H{"sql": "select array[1,2][1], m['a'] from t"},
+			want: "SELECT\n  ARRAY[1, 2][1],\n  m['a']\nFROM t",
+		},
+		{
+			name: "typed literals and intervals",
+			body: gin.H{"sql": "select date '2020-01-01' + interval '1' day, interval -'1-2' year to month, t.day from t where ts > timestamp '2020-01-01 00:00:00'"},
+			want: "SELECT\n  DATE '2020-01-01' + INTERVAL '1' DAY,\n  INTERVAL -'1-2' YEAR TO MONTH,\n  t.day\nFROM t\nWHERE ts > TIMESTAMP '2020-01-01 00:00:00'",
+		},
+		{
+			name: "lowercase typed literals",
+			body: gin.H{"sql": "SELECT DATE '2020-01-01' FROM t WHERE INTERVAL '1' DAY > d", "keywordCase": "lower"},
+			want: "select\n  date '2020-01-01'\nfrom t\nwhere interval '1' day > d",
+		},
+		{
+			name: "statements",
+			body: gin.H{"sql": "select 1; select 2"},
+			want: "SELECT\n  1;\n\nSELECT\n  2",
+		},
+	}
+
+	ts := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatted := doJSON[SQLFormatResponse](t, ts, http.MethodPost, "/api/sql/format", tt.body, http.StatusOK)
+			if formatted.SQL != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", formatted.SQL, tt.want)
+			}
+			for i, line := range strings.Split(formatted.SQL, "\n") {
+				if strings.HasSuffix(line, " ") {
+					t.Errorf("line %d %q ends with a space", i+1, line)
+				}
+			}
+
+			// Formatting is stable
+			again := doJSON[SQLFormatResponse](t, ts, http.MethodPost, "/api/sql/format",
+				gin.H{"sql": formatted.SQL, "keywordCase": tt.body["keywordCase"], "indent": tt.body["indent"], "useTabs": tt.body["useTabs"]}, http.StatusOK)
+			if again.SQL != formatted.SQL {
+				t.Errorf("formatted again as\n%s", again.SQL)
+			}
+		})
+	}
+}
+
+func TestSQLSizeLimits(t *testing.T) {
+	ts := newTestServer(t)
+	long := "SELECT '" + strings.Repeat("x", maxSQLTextBytes) + "'"
+	nested := "SELECT " + strings.Repeat("(", maxSQLNesting+1) + "1" + strings.Repeat(")", maxSQLNesting+1)
+	deepest := "SELECT " + strings.Repeat("(", maxSQLNesting) + "1" + strings.Repeat(")", maxSQLNesting)
+
+	tests := []struct {
+		name   string
+		body   interface{}
+		status int
+	}{
+		{name: "too long", body: gin.H{"sql": long}, status: http.StatusBadRequest},
+		{name: "nested too deep", body: gin.H{"sql": nested}, status: http.StatusBadRequest},
+		{name: "nested as deep as allowed", body: gin.H{"sql": deepest}, status: http.StatusOK},
+		{name: "parentheses in strings", body: gin.H{"sql": "SELECT '" + strings.Repeat("(", maxSQLNesting+1) + "'"}, status: http.StatusOK},
+		{name: "invalid body", body: "{", status: http.StatusBadRequest},
+	}
+
+	for _, path := range []string{"/api/sql/format", "/api/sql/lint"} {
+		for _, tt := range tests {
+			t.Run(path+" "+tt.name, func(t *testing.T) {
+				doJSON[gin.H](t, ts, http.MethodPost, path, tt.body, tt.status)
+			})
+		}
+	}
+
+	invalid := []gin.H{{"sql": "SELECT 1", "keywordCase": "title"}, {"sql": "SELECT 1", "indent": maxSQLIndent + 1}}
+	for _, body := range invalid {
+		doJSON[gin.H](t, ts, http.MethodPost, "/api/sql/format", body, http.StatusBadRequest)
+	}
+}
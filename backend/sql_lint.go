@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultLargeTableBytes = 1 << 30
+
+// Most problems reported, the first ones in the SQL
+const maxLintProblems = 100
+
+// Rules of SQLProblem
+const (
+	lintPlaceholder     = "placeholder"
+	lintSelectStar      = "select-star"
+	lintPartitionFilter = "partition-filter"
+	lintCrossJoin       = "cross-join"
+	lintUnknownTable    = "unknown-table"
+	lintUnknownColumn   = "unknown-column"
+)
+
+// Helper function to read SQL_LINT_LARGE_TABLE_BYTES, the size from which
+// reading every column of a table is flagged
+func loadLargeTableBytes() (int64, error) {
+	value := os.Getenv("SQL_LINT_LARGE_TABLE_BYTES")
+	if value == "" {
+		return defaultLargeTableBytes, nil
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 1 {
+		return 0, fmt.Errorf("invalid SQL_LINT_LARGE_TABLE_BYTES: %s", value)
+	}
+	return size, nil
+}
+
+// Helper function to return the size of a table in bytes, from the statistics
+// Glue crawlers and Hive keep in its parameters, or -1 when it isn't known
+func tableSizeBytes(table CatalogTable) int64 {
+	for _, key := range []string{"sizeKey", "totalSize", "rawDataSize"} {
+		if size, err := strconv.ParseInt(table.Properties[key], 10, 64); err == nil && size > 0 {
+			return size
+		}
+	}
+	return -1
+}
+
+// Helper function to tell whether a table is large. Partitioned tables of
+// unknown size are assumed to be.
+func isLargeTable(table CatalogTable, largeTableBytes int64) bool {
+	if size := tableSizeBytes(table); size >= 0 {
+		return size >= largeTableBytes
+	}
+	return len(table.PartitionKeys) > 0
+}
+
+// Helper function to find the lines and columns of byte offsets in text, in
+// one pass over it
+func sqlPositions(sql string, offsets []int) map[int][2]int {
+	sorted := append([]int(nil), offsets...)
+	sort.Ints(sorted)
+
+	positions := make(map[int][2]int, len(sorted))
+	line, column, at := 1, 1, 0
+	for _, offset := range sorted {
+		for _, r := range sql[at:offset] {
+			if r == '\n' {
+				line, column = line+1, 1
+			} else {
+				column++
+			}
+		}
+		at = offset
+		positions[offset] = [2]int{line, column}
+	}
+	return positions
+}
+
+// lintProblem is a problem with the byte offsets it spans, which are turned
+// into lines and columns once the problems are sorted and capped
+type lintProblem struct {
+	SQLProblem
+	start, end int
+}
+
+// sqlLinter checks SQL for mistakes and for queries that scan more than they need
+type sqlLinter struct {
+	sql             string
+	parser          *sqlParser
+	index           *catalogIndex // nil when the catalog couldn't be read
+	database        string
+	largeTableBytes int64
+
+	tables      map[*sqlScope][]*CatalogTable // Catalog table of each FROM item, nil when unknown
+	owners      map[*sqlScope]map[string]int  // First FROM item with each column, by lowercase name
+	unknown     map[*sqlScope]bool            // Whether some FROM items aren't catalog tables
+	columnNames map[*CatalogTable]map[string]bool
+	problems    []lintProblem
+}
+
+// Helper function to check SQL. Tables and columns are checked against the
+// catalog when index isn't nil; unqualified tables are looked up in database.
+// Only the first maxLintProblems problems are returned, truncated tells
+// whether there were more.
+func lintSQL(sql string, index *catalogIndex, database string, largeTableBytes int64) (problems []SQLProblem, truncated bool) {
+	l := &sqlLinter{
+		sql:             sql,
+		parser:          parseSQL(sql),
+		index:           index,
+		database:        database,
+		largeTableBytes: largeTableBytes,
+		tables:          map[*sqlScope][]*CatalogTable{},
+		owners:          map[*sqlScope]map[string]int{},
+		unknown:         map[*sqlScope]bool{},
+		columnNames:     map[*CatalogTable]map[string]bool{},
+	}
+
+	l.placeholders()
+	for _, block := range l.parser.blocks {
+		tables := make([]*CatalogTable, len(block.items))
+		for k, item := range block.items {
+			tables[k] = l.table(item)
+		}
+		l.tables[block] = tables
+		l.owners[block], l.unknown[block] = columnOwners(tables)
+	}
+	for _, block := range l.parser.blocks {
+		l.joins(block)
+		l.stars(block)
+		l.columns(block)
+	}
+
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		return l.problems[i].start < l.problems[j].start
+	})
+	if len(l.problems) > maxLintProblems {
+		l.problems, truncated = l.problems[:maxLintProblems], true
+	}
+
+	var offsets []int
+	for _, problem := range l.problems {
+		offsets = append(offsets, problem.start, problem.end)
+	}
+	positions := sqlPositions(sql, offsets)
+	problems = make([]SQLProblem, len(l.problems))
+	for i, problem := range l.problems {
+		problem.Line, problem.Column = positions[problem.start][0], positions[problem.start][1]
+		problem.EndLine, problem.EndColumn = positions[problem.end][0], positions[problem.end][1]
+		problems[i] = problem.SQLProblem
+	}
+	return problems, truncated
+}
+
+// Helper function to map the columns of the tables of a block to the first
+// FROM item that has them, and tell whether some items aren't catalog tables
+func columnOwners(tables []*CatalogTable) (map[string]int, bool) {
+	owners := map[string]int{}
+	seen := map[*CatalogTable]bool{}
+	unknown := false
+	for k, table := range tables {
+		if table == nil {
+			unknown = true
+			continue
+		}
+		if seen[table] {
+			continue
+		}
+		seen[table] = true
+		for _, column := range tableColumns(*table) {
+			if _, ok := owners[strings.ToLower(column.Name)]; !ok {
+				owners[strings.ToLower(column.Name)] = k
+			}
+		}
+	}
+	return owners, unknown
+}
+
+// Helper function to find a column of a table, partition keys included
+func (l *sqlLinter) hasColumn(table *CatalogTable, name string) bool {
+	names, ok := l.columnNames[table]
+	if !ok {
+		names = map[string]bool{}
+		for _, column := range tableColumns(*table) {
+			names[strings.ToLower(column.Name)] = true
+		}
+		l.columnNames[table] = names
+	}
+	return names[strings.ToLower(name)]
+}
+
+// Helper function to record a problem between two byte offsets
+func (l *sqlLinter) report(rule, severity string, start, end int, message string) {
+	problem := SQLProblem{Rule: rule, Severity: severity, Message: message}
+	l.problems = append(l.problems, lintProblem{SQLProblem: problem, start: start, end: end})
+}
+
+// Helper function to record a problem from the token at first to the token at last
+func (l *sqlLinter) reportTokens(rule, severity string, first, last int, message string) {
+	tokens := l.parser.tokens
+	if last < first {
+		last = first
+	}
+	l.report(rule, severity, tokens[first].Offset, tokens[last].Offset+len(tokens[last].Text), message)
+}
+
+// Helper function to check that {{param}} placeholders are closed and named,
+// the way the editor finds them
+func (l *sqlLinter) placeholders() {
+	sql := l.sql
+	for i := 0; i+1 < len(sql); {
+		switch {
+		case strings.HasPrefix(sql[i:], "{{"):
+			end := endOfPlaceholder(sql, i)
+			if end < 0 {
+				l.report(lintPlaceholder, "error", i, i+2, "{{ is not closed by }}")
+				i += 2
+				continue
+			}
+			if strings.TrimSpace(sql[i+2:end-2]) == "" {
+				l.report(lintPlaceholder, "error", i, end, "Placeholder has no parameter name")
+			}
+			i = end
+		case strings.HasPrefix(sql[i:], "}}"):
+			l.report(lintPlaceholder, "error", i, i+2, "}} has no matching {{")
+			i += 2
+		default:
+			i++
+		}
+	}
+}
+
+// Helper function to tell whether some databases couldn't be listed
+// completely, so missing tables may exist
+func (l *sqlLinter) partialCatalog() bool {
+	for _, db := range l.index.catalog.Databases {
+		if db.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to find the catalog table a FROM item reads, reporting
+// tables that don't exist. It returns nil for subqueries, CTEs, tables of
+// other catalogs and tables that can't be checked.
+func (l *sqlLinter) table(item sqlFromItem) *CatalogTable {
+	if l.index == nil || item.name == nil {
+		return nil
+	}
+	name := item.name
+	if len(name) == 3 && !strings.EqualFold(name[0], l.index.catalog.Catalog) {
+		return nil
+	}
+	full := strings.Join(name, ".")
+
+	database := l.database
+	if len(name) > 1 {
+		database = name[len(name)-2]
+	}
+	if database == "" {
+		_, table := l.index.resolve(name, "")
+		if table == nil && !l.partialCatalog() {
+			l.reportTokens(lintUnknownTable, "error", item.start, item.end-1, fmt.Sprintf("Table %s does not exist", full))
+		}
+		return table
+	}
+
+	db, ok := l.index.databases[strings.ToLower(database)]
+	if !ok {
+		if len(name) > 1 && !l.partialCatalog() {
+			l.reportTokens(lintUnknownTable, "error", item.start, item.end-1, fmt.Sprintf("Database %s does not exist", database))
+		}
+		return nil
+	}
+	_, table := l.index.table(db.Name, name[len(name)-1])
+	if table == nil && db.Error == "" {
+		l.reportTokens(lintUnknownTable, "error", item.start, item.end-1,
+			fmt.Sprintf("Table %s does not exist in database %s", name[len(name)-1], db.Name))
+	}
+	return table
+}
+
+// Helper function to describe a FROM item in messages
+func describeFromItem(item sqlFromItem) string {
+	switch {
+	case item.name != nil:
+		return strings.Join(item.name, ".")
+	case item.alias != "":
+		return item.alias
+	}
+	return "the subquery"
+}
+
+// Helper function to find the FROM item of a block a column reference is to,
+// and which part of the reference is the column. item is -1 when that can't
+// be told from the block alone.
+func (l *sqlLinter) attribute(scope *sqlScope, ref []string) (item int, part int) {
+	for k := len(ref) - 1; k >= 1; k-- {
+		if block, i := scope.find(ref[:k]); block != nil {
+			if block != scope {
+				return -1, k
+			}
+			return i, k
+		}
+	}
+
+	// Unqualified columns are to the table that has them
+	if i, ok := l.owners[scope][ref[0]]; ok {
+		return i, 0
+	}
+	if len(scope.items) == 1 {
+		return 0, 0
+	}
+	return -1, 0
+}
+
+// Helper function to tell whether a column may be one of an outer block, that
+// isn't a table of the catalog or has a column of that name
+func (l *sqlLinter) outerColumn(scope *sqlScope, column string) bool {
+	for block := scope.parent; block != nil; block = block.parent {
+		if _, ok := l.owners[block][column]; ok || l.unknown[block] {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to check the columns of a block exist, and that the
+// partitioned tables it reads are filtered on their partition keys
+func (l *sqlLinter) columns(scope *sqlScope) {
+	tables := l.tables[scope]
+	filtered := make([]bool, len(scope.items))
+
+	for j, ref := range scope.refs {
+		if len(ref) == 1 && scope.aliases[ref[0]] || strings.HasPrefix(ref[0], "$") {
+			continue // Output aliases and hidden columns such as "$path"
+		}
+		item, part := l.attribute(scope, ref)
+		if item < 0 || tables[item] == nil {
+			continue
+		}
+		table, column := *tables[item], ref[part]
+
+		if l.hasColumn(tables[item], column) {
+			for _, key := range table.PartitionKeys {
+				if scope.filters[j] && strings.EqualFold(key.Name, column) {
+					filtered[item] = true
+				}
+			}
+			continue
+		}
+
+		// Unqualified names may be columns of a subquery or of an outer block
+		if part == 0 {
+			if l.outerColumn(scope, column) || l.unknown[scope] {
+				continue
+			}
+		}
+		// The parts of a reference are separated by dots
+		start := scope.refTokens[j]
+		l.reportTokens(lintUnknownColumn, "error", start, start+2*part,
+			fmt.Sprintf("Column %s does not exist in %s", column, describeFromItem(scope.items[item])))
+	}
+
+	for k, item := range scope.items {
+		if tables[k] == nil || len(tables[k].PartitionKeys) == 0 || filtered[k] {
+			continue
+		}
+		var keys []string
+		for _, key := range tables[k].PartitionKeys {
+			keys = append(keys, key.Name)
+		}
+		l.reportTokens(lintPartitionFilter, "warning", item.start, item.end-1,
+			fmt.Sprintf("%s is read without a condition on its partition keys (%s), so every partition is scanned",
+				describeFromItem(item), strings.Join(keys, ", ")))
+	}
+}
+
+// Helper function to flag SELECT * and t.* on large tables
+func (l *sqlLinter) stars(scope *sqlScope) {
+	if len(scope.stars) == 0 {
+		return
+	}
+	tables := l.tables[scope]
+	large := make([]bool, len(scope.items))
+	var names []string
+	for k, item := range scope.items {
+		if tables[k] != nil && isLargeTable(*tables[k], l.largeTableBytes) {
+			large[k] = true
+			names = append(names, describeFromItem(item))
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	message := func(names string) string {
+		return fmt.Sprintf("Selecting every column of large table %s scans all of its data; list the columns needed", names)
+	}
+	every := message(strings.Join(names, ", "))
+	for _, star := range scope.stars {
+		if star.qualifier == nil {
+			l.reportTokens(lintSelectStar, "warning", star.start, star.end-1, every)
+			continue
+		}
+		if block, i := scope.find(star.qualifier); block == scope && large[i] {
+			l.reportTokens(lintSelectStar, "warning", star.start, star.end-1, message(describeFromItem(scope.items[i])))
+		}
+	}
+}
+
+// Helper function to flag joins that combine every row of both sides
+func (l *sqlLinter) joins(scope *sqlScope) {
+	// Comma joins are fine when a WHERE condition relates the table to those before it
+	conditioned := make([]bool, len(scope.items))
+	first := len(scope.items)
+	for j, ref := range scope.refs {
+		if !scope.filters[j] {
+			continue
+		}
+		if i, _ := l.attribute(scope, ref); i >= 0 {
+			conditioned[i] = true
+			first = min(first, i)
+		}
+	}
+
+	for k, item := range scope.items {
+		if item.join == "" || l.parser.is(item.start, "UNNEST") || l.parser.is(item.start, "TABLE") ||
+			l.parser.is(item.start, "LATERAL") {
+			continue
+		}
+
+		if item.join == "cross" {
+			l.reportTokens(lintCrossJoin, "warning", item.start-2, max(item.end-1, item.start),
+				fmt.Sprintf("CROSS JOIN combines every row of %s with every row before it", describeFromItem(item)))
+			continue
+		}
+		if !conditioned[k] || first >= k {
+			l.reportTokens(lintCrossJoin, "warning", item.start, max(item.end-1, item.start),
+				fmt.Sprintf("%s is joined without a condition, so every row is combined with every row before it",
+					describeFromItem(item)))
+		}
+	}
+}
+
+func (s *Server) lintSQL(c *gin.Context) {
+	var req SQLLintRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkSQLSize(c, req.SQL) {
+		return
+	}
+
+	// Problems that don't need the catalog are reported when it can't be read
+	response := SQLLintResponse{}
+	var index *catalogIndex
+	cache, err := s.catalogCache(req.Catalog, false)
+	if err == errCatalogNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err == nil {
+		var catalog *AthenaCatalog
+		if catalog, err = cache.fetch(false); err == nil {
+			catalogIndex := newCatalogIndex(catalog)
+			index = &catalogIndex
+		}
+	}
+	if err != nil {
+		response.CatalogError = err.Error()
+	}
+
+	response.Problems, response.Truncated = lintSQL(req.SQL, index, strings.TrimSpace(req.Database), s.largeTableBytes)
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/gin-gonic/gin"
+)
+
+// Helper function to list the rules of the problems lint found, sorted
+func problemRules(problems []SQLProblem) []string {
+	rules := []string{}
+	for _, problem := range problems {
+		rules = append(rules, problem.Rule)
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+func TestLintSQL(t *testing.T) {
+	ts := newTestServer(t)
+	events := testTable("events", "id:bigint", "name:varchar")
+	events.PartitionKeys = []*athena.Column{{Name: aws.String("dt"), Type: aws.String("string")}}
+	ts.athena.AddTable("logs", events)
+	small := testTable("users", "id:bigint", "email:varchar")
+	small.Parameters = aws.StringMap(map[string]string{"totalSize": "1024"})
+	ts.athena.AddTable("logs", small)
+
+	tests := []struct {
+		name  string
+		body  gin.H
+		rules []string
+	}{
+		{name: "clean", body: gin.H{"sql": "SELECT id, name FROM logs.events WHERE dt = '2024-01-01'"}, rules: []string{}},
+		{name: "default database", body: gin.H{"sql": "SELECT email FROM users", "database": "logs"}, rules: []string{}},
+		{name: "small table star", body: gin.H{"sql": "SELECT * FROM logs.users"}, rules: []string{}},
+		{name: "partitioned table star", body: gin.H{"sql": "SELECT * FROM logs.events WHERE dt = '2024-01-01'"}, rules: []string{lintSelectStar}},
+		{name: "no partition filter", body: gin.H{"sql": "SELECT id FROM logs.events"}, rules: []string{lintPartitionFilter}},
+		{name: "unknown table", body: gin.H{"sql": "SELECT id FROM logs.clicks"}, rules: []string{lintUnknownTable}},
+		{name: "unknown column", body: gin.H{"sql": "SELECT u.phone FROM logs.users u"}, rules: []string{lintUnknownColumn}},
+		{name: "cross join", body: gin.H{"sql": "SELECT u.id FROM logs.users u CROSS JOIN logs.users v"}, rules: []string{lintCrossJoin}},
+		{name: "unclosed placeholder", body: gin.H{"sql": "SELECT id FROM logs.users WHERE id = {{id"}, rules: []string{lintPlaceholder}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := doJSON[SQLLintResponse](t, ts, http.MethodPost, "/api/sql/lint", tt.body, http.StatusOK)
+			if rules := problemRules(response.Problems); !reflect.DeepEqual(rules, tt.rules) {
+				t.Errorf("problems %+v, want %v", response.Problems, tt.rules)
+			}
+			if response.CatalogError != "" {
+				t.Errorf("catalog error %s", response.CatalogError)
+			}
+		})
+	}
+
+	// Problems point at where they are
+	response := doJSON[SQLLintResponse](t, ts, http.MethodPost, "/api/sql/lint", gin.H{"sql": "SELECT id\nFROM logs.clicks"}, http.StatusOK)
+	if len(response.Problems) != 1 {
+		t.Fatalf("problems %+v", response.Problems)
+	}
+	if problem := response.Problems[0]; problem.Severity != "error" || problem.Line != 2 || problem.Column != 6 ||
+		problem.EndLine != 2 || problem.EndColumn != 17 {
+		t.Errorf("problem %+v, want logs.clicks on line 2", problem)
+	}
+
+	doJSON[gin.H](t, ts, http.MethodPost, "/api/sql/lint", gin.H{"sql": "SELECT 1", "catalog": "nope"}, http.StatusNotFound)
+}
+
+func TestLintSQLWithoutCatalog(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.FailNext("ListDatabases", awserr.New(athena.ErrCodeInternalServerException, "Internal error", nil))
+
+	response := doJSON[SQLLintResponse](t, ts, http.MethodPost, "/api/sql/lint",
+		gin.H{"sql": "SELECT * FROM logs.clicks WHERE id = {{id"}, http.StatusOK)
+	if rules := problemRules(response.Problems); !reflect.DeepEqual(rules, []string{lintPlaceholder}) || response.CatalogError == "" {
+		t.Errorf("problems %+v with catalog error %q, want only the placeholder and the error", response.Problems, response.CatalogError)
+	}
+}
+
+func TestLintSQLLimits(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.AddTable("logs", testTable("users", "id:bigint", "email:varchar"))
+	events := testTable("events", "id:bigint")
+	events.PartitionKeys = []*athena.Column{{Name: aws.String("dt"), Type: aws.String("string")}}
+	ts.athena.AddTable("logs", events)
+
+	// Lint of the largest SQL accepted stays quick, however many problems it has
+	fill := func(prefix, repeat, suffix string) string {
+		return prefix + strings.Repeat(repeat, (maxSQLTextBytes-len(prefix)-len(suffix))/len(repeat)) + suffix
+	}
+	tests := []struct {
+		name string
+		sql  string
+	}{
+		{name: "unclosed placeholders", sql: fill("", "{{", "")},
+		{name: "unopened placeholders", sql: fill("", "}}", "")},
+		{name: "placeholders on many lines", sql: fill("", "{{\n", "")},
+		{name: "joins", sql: fill("SELECT * FROM logs.users u", " JOIN logs.users t ON t.id = u.id", "")},
+		{name: "comma joins", sql: fill("SELECT * FROM logs.users", ", logs.users", " WHERE id = 1")},
+		{name: "unknown columns", sql: fill("SELECT 1 FROM logs.users u, logs.users v WHERE ", "u.a = v.b AND ", "true")},
+		{name: "stars", sql: "SELECT " + strings.Repeat("*, ", maxSQLTextBytes/6) + "1 FROM logs.events" +
+			strings.Repeat(", logs.events", (maxSQLTextBytes/2-64)/13)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql := tt.sql
+			start := time.Now()
+			response := doJSON[SQLLintResponse](t, ts, http.MethodPost, "/api/sql/lint", gin.H{"sql": sql}, http.StatusOK)
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("lint of %d bytes took %v", len(sql), elapsed)
+			}
+			if len(response.Problems) > maxLintProblems {
+				t.Errorf("%d problems, want at most %d", len(response.Problems), maxLintProblems)
+			}
+		})
+	}
+
+	// The first problems are kept, and where they are is still right
+	response := doJSON[SQLLintResponse](t, ts, http.MethodPost, "/api/sql/lint", gin.H{"sql": strings.Repeat("é {{\n", 1000)}, http.StatusOK)
+	if len(response.Problems) != maxLintProblems || !response.Truncated {
+		t.Fatalf("%d problems, truncated %v", len(response.Problems), response.Truncated)
+	}
+	if last := response.Problems[maxLintProblems-1]; last.Line != maxLintProblems || last.Column != 3 || last.EndLine != maxLintProblems || last.EndColumn != 5 {
+		t.Errorf("last problem %+v", last)
+	}
+}
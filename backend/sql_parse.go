@@ -0,0 +1,1015 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kinds of SQL tokens
+type sqlTokenKind int
+
+const (
+	sqlWord        sqlTokenKind = iota // Keyword or unquoted identifier
+	sqlQuoted                          // "identifier" or `identifier`
+	sqlString                          // 'literal'
+	sqlNumber                          // 42, 1.5 or 1e3
+	sqlPlaceholder                     // {{param}}
+	sqlComment                         // -- line or /* block */
+	sqlSymbol                          // Operators and punctuation
+)
+
+// sqlToken is a token of SQL text and where it starts
+type sqlToken struct {
+	Kind   sqlTokenKind
+	Text   string
+	Offset int // In bytes
+	Line   int // From 1
+	Column int // From 1, in characters
+}
+
+// Words that can't be used as unquoted identifiers in Presto and Trino
+var sqlReservedWords = map[string]bool{
+	"ALTER": true, "AND": true, "AS": true, "BETWEEN": true, "BY": true, "CASE": true, "CAST": true,
+	"CONSTRAINT": true, "CREATE": true, "CROSS": true, "CUBE": true, "CURRENT_CATALOG": true,
+	"CURRENT_DATE": true, "CURRENT_PATH": true, "CURRENT_ROLE": true, "CURRENT_SCHEMA": true,
+	"CURRENT_TIME": true, "CURRENT_TIMESTAMP": true, "CURRENT_USER": true, "DEALLOCATE": true,
+	"DELETE": true, "DESCRIBE": true, "DISTINCT": true, "DROP": true, "ELSE": true, "END": true,
+	"ESCAPE": true, "EXCEPT": true, "EXECUTE": true, "EXISTS": true, "EXTRACT": true, "FALSE": true,
+	"FOR": true, "FROM": true, "FULL": true, "GROUP": true, "GROUPING": true, "HAVING": true, "IN": true,
+	"INNER": true, "INSERT": true, "INTERSECT": true, "INTO": true, "IS": true, "JOIN": true, "LEFT": true,
+	"LIKE": true, "LOCALTIME": true, "LOCALTIMESTAMP": true, "NATURAL": true, "NORMALIZE": true, "NOT": true,
+	"NULL": true, "ON": true, "OR": true, "ORDER": true, "OUTER": true, "PREPARE": true, "RECURSIVE": true,
+	"RIGHT": true, "ROLLUP": true, "SELECT": true, "SKIP": true, "TABLE": true, "THEN": true, "TRIM": true,
+	"TRUE": true, "UESCAPE": true, "UNION": true, "UNNEST": true, "USING": true, "VALUES": true,
+	"WHEN": true, "WHERE": true, "WITH": true,
+}
+
+// Words that aren't reserved but are part of the syntax wherever they appear
+// in statements, so they aren't taken for column names
+var sqlSyntaxWords = map[string]bool{
+	"ALL": true, "ANY": true, "ARRAY": true, "ASC": true, "AT": true, "BERNOULLI": true, "BOTH": true,
+	"CURRENT": true, "DESC": true, "FETCH": true, "FILTER": true, "FIRST": true, "FOLLOWING": true,
+	"IF": true, "IGNORE": true, "INTERVAL": true, "LAST": true, "LATERAL": true, "LEADING": true,
+	"LIMIT": true, "MATCHED": true, "MERGE": true, "NEXT": true, "NULLS": true, "OF": true, "OFFSET": true,
+	"ONLY": true, "ORDINALITY": true, "OVER": true, "PARTITION": true, "PRECEDING": true, "RANGE": true,
+	"REPLACE": true, "RESPECT": true, "ROW": true, "ROWS": true, "SET": true, "SETS": true, "SOME": true,
+	"SYSTEM": true, "TABLESAMPLE": true, "TIES": true, "TO": true, "TRAILING": true, "TRY_CAST": true,
+	"UNBOUNDED": true, "UPDATE": true, "VIEW": true, "WINDOW": true,
+}
+
+// Units of INTERVAL literals
+var sqlIntervalUnits = map[string]bool{"YEAR": true, "MONTH": true, "DAY": true, "HOUR": true, "MINUTE": true, "SECOND": true}
+
+// Operators of more than one character
+var sqlOperators = []string{"<=", ">=", "<>", "!=", "||", "->", "=>"}
+
+// Statements that name one table after a fixed prefix, such as DROP TABLE
+var sqlTableStatements = [][]string{
+	{"ALTER", "TABLE"}, {"DROP", "TABLE"}, {"DROP", "VIEW"}, {"MSCK", "REPAIR", "TABLE"}, {"DESCRIBE"},
+	{"SHOW", "COLUMNS", "FROM"}, {"SHOW", "COLUMNS", "IN"}, {"SHOW", "PARTITIONS"},
+	{"SHOW", "CREATE", "TABLE"}, {"SHOW", "CREATE", "VIEW"}, {"SHOW", "TBLPROPERTIES"},
+}
+
+func isSQLKeyword(word string) bool {
+	word = strings.ToUpper(word)
+	return sqlReservedWords[word] || sqlSyntaxWords[word]
+}
+
+// Helper function to find where a quoted string or identifier that starts at
+// start ends; doubled quotes escape the quote. Unterminated ones run to the end.
+func endOfQuoted(sql string, start int, quote byte) int {
+	for i := start + 1; i < len(sql); i++ {
+		if sql[i] != quote {
+			continue
+		}
+		if i+1 < len(sql) && sql[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(sql)
+}
+
+// Helper function to find where a number that starts at start ends
+func endOfNumber(sql string, start int) int {
+	i := start
+	for i < len(sql) && (sql[i] >= '0' && sql[i] <= '9' || sql[i] == '.') {
+		i++
+	}
+	if i < len(sql) && (sql[i] == 'e' || sql[i] == 'E') {
+		j := i + 1
+		if j < len(sql) && (sql[j] == '+' || sql[j] == '-') {
+			j++
+		}
+		if j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+			i = j
+			for i < len(sql) && sql[i] >= '0' && sql[i] <= '9' {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+// Helper function to find where a {{param}} placeholder that starts at start
+// ends, or -1 when it isn't closed. Names can't hold braces or newlines, so
+// only the text up to the next one is looked at.
+func endOfPlaceholder(sql string, start int) int {
+	end := strings.IndexAny(sql[start+2:], "{}\n")
+	if end < 0 || !strings.HasPrefix(sql[start+2+end:], "}}") {
+		return -1
+	}
+	return start + end + 4
+}
+
+func isSQLWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Helper function to split SQL into tokens, keeping comments and skipping whitespace
+func tokenizeSQL(sql string) []sqlToken {
+	var tokens []sqlToken
+	line, column := 1, 1
+	for i := 0; i < len(sql); {
+		start := i
+		r, size := utf8.DecodeRuneInString(sql[i:])
+		kind := sqlSymbol
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case strings.HasPrefix(sql[i:], "--"):
+			kind = sqlComment
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			kind = sqlComment
+			if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "{{"):
+			if end := endOfPlaceholder(sql, i); end >= 0 {
+				kind = sqlPlaceholder
+				i = end
+			} else {
+				i++
+			}
+		case r == '\'':
+			kind = sqlString
+			i = endOfQuoted(sql, i, '\'')
+		case r == '"' || r == '`':
+			kind = sqlQuoted
+			i = endOfQuoted(sql, i, byte(r))
+		case r >= '0' && r <= '9':
+			kind = sqlNumber
+			i = endOfNumber(sql, i)
+		case isSQLWordRune(r):
+			kind = sqlWord
+			for i < len(sql) {
+				r, size := utf8.DecodeRuneInString(sql[i:])
+				if !isSQLWordRune(r) {
+					break
+				}
+				i += size
+			}
+		default:
+			i += size
+			for _, operator := range sqlOperators {
+				if strings.HasPrefix(sql[start:], operator) {
+					i = start + len(operator)
+					break
+				}
+			}
+		}
+
+		if !unicode.IsSpace(r) {
+			tokens = append(tokens, sqlToken{Kind: kind, Text: sql[start:i], Offset: start, Line: line, Column: column})
+		}
+		for _, r := range sql[start:i] {
+			if r == '\n' {
+				line, column = line+1, 1
+			} else {
+				column++
+			}
+		}
+	}
+	return tokens
+}
+
+// Helper function to return the lowercase name of an identifier token
+func (t sqlToken) name() string {
+	if t.Kind == sqlQuoted {
+		quote := t.Text[:1]
+		name := strings.TrimPrefix(t.Text, quote)
+		if len(t.Text) > 1 {
+			name = strings.TrimSuffix(name, quote)
+		}
+		return strings.ToLower(strings.ReplaceAll(name, quote+quote, quote))
+	}
+	return strings.ToLower(t.Text)
+}
+
+// Helper function to tell whether a string literal or comment token takes in
+// whatever follows it, as one that isn't closed does. Line comments are
+// closed by the newline after them, which only the caller can see.
+func (t sqlToken) unterminated() bool {
+	switch t.Kind {
+	case sqlString:
+		// Quotes inside a literal are doubled, so a closed one has an even number
+		return strings.Count(t.Text, "'")%2 == 1
+	case sqlComment:
+		return strings.HasPrefix(t.Text, "--") || len(t.Text) < 4 || !strings.HasSuffix(t.Text, "*/")
+	}
+	return false
+}
+
+func (t sqlToken) isKeyword() bool {
+	return t.Kind == sqlWord && isSQLKeyword(t.Text)
+}
+
+// sqlReferences is what SQL reads and writes. Names are lowercase and
+// qualified as they are written.
+type sqlReferences struct {
+	Tables    []string // Tables read and written, in order of appearance
+	Databases []string // Databases tables are qualified with
+	Columns   []string // table.column, with the table as in Tables
+	Lineage   *QueryLineage
+}
+
+// sqlFromItem is something a query block reads from
+type sqlFromItem struct {
+	name  []string // Parts of the table name; nil for subqueries, CTEs and table functions
+	alias string
+	start int    // Token the item starts at
+	end   int    // Token after the table name
+	join  string // "cross" after CROSS JOIN, "comma" after a comma in the FROM clause
+	body  int    // Parenthesis of the subquery or CTE query read, 0 for tables and functions
+}
+
+// sqlStar is a * or t.* of a select list
+type sqlStar struct {
+	qualifier []string // nil for *
+	start     int
+	end       int
+}
+
+// sqlScope is a query block: what it reads from, the names its columns are
+// referred to by, and the names that aren't columns
+type sqlScope struct {
+	parent  *sqlScope
+	items   []sqlFromItem
+	refs    [][]string
+	aliases map[string]bool // Output aliases, lambda parameters and window names
+	ctes    map[string]int  // Parenthesis of each CTE query, 0 until it is read
+	names   map[string]int  // Item each alias and qualified name refers to, for find
+	indexed int             // Items in names
+
+	// Where refs are, for linting
+	refTokens []int  // Token each of refs starts at
+	filters   []bool // Whether each of refs is in a WHERE or ON condition
+	filtering bool   // Whether a WHERE or ON condition is being walked
+	stars     []sqlStar
+}
+
+func (scope *sqlScope) alias(name string) {
+	if scope.aliases == nil {
+		scope.aliases = map[string]bool{}
+	}
+	scope.aliases[name] = true
+}
+
+// Helper function to find the CTE a name refers to in this block or the blocks
+// it is nested in, returning the parenthesis its query starts at
+func (scope *sqlScope) cte(name string) (int, bool) {
+	for ; scope != nil; scope = scope.parent {
+		if body, ok := scope.ctes[name]; ok {
+			return body, true
+		}
+	}
+	return 0, false
+}
+
+// Helper function to find what a qualifier such as o or sales.orders refers
+// to, in this block or the blocks it is nested in
+func (scope *sqlScope) lookup(qualifier []string) (sqlFromItem, bool) {
+	if block, i := scope.find(qualifier); block != nil {
+		return block.items[i], true
+	}
+	return sqlFromItem{}, false
+}
+
+// Helper function to find the block and index of the item a qualifier refers
+// to. The block is nil when there is none. An alias or name used twice refers
+// to the last item.
+func (scope *sqlScope) find(qualifier []string) (*sqlScope, int) {
+	key := strings.Join(qualifier, "\x00")
+	for ; scope != nil; scope = scope.parent {
+		scope.index()
+		if i, ok := scope.names[key]; ok {
+			return scope, i
+		}
+	}
+	return nil, -1
+}
+
+// Helper function to add the items read since the last lookup to names: the
+// alias of each, and the qualified endings of table names such as sales.orders
+func (scope *sqlScope) index() {
+	if scope.names == nil {
+		scope.names = map[string]int{}
+	}
+	for ; scope.indexed < len(scope.items); scope.indexed++ {
+		item := scope.items[scope.indexed]
+		scope.names[item.alias] = scope.indexed
+		for k := 2; k <= len(item.name); k++ {
+			scope.names[strings.Join(item.name[len(item.name)-k:], "\x00")] = scope.indexed
+		}
+	}
+}
+
+// sqlParser reads the references of statements. It follows the structure of
+// queries (blocks, subqueries, CTEs, joins and aliases) without validating
+// them, so incomplete SQL still yields what can be made out of it.
+type sqlParser struct {
+	tokens []sqlToken // Without comments
+	match  []int      // Index of the matching parenthesis, -1 when unbalanced
+
+	refs    sqlReferences
+	seen    map[string]bool
+	reads   []string
+	lineage *QueryLineage
+	blocks  []*sqlScope // Every query block once resolved, for linting
+}
+
+// Helper function to extract the tables, databases and columns SQL references,
+// and what a CREATE TABLE AS, CREATE VIEW, INSERT or MERGE writes
+func parseSQLReferences(sql string) sqlReferences {
+	p := parseSQL(sql)
+
+	refs := p.refs
+	if refs.Tables == nil {
+		refs.Tables = []string{}
+	}
+	if refs.Databases == nil {
+		refs.Databases = []string{}
+	}
+	if refs.Columns == nil {
+		refs.Columns = []string{}
+	}
+	if p.lineage != nil {
+		p.lineage.Sources = []string{}
+		for _, table := range p.reads {
+			p.lineage.Sources = append(p.lineage.Sources, table)
+		}
+		refs.Lineage = p.lineage
+	}
+	return refs
+}
+
+// Helper function to parse every statement of SQL
+func parseSQL(sql string) *sqlParser {
+	p := &sqlParser{seen: map[string]bool{}}
+	for _, token := range tokenizeSQL(sql) {
+		if token.Kind != sqlComment {
+			p.tokens = append(p.tokens, token)
+		}
+	}
+
+	p.match = make([]int, len(p.tokens))
+	var open []int
+	for i, token := range p.tokens {
+		p.match[i] = -1
+		switch {
+		case token.Kind == sqlSymbol && token.Text == "(":
+			open = append(open, i)
+		case token.Kind == sqlSymbol && token.Text == ")" && len(open) > 0:
+			p.match[open[len(open)-1]] = i
+			p.match[i] = open[len(open)-1]
+			open = open[:len(open)-1]
+		}
+	}
+
+	start := 0
+	for i := 0; ; i = min(p.next(i), len(p.tokens)) {
+		if i == len(p.tokens) || p.isSymbol(i, ";") {
+			p.statement(start, i)
+			start = i + 1
+		}
+		if i == len(p.tokens) {
+			break
+		}
+	}
+	return p
+}
+
+func (p *sqlParser) is(i int, keyword string) bool {
+	return i >= 0 && i < len(p.tokens) && p.tokens[i].Kind == sqlWord && strings.EqualFold(p.tokens[i].Text, keyword)
+}
+
+func (p *sqlParser) isSymbol(i int, symbol string) bool {
+	return i >= 0 && i < len(p.tokens) && p.tokens[i].Kind == sqlSymbol && p.tokens[i].Text == symbol
+}
+
+// Helper function to return the index of the parenthesis closing the one at i,
+// or end when it isn't closed before end
+func (p *sqlParser) close(i, end int) int {
+	if m := p.match[i]; m >= 0 && m < end {
+		return m
+	}
+	return end
+}
+
+// Helper function to step over a token, or a parenthesized group
+func (p *sqlParser) next(i int) int {
+	if i < len(p.tokens) && p.match[i] > i {
+		return p.match[i] + 1
+	}
+	return i + 1
+}
+
+// Helper function to tell whether a query starts at i
+func (p *sqlParser) isQueryStart(i int) bool {
+	if p.isSymbol(i, "(") {
+		return p.isQueryStart(i + 1)
+	}
+	return p.is(i, "SELECT") || p.is(i, "WITH") || p.is(i, "VALUES")
+}
+
+func (p *sqlParser) isIdentifier(i, end int) bool {
+	if i >= end {
+		return false
+	}
+	token := p.tokens[i]
+	return token.Kind == sqlQuoted || token.Kind == sqlWord && !isSQLKeyword(token.Text)
+}
+
+// Helper function to read a possibly qualified name at i. ok is false when
+// part of it is a placeholder.
+func (p *sqlParser) qualifiedName(i, end int) (parts []string, next int, ok bool) {
+	ok = true
+	for i < end {
+		token := p.tokens[i]
+		switch token.Kind {
+		case sqlWord, sqlQuoted:
+			parts = append(parts, token.name())
+		case sqlPlaceholder:
+			parts = append(parts, token.Text)
+			ok = false
+		default:
+			return parts, i, ok
+		}
+		i++
+		if !p.isSymbol(i, ".") || i+1 >= end || p.isSymbol(i+1, "*") {
+			return parts, i, ok
+		}
+		i++
+	}
+	return parts, i, ok
+}
+
+// Helper function to read an optional alias, with its column names, at i
+func (p *sqlParser) alias(i, end int) (string, int) {
+	if p.is(i, "AS") {
+		i++
+	} else if !p.isIdentifier(i, end) {
+		return "", i
+	}
+	if i >= end || p.tokens[i].Kind != sqlWord && p.tokens[i].Kind != sqlQuoted {
+		return "", i
+	}
+	alias := p.tokens[i].name()
+	i++
+	if p.isSymbol(i, "(") {
+		i = min(p.close(i, end)+1, end)
+	}
+	return alias, i
+}
+
+func (p *sqlParser) addTable(name []string, read bool) {
+	key := strings.Join(name, ".")
+	if !p.seen["table:"+key] {
+		p.seen["table:"+key] = true
+		p.refs.Tables = append(p.refs.Tables, key)
+	}
+	if len(name) > 1 && !p.seen["database:"+name[len(name)-2]] {
+		p.seen["database:"+name[len(name)-2]] = true
+		p.refs.Databases = append(p.refs.Databases, name[len(name)-2])
+	}
+	if read && !p.seen["read:"+key] {
+		p.seen["read:"+key] = true
+		p.reads = append(p.reads, key)
+	}
+}
+
+func (p *sqlParser) addColumn(table []string, column string) {
+	key := strings.Join(table, ".") + "." + column
+	if !p.seen["column:"+key] {
+		p.seen["column:"+key] = true
+		p.refs.Columns = append(p.refs.Columns, key)
+	}
+}
+
+func (p *sqlParser) write(lineageType string, target []string) {
+	p.addTable(target, false)
+	if p.lineage == nil {
+		p.lineage = &QueryLineage{Type: lineageType, Target: strings.Join(target, ".")}
+	}
+}
+
+// Helper function to read the references of one statement
+func (p *sqlParser) statement(start, end int) {
+	if start >= end {
+		return
+	}
+
+	switch {
+	case p.is(start, "EXPLAIN"):
+		i := start + 1
+		for p.is(i, "ANALYZE") || p.is(i, "VERBOSE") {
+			i++
+		}
+		if p.isSymbol(i, "(") {
+			i = min(p.close(i, end)+1, end)
+		}
+		p.statement(i, end)
+
+	case p.is(start, "INSERT") && p.is(start+1, "INTO"):
+		i := start + 2
+		if p.is(i, "TABLE") {
+			i++
+		}
+		target, i, ok := p.qualifiedName(i, end)
+		if !ok || len(target) == 0 {
+			p.query(i, end, nil)
+			return
+		}
+		p.write(lineageInsert, target)
+		if p.isSymbol(i, "(") && !p.isQueryStart(i+1) {
+			close := p.close(i, end)
+			for j := i + 1; j < close; j++ {
+				if p.isIdentifier(j, close) {
+					p.addColumn(target, p.tokens[j].name())
+				}
+			}
+			i = min(close+1, end)
+		}
+		p.query(i, end, nil)
+
+	case p.is(start, "CREATE"):
+		i := start + 1
+		if p.is(i, "OR") && p.is(i+1, "REPLACE") {
+			i += 2
+		}
+		if p.is(i, "EXTERNAL") {
+			i++
+		}
+		lineageType := lineageCTAS
+		if p.is(i, "VIEW") {
+			lineageType = lineageView
+		} else if !p.is(i, "TABLE") {
+			return
+		}
+		i++
+		if p.is(i, "IF") && p.is(i+1, "NOT") && p.is(i+2, "EXISTS") {
+			i += 3
+		}
+		target, i, ok := p.qualifiedName(i, end)
+		if !ok || len(target) == 0 {
+			return
+		}
+		// The query follows AS, after column definitions, comments and properties
+		for ; i < end; i = p.next(i) {
+			if p.is(i, "AS") && p.isQueryStart(i+1) {
+				p.write(lineageType, target)
+				p.query(i+1, end, nil)
+				return
+			}
+		}
+		p.addTable(target, false)
+
+	case p.is(start, "MERGE") && p.is(start+1, "INTO"), p.is(start, "UPDATE"):
+		i := start + 1
+		if p.is(start, "MERGE") {
+			i++
+		}
+		target, i, ok := p.qualifiedName(i, end)
+		if !ok || len(target) == 0 {
+			return
+		}
+		if p.is(start, "MERGE") {
+			p.write(lineageMerge, target)
+		} else {
+			p.addTable(target, false)
+		}
+		item := sqlFromItem{name: target, alias: target[len(target)-1], start: start + 1, end: i}
+		if p.is(start, "MERGE") {
+			item.start++
+		}
+		if alias, next := p.alias(i, end); alias != "" {
+			item.alias, i = alias, next
+		}
+		scope := &sqlScope{items: []sqlFromItem{item}}
+		p.walk(i, end, scope, "")
+		p.resolve(scope)
+
+	case p.is(start, "UNLOAD") && p.isSymbol(start+1, "("):
+		p.query(start+2, p.close(start+1, end), nil)
+
+	case p.isQueryStart(start) || p.is(start, "DELETE"):
+		p.query(start, end, nil)
+
+	default:
+		for _, prefix := range sqlTableStatements {
+			i := start
+			for _, word := range prefix {
+				if !p.is(i, word) {
+					break
+				}
+				i++
+			}
+			if i-start != len(prefix) {
+				continue
+			}
+			for p.is(i, "IF") || p.is(i, "EXISTS") || p.is(i, "FORMATTED") || p.is(i, "EXTENDED") {
+				i++
+			}
+			if name, _, ok := p.qualifiedName(i, end); ok && len(name) > 0 {
+				p.addTable(name, false)
+			}
+			return
+		}
+	}
+}
+
+// Helper function to read a query: its CTEs and the blocks its set operations combine
+func (p *sqlParser) query(start, end int, parent *sqlScope) {
+	scope := &sqlScope{parent: parent, ctes: map[string]int{}}
+
+	i := start
+	if p.is(i, "WITH") {
+		i++
+		if p.is(i, "RECURSIVE") {
+			i++
+		}
+		for p.isIdentifier(i, end) {
+			name := p.tokens[i].name()
+			scope.ctes[name] = 0
+			i++
+			if p.isSymbol(i, "(") {
+				i = min(p.close(i, end)+1, end)
+			}
+			if !p.is(i, "AS") || !p.isSymbol(i+1, "(") {
+				break
+			}
+			scope.ctes[name] = i + 1
+			close := p.close(i+1, end)
+			p.query(i+2, close, scope)
+			i = min(close+1, end)
+			if !p.isSymbol(i, ",") {
+				break
+			}
+			i++
+		}
+	}
+
+	blockStart := i
+	for j := i; ; j = min(p.next(j), end) {
+		if j == end || p.is(j, "UNION") || p.is(j, "INTERSECT") || p.is(j, "EXCEPT") {
+			block := &sqlScope{parent: scope}
+			p.walk(blockStart, j, block, "")
+			p.resolve(block)
+			blockStart = j + 1
+		}
+		if j == end {
+			return
+		}
+	}
+}
+
+// Helper function to read what a FROM clause item at i reads, returning where it ends
+func (p *sqlParser) fromItem(i, end int, scope *sqlScope) int {
+	if i >= end {
+		return i
+	}
+
+	switch {
+	case p.isSymbol(i, "("):
+		close := p.close(i, end)
+		if p.isQueryStart(i + 1) {
+			p.query(i+1, close, scope)
+		} else {
+			// A join in parentheses
+			p.walk(p.fromItem(i+1, close, scope), close, scope, "from")
+		}
+		alias, next := p.alias(min(close+1, end), end)
+		item := sqlFromItem{alias: alias, start: i, end: i}
+		if p.isQueryStart(i + 1) {
+			item.body = i
+		}
+		scope.items = append(scope.items, item)
+		return next
+
+	case p.is(i, "LATERAL"):
+		return p.fromItem(i+1, end, scope)
+
+	case (p.is(i, "UNNEST") || p.is(i, "TABLE")) && p.isSymbol(i+1, "("):
+		close := p.close(i+1, end)
+		p.walk(i+2, close, scope, "args")
+		next := min(close+1, end)
+		if p.is(next, "WITH") && p.is(next+1, "ORDINALITY") {
+			next += 2
+		}
+		alias, next := p.alias(next, end)
+		scope.items = append(scope.items, sqlFromItem{alias: alias, start: i, end: i})
+		return next
+	}
+
+	if !p.isIdentifier(i, end) && (i >= end || p.tokens[i].Kind != sqlPlaceholder) {
+		return i
+	}
+	name, next, ok := p.qualifiedName(i, end)
+	item := sqlFromItem{alias: name[len(name)-1], start: i, end: next}
+	body, isCTE := 0, false
+	if len(name) == 1 {
+		body, isCTE = scope.cte(name[0])
+	}
+	if ok && !isCTE {
+		item.name = name
+		p.addTable(name, true)
+	}
+	item.body = body
+
+	if p.is(next, "FOR") && p.is(next+2, "AS") && p.is(next+3, "OF") {
+		// Time travel, such as FOR TIMESTAMP AS OF, is followed by an expression
+		scope.items = append(scope.items, item)
+		return next + 4
+	}
+	if alias, after := p.alias(next, end); alias != "" {
+		item.alias, next = alias, after
+	}
+	scope.items = append(scope.items, item)
+	return next
+}
+
+// Helper function to walk the tokens of a query block, or part of one, from
+// start to end. clause is where the tokens are: "select" for the select list,
+// "from" for the FROM clause, "args" for function arguments, "window" for the
+// WINDOW clause and "" or "expr" elsewhere.
+func (p *sqlParser) walk(start, end int, scope *sqlScope, clause string) {
+	for i := start; i < end; {
+		token := p.tokens[i]
+		switch {
+		case p.isSymbol(i, "("):
+			close := p.close(i, end)
+			switch {
+			case p.isQueryStart(i + 1):
+				p.query(i+1, close, scope)
+			case p.isSymbol(close+1, "->"):
+				// Parameters of a lambda expression
+				for j := i + 1; j < close; j++ {
+					if p.isIdentifier(j, close) {
+						scope.alias(p.tokens[j].name())
+					}
+				}
+			default:
+				p.walk(i+1, close, scope, clause)
+			}
+			i = close + 1
+
+		case p.isSymbol(i, ",") && clause == "from":
+			items := len(scope.items)
+			i = p.fromItem(i+1, end, scope)
+			if len(scope.items) > items {
+				scope.items[items].join = "comma"
+			}
+
+		case p.isSymbol(i, "*") && clause == "select" &&
+			(p.is(i-1, "SELECT") || p.is(i-1, "DISTINCT") || p.is(i-1, "ALL") || p.isSymbol(i-1, ",")):
+			scope.stars = append(scope.stars, sqlStar{start: i, end: i + 1})
+			i++
+
+		case token.isKeyword():
+			i = p.keyword(i, end, scope, &clause)
+
+		case token.Kind == sqlWord || token.Kind == sqlQuoted:
+			i = p.identifier(i, end, scope, clause)
+
+		default:
+			i++
+		}
+	}
+}
+
+// Helper function to handle the keyword at i, returning where to continue
+func (p *sqlParser) keyword(i, end int, scope *sqlScope, clause *string) int {
+	keyword := strings.ToUpper(p.tokens[i].Text)
+	inArgs := *clause == "args"
+
+	switch keyword {
+	case "SELECT":
+		if !inArgs {
+			*clause = "select"
+			scope.filtering = false
+		}
+
+	case "FROM", "JOIN":
+		if inArgs || keyword == "FROM" && p.is(i-1, "DISTINCT") && (p.is(i-2, "IS") || p.is(i-2, "NOT")) {
+			// Such as EXTRACT(YEAR FROM ts) or a IS DISTINCT FROM b
+			return i + 1
+		}
+		*clause = "from"
+		scope.filtering = false
+		items := len(scope.items)
+		next := p.fromItem(i+1, end, scope)
+		if len(scope.items) > items && keyword == "JOIN" && p.is(i-1, "CROSS") {
+			scope.items[items].join = "cross"
+		}
+		return next
+
+	case "USING":
+		if *clause == "from" && p.isSymbol(i+1, "(") && !p.isQueryStart(i+2) {
+			// Join columns, which both sides of the join have
+			close := p.close(i+1, end)
+			for j := i + 2; j < close; j++ {
+				if !p.isIdentifier(j, close) {
+					continue
+				}
+				for k := len(scope.items) - 1; k >= 0 && k >= len(scope.items)-2; k-- {
+					if scope.items[k].name != nil {
+						p.addColumn(scope.items[k].name, p.tokens[j].name())
+					}
+				}
+			}
+			return min(close+1, end)
+		}
+		if !inArgs {
+			// The source of a MERGE
+			*clause = "from"
+			return p.fromItem(i+1, end, scope)
+		}
+
+	case "CAST", "TRY_CAST":
+		if p.isSymbol(i+1, "(") {
+			// The type after AS isn't walked
+			close := p.close(i+1, end)
+			j := i + 2
+			for j < close && !p.is(j, "AS") {
+				j = p.next(j)
+			}
+			p.walk(i+2, min(j, close), scope, "args")
+			return min(close+1, end)
+		}
+
+	case "EXTRACT", "TRIM", "NORMALIZE", "GROUPING":
+		if p.isSymbol(i+1, "(") {
+			close := p.close(i+1, end)
+			j := i + 2
+			if keyword == "EXTRACT" {
+				// The field before FROM isn't a column
+				for j < close && !p.is(j, "FROM") {
+					j++
+				}
+			}
+			p.walk(j, close, scope, "args")
+			return min(close+1, end)
+		}
+
+	case "INTERVAL":
+		j := i + 1
+		if p.isSymbol(j, "-") || p.isSymbol(j, "+") {
+			j++
+		}
+		if j < end && p.tokens[j].Kind == sqlString {
+			j++
+			for j < end && (sqlIntervalUnits[strings.ToUpper(p.tokens[j].Text)] || p.is(j, "TO")) {
+				j++
+			}
+			return j
+		}
+
+	case "AT":
+		if p.is(i+1, "TIME") && p.is(i+2, "ZONE") {
+			return i + 3
+		}
+
+	case "OVER":
+		if p.isIdentifier(i+1, end) {
+			// A named window
+			return i + 2
+		}
+
+	case "WITH":
+		if p.is(i+1, "DATA") {
+			return i + 2
+		}
+		if p.is(i+1, "NO") && p.is(i+2, "DATA") {
+			return i + 3
+		}
+
+	case "WINDOW":
+		*clause = "window"
+		scope.filtering = false
+
+	case "ON", "WHERE", "GROUP", "HAVING", "ORDER", "LIMIT", "OFFSET", "FETCH", "SET", "VALUES", "WHEN":
+		if !inArgs && *clause != "window" {
+			*clause = "expr"
+			scope.filtering = keyword == "ON" || keyword == "WHERE"
+		}
+	}
+	return i + 1
+}
+
+// Helper function to handle the identifier at i: a column, a function, an
+// alias or a lambda parameter. It returns where to continue.
+func (p *sqlParser) identifier(i, end int, scope *sqlScope, clause string) int {
+	name, next, ok := p.qualifiedName(i, end)
+	switch {
+	case p.isSymbol(next, "("):
+		close := p.close(next, end)
+		p.walk(next+1, close, scope, "args")
+		return min(close+1, end)
+
+	case p.isSymbol(next, "->") || p.isSymbol(next, "=>"):
+		// A lambda parameter or a named argument
+		scope.alias(name[0])
+		return next
+
+	case p.isSymbol(next, ".") && p.isSymbol(next+1, "*"):
+		if clause == "select" && ok {
+			scope.stars = append(scope.stars, sqlStar{qualifier: name, start: i, end: next + 2})
+		}
+		return next + 2
+
+	case len(name) == 1 && p.tokens[i].Kind == sqlWord && next < end && p.tokens[next].Kind == sqlString:
+		// A typed literal such as DATE '2024-01-01'
+		return next + 1
+
+	case clause == "window" && p.is(next, "AS"):
+		scope.alias(name[0])
+		return next
+
+	case p.is(i-1, "AS") || clause == "select" && p.endsExpression(i-1):
+		scope.alias(name[0])
+		return next
+	}
+
+	if ok {
+		scope.refs = append(scope.refs, name)
+		scope.refTokens = append(scope.refTokens, i)
+		scope.filters = append(scope.filters, scope.filtering)
+	}
+	return next
+}
+
+// Helper function to tell whether the token at i can end an expression, so an
+// identifier after it is an alias
+func (p *sqlParser) endsExpression(i int) bool {
+	if i < 0 || i >= len(p.tokens) {
+		return false
+	}
+	token := p.tokens[i]
+	switch token.Kind {
+	case sqlQuoted, sqlString, sqlNumber, sqlPlaceholder:
+		return true
+	case sqlWord:
+		return !token.isKeyword() || p.is(i, "END") || p.is(i, "NULL") || p.is(i, "TRUE") || p.is(i, "FALSE") ||
+			strings.HasPrefix(strings.ToUpper(token.Text), "CURRENT_") || strings.HasPrefix(strings.ToUpper(token.Text), "LOCALTIME")
+	}
+	return token.Text == ")" || token.Text == "]"
+}
+
+// Helper function to attribute the column references of a block to the tables
+// they belong to. Qualified references name a table of the block or of an
+// outer one; others can only be attributed when the block reads one table.
+func (p *sqlParser) resolve(scope *sqlScope) {
+	p.blocks = append(p.blocks, scope)
+	for _, ref := range scope.refs {
+		if len(ref) == 1 && scope.aliases[ref[0]] {
+			continue
+		}
+
+		resolved := false
+		for k := len(ref) - 1; k >= 1 && !resolved; k-- {
+			if item, ok := scope.lookup(ref[:k]); ok {
+				if item.name != nil {
+					p.addColumn(item.name, ref[k])
+				}
+				resolved = true
+			}
+		}
+		if resolved {
+			continue
+		}
+
+		// The first part of the others is the column; the rest are fields of it
+		if len(scope.items) == 1 && scope.items[0].name != nil {
+			p.addColumn(scope.items[0].name, ref[0])
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned by a Store when a document doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// Which documents a listing covers with regard to the trash
+type trashState int
+
+const (
+	notTrashed trashState = iota
+	onlyTrashed
+	anyTrashState
+)
+
+type QueryFilter struct {
+	Folder        *string // nil means any folder
+	Recursive     bool    // Also match the subfolders of Folder
+	Tags          []string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	DeletedBefore *time.Time
+	Trash         trashState
+}
+
+type QueryRunFilter struct {
+	QueryID        *primitive.ObjectID
+	Statuses       []string
+	ExecutedAfter  *time.Time
+	ExecutedBefore *time.Time
+	DeletedBefore  *time.Time
+	CacheKey       string
+	ResultsS3URL   string
+	Trash          trashState
+}
+
+// SchemaChangeFilter selects schema changes; empty names match any
+type SchemaChangeFilter struct {
+	Catalog  string
+	Database string
+	Table    string
+	Since    *time.Time
+}
+
+type SearchFilter struct {
+	Author    string
+	Tags      []string
+	RanAfter  *time.Time
+	RanBefore *time.Time
+}
+
+// QueryUpdate lists the changes to a query; nil fields are left alone.
+// Tags can either be replaced or have tags added and removed, not both.
+type QueryUpdate struct {
+	Name        *string
+	SQL         *string
+	Description *string
+	Folder      *string
+	Tags        *[]string
+	AddTags     []string
+	RemoveTags  []string
+	References  *sqlReferences // What the SQL reads and writes, parsed again when it changes
+	// Only update the query while its SQL is still this, ErrNotFound otherwise
+	IfSQL     *string
+	UpdatedAt *time.Time
+	LastRunAt *time.Time
+	// Seconds results are cached for; a negative value removes the override
+	ResultCacheTTL *int
+	Catalog        *string
+	Database       *string
+	WorkGroup      *string
+}
+
+type QueryRunUpdate struct {
+	Status       *string
+	ResultsS3URL *string
+	ErrorMessage *string
+	CompletedAt  *time.Time
+	FromCache    *bool
+}
+
+// Store persists saved queries and their runs. Listings return the page, the
+// cursor of the next page ("" on the last page) and the total number of matches;
+// a page limit of 0 returns every match.
+type Store interface {
+	ListQueries(ctx context.Context, filter QueryFilter, page pageRequest) ([]Query, string, int64, error)
+	GetQuery(ctx context.Context, id primitive.ObjectID) (Query, error)
+	CreateQuery(ctx context.Context, query *Query) error
+	// UpdateQuery only updates queries that are not in the trash
+	UpdateQuery(ctx context.Context, id primitive.ObjectID, update QueryUpdate) (Query, error)
+	// TrashQuery moves a query and its runs to the trash with the same timestamp
+	TrashQuery(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error
+	// RestoreQuery restores a query and the runs that were trashed together with it
+	RestoreQuery(ctx context.Context, id primitive.ObjectID) (Query, error)
+	// DeleteQuery deletes a query together with its visualizations
+	DeleteQuery(ctx context.Context, id primitive.ObjectID) error
+	CountTags(ctx context.Context) ([]TagCount, error)
+	CountFolders(ctx context.Context) (map[string]int64, error)
+	SearchQueries(ctx context.Context, text string, filter SearchFilter, limit int) ([]SearchHit, error)
+
+	ListQueryRuns(ctx context.Context, filter QueryRunFilter, page pageRequest) ([]QueryRun, string, int64, error)
+	GetQueryRun(ctx context.Context, id primitive.ObjectID) (QueryRun, error)
+	GetQueryRunByExecutionID(ctx context.Context, executionID string) (QueryRun, error)
+	CreateQueryRun(ctx context.Context, run *QueryRun) error
+	UpdateQueryRun(ctx context.Context, id primitive.ObjectID, update QueryRunUpdate) error
+	TrashQueryRun(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error
+	RestoreQueryRun(ctx context.Context, id primitive.ObjectID) (QueryRun, error)
+	// DeleteQueryRun deletes a run together with its share links
+	DeleteQueryRun(ctx context.Context, id primitive.ObjectID) error
+	SearchQueryRuns(ctx context.Context, text string, filter SearchFilter, limit int) ([]SearchHit, error)
+
+	CreateDownloadLink(ctx context.Context, link *DownloadLink) error
+	// ListDownloadLinks returns the links handed out for an execution, newest first
+	ListDownloadLinks(ctx context.Context, executionID string) ([]DownloadLink, error)
+
+	// GetResultProfile returns ErrNotFound until a profile was saved for the execution
+	GetResultProfile(ctx context.Context, executionID string) (ResultProfile, error)
+	SaveResultProfile(ctx context.Context, profile *ResultProfile) error
+
+	// ListVisualizations returns the visualizations of a query in the order they were created
+	ListVisualizations(ctx context.Context, queryID primitive.ObjectID) ([]Visualization, error)
+	GetVisualization(ctx context.Context, id primitive.ObjectID) (Visualization, error)
+	CreateVisualization(ctx context.Context, visualization *Visualization) error
+	// UpdateVisualization replaces a visualization, keeping its query and creation
+	UpdateVisualization(ctx context.Context, visualization *Visualization) error
+	DeleteVisualization(ctx context.Context, id primitive.ObjectID) error
+
+	CreateShareLink(ctx context.Context, link *ShareLink) error
+	GetShareLink(ctx context.Context, id primitive.ObjectID) (ShareLink, error)
+	// ListShareLinks returns the share links of a run, newest first
+	ListShareLinks(ctx context.Context, runID primitive.ObjectID) ([]ShareLink, error)
+	// RevokeShareLink revokes a link; links revoked before keep their first revocation
+	RevokeShareLink(ctx context.Context, id primitive.ObjectID, revokedBy string, revokedAt time.Time) (ShareLink, error)
+
+	// ListDashboards returns every dashboard ordered by name
+	ListDashboards(ctx context.Context) ([]Dashboard, error)
+	GetDashboard(ctx context.Context, id primitive.ObjectID) (Dashboard, error)
+	CreateDashboard(ctx context.Context, dashboard *Dashboard) error
+	// UpdateDashboard replaces a dashboard, keeping its creation
+	UpdateDashboard(ctx context.Context, dashboard *Dashboard) error
+	DeleteDashboard(ctx context.Context, id primitive.ObjectID) error
+
+	// ListTableSchemas returns the snapshots of the tables of a catalog
+	ListTableSchemas(ctx context.Context, catalog string) ([]TableSchema, error)
+	// SaveTableSchemas creates or replaces the snapshots of tables
+	SaveTableSchemas(ctx context.Context, schemas []TableSchema) error
+	DeleteTableSchemas(ctx context.Context, schemas []TableSchema) error
+	CreateSchemaChanges(ctx context.Context, changes []SchemaChange) error
+	// ListSchemaChanges returns matching changes, newest first; a limit of 0 returns them all
+	ListSchemaChanges(ctx context.Context, filter SchemaChangeFilter, limit int) ([]SchemaChange, error)
+
+	Close(ctx context.Context) error
+}
+
+// Helper function to open the store selected by STORAGE_DRIVER: mongo (the
+// default), sqlite for single node installs, postgres, or memory for
+// development and tests (nothing is persisted)
+func openStore(ctx context.Context) (Store, error) {
+	driver := os.Getenv("STORAGE_DRIVER")
+	switch driver {
+	case "", "mongo":
+		mongoURI := os.Getenv("MONGO_URI")
+		if mongoURI == "" {
+			mongoURI = "mongodb://localhost:27017/zeus"
+		}
+		return newMongoStore(ctx, mongoURI)
+	case "sqlite":
+		dsn := os.Getenv("DATABASE_URL")
+		if dsn == "" {
+			dsn = "zeus.db"
+		}
+		return newSQLStore(ctx, "sqlite", dsn)
+	case "postgres":
+		dsn := os.Getenv("DATABASE_URL")
+		if dsn == "" {
+			return nil, fmt.Errorf("DATABASE_URL must be set when STORAGE_DRIVER is postgres")
+		}
+		return newSQLStore(ctx, "postgres", dsn)
+	case "memory":
+		return newMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_DRIVER %q", driver)
+	}
+}
+
+// Helper function to apply an update to a query in memory, for stores that
+// read, modify and write whole rows
+func applyQueryUpdate(query *Query, update QueryUpdate) {
+	if update.Name != nil {
+		query.Name = *update.Name
+	}
+	if update.SQL != nil {
+		query.SQL = *update.SQL
+	}
+	if update.Description != nil {
+		query.Description = *update.Description
+	}
+	if update.Folder != nil {
+		query.Folder = *update.Folder
+	}
+	if update.Tags != nil {
+		query.Tags = append([]string{}, (*update.Tags)...)
+	}
+	if len(update.AddTags) > 0 {
+		query.Tags, _ = normalizeTags(append(append([]string{}, query.Tags...), update.AddTags...))
+	}
+	if len(update.RemoveTags) > 0 {
+		remove := map[string]bool{}
+		for _, tag := range update.RemoveTags {
+			remove[tag] = true
+		}
+		tags := []string{}
+		for _, tag := range query.Tags {
+			if !remove[tag] {
+				tags = append(tags, tag)
+			}
+		}
+		query.Tags = tags
+	}
+	if update.References != nil {
+		refs := copySQLReferences(*update.References)
+		query.Tables, query.Databases, query.Columns, query.Lineage = refs.Tables, refs.Databases, refs.Columns, refs.Lineage
+	}
+	if update.UpdatedAt != nil {
+		query.UpdatedAt = *update.UpdatedAt
+	}
+	if update.LastRunAt != nil {
+		lastRunAt := *update.LastRunAt
+		query.LastRunAt = &lastRunAt
+	}
+	if update.Catalog != nil {
+		query.Catalog = *update.Catalog
+	}
+	if update.Database != nil {
+		query.Database = *update.Database
+	}
+	if update.WorkGroup != nil {
+		query.WorkGroup = *update.WorkGroup
+	}
+	if update.ResultCacheTTL != nil {
+		query.ResultCacheTTL = nil
+		if *update.ResultCacheTTL >= 0 {
+			ttl := *update.ResultCacheTTL
+			query.ResultCacheTTL = &ttl
+		}
+	}
+}
+
+// Helper function to apply a status update to a query run in memory
+func applyQueryRunUpdate(run *QueryRun, update QueryRunUpdate) {
+	if update.Status != nil {
+		run.Status = *update.Status
+	}
+	if update.ResultsS3URL != nil {
+		run.ResultsS3URL = *update.ResultsS3URL
+	}
+	if update.ErrorMessage != nil {
+		run.ErrorMessage = *update.ErrorMessage
+	}
+	if update.CompletedAt != nil {
+		completedAt := *update.CompletedAt
+		run.CompletedAt = &completedAt
+	}
+	if update.FromCache != nil {
+		run.FromCache = *update.FromCache
+	}
+}
+
+// Helper function to score a document for stores without a native text index:
+// every search term found in a field adds that field's weight
+func scoreSearchTerms(terms []string, fields map[string]float64, values map[string]string) float64 {
+	var score float64
+	for _, term := range terms {
+		for field, weight := range fields {
+			if containsFold(values[field], term) {
+				score += weight
+			}
+		}
+	}
+	return score
+}
+
+// Helper function to check whether s contains the lowercase substring sub, ignoring case
+func containsFold(s, sub string) bool {
+	return strings.Contains(strings.ToLower(s), sub)
+}
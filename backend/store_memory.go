@@ -0,0 +1,876 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore keeps queries and runs in maps. It backs STORAGE_DRIVER=memory
+// and handler tests, and behaves like the other stores for filters, sorting
+// and keyset pagination.
+type memoryStore struct {
+	mu         sync.RWMutex
+	queries    map[primitive.ObjectID]Query
+	runs       map[primitive.ObjectID]QueryRun
+	links      []DownloadLink
+	profiles   map[string]ResultProfile
+	charts     []Visualization // In creation order
+	dashboards map[primitive.ObjectID]Dashboard
+	shares     []ShareLink            // In creation order
+	schemas    map[string]TableSchema // By schemaKey
+	changes    []SchemaChange         // In detection order
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		queries:    map[primitive.ObjectID]Query{},
+		runs:       map[primitive.ObjectID]QueryRun{},
+		profiles:   map[string]ResultProfile{},
+		dashboards: map[primitive.ObjectID]Dashboard{},
+		schemas:    map[string]TableSchema{},
+	}
+}
+
+func (m *memoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// Helper function to copy a query so callers can't modify stored slices
+func copyQuery(query Query) Query {
+	query.Tags = append([]string{}, query.Tags...)
+	refs := copySQLReferences(sqlReferences{Tables: query.Tables, Databases: query.Databases, Columns: query.Columns, Lineage: query.Lineage})
+	query.Tables, query.Databases, query.Columns, query.Lineage = refs.Tables, refs.Databases, refs.Columns, refs.Lineage
+	if query.ResultCacheTTL != nil {
+		ttl := *query.ResultCacheTTL
+		query.ResultCacheTTL = &ttl
+	}
+	return query
+}
+
+// Helper function to copy a query run so callers can't modify the stored parameters
+func copyQueryRun(run QueryRun) QueryRun {
+	if run.Parameters != nil {
+		parameters := make(map[string]string, len(run.Parameters))
+		for name, value := range run.Parameters {
+			parameters[name] = value
+		}
+		run.Parameters = parameters
+	}
+	return run
+}
+
+func inDateRange(t time.Time, after, before *time.Time) bool {
+	if after != nil && t.Before(*after) {
+		return false
+	}
+	if before != nil && !t.Before(*before) {
+		return false
+	}
+	return true
+}
+
+func matchesTrash(deletedAt *time.Time, trash trashState, deletedBefore *time.Time) bool {
+	switch trash {
+	case notTrashed:
+		if deletedAt != nil {
+			return false
+		}
+	case onlyTrashed:
+		if deletedAt == nil {
+			return false
+		}
+	}
+	if deletedBefore != nil && (deletedAt == nil || !deletedAt.Before(*deletedBefore)) {
+		return false
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAllTags(tags, required []string) bool {
+	for _, tag := range required {
+		if !containsString(tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesQueryFilter(query Query, f QueryFilter) bool {
+	if f.Folder != nil {
+		switch {
+		case !f.Recursive:
+			if query.Folder != *f.Folder {
+				return false
+			}
+		case *f.Folder != "":
+			if query.Folder != *f.Folder && !strings.HasPrefix(query.Folder, *f.Folder+"/") {
+				return false
+			}
+		}
+	}
+
+	return hasAllTags(query.Tags, f.Tags) &&
+		inDateRange(query.CreatedAt, f.CreatedAfter, f.CreatedBefore) &&
+		inDateRange(query.UpdatedAt, f.UpdatedAfter, f.UpdatedBefore) &&
+		matchesTrash(query.DeletedAt, f.Trash, f.DeletedBefore)
+}
+
+func matchesQueryRunFilter(run QueryRun, f QueryRunFilter) bool {
+	if f.QueryID != nil && run.QueryID != *f.QueryID {
+		return false
+	}
+	if len(f.Statuses) > 0 && !containsString(f.Statuses, run.Status) {
+		return false
+	}
+	if f.CacheKey != "" && run.CacheKey != f.CacheKey {
+		return false
+	}
+	if f.ResultsS3URL != "" && run.ResultsS3URL != f.ResultsS3URL {
+		return false
+	}
+
+	return inDateRange(run.ExecutedAt, f.ExecutedAfter, f.ExecutedBefore) &&
+		matchesTrash(run.DeletedAt, f.Trash, f.DeletedBefore)
+}
+
+// Helper function to order two cursors the way the other stores sort: missing
+// values first, names case-insensitively and the ID as a tie breaker
+func compareCursors(a, b pageCursor) int {
+	switch {
+	case a.Value == nil && b.Value != nil:
+		return -1
+	case a.Value != nil && b.Value == nil:
+		return 1
+	}
+
+	switch av := a.Value.(type) {
+	case time.Time:
+		bv := b.Value.(time.Time)
+		if av.Before(bv) {
+			return -1
+		}
+		if av.After(bv) {
+			return 1
+		}
+	case string:
+		if c := strings.Compare(strings.ToLower(av), strings.ToLower(b.Value.(string))); c != 0 {
+			return c
+		}
+	}
+
+	return strings.Compare(a.ID.Hex(), b.ID.Hex())
+}
+
+// Helper function to sort matching items and cut one page out of them
+func memoryPage[T any](items []T, page pageRequest, cursorOf func(T) pageCursor) ([]T, string, int64) {
+	sort.Slice(items, func(i, j int) bool {
+		c := compareCursors(cursorOf(items[i]), cursorOf(items[j]))
+		if page.Desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	total := int64(len(items))
+
+	if page.After != nil {
+		start := len(items)
+		for i, item := range items {
+			c := compareCursors(cursorOf(item), *page.After)
+			if page.Desc && c < 0 || !page.Desc && c > 0 {
+				start = i
+				break
+			}
+		}
+		items = items[start:]
+	}
+
+	next := ""
+	if page.Limit > 0 && len(items) > page.Limit {
+		items = items[:page.Limit]
+		next = encodeCursor(cursorOf(items[len(items)-1]))
+	}
+
+	return items, next, total
+}
+
+func (m *memoryStore) ListQueries(ctx context.Context, filter QueryFilter, page pageRequest) ([]Query, string, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	queries := []Query{}
+	for _, query := range m.queries {
+		if matchesQueryFilter(query, filter) {
+			queries = append(queries, copyQuery(query))
+		}
+	}
+
+	queries, next, total := memoryPage(queries, page, queryCursorFunc(page.Sort))
+	return queries, next, total, nil
+}
+
+func (m *memoryStore) GetQuery(ctx context.Context, id primitive.ObjectID) (Query, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	query, ok := m.queries[id]
+	if !ok {
+		return Query{}, ErrNotFound
+	}
+	return copyQuery(query), nil
+}
+
+func (m *memoryStore) CreateQuery(ctx context.Context, query *Query) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query.ID = primitive.NewObjectID()
+	if query.Tags == nil {
+		query.Tags = []string{}
+	}
+	m.queries[query.ID] = copyQuery(*query)
+	return nil
+}
+
+func (m *memoryStore) UpdateQuery(ctx context.Context, id primitive.ObjectID, update QueryUpdate) (Query, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query, ok := m.queries[id]
+	if !ok || query.DeletedAt != nil || update.IfSQL != nil && query.SQL != *update.IfSQL {
+		return Query{}, ErrNotFound
+	}
+
+	query = copyQuery(query)
+	applyQueryUpdate(&query, update)
+	m.queries[id] = query
+	return copyQuery(query), nil
+}
+
+func (m *memoryStore) TrashQuery(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query, ok := m.queries[id]
+	if !ok || query.DeletedAt != nil {
+		return ErrNotFound
+	}
+
+	query.DeletedAt = &deletedAt
+	query.DeletedBy = deletedBy
+	m.queries[id] = query
+
+	for runID, run := range m.runs {
+		if run.QueryID == id && run.DeletedAt == nil {
+			run.DeletedAt = &deletedAt
+			run.DeletedBy = deletedBy
+			m.runs[runID] = run
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) RestoreQuery(ctx context.Context, id primitive.ObjectID) (Query, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query, ok := m.queries[id]
+	if !ok || query.DeletedAt == nil {
+		return Query{}, ErrNotFound
+	}
+
+	for runID, run := range m.runs {
+		if run.QueryID == id && run.DeletedAt != nil && run.DeletedAt.Equal(*query.DeletedAt) {
+			run.DeletedAt = nil
+			run.DeletedBy = ""
+			m.runs[runID] = run
+		}
+	}
+
+	query.DeletedAt = nil
+	query.DeletedBy = ""
+	m.queries[id] = query
+	return copyQuery(query), nil
+}
+
+func (m *memoryStore) DeleteQuery(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.queries[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.queries, id)
+
+	charts := []Visualization{}
+	for _, chart := range m.charts {
+		if chart.QueryID != id {
+			charts = append(charts, chart)
+		}
+	}
+	m.charts = charts
+	return nil
+}
+
+func (m *memoryStore) CountTags(ctx context.Context) ([]TagCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := map[string]int64{}
+	for _, query := range m.queries {
+		if query.DeletedAt != nil {
+			continue
+		}
+		for _, tag := range query.Tags {
+			counts[tag]++
+		}
+	}
+
+	tags := []TagCount{}
+	for tag, count := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags, nil
+}
+
+func (m *memoryStore) CountFolders(ctx context.Context) (map[string]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := map[string]int64{}
+	for _, query := range m.queries {
+		if query.DeletedAt == nil && query.Folder != "" {
+			counts[query.Folder]++
+		}
+	}
+	return counts, nil
+}
+
+func (m *memoryStore) SearchQueries(ctx context.Context, text string, f SearchFilter, limit int) ([]SearchHit, error) {
+	terms := searchTerms(text)
+	hits := []SearchHit{}
+	if len(terms) == 0 {
+		return hits, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, query := range m.queries {
+		if query.DeletedAt != nil || f.Author != "" && query.CreatedBy != f.Author || !hasAllTags(query.Tags, f.Tags) {
+			continue
+		}
+		if f.RanAfter != nil || f.RanBefore != nil {
+			if query.LastRunAt == nil || !inDateRange(*query.LastRunAt, f.RanAfter, f.RanBefore) {
+				continue
+			}
+		}
+
+		score := scoreSearchTerms(terms, querySearchWeights, map[string]string{
+			"name":        query.Name,
+			"description": query.Description,
+			"sql":         query.SQL,
+			"tables":      strings.Join(query.Tables, " "),
+		})
+		if score > 0 {
+			hit := copyQuery(query)
+			hits = append(hits, SearchHit{Type: "query", Score: score, Query: &hit})
+		}
+	}
+
+	return topSearchHits(hits, limit), nil
+}
+
+func (m *memoryStore) ListQueryRuns(ctx context.Context, filter QueryRunFilter, page pageRequest) ([]QueryRun, string, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	runs := []QueryRun{}
+	for _, run := range m.runs {
+		if matchesQueryRunFilter(run, filter) {
+			runs = append(runs, copyQueryRun(run))
+		}
+	}
+
+	runs, next, total := memoryPage(runs, page, queryRunCursorFunc(page.Sort))
+	return runs, next, total, nil
+}
+
+func (m *memoryStore) GetQueryRun(ctx context.Context, id primitive.ObjectID) (QueryRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	run, ok := m.runs[id]
+	if !ok {
+		return QueryRun{}, ErrNotFound
+	}
+	return copyQueryRun(run), nil
+}
+
+func (m *memoryStore) GetQueryRunByExecutionID(ctx context.Context, executionID string) (QueryRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, run := range m.runs {
+		if run.ExecutionID == executionID {
+			return copyQueryRun(run), nil
+		}
+	}
+	return QueryRun{}, ErrNotFound
+}
+
+func (m *memoryStore) CreateQueryRun(ctx context.Context, run *QueryRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	run.ID = primitive.NewObjectID()
+	m.runs[run.ID] = copyQueryRun(*run)
+	return nil
+}
+
+func (m *memoryStore) UpdateQueryRun(ctx context.Context, id primitive.ObjectID, update QueryRunUpdate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	run, ok := m.runs[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	applyQueryRunUpdate(&run, update)
+	m.runs[id] = run
+	return nil
+}
+
+func (m *memoryStore) TrashQueryRun(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	run, ok := m.runs[id]
+	if !ok || run.DeletedAt != nil {
+		return ErrNotFound
+	}
+
+	run.DeletedAt = &deletedAt
+	run.DeletedBy = deletedBy
+	m.runs[id] = run
+	return nil
+}
+
+func (m *memoryStore) RestoreQueryRun(ctx context.Context, id primitive.ObjectID) (QueryRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	run, ok := m.runs[id]
+	if !ok || run.DeletedAt == nil {
+		return QueryRun{}, ErrNotFound
+	}
+
+	run.DeletedAt = nil
+	run.DeletedBy = ""
+	m.runs[id] = run
+	return copyQueryRun(run), nil
+}
+
+func (m *memoryStore) DeleteQueryRun(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.runs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.runs, id)
+
+	shares := []ShareLink{}
+	for _, link := range m.shares {
+		if link.RunID != id {
+			shares = append(shares, link)
+		}
+	}
+	m.shares = shares
+	return nil
+}
+
+func (m *memoryStore) SearchQueryRuns(ctx context.Context, text string, f SearchFilter, limit int) ([]SearchHit, error) {
+	terms := searchTerms(text)
+	hits := []SearchHit{}
+	if len(terms) == 0 {
+		return hits, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, run := range m.runs {
+		if run.DeletedAt != nil || f.Author != "" && run.ExecutedBy != f.Author ||
+			!inDateRange(run.ExecutedAt, f.RanAfter, f.RanBefore) {
+			continue
+		}
+		if len(f.Tags) > 0 && !hasAllTags(m.queries[run.QueryID].Tags, f.Tags) {
+			continue
+		}
+
+		score := scoreSearchTerms(terms, map[string]float64{"sql": 1}, map[string]string{"sql": run.SQL})
+		if score > 0 {
+			hit := copyQueryRun(run)
+			hits = append(hits, SearchHit{Type: "run", Score: score, Run: &hit})
+		}
+	}
+
+	return topSearchHits(hits, limit), nil
+}
+
+func (m *memoryStore) CreateDownloadLink(ctx context.Context, link *DownloadLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link.ID = primitive.NewObjectID()
+	m.links = append(m.links, *link)
+	return nil
+}
+
+func (m *memoryStore) ListDownloadLinks(ctx context.Context, executionID string) ([]DownloadLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Links are appended in the order they were handed out
+	links := []DownloadLink{}
+	for i := len(m.links) - 1; i >= 0; i-- {
+		if m.links[i].ExecutionID == executionID {
+			links = append(links, m.links[i])
+		}
+	}
+	return links, nil
+}
+
+func (m *memoryStore) GetResultProfile(ctx context.Context, executionID string) (ResultProfile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	profile, ok := m.profiles[executionID]
+	if !ok {
+		return profile, ErrNotFound
+	}
+	return profile, nil
+}
+
+func (m *memoryStore) SaveResultProfile(ctx context.Context, profile *ResultProfile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.profiles[profile.ExecutionID] = *profile
+	return nil
+}
+
+// Helper function to copy a visualization so callers can't modify the stored one
+func copyVisualization(visualization Visualization) Visualization {
+	visualization.Columns.Y = append([]string{}, visualization.Columns.Y...)
+	if visualization.Options != nil {
+		options := make(map[string]interface{}, len(visualization.Options))
+		for key, value := range visualization.Options {
+			options[key] = value
+		}
+		visualization.Options = options
+	}
+	return visualization
+}
+
+func (m *memoryStore) ListVisualizations(ctx context.Context, queryID primitive.ObjectID) ([]Visualization, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	charts := []Visualization{}
+	for _, chart := range m.charts {
+		if chart.QueryID == queryID {
+			charts = append(charts, copyVisualization(chart))
+		}
+	}
+	return charts, nil
+}
+
+func (m *memoryStore) GetVisualization(ctx context.Context, id primitive.ObjectID) (Visualization, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, chart := range m.charts {
+		if chart.ID == id {
+			return copyVisualization(chart), nil
+		}
+	}
+	return Visualization{}, ErrNotFound
+}
+
+func (m *memoryStore) CreateVisualization(ctx context.Context, visualization *Visualization) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	visualization.ID = primitive.NewObjectID()
+	m.charts = append(m.charts, copyVisualization(*visualization))
+	return nil
+}
+
+func (m *memoryStore) UpdateVisualization(ctx context.Context, visualization *Visualization) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, chart := range m.charts {
+		if chart.ID == visualization.ID {
+			visualization.QueryID = chart.QueryID
+			visualization.CreatedBy = chart.CreatedBy
+			visualization.CreatedAt = chart.CreatedAt
+			m.charts[i] = copyVisualization(*visualization)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *memoryStore) DeleteVisualization(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, chart := range m.charts {
+		if chart.ID == id {
+			m.charts = append(m.charts[:i], m.charts[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Helper function to copy a dashboard so callers can't modify the stored one
+func copyDashboard(dashboard Dashboard) Dashboard {
+	dashboard.Parameters = append([]DashboardParameter{}, dashboard.Parameters...)
+	widgets := make([]DashboardWidget, len(dashboard.Widgets))
+	for i, widget := range dashboard.Widgets {
+		if widget.VisualizationID != nil {
+			id := *widget.VisualizationID
+			widget.VisualizationID = &id
+		}
+		if widget.Parameters != nil {
+			parameters := make(map[string]string, len(widget.Parameters))
+			for name, value := range widget.Parameters {
+				parameters[name] = value
+			}
+			widget.Parameters = parameters
+		}
+		widgets[i] = widget
+	}
+	dashboard.Widgets = widgets
+	return dashboard
+}
+
+func (m *memoryStore) ListDashboards(ctx context.Context) ([]Dashboard, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dashboards := make([]Dashboard, 0, len(m.dashboards))
+	for _, dashboard := range m.dashboards {
+		dashboards = append(dashboards, copyDashboard(dashboard))
+	}
+	sort.Slice(dashboards, func(i, j int) bool {
+		a, b := strings.ToLower(dashboards[i].Name), strings.ToLower(dashboards[j].Name)
+		return a < b || a == b && dashboards[i].ID.Hex() < dashboards[j].ID.Hex()
+	})
+	return dashboards, nil
+}
+
+func (m *memoryStore) GetDashboard(ctx context.Context, id primitive.ObjectID) (Dashboard, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dashboard, ok := m.dashboards[id]
+	if !ok {
+		return dashboard, ErrNotFound
+	}
+	return copyDashboard(dashboard), nil
+}
+
+func (m *memoryStore) CreateDashboard(ctx context.Context, dashboard *Dashboard) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dashboard.ID = primitive.NewObjectID()
+	m.dashboards[dashboard.ID] = copyDashboard(*dashboard)
+	return nil
+}
+
+func (m *memoryStore) UpdateDashboard(ctx context.Context, dashboard *Dashboard) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.dashboards[dashboard.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	dashboard.CreatedBy = existing.CreatedBy
+	dashboard.CreatedAt = existing.CreatedAt
+	m.dashboards[dashboard.ID] = copyDashboard(*dashboard)
+	return nil
+}
+
+func (m *memoryStore) DeleteDashboard(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.dashboards[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.dashboards, id)
+	return nil
+}
+
+func (m *memoryStore) CreateShareLink(ctx context.Context, link *ShareLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link.ID = primitive.NewObjectID()
+	m.shares = append(m.shares, *link)
+	return nil
+}
+
+func (m *memoryStore) GetShareLink(ctx context.Context, id primitive.ObjectID) (ShareLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, link := range m.shares {
+		if link.ID == id {
+			return link, nil
+		}
+	}
+	return ShareLink{}, ErrNotFound
+}
+
+func (m *memoryStore) ListShareLinks(ctx context.Context, runID primitive.ObjectID) ([]ShareLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	links := []ShareLink{}
+	for i := len(m.shares) - 1; i >= 0; i-- {
+		if m.shares[i].RunID == runID {
+			links = append(links, m.shares[i])
+		}
+	}
+	return links, nil
+}
+
+func (m *memoryStore) RevokeShareLink(ctx context.Context, id primitive.ObjectID, revokedBy string, revokedAt time.Time) (ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, link := range m.shares {
+		if link.ID != id {
+			continue
+		}
+		if link.RevokedAt == nil {
+			m.shares[i].RevokedAt = &revokedAt
+			m.shares[i].RevokedBy = revokedBy
+		}
+		return m.shares[i], nil
+	}
+	return ShareLink{}, ErrNotFound
+}
+
+// Helper function to key the snapshot of a table
+func schemaKey(schema TableSchema) string {
+	return schema.Catalog + "/" + schema.Database + "/" + schema.Table
+}
+
+// Helper function to copy a table schema so callers can't modify stored columns
+func copyTableSchema(schema TableSchema) TableSchema {
+	schema.Columns = append([]Column{}, schema.Columns...)
+	if schema.PartitionKeys != nil {
+		schema.PartitionKeys = append([]Column{}, schema.PartitionKeys...)
+	}
+	return schema
+}
+
+func (m *memoryStore) ListTableSchemas(ctx context.Context, catalog string) ([]TableSchema, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	schemas := []TableSchema{}
+	for _, schema := range m.schemas {
+		if schema.Catalog == catalog {
+			schemas = append(schemas, copyTableSchema(schema))
+		}
+	}
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemaKey(schemas[i]) < schemaKey(schemas[j])
+	})
+	return schemas, nil
+}
+
+func (m *memoryStore) SaveTableSchemas(ctx context.Context, schemas []TableSchema) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, schema := range schemas {
+		m.schemas[schemaKey(schema)] = copyTableSchema(schema)
+	}
+	return nil
+}
+
+func (m *memoryStore) DeleteTableSchemas(ctx context.Context, schemas []TableSchema) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, schema := range schemas {
+		delete(m.schemas, schemaKey(schema))
+	}
+	return nil
+}
+
+func (m *memoryStore) CreateSchemaChanges(ctx context.Context, changes []SchemaChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range changes {
+		changes[i].ID = primitive.NewObjectID()
+		m.changes = append(m.changes, changes[i])
+	}
+	return nil
+}
+
+func (m *memoryStore) ListSchemaChanges(ctx context.Context, filter SchemaChangeFilter, limit int) ([]SchemaChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	changes := []SchemaChange{}
+	for _, change := range m.changes {
+		if filter.Catalog != "" && change.Catalog != filter.Catalog ||
+			filter.Database != "" && change.Database != filter.Database ||
+			filter.Table != "" && change.Table != filter.Table ||
+			filter.Since != nil && change.DetectedAt.Before(*filter.Since) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	// Changes detected together keep their order, newest run first
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].DetectedAt.After(changes[j].DetectedAt)
+	})
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
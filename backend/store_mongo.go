@@ -0,0 +1,987 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoStore keeps queries and runs in the "queries" and "queryruns" collections,
+// the audit of download links in "downloadlinks", result profiles in "resultprofiles"
+// chart definitions in "visualizations", dashboards in "dashboards", share
+// links in "sharelinks", catalog snapshots in "tableschemas" and the changes
+// between them in "schemachanges"
+type mongoStore struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+func newMongoStore(ctx context.Context, mongoURI string) (*mongoStore, error) {
+	// Decode free-form documents such as visualization options as maps, which
+	// encode to JSON objects, rather than as ordered key/value lists
+	clientOptions := options.Client().ApplyURI(mongoURI).SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true})
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Test the connection
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &mongoStore{client: client, db: client.Database("zeus")}
+	log.Println("Connected to MongoDB!")
+
+	if err := s.ensureIndexes(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *mongoStore) queries() *mongo.Collection {
+	return s.db.Collection("queries")
+}
+
+func (s *mongoStore) queryRuns() *mongo.Collection {
+	return s.db.Collection("queryruns")
+}
+
+func (s *mongoStore) downloadLinks() *mongo.Collection {
+	return s.db.Collection("downloadlinks")
+}
+
+func (s *mongoStore) resultProfiles() *mongo.Collection {
+	return s.db.Collection("resultprofiles")
+}
+
+func (s *mongoStore) visualizations() *mongo.Collection {
+	return s.db.Collection("visualizations")
+}
+
+func (s *mongoStore) dashboards() *mongo.Collection {
+	return s.db.Collection("dashboards")
+}
+
+func (s *mongoStore) shareLinks() *mongo.Collection {
+	return s.db.Collection("sharelinks")
+}
+
+func (s *mongoStore) tableSchemas() *mongo.Collection {
+	return s.db.Collection("tableschemas")
+}
+
+func (s *mongoStore) schemaChanges() *mongo.Collection {
+	return s.db.Collection("schemachanges")
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// Helper function to create the text indexes used by /api/search
+func (s *mongoStore) ensureIndexes(ctx context.Context) error {
+	weights := bson.M{}
+	for field, weight := range querySearchWeights {
+		weights[field] = weight
+	}
+
+	_, err := s.queries().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "name", Value: "text"},
+			{Key: "description", Value: "text"},
+			{Key: "sql", Value: "text"},
+			{Key: "tables", Value: "text"},
+		},
+		Options: options.Index().SetName("queries_search").SetWeights(weights),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create queries text index: %v", err)
+	}
+
+	_, err = s.queryRuns().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "sql", Value: "text"}},
+			Options: options.Index().SetName("queryruns_search"),
+		},
+		{
+			Keys: bson.D{{Key: "queryId", Value: 1}, {Key: "executedAt", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "executionId", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "cacheKey", Value: 1}, {Key: "executedAt", Value: -1}},
+			Options: options.Index().SetSparse(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create query runs indexes: %v", err)
+	}
+
+	_, err = s.downloadLinks().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "executionId", Value: 1}, {Key: "requestedAt", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create download links index: %v", err)
+	}
+
+	_, err = s.visualizations().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "queryId", Value: 1}, {Key: "createdAt", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create visualizations index: %v", err)
+	}
+
+	_, err = s.shareLinks().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "runId", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create share links index: %v", err)
+	}
+
+	_, err = s.tableSchemas().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "catalog", Value: 1}, {Key: "database", Value: 1}, {Key: "table", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create table schemas index: %v", err)
+	}
+
+	_, err = s.schemaChanges().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "catalog", Value: 1}, {Key: "database", Value: 1}, {Key: "table", Value: 1},
+				{Key: "detectedAt", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "detectedAt", Value: -1}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create schema changes indexes: %v", err)
+	}
+
+	return nil
+}
+
+// Helper function to add the trash condition of a listing
+func trashCondition(filter bson.M, trash trashState, deletedBefore *time.Time) {
+	switch trash {
+	case notTrashed:
+		filter["deletedAt"] = nil
+	case onlyTrashed:
+		filter["deletedAt"] = bson.M{"$ne": nil}
+	}
+	if deletedBefore != nil {
+		filter["deletedAt"] = bson.M{"$lt": *deletedBefore}
+	}
+}
+
+// Helper function to add a date range condition on field
+func dateRangeCondition(filter bson.M, field string, after, before *time.Time) {
+	dateRange := bson.M{}
+	if after != nil {
+		dateRange["$gte"] = *after
+	}
+	if before != nil {
+		dateRange["$lt"] = *before
+	}
+	if len(dateRange) > 0 {
+		filter[field] = dateRange
+	}
+}
+
+func mongoQueryFilter(f QueryFilter) bson.M {
+	filter := bson.M{}
+
+	if f.Folder != nil {
+		switch {
+		case !f.Recursive && *f.Folder == "":
+			// Queries saved before folders existed have no folder field at all
+			filter["folder"] = bson.M{"$in": bson.A{"", nil}}
+		case !f.Recursive:
+			filter["folder"] = *f.Folder
+		case *f.Folder != "":
+			filter["folder"] = bson.M{"$regex": "^" + regexp.QuoteMeta(*f.Folder) + "(/|$)"}
+		}
+	}
+
+	if len(f.Tags) > 0 {
+		filter["tags"] = bson.M{"$all": f.Tags}
+	}
+
+	dateRangeCondition(filter, "createdAt", f.CreatedAfter, f.CreatedBefore)
+	dateRangeCondition(filter, "updatedAt", f.UpdatedAfter, f.UpdatedBefore)
+	trashCondition(filter, f.Trash, f.DeletedBefore)
+
+	return filter
+}
+
+func mongoQueryRunFilter(f QueryRunFilter) bson.M {
+	filter := bson.M{}
+
+	if f.QueryID != nil {
+		filter["queryId"] = *f.QueryID
+	}
+	if len(f.Statuses) > 0 {
+		filter["status"] = bson.M{"$in": f.Statuses}
+	}
+	if f.CacheKey != "" {
+		filter["cacheKey"] = f.CacheKey
+	}
+	if f.ResultsS3URL != "" {
+		filter["resultsS3Url"] = f.ResultsS3URL
+	}
+
+	dateRangeCondition(filter, "executedAt", f.ExecutedAfter, f.ExecutedBefore)
+	trashCondition(filter, f.Trash, f.DeletedBefore)
+
+	return filter
+}
+
+// Helper function to build the keyset condition selecting documents after the cursor.
+// Missing values sort before everything else in Mongo, which the conditions mirror.
+func mongoCursorFilter(p pageRequest) bson.M {
+	if p.After == nil {
+		return nil
+	}
+
+	field := p.Sort.Field
+	idOperator := "$gt"
+	if p.Desc {
+		idOperator = "$lt"
+	}
+	sameValueLaterID := func(value interface{}) bson.M {
+		return bson.M{field: value, "_id": bson.M{idOperator: p.After.ID}}
+	}
+
+	if p.After.Value == nil {
+		if p.Desc {
+			return sameValueLaterID(nil)
+		}
+		return bson.M{"$or": bson.A{
+			sameValueLaterID(nil),
+			bson.M{field: bson.M{"$ne": nil}},
+		}}
+	}
+
+	if p.Desc {
+		return bson.M{"$or": bson.A{
+			bson.M{field: bson.M{"$lt": p.After.Value}},
+			sameValueLaterID(p.After.Value),
+			bson.M{field: nil},
+		}}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{"$gt": p.After.Value}},
+		sameValueLaterID(p.After.Value),
+	}}
+}
+
+func mongoFindOptions(p pageRequest) *options.FindOptions {
+	direction := 1
+	if p.Desc {
+		direction = -1
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: p.Sort.Field, Value: direction}, {Key: "_id", Value: direction}})
+	if p.Limit > 0 {
+		opts.SetLimit(int64(p.Limit + 1))
+	}
+	if !p.Sort.IsTime {
+		// Sort names case-insensitively; the cursor filter then uses the same collation
+		opts.SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	}
+	return opts
+}
+
+// Helper function to fetch one page of a collection
+func findPage[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, page pageRequest, cursorOf func(T) pageCursor) ([]T, string, int64, error) {
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	pageFilter := filter
+	if cursorFilter := mongoCursorFilter(page); cursorFilter != nil {
+		pageFilter = bson.M{"$and": bson.A{filter, cursorFilter}}
+	}
+
+	cursor, err := collection.Find(ctx, pageFilter, mongoFindOptions(page))
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer cursor.Close(ctx)
+
+	items := []T{}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, "", 0, err
+	}
+
+	next := ""
+	if page.Limit > 0 && len(items) > page.Limit {
+		items = items[:page.Limit]
+		next = encodeCursor(cursorOf(items[len(items)-1]))
+	}
+
+	return items, next, total, nil
+}
+
+// Helper function to map the driver's "no documents" error onto ErrNotFound
+func mongoError(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *mongoStore) ListQueries(ctx context.Context, filter QueryFilter, page pageRequest) ([]Query, string, int64, error) {
+	return findPage(ctx, s.queries(), mongoQueryFilter(filter), page, queryCursorFunc(page.Sort))
+}
+
+func (s *mongoStore) GetQuery(ctx context.Context, id primitive.ObjectID) (Query, error) {
+	var query Query
+	err := s.queries().FindOne(ctx, bson.M{"_id": id}).Decode(&query)
+	return query, mongoError(err)
+}
+
+func (s *mongoStore) CreateQuery(ctx context.Context, query *Query) error {
+	query.ID = primitive.NewObjectID()
+	_, err := s.queries().InsertOne(ctx, query)
+	return err
+}
+
+func (s *mongoStore) UpdateQuery(ctx context.Context, id primitive.ObjectID, u QueryUpdate) (Query, error) {
+	set := bson.M{}
+	if u.Name != nil {
+		set["name"] = *u.Name
+	}
+	if u.SQL != nil {
+		set["sql"] = *u.SQL
+	}
+	if u.Description != nil {
+		set["description"] = *u.Description
+	}
+	if u.Folder != nil {
+		set["folder"] = *u.Folder
+	}
+	if u.Tags != nil {
+		set["tags"] = *u.Tags
+	}
+	if u.References != nil {
+		set["tables"] = u.References.Tables
+		set["databases"] = u.References.Databases
+		set["columns"] = u.References.Columns
+	}
+	if u.UpdatedAt != nil {
+		set["updatedAt"] = *u.UpdatedAt
+	}
+	if u.LastRunAt != nil {
+		set["lastRunAt"] = *u.LastRunAt
+	}
+
+	update := bson.M{}
+	unset := bson.M{}
+	for field, value := range map[string]*string{"catalog": u.Catalog, "database": u.Database, "workGroup": u.WorkGroup} {
+		if value == nil {
+			continue
+		}
+		if *value != "" {
+			set[field] = *value
+		} else {
+			unset[field] = ""
+		}
+	}
+	if u.ResultCacheTTL != nil {
+		if *u.ResultCacheTTL >= 0 {
+			set["resultCacheTtl"] = *u.ResultCacheTTL
+		} else {
+			unset["resultCacheTtl"] = ""
+		}
+	}
+	if u.References != nil {
+		if u.References.Lineage != nil {
+			set["lineage"] = u.References.Lineage
+		} else {
+			unset["lineage"] = ""
+		}
+	}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(u.AddTags) > 0 {
+		update["$addToSet"] = bson.M{"tags": bson.M{"$each": u.AddTags}}
+	}
+	if len(u.RemoveTags) > 0 {
+		update["$pull"] = bson.M{"tags": bson.M{"$in": u.RemoveTags}}
+	}
+
+	filter := bson.M{"_id": id, "deletedAt": nil}
+	if u.IfSQL != nil {
+		filter["sql"] = *u.IfSQL
+	}
+
+	if len(update) == 0 {
+		var query Query
+		err := s.queries().FindOne(ctx, filter).Decode(&query)
+		return query, mongoError(err)
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var query Query
+	err := s.queries().FindOneAndUpdate(ctx, filter, update, opts).Decode(&query)
+	return query, mongoError(err)
+}
+
+func (s *mongoStore) TrashQuery(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error {
+	trashed := bson.M{"$set": bson.M{"deletedAt": deletedAt, "deletedBy": deletedBy}}
+
+	result, err := s.queries().UpdateOne(ctx, bson.M{"_id": id, "deletedAt": nil}, trashed)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	_, err = s.queryRuns().UpdateMany(ctx, bson.M{"queryId": id, "deletedAt": nil}, trashed)
+	return err
+}
+
+func (s *mongoStore) RestoreQuery(ctx context.Context, id primitive.ObjectID) (Query, error) {
+	var query Query
+	err := s.queries().FindOne(ctx, bson.M{"_id": id, "deletedAt": bson.M{"$ne": nil}}).Decode(&query)
+	if err != nil {
+		return query, mongoError(err)
+	}
+
+	restored := bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}}
+
+	_, err = s.queryRuns().UpdateMany(ctx, bson.M{"queryId": id, "deletedAt": *query.DeletedAt}, restored)
+	if err != nil {
+		return query, err
+	}
+
+	_, err = s.queries().UpdateOne(ctx, bson.M{"_id": id}, restored)
+	if err != nil {
+		return query, err
+	}
+
+	query.DeletedAt = nil
+	query.DeletedBy = ""
+	return query, nil
+}
+
+func (s *mongoStore) DeleteQuery(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.queries().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	_, err = s.visualizations().DeleteMany(ctx, bson.M{"queryId": id})
+	return err
+}
+
+func (s *mongoStore) CountTags(ctx context.Context) ([]TagCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"deletedAt": nil}}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := s.queries().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tags := []TagCount{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (s *mongoStore) CountFolders(ctx context.Context) (map[string]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"folder": bson.M{"$nin": bson.A{"", nil}}, "deletedAt": nil}}},
+		{{Key: "$group", Value: bson.M{"_id": "$folder", "count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := s.queries().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Folder string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{}
+	for _, group := range groups {
+		counts[group.Folder] = group.Count
+	}
+	return counts, nil
+}
+
+func mongoTextSearchOptions(limit int) *options.FindOptions {
+	return options.Find().
+		SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}}).
+		SetLimit(int64(limit))
+}
+
+func (s *mongoStore) SearchQueries(ctx context.Context, text string, f SearchFilter, limit int) ([]SearchHit, error) {
+	filter := bson.M{"$text": bson.M{"$search": text}, "deletedAt": nil}
+	if f.Author != "" {
+		filter["createdBy"] = f.Author
+	}
+	if len(f.Tags) > 0 {
+		filter["tags"] = bson.M{"$all": f.Tags}
+	}
+	dateRangeCondition(filter, "lastRunAt", f.RanAfter, f.RanBefore)
+
+	cursor, err := s.queries().Find(ctx, filter, mongoTextSearchOptions(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []struct {
+		Query `bson:",inline"`
+		Score float64 `bson:"score"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	hits := make([]SearchHit, 0, len(docs))
+	for i := range docs {
+		query := docs[i].Query
+		hits = append(hits, SearchHit{Type: "query", Score: docs[i].Score, Query: &query})
+	}
+	return hits, nil
+}
+
+func (s *mongoStore) ListQueryRuns(ctx context.Context, filter QueryRunFilter, page pageRequest) ([]QueryRun, string, int64, error) {
+	return findPage(ctx, s.queryRuns(), mongoQueryRunFilter(filter), page, queryRunCursorFunc(page.Sort))
+}
+
+func (s *mongoStore) GetQueryRun(ctx context.Context, id primitive.ObjectID) (QueryRun, error) {
+	var run QueryRun
+	err := s.queryRuns().FindOne(ctx, bson.M{"_id": id}).Decode(&run)
+	return run, mongoError(err)
+}
+
+func (s *mongoStore) GetQueryRunByExecutionID(ctx context.Context, executionID string) (QueryRun, error) {
+	var run QueryRun
+	err := s.queryRuns().FindOne(ctx, bson.M{"executionId": executionID}).Decode(&run)
+	return run, mongoError(err)
+}
+
+func (s *mongoStore) CreateQueryRun(ctx context.Context, run *QueryRun) error {
+	run.ID = primitive.NewObjectID()
+	_, err := s.queryRuns().InsertOne(ctx, run)
+	return err
+}
+
+func (s *mongoStore) UpdateQueryRun(ctx context.Context, id primitive.ObjectID, u QueryRunUpdate) error {
+	set := bson.M{}
+	if u.Status != nil {
+		set["status"] = *u.Status
+	}
+	if u.ResultsS3URL != nil {
+		set["resultsS3Url"] = *u.ResultsS3URL
+	}
+	if u.ErrorMessage != nil {
+		set["errorMessage"] = *u.ErrorMessage
+	}
+	if u.CompletedAt != nil {
+		set["completedAt"] = *u.CompletedAt
+	}
+	if u.FromCache != nil {
+		set["fromCache"] = *u.FromCache
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	result, err := s.queryRuns().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) TrashQueryRun(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error {
+	result, err := s.queryRuns().UpdateOne(ctx, bson.M{"_id": id, "deletedAt": nil}, bson.M{
+		"$set": bson.M{"deletedAt": deletedAt, "deletedBy": deletedBy},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) RestoreQueryRun(ctx context.Context, id primitive.ObjectID) (QueryRun, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var run QueryRun
+	err := s.queryRuns().FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deletedAt": bson.M{"$ne": nil}},
+		bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}},
+		opts,
+	).Decode(&run)
+	return run, mongoError(err)
+}
+
+func (s *mongoStore) DeleteQueryRun(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.queryRuns().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	_, err = s.shareLinks().DeleteMany(ctx, bson.M{"runId": id})
+	return err
+}
+
+func (s *mongoStore) SearchQueryRuns(ctx context.Context, text string, f SearchFilter, limit int) ([]SearchHit, error) {
+	filter := bson.M{"$text": bson.M{"$search": text}, "deletedAt": nil}
+	if f.Author != "" {
+		filter["executedBy"] = f.Author
+	}
+	dateRangeCondition(filter, "executedAt", f.RanAfter, f.RanBefore)
+
+	if len(f.Tags) > 0 {
+		// Runs don't carry tags, so restrict them to runs of tagged queries
+		ids, err := s.queries().Distinct(ctx, "_id", bson.M{"tags": bson.M{"$all": f.Tags}})
+		if err != nil {
+			return nil, err
+		}
+		filter["queryId"] = bson.M{"$in": ids}
+	}
+
+	cursor, err := s.queryRuns().Find(ctx, filter, mongoTextSearchOptions(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []struct {
+		QueryRun `bson:",inline"`
+		Score    float64 `bson:"score"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	hits := make([]SearchHit, 0, len(docs))
+	for i := range docs {
+		run := docs[i].QueryRun
+		hits = append(hits, SearchHit{Type: "run", Score: docs[i].Score, Run: &run})
+	}
+	return hits, nil
+}
+
+func (s *mongoStore) CreateDownloadLink(ctx context.Context, link *DownloadLink) error {
+	link.ID = primitive.NewObjectID()
+	_, err := s.downloadLinks().InsertOne(ctx, link)
+	return err
+}
+
+func (s *mongoStore) ListDownloadLinks(ctx context.Context, executionID string) ([]DownloadLink, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "requestedAt", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := s.downloadLinks().Find(ctx, bson.M{"executionId": executionID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	links := []DownloadLink{}
+	if err := cursor.All(ctx, &links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (s *mongoStore) GetResultProfile(ctx context.Context, executionID string) (ResultProfile, error) {
+	var profile ResultProfile
+	err := s.resultProfiles().FindOne(ctx, bson.M{"_id": executionID}).Decode(&profile)
+	return profile, mongoError(err)
+}
+
+func (s *mongoStore) SaveResultProfile(ctx context.Context, profile *ResultProfile) error {
+	_, err := s.resultProfiles().ReplaceOne(ctx, bson.M{"_id": profile.ExecutionID}, profile,
+		options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoStore) ListVisualizations(ctx context.Context, queryID primitive.ObjectID) ([]Visualization, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.visualizations().Find(ctx, bson.M{"queryId": queryID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	charts := []Visualization{}
+	if err := cursor.All(ctx, &charts); err != nil {
+		return nil, err
+	}
+	return charts, nil
+}
+
+func (s *mongoStore) GetVisualization(ctx context.Context, id primitive.ObjectID) (Visualization, error) {
+	var visualization Visualization
+	err := s.visualizations().FindOne(ctx, bson.M{"_id": id}).Decode(&visualization)
+	return visualization, mongoError(err)
+}
+
+func (s *mongoStore) CreateVisualization(ctx context.Context, visualization *Visualization) error {
+	visualization.ID = primitive.NewObjectID()
+	_, err := s.visualizations().InsertOne(ctx, visualization)
+	return err
+}
+
+func (s *mongoStore) UpdateVisualization(ctx context.Context, visualization *Visualization) error {
+	set := bson.M{
+		"name":        visualization.Name,
+		"type":        visualization.Type,
+		"columns":     visualization.Columns,
+		"aggregation": visualization.Aggregation,
+		"updatedAt":   visualization.UpdatedAt,
+	}
+	update := bson.M{"$set": set}
+	if visualization.Options != nil {
+		set["options"] = visualization.Options
+	} else {
+		update["$unset"] = bson.M{"options": ""}
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.visualizations().FindOneAndUpdate(ctx, bson.M{"_id": visualization.ID}, update, opts).Decode(visualization)
+	return mongoError(err)
+}
+
+func (s *mongoStore) DeleteVisualization(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.visualizations().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) ListDashboards(ctx context.Context) ([]Dashboard, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}).
+		SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	cursor, err := s.dashboards().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	dashboards := []Dashboard{}
+	if err := cursor.All(ctx, &dashboards); err != nil {
+		return nil, err
+	}
+	return dashboards, nil
+}
+
+func (s *mongoStore) GetDashboard(ctx context.Context, id primitive.ObjectID) (Dashboard, error) {
+	var dashboard Dashboard
+	err := s.dashboards().FindOne(ctx, bson.M{"_id": id}).Decode(&dashboard)
+	return dashboard, mongoError(err)
+}
+
+func (s *mongoStore) CreateDashboard(ctx context.Context, dashboard *Dashboard) error {
+	dashboard.ID = primitive.NewObjectID()
+	_, err := s.dashboards().InsertOne(ctx, dashboard)
+	return err
+}
+
+func (s *mongoStore) UpdateDashboard(ctx context.Context, dashboard *Dashboard) error {
+	update := bson.M{"$set": bson.M{
+		"name":        dashboard.Name,
+		"description": dashboard.Description,
+		"parameters":  dashboard.Parameters,
+		"widgets":     dashboard.Widgets,
+		"updatedAt":   dashboard.UpdatedAt,
+	}}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.dashboards().FindOneAndUpdate(ctx, bson.M{"_id": dashboard.ID}, update, opts).Decode(dashboard)
+	return mongoError(err)
+}
+
+func (s *mongoStore) DeleteDashboard(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.dashboards().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) CreateShareLink(ctx context.Context, link *ShareLink) error {
+	link.ID = primitive.NewObjectID()
+	_, err := s.shareLinks().InsertOne(ctx, link)
+	return err
+}
+
+func (s *mongoStore) GetShareLink(ctx context.Context, id primitive.ObjectID) (ShareLink, error) {
+	var link ShareLink
+	err := s.shareLinks().FindOne(ctx, bson.M{"_id": id}).Decode(&link)
+	return link, mongoError(err)
+}
+
+func (s *mongoStore) ListShareLinks(ctx context.Context, runID primitive.ObjectID) ([]ShareLink, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := s.shareLinks().Find(ctx, bson.M{"runId": runID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	links := []ShareLink{}
+	if err := cursor.All(ctx, &links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (s *mongoStore) RevokeShareLink(ctx context.Context, id primitive.ObjectID, revokedBy string, revokedAt time.Time) (ShareLink, error) {
+	_, err := s.shareLinks().UpdateOne(ctx, bson.M{"_id": id, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": revokedAt, "revokedBy": revokedBy}})
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return s.GetShareLink(ctx, id)
+}
+
+// Helper function to select the snapshot of a table
+func tableSchemaFilter(schema TableSchema) bson.M {
+	return bson.M{"catalog": schema.Catalog, "database": schema.Database, "table": schema.Table}
+}
+
+func (s *mongoStore) ListTableSchemas(ctx context.Context, catalog string) ([]TableSchema, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "database", Value: 1}, {Key: "table", Value: 1}}).
+		SetProjection(bson.M{"_id": 0})
+	cursor, err := s.tableSchemas().Find(ctx, bson.M{"catalog": catalog}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	schemas := []TableSchema{}
+	if err := cursor.All(ctx, &schemas); err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+func (s *mongoStore) SaveTableSchemas(ctx context.Context, schemas []TableSchema) error {
+	if len(schemas) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, len(schemas))
+	for i, schema := range schemas {
+		models[i] = mongo.NewReplaceOneModel().SetFilter(tableSchemaFilter(schema)).SetReplacement(schema).SetUpsert(true)
+	}
+	_, err := s.tableSchemas().BulkWrite(ctx, models)
+	return err
+}
+
+func (s *mongoStore) DeleteTableSchemas(ctx context.Context, schemas []TableSchema) error {
+	if len(schemas) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, len(schemas))
+	for i, schema := range schemas {
+		models[i] = mongo.NewDeleteOneModel().SetFilter(tableSchemaFilter(schema))
+	}
+	_, err := s.tableSchemas().BulkWrite(ctx, models)
+	return err
+}
+
+func (s *mongoStore) CreateSchemaChanges(ctx context.Context, changes []SchemaChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(changes))
+	for i := range changes {
+		changes[i].ID = primitive.NewObjectID()
+		documents[i] = changes[i]
+	}
+	_, err := s.schemaChanges().InsertMany(ctx, documents)
+	return err
+}
+
+func (s *mongoStore) ListSchemaChanges(ctx context.Context, filter SchemaChangeFilter, limit int) ([]SchemaChange, error) {
+	query := bson.M{}
+	if filter.Catalog != "" {
+		query["catalog"] = filter.Catalog
+	}
+	if filter.Database != "" {
+		query["database"] = filter.Database
+	}
+	if filter.Table != "" {
+		query["table"] = filter.Table
+	}
+	dateRangeCondition(query, "detectedAt", filter.Since, nil)
+
+	// Object IDs grow within a second, so changes detected together keep their order
+	opts := options.Find().SetSort(bson.D{{Key: "detectedAt", Value: -1}, {Key: "_id", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := s.schemaChanges().Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	changes := []SchemaChange{}
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
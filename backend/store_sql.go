@@ -0,0 +1,1549 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	_ "modernc.org/sqlite"
+)
+
+// How many rows are scored in Go when searching without a text index
+const sqlSearchCandidates = 500
+
+// sqlStore keeps queries and runs in SQLite (single node) or Postgres (HA).
+// Timestamps are stored as Unix milliseconds and lists as JSON text so both
+// databases share the same schema and statements.
+type sqlStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+// Schema migrations, applied in order and recorded in schema_migrations.
+// Never edit a migration that has been released; append a new one instead.
+var sqlMigrations = [][]string{
+	{
+		`CREATE TABLE queries (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			sql_text TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			folder TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL DEFAULT '[]',
+			referenced_tables TEXT NOT NULL DEFAULT '[]',
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL,
+			last_run_at BIGINT,
+			deleted_at BIGINT,
+			deleted_by TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX queries_folder ON queries (folder)`,
+		`CREATE INDEX queries_updated_at ON queries (updated_at)`,
+		`CREATE TABLE query_tags (
+			query_id TEXT NOT NULL,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (query_id, tag)
+		)`,
+		`CREATE INDEX query_tags_tag ON query_tags (tag)`,
+		`CREATE TABLE query_runs (
+			id TEXT PRIMARY KEY,
+			query_id TEXT NOT NULL,
+			sql_text TEXT NOT NULL DEFAULT '',
+			execution_id TEXT NOT NULL,
+			status TEXT NOT NULL,
+			results_s3_url TEXT NOT NULL DEFAULT '',
+			error_message TEXT NOT NULL DEFAULT '',
+			parameters TEXT NOT NULL DEFAULT '{}',
+			executed_by TEXT NOT NULL DEFAULT '',
+			executed_at BIGINT NOT NULL,
+			completed_at BIGINT,
+			deleted_at BIGINT,
+			deleted_by TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX query_runs_query_id ON query_runs (query_id, executed_at)`,
+		`CREATE INDEX query_runs_execution_id ON query_runs (execution_id)`,
+	},
+	{
+		`ALTER TABLE queries ADD COLUMN result_cache_ttl INTEGER`,
+		`ALTER TABLE query_runs ADD COLUMN cache_key TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE query_runs ADD COLUMN from_cache BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE INDEX query_runs_cache_key ON query_runs (cache_key, executed_at)`,
+	},
+	{
+		`CREATE TABLE download_links (
+			id TEXT PRIMARY KEY,
+			execution_id TEXT NOT NULL,
+			results_s3_url TEXT NOT NULL,
+			requested_by TEXT NOT NULL DEFAULT '',
+			client_ip TEXT NOT NULL DEFAULT '',
+			requested_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX download_links_execution_id ON download_links (execution_id, requested_at)`,
+	},
+	{
+		`CREATE TABLE result_profiles (
+			execution_id TEXT PRIMARY KEY,
+			profile TEXT NOT NULL,
+			computed_at BIGINT NOT NULL
+		)`,
+	},
+	{
+		`CREATE TABLE visualizations (
+			id TEXT PRIMARY KEY,
+			query_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			columns TEXT NOT NULL,
+			aggregation TEXT NOT NULL,
+			options TEXT NOT NULL DEFAULT 'null',
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX visualizations_query_id ON visualizations (query_id, created_at)`,
+	},
+	{
+		`CREATE TABLE dashboards (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			parameters TEXT NOT NULL DEFAULT '[]',
+			widgets TEXT NOT NULL DEFAULT '[]',
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL
+		)`,
+	},
+	{
+		`CREATE TABLE share_links (
+			id TEXT PRIMARY KEY,
+			run_id TEXT NOT NULL,
+			permission TEXT NOT NULL,
+			password_hash TEXT NOT NULL DEFAULT '',
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL,
+			revoked_at BIGINT,
+			revoked_by TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX share_links_run_id ON share_links (run_id, created_at)`,
+	},
+	{
+		`ALTER TABLE queries ADD COLUMN catalog_name TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE queries ADD COLUMN database_name TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE queries ADD COLUMN work_group TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE query_runs ADD COLUMN catalog_name TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE query_runs ADD COLUMN database_name TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE query_runs ADD COLUMN work_group TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		`CREATE TABLE table_schemas (
+			catalog_name TEXT NOT NULL,
+			database_name TEXT NOT NULL,
+			table_name TEXT NOT NULL,
+			columns TEXT NOT NULL DEFAULT '[]',
+			partition_keys TEXT NOT NULL DEFAULT '[]',
+			snapshot_at BIGINT NOT NULL,
+			PRIMARY KEY (catalog_name, database_name, table_name)
+		)`,
+		`CREATE TABLE schema_changes (
+			id TEXT PRIMARY KEY,
+			catalog_name TEXT NOT NULL,
+			database_name TEXT NOT NULL,
+			table_name TEXT NOT NULL,
+			change_type TEXT NOT NULL,
+			column_name TEXT NOT NULL DEFAULT '',
+			old_type TEXT NOT NULL DEFAULT '',
+			new_type TEXT NOT NULL DEFAULT '',
+			detected_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX schema_changes_table ON schema_changes (catalog_name, database_name, table_name, detected_at)`,
+		`CREATE INDEX schema_changes_detected_at ON schema_changes (detected_at)`,
+	},
+	{
+		`ALTER TABLE queries ADD COLUMN referenced_databases TEXT NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE queries ADD COLUMN referenced_columns TEXT NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE queries ADD COLUMN lineage TEXT NOT NULL DEFAULT ''`,
+	},
+}
+
+// Sort expressions for the fields of querySortFields, queryRunSortFields and
+// trashSortFields. Missing timestamps sort first like they do in Mongo.
+var sqlSortExpressions = map[string]string{
+	"updatedAt":   "updated_at",
+	"createdAt":   "created_at",
+	"name":        "LOWER(name)",
+	"lastRunAt":   "COALESCE(last_run_at, -1)",
+	"deletedAt":   "COALESCE(deleted_at, -1)",
+	"executedAt":  "executed_at",
+	"completedAt": "COALESCE(completed_at, -1)",
+}
+
+const sqlQueryColumns = `id, name, sql_text, description, folder, tags, referenced_tables, created_by,
+	created_at, updated_at, last_run_at, deleted_at, deleted_by, result_cache_ttl, catalog_name, database_name,
+	work_group, referenced_databases, referenced_columns, lineage`
+
+const sqlVisualizationColumns = `id, query_id, name, type, columns, aggregation, options, created_by,
+	created_at, updated_at`
+
+const sqlDashboardColumns = `id, name, description, parameters, widgets, created_by, created_at, updated_at`
+
+const sqlShareLinkColumns = `id, run_id, permission, password_hash, created_by, created_at, expires_at,
+	revoked_at, revoked_by`
+
+const sqlTableSchemaColumns = `catalog_name, database_name, table_name, columns, partition_keys, snapshot_at`
+
+const sqlSchemaChangeColumns = `id, catalog_name, database_name, table_name, change_type, column_name, old_type,
+	new_type, detected_at`
+
+const sqlQueryRunColumns = `id, query_id, sql_text, execution_id, status, results_s3_url, error_message,
+	parameters, executed_by, executed_at, completed_at, deleted_at, deleted_by, cache_key, from_cache, catalog_name,
+	database_name, work_group`
+
+func newSQLStore(ctx context.Context, dialect, dsn string) (*sqlStore, error) {
+	db, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if dialect == "sqlite" {
+		// SQLite allows a single writer; serializing access avoids "database is locked"
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s := &sqlStore{db: db, dialect: dialect}
+	if err := s.migrate(ctx); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Connected to %s!", dialect)
+	return s, nil
+}
+
+func (s *sqlStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+// Helper function to rewrite ? placeholders into $1, $2... for Postgres
+func (s *sqlStore) rebind(query string) string {
+	if s.dialect != "postgres" {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Helper function to apply the migrations that haven't been applied yet
+func (s *sqlStore) migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	var current int
+	err = s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for version := current + 1; version <= len(sqlMigrations); version++ {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		for _, statement := range sqlMigrations[version-1] {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d failed: %v", version, err)
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`),
+			version, toMillis(time.Now()))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Applied schema migration %d", version)
+	}
+
+	return nil
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.UnixMilli(ms).UTC()
+}
+
+// Helper function to round times to the milliseconds the database keeps, so the
+// values a write leaves in its argument are the ones later reads return
+func truncateMillis(times ...*time.Time) {
+	for _, t := range times {
+		if t != nil {
+			*t = t.Truncate(time.Millisecond)
+		}
+	}
+}
+
+func nullableMillis(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return toMillis(*t)
+}
+
+func nullableInt(value *int) interface{} {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+func timeFromNullable(ms sql.NullInt64) *time.Time {
+	if !ms.Valid {
+		return nil
+	}
+	t := fromMillis(ms.Int64)
+	return &t
+}
+
+func toJSONText(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// Helper function to escape LIKE wildcards; statements use ESCAPE '\'
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Conditions of a WHERE clause and their arguments
+type sqlWhere struct {
+	clauses []string
+	args    []interface{}
+}
+
+func (w *sqlWhere) add(clause string, args ...interface{}) {
+	w.clauses = append(w.clauses, clause)
+	w.args = append(w.args, args...)
+}
+
+func (w *sqlWhere) String() string {
+	if len(w.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.clauses, " AND ")
+}
+
+func (w *sqlWhere) dateRange(column string, after, before *time.Time) {
+	if after != nil {
+		w.add(column+" >= ?", toMillis(*after))
+	}
+	if before != nil {
+		w.add(column+" < ?", toMillis(*before))
+	}
+}
+
+func (w *sqlWhere) trash(trash trashState, deletedBefore *time.Time) {
+	switch trash {
+	case notTrashed:
+		w.add("deleted_at IS NULL")
+	case onlyTrashed:
+		w.add("deleted_at IS NOT NULL")
+	}
+	if deletedBefore != nil {
+		w.add("deleted_at < ?", toMillis(*deletedBefore))
+	}
+}
+
+func (w *sqlWhere) in(column string, values []string) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	w.add(column+" IN ("+placeholders+")", args...)
+}
+
+// Helper function to restrict a column holding query IDs to queries carrying all tags
+func (w *sqlWhere) taggedWith(column string, tags []string) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(tags)), ", ")
+	args := make([]interface{}, 0, len(tags)+1)
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	args = append(args, len(tags))
+	w.add(column+" IN (SELECT query_id FROM query_tags WHERE tag IN ("+placeholders+
+		") GROUP BY query_id HAVING COUNT(*) = ?)", args...)
+}
+
+func sqlQueryWhere(f QueryFilter) *sqlWhere {
+	w := &sqlWhere{}
+
+	if f.Folder != nil {
+		switch {
+		case !f.Recursive:
+			w.add("folder = ?", *f.Folder)
+		case *f.Folder != "":
+			w.add(`(folder = ? OR folder LIKE ? ESCAPE '\')`, *f.Folder, likeEscape(*f.Folder)+"/%")
+		}
+	}
+
+	if len(f.Tags) > 0 {
+		w.taggedWith("id", f.Tags)
+	}
+
+	w.dateRange("created_at", f.CreatedAfter, f.CreatedBefore)
+	w.dateRange("updated_at", f.UpdatedAfter, f.UpdatedBefore)
+	w.trash(f.Trash, f.DeletedBefore)
+	return w
+}
+
+func sqlQueryRunWhere(f QueryRunFilter) *sqlWhere {
+	w := &sqlWhere{}
+
+	if f.QueryID != nil {
+		w.add("query_id = ?", f.QueryID.Hex())
+	}
+	if len(f.Statuses) > 0 {
+		w.in("status", f.Statuses)
+	}
+	if f.CacheKey != "" {
+		w.add("cache_key = ?", f.CacheKey)
+	}
+	if f.ResultsS3URL != "" {
+		w.add("results_s3_url = ?", f.ResultsS3URL)
+	}
+
+	w.dateRange("executed_at", f.ExecutedAfter, f.ExecutedBefore)
+	w.trash(f.Trash, f.DeletedBefore)
+	return w
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanQuery(row rowScanner) (Query, error) {
+	var query Query
+	var id, tags, tables, databases, columns, lineage string
+	var createdAt, updatedAt int64
+	var lastRunAt, deletedAt, resultCacheTTL sql.NullInt64
+
+	err := row.Scan(&id, &query.Name, &query.SQL, &query.Description, &query.Folder, &tags, &tables,
+		&query.CreatedBy, &createdAt, &updatedAt, &lastRunAt, &deletedAt, &query.DeletedBy, &resultCacheTTL,
+		&query.Catalog, &query.Database, &query.WorkGroup, &databases, &columns, &lineage)
+	if err == sql.ErrNoRows {
+		return query, ErrNotFound
+	}
+	if err != nil {
+		return query, err
+	}
+
+	query.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return query, err
+	}
+	if err := json.Unmarshal([]byte(tags), &query.Tags); err != nil {
+		return query, err
+	}
+	if err := json.Unmarshal([]byte(tables), &query.Tables); err != nil {
+		return query, err
+	}
+	if err := json.Unmarshal([]byte(databases), &query.Databases); err != nil {
+		return query, err
+	}
+	if err := json.Unmarshal([]byte(columns), &query.Columns); err != nil {
+		return query, err
+	}
+	// Queries saved before lineage was parsed have none until they're parsed again
+	if lineage != "" {
+		if err := json.Unmarshal([]byte(lineage), &query.Lineage); err != nil {
+			return query, err
+		}
+	}
+	query.CreatedAt = fromMillis(createdAt)
+	query.UpdatedAt = fromMillis(updatedAt)
+	query.LastRunAt = timeFromNullable(lastRunAt)
+	query.DeletedAt = timeFromNullable(deletedAt)
+	if resultCacheTTL.Valid {
+		ttl := int(resultCacheTTL.Int64)
+		query.ResultCacheTTL = &ttl
+	}
+	return query, nil
+}
+
+func scanQueryRun(row rowScanner) (QueryRun, error) {
+	var run QueryRun
+	var id, queryID, parameters string
+	var executedAt int64
+	var completedAt, deletedAt sql.NullInt64
+
+	err := row.Scan(&id, &queryID, &run.SQL, &run.ExecutionID, &run.Status, &run.ResultsS3URL, &run.ErrorMessage,
+		&parameters, &run.ExecutedBy, &executedAt, &completedAt, &deletedAt, &run.DeletedBy, &run.CacheKey, &run.FromCache,
+		&run.Catalog, &run.Database, &run.WorkGroup)
+	if err == sql.ErrNoRows {
+		return run, ErrNotFound
+	}
+	if err != nil {
+		return run, err
+	}
+
+	if run.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return run, err
+	}
+	if run.QueryID, err = primitive.ObjectIDFromHex(queryID); err != nil {
+		return run, err
+	}
+	if err := json.Unmarshal([]byte(parameters), &run.Parameters); err != nil {
+		return run, err
+	}
+	run.ExecutedAt = fromMillis(executedAt)
+	run.CompletedAt = timeFromNullable(completedAt)
+	run.DeletedAt = timeFromNullable(deletedAt)
+	return run, nil
+}
+
+func scanVisualization(row rowScanner) (Visualization, error) {
+	var visualization Visualization
+	var id, queryID, columns, options string
+	var createdAt, updatedAt int64
+
+	err := row.Scan(&id, &queryID, &visualization.Name, &visualization.Type, &columns, &visualization.Aggregation,
+		&options, &visualization.CreatedBy, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return visualization, ErrNotFound
+	}
+	if err != nil {
+		return visualization, err
+	}
+
+	if visualization.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return visualization, err
+	}
+	if visualization.QueryID, err = primitive.ObjectIDFromHex(queryID); err != nil {
+		return visualization, err
+	}
+	if err := json.Unmarshal([]byte(columns), &visualization.Columns); err != nil {
+		return visualization, err
+	}
+	if err := json.Unmarshal([]byte(options), &visualization.Options); err != nil {
+		return visualization, err
+	}
+	visualization.CreatedAt = fromMillis(createdAt)
+	visualization.UpdatedAt = fromMillis(updatedAt)
+	return visualization, nil
+}
+
+func scanDashboard(row rowScanner) (Dashboard, error) {
+	var dashboard Dashboard
+	var id, parameters, widgets string
+	var createdAt, updatedAt int64
+
+	err := row.Scan(&id, &dashboard.Name, &dashboard.Description, &parameters, &widgets, &dashboard.CreatedBy,
+		&createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return dashboard, ErrNotFound
+	}
+	if err != nil {
+		return dashboard, err
+	}
+
+	if dashboard.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return dashboard, err
+	}
+	if err := json.Unmarshal([]byte(parameters), &dashboard.Parameters); err != nil {
+		return dashboard, err
+	}
+	if err := json.Unmarshal([]byte(widgets), &dashboard.Widgets); err != nil {
+		return dashboard, err
+	}
+	dashboard.CreatedAt = fromMillis(createdAt)
+	dashboard.UpdatedAt = fromMillis(updatedAt)
+	return dashboard, nil
+}
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var link ShareLink
+	var id, runID string
+	var createdAt, expiresAt int64
+	var revokedAt sql.NullInt64
+
+	err := row.Scan(&id, &runID, &link.Permission, &link.PasswordHash, &link.CreatedBy, &createdAt, &expiresAt,
+		&revokedAt, &link.RevokedBy)
+	if err == sql.ErrNoRows {
+		return link, ErrNotFound
+	}
+	if err != nil {
+		return link, err
+	}
+
+	if link.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return link, err
+	}
+	if link.RunID, err = primitive.ObjectIDFromHex(runID); err != nil {
+		return link, err
+	}
+	link.CreatedAt = fromMillis(createdAt)
+	link.ExpiresAt = fromMillis(expiresAt)
+	link.RevokedAt = timeFromNullable(revokedAt)
+	return link, nil
+}
+
+func scanTableSchema(row rowScanner) (TableSchema, error) {
+	var schema TableSchema
+	var columns, partitionKeys string
+	var snapshotAt int64
+
+	err := row.Scan(&schema.Catalog, &schema.Database, &schema.Table, &columns, &partitionKeys, &snapshotAt)
+	if err != nil {
+		return schema, err
+	}
+
+	if err := json.Unmarshal([]byte(columns), &schema.Columns); err != nil {
+		return schema, err
+	}
+	if err := json.Unmarshal([]byte(partitionKeys), &schema.PartitionKeys); err != nil {
+		return schema, err
+	}
+	schema.SnapshotAt = fromMillis(snapshotAt)
+	return schema, nil
+}
+
+func scanSchemaChange(row rowScanner) (SchemaChange, error) {
+	var change SchemaChange
+	var id string
+	var detectedAt int64
+
+	err := row.Scan(&id, &change.Catalog, &change.Database, &change.Table, &change.Type, &change.Column,
+		&change.OldType, &change.NewType, &detectedAt)
+	if err != nil {
+		return change, err
+	}
+
+	if change.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return change, err
+	}
+	change.DetectedAt = fromMillis(detectedAt)
+	return change, nil
+}
+
+// Helper function to fetch one page of a table with keyset pagination
+func sqlPage[T any](ctx context.Context, s *sqlStore, table, columns string, where *sqlWhere, page pageRequest,
+	scan func(rowScanner) (T, error), cursorOf func(T) pageCursor) ([]T, string, int64, error) {
+	var total int64
+	err := s.db.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM "+table+where.String()), where.args...).Scan(&total)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	sortExpression, ok := sqlSortExpressions[page.Sort.Field]
+	if !ok {
+		return nil, "", 0, fmt.Errorf("cannot sort on %s", page.Sort.Field)
+	}
+	direction, operator := "ASC", ">"
+	if page.Desc {
+		direction, operator = "DESC", "<"
+	}
+
+	pageWhere := &sqlWhere{clauses: append([]string{}, where.clauses...), args: append([]interface{}{}, where.args...)}
+	if page.After != nil {
+		var value interface{} = int64(-1)
+		switch v := page.After.Value.(type) {
+		case time.Time:
+			value = toMillis(v)
+		case string:
+			value = strings.ToLower(v)
+		}
+		pageWhere.add(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", sortExpression, operator),
+			value, value, page.After.ID.Hex())
+	}
+
+	statement := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s %s, id %s",
+		columns, table, pageWhere.String(), sortExpression, direction, direction)
+	if page.Limit > 0 {
+		statement += " LIMIT " + strconv.Itoa(page.Limit+1)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(statement), pageWhere.args...)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer rows.Close()
+
+	items := []T{}
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", 0, err
+	}
+
+	next := ""
+	if page.Limit > 0 && len(items) > page.Limit {
+		items = items[:page.Limit]
+		next = encodeCursor(cursorOf(items[len(items)-1]))
+	}
+
+	return items, next, total, nil
+}
+
+// Helper function to replace the rows of query_tags for a query
+func writeQueryTags(ctx context.Context, s *sqlStore, tx *sql.Tx, id string, tags []string) error {
+	if _, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM query_tags WHERE query_id = ?`), id); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO query_tags (query_id, tag) VALUES (?, ?)`), id, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) ListQueries(ctx context.Context, filter QueryFilter, page pageRequest) ([]Query, string, int64, error) {
+	return sqlPage(ctx, s, "queries", sqlQueryColumns, sqlQueryWhere(filter), page, scanQuery, queryCursorFunc(page.Sort))
+}
+
+func (s *sqlStore) GetQuery(ctx context.Context, id primitive.ObjectID) (Query, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind("SELECT "+sqlQueryColumns+" FROM queries WHERE id = ?"), id.Hex())
+	return scanQuery(row)
+}
+
+func (s *sqlStore) CreateQuery(ctx context.Context, query *Query) error {
+	query.ID = primitive.NewObjectID()
+	truncateMillis(&query.CreatedAt, &query.UpdatedAt, query.LastRunAt, query.DeletedAt)
+	if query.Tags == nil {
+		query.Tags = []string{}
+	}
+	if query.Tables == nil {
+		query.Tables = []string{}
+	}
+	if query.Databases == nil {
+		query.Databases = []string{}
+	}
+	if query.Columns == nil {
+		query.Columns = []string{}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO queries (`+sqlQueryColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		query.ID.Hex(), query.Name, query.SQL, query.Description, query.Folder, toJSONText(query.Tags),
+		toJSONText(query.Tables), query.CreatedBy, toMillis(query.CreatedAt), toMillis(query.UpdatedAt),
+		nullableMillis(query.LastRunAt), nullableMillis(query.DeletedAt), query.DeletedBy, nullableInt(query.ResultCacheTTL),
+		query.Catalog, query.Database, query.WorkGroup, toJSONText(query.Databases), toJSONText(query.Columns),
+		toJSONText(query.Lineage))
+	if err != nil {
+		return err
+	}
+
+	if err := writeQueryTags(ctx, s, tx, query.ID.Hex(), query.Tags); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) UpdateQuery(ctx context.Context, id primitive.ObjectID, update QueryUpdate) (Query, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Query{}, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, s.rebind("SELECT "+sqlQueryColumns+" FROM queries WHERE id = ? AND deleted_at IS NULL"), id.Hex())
+	query, err := scanQuery(row)
+	if err != nil {
+		return query, err
+	}
+	previousSQL := query.SQL
+	if update.IfSQL != nil && previousSQL != *update.IfSQL {
+		return Query{}, ErrNotFound
+	}
+
+	applyQueryUpdate(&query, update)
+	truncateMillis(&query.UpdatedAt, query.LastRunAt)
+
+	// The SQL is compared again, as the row isn't locked while it is read
+	result, err := tx.ExecContext(ctx, s.rebind(`UPDATE queries SET name = ?, sql_text = ?, description = ?, folder = ?,
+		tags = ?, referenced_tables = ?, updated_at = ?, last_run_at = ?, result_cache_ttl = ?, catalog_name = ?,
+		database_name = ?, work_group = ?, referenced_databases = ?, referenced_columns = ?, lineage = ?
+		WHERE id = ? AND sql_text = ?`),
+		query.Name, query.SQL, query.Description, query.Folder, toJSONText(query.Tags), toJSONText(query.Tables),
+		toMillis(query.UpdatedAt), nullableMillis(query.LastRunAt), nullableInt(query.ResultCacheTTL), query.Catalog,
+		query.Database, query.WorkGroup, toJSONText(query.Databases), toJSONText(query.Columns),
+		toJSONText(query.Lineage), id.Hex(), previousSQL)
+	if err != nil {
+		return query, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return query, err
+	} else if affected == 0 {
+		return Query{}, ErrNotFound
+	}
+
+	if update.Tags != nil || len(update.AddTags) > 0 || len(update.RemoveTags) > 0 {
+		if err := writeQueryTags(ctx, s, tx, id.Hex(), query.Tags); err != nil {
+			return query, err
+		}
+	}
+
+	return query, tx.Commit()
+}
+
+func (s *sqlStore) TrashQuery(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, s.rebind(`UPDATE queries SET deleted_at = ?, deleted_by = ?
+		WHERE id = ? AND deleted_at IS NULL`), toMillis(deletedAt), deletedBy, id.Hex())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, s.rebind(`UPDATE query_runs SET deleted_at = ?, deleted_by = ?
+		WHERE query_id = ? AND deleted_at IS NULL`), toMillis(deletedAt), deletedBy, id.Hex())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) RestoreQuery(ctx context.Context, id primitive.ObjectID) (Query, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Query{}, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, s.rebind("SELECT "+sqlQueryColumns+" FROM queries WHERE id = ? AND deleted_at IS NOT NULL"), id.Hex())
+	query, err := scanQuery(row)
+	if err != nil {
+		return query, err
+	}
+
+	_, err = tx.ExecContext(ctx, s.rebind(`UPDATE query_runs SET deleted_at = NULL, deleted_by = ''
+		WHERE query_id = ? AND deleted_at = ?`), id.Hex(), toMillis(*query.DeletedAt))
+	if err != nil {
+		return query, err
+	}
+
+	_, err = tx.ExecContext(ctx, s.rebind(`UPDATE queries SET deleted_at = NULL, deleted_by = '' WHERE id = ?`), id.Hex())
+	if err != nil {
+		return query, err
+	}
+
+	query.DeletedAt = nil
+	query.DeletedBy = ""
+	return query, tx.Commit()
+}
+
+func (s *sqlStore) DeleteQuery(ctx context.Context, id primitive.ObjectID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM queries WHERE id = ?`), id.Hex())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNotFound
+	}
+
+	if err := writeQueryTags(ctx, s, tx, id.Hex(), nil); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM visualizations WHERE query_id = ?`), id.Hex()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) CountTags(ctx context.Context) ([]TagCount, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT t.tag, COUNT(*) FROM query_tags t
+		JOIN queries q ON q.id = t.query_id
+		WHERE q.deleted_at IS NULL
+		GROUP BY t.tag
+		ORDER BY COUNT(*) DESC, t.tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Tag, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func (s *sqlStore) CountFolders(ctx context.Context) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT folder, COUNT(*) FROM queries
+		WHERE deleted_at IS NULL AND folder <> ''
+		GROUP BY folder`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int64{}
+	for rows.Next() {
+		var folder string
+		var count int64
+		if err := rows.Scan(&folder, &count); err != nil {
+			return nil, err
+		}
+		counts[folder] = count
+	}
+	return counts, rows.Err()
+}
+
+// Helper function to match any of the search terms in any of the columns
+func (w *sqlWhere) anyTerm(terms []string, columns ...string) {
+	var conditions []string
+	var args []interface{}
+	for _, term := range terms {
+		for _, column := range columns {
+			conditions = append(conditions, "LOWER("+column+`) LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+likeEscape(term)+"%")
+		}
+	}
+	w.add("("+strings.Join(conditions, " OR ")+")", args...)
+}
+
+func (s *sqlStore) SearchQueries(ctx context.Context, text string, f SearchFilter, limit int) ([]SearchHit, error) {
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return []SearchHit{}, nil
+	}
+
+	w := &sqlWhere{}
+	w.add("deleted_at IS NULL")
+	w.anyTerm(terms, "name", "description", "sql_text", "referenced_tables")
+	if f.Author != "" {
+		w.add("created_by = ?", f.Author)
+	}
+	if len(f.Tags) > 0 {
+		w.taggedWith("id", f.Tags)
+	}
+	w.dateRange("last_run_at", f.RanAfter, f.RanBefore)
+
+	statement := "SELECT " + sqlQueryColumns + " FROM queries" + w.String() +
+		" ORDER BY updated_at DESC LIMIT " + strconv.Itoa(sqlSearchCandidates)
+	rows, err := s.db.QueryContext(ctx, s.rebind(statement), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []SearchHit{}
+	for rows.Next() {
+		query, err := scanQuery(rows)
+		if err != nil {
+			return nil, err
+		}
+		score := scoreSearchTerms(terms, querySearchWeights, map[string]string{
+			"name":        query.Name,
+			"description": query.Description,
+			"sql":         query.SQL,
+			"tables":      strings.Join(query.Tables, " "),
+		})
+		hits = append(hits, SearchHit{Type: "query", Score: score, Query: &query})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return topSearchHits(hits, limit), nil
+}
+
+func (s *sqlStore) ListQueryRuns(ctx context.Context, filter QueryRunFilter, page pageRequest) ([]QueryRun, string, int64, error) {
+	return sqlPage(ctx, s, "query_runs", sqlQueryRunColumns, sqlQueryRunWhere(filter), page, scanQueryRun, queryRunCursorFunc(page.Sort))
+}
+
+func (s *sqlStore) GetQueryRun(ctx context.Context, id primitive.ObjectID) (QueryRun, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind("SELECT "+sqlQueryRunColumns+" FROM query_runs WHERE id = ?"), id.Hex())
+	return scanQueryRun(row)
+}
+
+func (s *sqlStore) GetQueryRunByExecutionID(ctx context.Context, executionID string) (QueryRun, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind("SELECT "+sqlQueryRunColumns+" FROM query_runs WHERE execution_id = ?"), executionID)
+	return scanQueryRun(row)
+}
+
+func (s *sqlStore) CreateQueryRun(ctx context.Context, run *QueryRun) error {
+	run.ID = primitive.NewObjectID()
+	truncateMillis(&run.ExecutedAt, run.CompletedAt, run.DeletedAt)
+	parameters := run.Parameters
+	if parameters == nil {
+		parameters = map[string]string{}
+	}
+
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO query_runs (`+sqlQueryRunColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		run.ID.Hex(), run.QueryID.Hex(), run.SQL, run.ExecutionID, run.Status, run.ResultsS3URL, run.ErrorMessage,
+		toJSONText(parameters), run.ExecutedBy, toMillis(run.ExecutedAt), nullableMillis(run.CompletedAt),
+		nullableMillis(run.DeletedAt), run.DeletedBy, run.CacheKey, run.FromCache, run.Catalog, run.Database, run.WorkGroup)
+	return err
+}
+
+func (s *sqlStore) UpdateQueryRun(ctx context.Context, id primitive.ObjectID, update QueryRunUpdate) error {
+	var sets []string
+	var args []interface{}
+	if update.Status != nil {
+		sets = append(sets, "status = ?")
+		args = append(args, *update.Status)
+	}
+	if update.ResultsS3URL != nil {
+		sets = append(sets, "results_s3_url = ?")
+		args = append(args, *update.ResultsS3URL)
+	}
+	if update.ErrorMessage != nil {
+		sets = append(sets, "error_message = ?")
+		args = append(args, *update.ErrorMessage)
+	}
+	if update.CompletedAt != nil {
+		sets = append(sets, "completed_at = ?")
+		args = append(args, toMillis(*update.CompletedAt))
+	}
+	if update.FromCache != nil {
+		sets = append(sets, "from_cache = ?")
+		args = append(args, *update.FromCache)
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	args = append(args, id.Hex())
+	result, err := s.db.ExecContext(ctx, s.rebind("UPDATE query_runs SET "+strings.Join(sets, ", ")+" WHERE id = ?"), args...)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) TrashQueryRun(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error {
+	result, err := s.db.ExecContext(ctx, s.rebind(`UPDATE query_runs SET deleted_at = ?, deleted_by = ?
+		WHERE id = ? AND deleted_at IS NULL`), toMillis(deletedAt), deletedBy, id.Hex())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) RestoreQueryRun(ctx context.Context, id primitive.ObjectID) (QueryRun, error) {
+	result, err := s.db.ExecContext(ctx, s.rebind(`UPDATE query_runs SET deleted_at = NULL, deleted_by = ''
+		WHERE id = ? AND deleted_at IS NOT NULL`), id.Hex())
+	if err != nil {
+		return QueryRun{}, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return QueryRun{}, err
+	} else if affected == 0 {
+		return QueryRun{}, ErrNotFound
+	}
+	return s.GetQueryRun(ctx, id)
+}
+
+func (s *sqlStore) DeleteQueryRun(ctx context.Context, id primitive.ObjectID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM query_runs WHERE id = ?`), id.Hex())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM share_links WHERE run_id = ?`), id.Hex()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) SearchQueryRuns(ctx context.Context, text string, f SearchFilter, limit int) ([]SearchHit, error) {
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return []SearchHit{}, nil
+	}
+
+	w := &sqlWhere{}
+	w.add("deleted_at IS NULL")
+	w.anyTerm(terms, "sql_text")
+	if f.Author != "" {
+		w.add("executed_by = ?", f.Author)
+	}
+	if len(f.Tags) > 0 {
+		w.taggedWith("query_id", f.Tags)
+	}
+	w.dateRange("executed_at", f.RanAfter, f.RanBefore)
+
+	statement := "SELECT " + sqlQueryRunColumns + " FROM query_runs" + w.String() +
+		" ORDER BY executed_at DESC LIMIT " + strconv.Itoa(sqlSearchCandidates)
+	rows, err := s.db.QueryContext(ctx, s.rebind(statement), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []SearchHit{}
+	for rows.Next() {
+		run, err := scanQueryRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		score := scoreSearchTerms(terms, map[string]float64{"sql": 1}, map[string]string{"sql": run.SQL})
+		hits = append(hits, SearchHit{Type: "run", Score: score, Run: &run})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return topSearchHits(hits, limit), nil
+}
+
+func (s *sqlStore) CreateDownloadLink(ctx context.Context, link *DownloadLink) error {
+	link.ID = primitive.NewObjectID()
+	truncateMillis(&link.RequestedAt, &link.ExpiresAt)
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO download_links (id, execution_id, results_s3_url,
+		requested_by, client_ip, requested_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		link.ID.Hex(), link.ExecutionID, link.ResultsS3URL, link.RequestedBy, link.ClientIP,
+		toMillis(link.RequestedAt), toMillis(link.ExpiresAt))
+	return err
+}
+
+func (s *sqlStore) ListDownloadLinks(ctx context.Context, executionID string) ([]DownloadLink, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id, execution_id, results_s3_url, requested_by, client_ip,
+		requested_at, expires_at FROM download_links WHERE execution_id = ? ORDER BY requested_at DESC, id DESC`), executionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []DownloadLink{}
+	for rows.Next() {
+		var link DownloadLink
+		var id string
+		var requestedAt, expiresAt int64
+		err := rows.Scan(&id, &link.ExecutionID, &link.ResultsS3URL, &link.RequestedBy, &link.ClientIP,
+			&requestedAt, &expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if link.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, err
+		}
+		link.RequestedAt = fromMillis(requestedAt)
+		link.ExpiresAt = fromMillis(expiresAt)
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *sqlStore) GetResultProfile(ctx context.Context, executionID string) (ResultProfile, error) {
+	var profile ResultProfile
+	var data string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT profile FROM result_profiles WHERE execution_id = ?`),
+		executionID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return profile, ErrNotFound
+	}
+	if err != nil {
+		return profile, err
+	}
+
+	err = json.Unmarshal([]byte(data), &profile)
+	return profile, err
+}
+
+func (s *sqlStore) SaveResultProfile(ctx context.Context, profile *ResultProfile) error {
+	truncateMillis(&profile.ComputedAt)
+	// Profiles of an execution are identical, so a concurrent insert can be kept
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO result_profiles (execution_id, profile, computed_at)
+		VALUES (?, ?, ?) ON CONFLICT (execution_id) DO NOTHING`),
+		profile.ExecutionID, toJSONText(profile), toMillis(profile.ComputedAt))
+	return err
+}
+
+func (s *sqlStore) ListVisualizations(ctx context.Context, queryID primitive.ObjectID) ([]Visualization, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+sqlVisualizationColumns+` FROM visualizations
+		WHERE query_id = ? ORDER BY created_at, id`), queryID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	charts := []Visualization{}
+	for rows.Next() {
+		visualization, err := scanVisualization(rows)
+		if err != nil {
+			return nil, err
+		}
+		charts = append(charts, visualization)
+	}
+	return charts, rows.Err()
+}
+
+func (s *sqlStore) GetVisualization(ctx context.Context, id primitive.ObjectID) (Visualization, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT `+sqlVisualizationColumns+` FROM visualizations WHERE id = ?`), id.Hex())
+	return scanVisualization(row)
+}
+
+func (s *sqlStore) CreateVisualization(ctx context.Context, visualization *Visualization) error {
+	visualization.ID = primitive.NewObjectID()
+	truncateMillis(&visualization.CreatedAt, &visualization.UpdatedAt)
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO visualizations (`+sqlVisualizationColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		visualization.ID.Hex(), visualization.QueryID.Hex(), visualization.Name, visualization.Type,
+		toJSONText(visualization.Columns), visualization.Aggregation, toJSONText(visualization.Options),
+		visualization.CreatedBy, toMillis(visualization.CreatedAt), toMillis(visualization.UpdatedAt))
+	return err
+}
+
+func (s *sqlStore) UpdateVisualization(ctx context.Context, visualization *Visualization) error {
+	truncateMillis(&visualization.UpdatedAt)
+	result, err := s.db.ExecContext(ctx, s.rebind(`UPDATE visualizations SET name = ?, type = ?, columns = ?,
+		aggregation = ?, options = ?, updated_at = ? WHERE id = ?`),
+		visualization.Name, visualization.Type, toJSONText(visualization.Columns), visualization.Aggregation,
+		toJSONText(visualization.Options), toMillis(visualization.UpdatedAt), visualization.ID.Hex())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNotFound
+	}
+
+	updated, err := s.GetVisualization(ctx, visualization.ID)
+	if err != nil {
+		return err
+	}
+	*visualization = updated
+	return nil
+}
+
+func (s *sqlStore) DeleteVisualization(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM visualizations WHERE id = ?`), id.Hex())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) ListDashboards(ctx context.Context) ([]Dashboard, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqlDashboardColumns+` FROM dashboards ORDER BY LOWER(name), id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dashboards := []Dashboard{}
+	for rows.Next() {
+		dashboard, err := scanDashboard(rows)
+		if err != nil {
+			return nil, err
+		}
+		dashboards = append(dashboards, dashboard)
+	}
+	return dashboards, rows.Err()
+}
+
+func (s *sqlStore) GetDashboard(ctx context.Context, id primitive.ObjectID) (Dashboard, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT `+sqlDashboardColumns+` FROM dashboards WHERE id = ?`), id.Hex())
+	return scanDashboard(row)
+}
+
+func (s *sqlStore) CreateDashboard(ctx context.Context, dashboard *Dashboard) error {
+	dashboard.ID = primitive.NewObjectID()
+	truncateMillis(&dashboard.CreatedAt, &dashboard.UpdatedAt)
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO dashboards (`+sqlDashboardColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		dashboard.ID.Hex(), dashboard.Name, dashboard.Description, toJSONText(dashboard.Parameters),
+		toJSONText(dashboard.Widgets), dashboard.CreatedBy, toMillis(dashboard.CreatedAt), toMillis(dashboard.UpdatedAt))
+	return err
+}
+
+func (s *sqlStore) UpdateDashboard(ctx context.Context, dashboard *Dashboard) error {
+	truncateMillis(&dashboard.UpdatedAt)
+	result, err := s.db.ExecContext(ctx, s.rebind(`UPDATE dashboards SET name = ?, description = ?, parameters = ?,
+		widgets = ?, updated_at = ? WHERE id = ?`),
+		dashboard.Name, dashboard.Description, toJSONText(dashboard.Parameters), toJSONText(dashboard.Widgets),
+		toMillis(dashboard.UpdatedAt), dashboard.ID.Hex())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNotFound
+	}
+
+	updated, err := s.GetDashboard(ctx, dashboard.ID)
+	if err != nil {
+		return err
+	}
+	*dashboard = updated
+	return nil
+}
+
+func (s *sqlStore) DeleteDashboard(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM dashboards WHERE id = ?`), id.Hex())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) CreateShareLink(ctx context.Context, link *ShareLink) error {
+	link.ID = primitive.NewObjectID()
+	truncateMillis(&link.CreatedAt, &link.ExpiresAt, link.RevokedAt)
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO share_links (`+sqlShareLinkColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		link.ID.Hex(), link.RunID.Hex(), link.Permission, link.PasswordHash, link.CreatedBy,
+		toMillis(link.CreatedAt), toMillis(link.ExpiresAt), nullableMillis(link.RevokedAt), link.RevokedBy)
+	return err
+}
+
+func (s *sqlStore) GetShareLink(ctx context.Context, id primitive.ObjectID) (ShareLink, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT `+sqlShareLinkColumns+` FROM share_links WHERE id = ?`), id.Hex())
+	return scanShareLink(row)
+}
+
+func (s *sqlStore) ListShareLinks(ctx context.Context, runID primitive.ObjectID) ([]ShareLink, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+sqlShareLinkColumns+` FROM share_links
+		WHERE run_id = ? ORDER BY created_at DESC, id DESC`), runID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *sqlStore) RevokeShareLink(ctx context.Context, id primitive.ObjectID, revokedBy string, revokedAt time.Time) (ShareLink, error) {
+	_, err := s.db.ExecContext(ctx, s.rebind(`UPDATE share_links SET revoked_at = ?, revoked_by = ?
+		WHERE id = ? AND revoked_at IS NULL`), toMillis(revokedAt), revokedBy, id.Hex())
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return s.GetShareLink(ctx, id)
+}
+
+func (s *sqlStore) ListTableSchemas(ctx context.Context, catalog string) ([]TableSchema, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+sqlTableSchemaColumns+` FROM table_schemas
+		WHERE catalog_name = ? ORDER BY database_name, table_name`), catalog)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schemas := []TableSchema{}
+	for rows.Next() {
+		schema, err := scanTableSchema(rows)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, rows.Err()
+}
+
+func (s *sqlStore) SaveTableSchemas(ctx context.Context, schemas []TableSchema) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, schema := range schemas {
+		partitionKeys := schema.PartitionKeys
+		if partitionKeys == nil {
+			partitionKeys = []Column{}
+		}
+		_, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO table_schemas (`+sqlTableSchemaColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (catalog_name, database_name, table_name) DO UPDATE SET columns = excluded.columns,
+			partition_keys = excluded.partition_keys, snapshot_at = excluded.snapshot_at`),
+			schema.Catalog, schema.Database, schema.Table, toJSONText(schema.Columns), toJSONText(partitionKeys),
+			toMillis(schema.SnapshotAt))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) DeleteTableSchemas(ctx context.Context, schemas []TableSchema) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, schema := range schemas {
+		_, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM table_schemas
+			WHERE catalog_name = ? AND database_name = ? AND table_name = ?`), schema.Catalog, schema.Database, schema.Table)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) CreateSchemaChanges(ctx context.Context, changes []SchemaChange) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range changes {
+		change := &changes[i]
+		change.ID = primitive.NewObjectID()
+		truncateMillis(&change.DetectedAt)
+		_, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO schema_changes (`+sqlSchemaChangeColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			change.ID.Hex(), change.Catalog, change.Database, change.Table, change.Type, change.Column,
+			change.OldType, change.NewType, toMillis(change.DetectedAt))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) ListSchemaChanges(ctx context.Context, filter SchemaChangeFilter, limit int) ([]SchemaChange, error) {
+	where := &sqlWhere{}
+	if filter.Catalog != "" {
+		where.add("catalog_name = ?", filter.Catalog)
+	}
+	if filter.Database != "" {
+		where.add("database_name = ?", filter.Database)
+	}
+	if filter.Table != "" {
+		where.add("table_name = ?", filter.Table)
+	}
+	where.dateRange("detected_at", filter.Since, nil)
+
+	// Object IDs grow within a second, so changes detected together keep their order
+	query := `SELECT ` + sqlSchemaChangeColumns + ` FROM schema_changes` + where.String() +
+		` ORDER BY detected_at DESC, id ASC`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []SchemaChange{}
+	for rows.Next() {
+		change, err := scanSchemaChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultTrashRetentionDays = 30
+const defaultTrashPurgeInterval = time.Hour
+
+func (s *Server) getTrashedQueries(c *gin.Context) {
+	page, err := parsePageRequest(c, trashSortFields, "deleted")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	queries, next, total, err := s.store.ListQueries(context.Background(), QueryFilter{Trash: onlyTrashed}, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setPaginationHeaders(c, total, next)
+	c.JSON(http.StatusOK, queries)
+}
+
+func (s *Server) getTrashedQueryRuns(c *gin.Context) {
+	page, err := parsePageRequest(c, trashSortFields, "deleted")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := QueryRunFilter{Trash: onlyTrashed}
+	if queryID := c.Query("queryId"); queryID != "" {
+		id, err := primitive.ObjectIDFromHex(queryID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
+			return
+		}
+		filter.QueryID = &id
+	}
+
+	runs, next, total, err := s.store.ListQueryRuns(context.Background(), filter, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setPaginationHeaders(c, total, next)
+	c.JSON(http.StatusOK, runs)
+}
+
+func (s *Server) restoreQuery(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
+		return
+	}
+
+	query, err := s.store.RestoreQuery(context.Background(), id)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query not found in trash"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, query)
+}
+
+func (s *Server) restoreQueryRun(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query run ID"})
+		return
+	}
+
+	ctx := context.Background()
+
+	run, err := s.store.GetQueryRun(ctx, id)
+	if err != nil || run.DeletedAt == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query run not found in trash"})
+		return
+	}
+
+	query, err := s.store.GetQuery(ctx, run.QueryID)
+	if err != nil && err != ErrNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err == ErrNotFound || query.DeletedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Restore the query before restoring its runs"})
+		return
+	}
+
+	run, err = s.store.RestoreQueryRun(ctx, id)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query run not found in trash"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+func (s *Server) purgeTrashedQuery(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
+		return
+	}
+
+	ctx := context.Background()
+
+	query, err := s.store.GetQuery(ctx, id)
+	if err != nil || query.DeletedAt == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query not found in trash"})
+		return
+	}
+
+	if err := s.purgeQuery(ctx, query); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Query deleted permanently"})
+}
+
+func (s *Server) purgeTrashedQueryRun(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query run ID"})
+		return
+	}
+
+	ctx := context.Background()
+
+	run, err := s.store.GetQueryRun(ctx, id)
+	if err != nil || run.DeletedAt == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query run not found in trash"})
+		return
+	}
+
+	if err := s.purgeQueryRun(ctx, run); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Query run deleted permanently"})
+}
+
+// Helper function to hard delete a run and its result files. The document is only
+// removed once S3 is cleaned up so a failed purge is retried on the next pass.
+// Result files shared with other runs through the cache are left for the last of them.
+func (s *Server) purgeQueryRun(ctx context.Context, run QueryRun) error {
+	if run.ResultsS3URL != "" {
+		shared, err := s.resultsShared(ctx, run)
+		if err != nil {
+			return err
+		}
+		if !shared {
+			if err := s.deleteS3Results(run.ResultsS3URL); err != nil {
+				return fmt.Errorf("failed to purge results of run %s: %v", run.ID.Hex(), err)
+			}
+		}
+	}
+
+	return s.store.DeleteQueryRun(ctx, run.ID)
+}
+
+// Helper function to hard delete a query together with all of its runs
+func (s *Server) purgeQuery(ctx context.Context, query Query) error {
+	filter := QueryRunFilter{QueryID: &query.ID, Trash: anyTrashState}
+	runs, _, _, err := s.store.ListQueryRuns(ctx, filter, pageRequest{Sort: queryRunSortFields["executed"]})
+	if err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		if err := s.purgeQueryRun(ctx, run); err != nil {
+			return err
+		}
+	}
+
+	return s.store.DeleteQuery(ctx, query.ID)
+}
+
+// Helper function to hard delete everything that has been in the trash since before cutoff
+func (s *Server) purgeExpiredTrash(ctx context.Context, cutoff time.Time) error {
+	page := pageRequest{Sort: trashSortFields["deleted"]}
+
+	queries, _, _, err := s.store.ListQueries(ctx, QueryFilter{Trash: onlyTrashed, DeletedBefore: &cutoff}, page)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range queries {
+		if err := s.purgeQuery(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	runs, _, _, err := s.store.ListQueryRuns(ctx, QueryRunFilter{Trash: onlyTrashed, DeletedBefore: &cutoff}, page)
+	if err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		if err := s.purgeQueryRun(ctx, run); err != nil {
+			return err
+		}
+	}
+
+	if len(queries) > 0 || len(runs) > 0 {
+		log.Printf("Purged %d queries and %d query runs from the trash", len(queries), len(runs))
+	}
+	return nil
+}
+
+// Helper function to start the background job that empties the trash. Items are kept
+// for TRASH_RETENTION_DAYS (0 disables purging) and checked every TRASH_PURGE_INTERVAL.
+func (s *Server) startTrashPurger() error {
+	retentionDays := defaultTrashRetentionDays
+	if value := os.Getenv("TRASH_RETENTION_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return fmt.Errorf("invalid TRASH_RETENTION_DAYS: %s", value)
+		}
+		retentionDays = days
+	}
+
+	if retentionDays == 0 {
+		log.Println("Trash purging is disabled")
+		return nil
+	}
+
+	interval := defaultTrashPurgeInterval
+	if value := os.Getenv("TRASH_PURGE_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return fmt.Errorf("invalid TRASH_PURGE_INTERVAL: %s", value)
+		}
+		interval = parsed
+	}
+
+	retention := time.Duration(retentionDays) * 24 * time.Hour
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.purgeExpiredTrash(context.Background(), time.Now().Add(-retention)); err != nil {
+				log.Printf("Failed to purge trash: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/gin-gonic/gin"
+)
+
+// Helper function to check whether the fake S3 still holds the result file of a run
+func hasTestResults(t *testing.T, ts *testServer, run QueryRun) bool {
+	t.Helper()
+	bucket, key, err := parseS3URL(run.ResultsS3URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.s3.mu.Lock()
+	defer ts.s3.mu.Unlock()
+	_, ok := ts.s3.objects[bucket+"/"+key]
+	return ok
+}
+
+// Helper function to list the IDs of the runs in the trash
+func trashedRunIDs(t *testing.T, ts *testServer) map[string]bool {
+	t.Helper()
+	ids := map[string]bool{}
+	for _, run := range doJSON[[]QueryRun](t, ts, http.MethodGet, "/api/trash/query-runs", nil, http.StatusOK) {
+		ids[run.ID.Hex()] = true
+	}
+	return ids
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	ts := newTestServer(t)
+	query := createTestQuery(t, ts, "Orders", "SELECT * FROM orders")
+	kept := runTestQuery(t, ts, query, nil)
+	trashed := runTestQuery(t, ts, query, gin.H{"noCache": true})
+
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/query-runs/"+trashed.ID.Hex(), nil, http.StatusOK)
+	if runs := doJSON[[]QueryRun](t, ts, http.MethodGet, "/api/queries/"+query.ID.Hex()+"/runs", nil, http.StatusOK); len(runs) != 1 || runs[0].ID != kept.ID {
+		t.Errorf("runs %+v after trashing one", runs)
+	}
+	if ids := trashedRunIDs(t, ts); len(ids) != 1 || !ids[trashed.ID.Hex()] {
+		t.Errorf("trashed runs %v", ids)
+	}
+
+	// Trashing a query trashes its runs, which come back with it
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/queries/"+query.ID.Hex(), nil, http.StatusOK)
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/queries/"+query.ID.Hex(), nil, http.StatusNotFound)
+	if queries := doJSON[[]Query](t, ts, http.MethodGet, "/api/trash/queries", nil, http.StatusOK); len(queries) != 1 || queries[0].ID != query.ID || queries[0].DeletedAt == nil {
+		t.Errorf("trashed queries %+v", queries)
+	}
+	if ids := trashedRunIDs(t, ts); len(ids) != 2 {
+		t.Errorf("trashed runs %v, want both", ids)
+	}
+	doJSON[gin.H](t, ts, http.MethodPost, "/api/trash/query-runs/"+kept.ID.Hex()+"/restore", nil, http.StatusConflict)
+
+	restored := doJSON[Query](t, ts, http.MethodPost, "/api/trash/queries/"+query.ID.Hex()+"/restore", nil, http.StatusOK)
+	if restored.DeletedAt != nil || restored.DeletedBy != "" {
+		t.Errorf("restored query %+v", restored)
+	}
+
+	// The run trashed on its own stays in the trash until restored itself
+	if ids := trashedRunIDs(t, ts); len(ids) != 1 || !ids[trashed.ID.Hex()] {
+		t.Errorf("trashed runs %v after restoring the query", ids)
+	}
+	run := doJSON[QueryRun](t, ts, http.MethodPost, "/api/trash/query-runs/"+trashed.ID.Hex()+"/restore", nil, http.StatusOK)
+	if run.DeletedAt != nil {
+		t.Errorf("restored run %+v", run)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{name: "restore a query not in the trash", method: http.MethodPost, path: "/api/trash/queries/" + query.ID.Hex() + "/restore", status: http.StatusNotFound},
+		{name: "restore a run not in the trash", method: http.MethodPost, path: "/api/trash/query-runs/" + run.ID.Hex() + "/restore", status: http.StatusNotFound},
+		{name: "purge a query not in the trash", method: http.MethodDelete, path: "/api/trash/queries/" + query.ID.Hex(), status: http.StatusNotFound},
+		{name: "purge a run not in the trash", method: http.MethodDelete, path: "/api/trash/query-runs/" + run.ID.Hex(), status: http.StatusNotFound},
+		{name: "invalid query ID", method: http.MethodPost, path: "/api/trash/queries/nope/restore", status: http.StatusBadRequest},
+		{name: "invalid run ID", method: http.MethodDelete, path: "/api/trash/query-runs/nope", status: http.StatusBadRequest},
+		{name: "invalid query filter", method: http.MethodGet, path: "/api/trash/query-runs?queryId=nope", status: http.StatusBadRequest},
+		{name: "invalid sort", method: http.MethodGet, path: "/api/trash/queries?sort=name", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doJSON[gin.H](t, ts, tt.method, tt.path, nil, tt.status)
+		})
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	ts := newTestServer(t)
+	query := createTestQuery(t, ts, "Orders", "SELECT * FROM orders")
+	first := runTestQuery(t, ts, query, nil)
+	cached := runTestQuery(t, ts, query, nil)
+	if !cached.FromCache || cached.ResultsS3URL != first.ResultsS3URL {
+		t.Fatalf("second run %+v doesn't share the results of the first", cached)
+	}
+	other := runTestQuery(t, ts, query, gin.H{"noCache": true})
+
+	// Results shared with another run are kept for it
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/query-runs/"+cached.ID.Hex(), nil, http.StatusOK)
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/trash/query-runs/"+cached.ID.Hex(), nil, http.StatusOK)
+	if !hasTestResults(t, ts, first) {
+		t.Error("purging a run deleted results another run shares")
+	}
+	if ids := trashedRunIDs(t, ts); len(ids) != 0 {
+		t.Errorf("trashed runs %v after purging", ids)
+	}
+
+	// A failed delete in S3 leaves the run in the trash to retry
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/query-runs/"+other.ID.Hex(), nil, http.StatusOK)
+	ts.s3.FailNext("DeleteObjects", awserr.New("InternalError", "Internal error", nil))
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/trash/query-runs/"+other.ID.Hex(), nil, http.StatusInternalServerError)
+	if ids := trashedRunIDs(t, ts); !ids[other.ID.Hex()] || !hasTestResults(t, ts, other) {
+		t.Errorf("trashed runs %v after a failed purge", ids)
+	}
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/trash/query-runs/"+other.ID.Hex(), nil, http.StatusOK)
+	if hasTestResults(t, ts, other) {
+		t.Error("purging a run kept its results")
+	}
+
+	// Purging a query purges its runs, and the last run of shared results deletes them
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/queries/"+query.ID.Hex(), nil, http.StatusOK)
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/trash/queries/"+query.ID.Hex(), nil, http.StatusOK)
+	if hasTestResults(t, ts, first) {
+		t.Error("purging the last run sharing results kept them")
+	}
+	if ids := trashedRunIDs(t, ts); len(ids) != 0 {
+		t.Errorf("trashed runs %v after purging their query", ids)
+	}
+	if queries := doJSON[[]Query](t, ts, http.MethodGet, "/api/trash/queries", nil, http.StatusOK); len(queries) != 0 {
+		t.Errorf("trashed queries %+v after purging", queries)
+	}
+	doJSON[gin.H](t, ts, http.MethodPost, "/api/trash/queries/"+query.ID.Hex()+"/restore", nil, http.StatusNotFound)
+}
+
+func TestStartTrashPurger(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"TRASH_RETENTION_DAYS": "-1"},
+		{"TRASH_RETENTION_DAYS": "a week"},
+		{"TRASH_PURGE_INTERVAL": "0s"},
+		{"TRASH_PURGE_INTERVAL": "hourly"},
+	} {
+		t.Setenv("TRASH_RETENTION_DAYS", "")
+		t.Setenv("TRASH_PURGE_INTERVAL", "")
+		for name, value := range env {
+			t.Setenv(name, value)
+		}
+		if err := newTestServer(t).startTrashPurger(); err == nil {
+			t.Errorf("started the purger with %v", env)
+		}
+	}
+
+	ts := newTestServer(t)
+	ctx := context.Background()
+	query := createTestQuery(t, ts, "Orders", "SELECT * FROM orders")
+	expired := runTestQuery(t, ts, query, nil)
+	recent := runTestQuery(t, ts, query, gin.H{"noCache": true})
+	if err := ts.store.TrashQueryRun(ctx, expired.ID, "", time.Now().Add(-3*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.TrashQueryRun(ctx, recent.ID, "", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// With purging disabled nothing is purged
+	t.Setenv("TRASH_RETENTION_DAYS", "0")
+	t.Setenv("TRASH_PURGE_INTERVAL", "10ms")
+	if err := ts.startTrashPurger(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if ids := trashedRunIDs(t, ts); len(ids) != 2 {
+		t.Fatalf("trashed runs %v with purging disabled", ids)
+	}
+
+	// Only what has been in the trash for longer than the retention is purged
+	t.Setenv("TRASH_RETENTION_DAYS", "2")
+	if err := ts.startTrashPurger(); err != nil {
+		t.Fatal(err)
+	}
+	for polls := 0; trashedRunIDs(t, ts)[expired.ID.Hex()]; polls++ {
+		if polls == 100 {
+			t.Fatal("the expired run is still in the trash")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ids := trashedRunIDs(t, ts); len(ids) != 1 || !ids[recent.ID.Hex()] {
+		t.Errorf("trashed runs %v, want only the recent one", ids)
+	}
+	if hasTestResults(t, ts, expired) || !hasTestResults(t, ts, recent) {
+		t.Error("purged the wrong results")
+	}
+}
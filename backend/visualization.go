@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Labels and series a chart holds before the rest are left out
+const maxChartLabels = 10000
+const maxChartSeries = 100
+
+var visualizationTypes = map[string]bool{
+	"line": true, "bar": true, "pie": true, "scatter": true, "pivot": true, "counter": true,
+}
+
+var chartAggregations = map[string]bool{
+	"none": true, "count": true, "sum": true, "avg": true, "min": true, "max": true,
+}
+
+// Helper function to check that a visualization maps the columns its type needs.
+// Whether the columns exist is only known once a run has results.
+func validateVisualization(visualization *Visualization) error {
+	if !visualizationTypes[visualization.Type] {
+		return fmt.Errorf("invalid visualization type %q", visualization.Type)
+	}
+	if visualization.Aggregation == "" {
+		visualization.Aggregation = "none"
+	}
+	if !chartAggregations[visualization.Aggregation] {
+		return fmt.Errorf("invalid aggregation %q", visualization.Aggregation)
+	}
+	if visualization.Columns.Y == nil {
+		visualization.Columns.Y = []string{}
+	}
+
+	columns := visualization.Columns
+	// Counting rows needs no value column
+	values := len(columns.Y)
+	if visualization.Aggregation == "count" && values == 0 {
+		values = 1
+	}
+
+	switch visualization.Type {
+	case "counter":
+		if values != 1 {
+			return fmt.Errorf("counters show exactly one y column")
+		}
+	case "pie":
+		if columns.X == "" || values != 1 || columns.Series != "" {
+			return fmt.Errorf("pie charts need an x column and exactly one y column, without series")
+		}
+	case "pivot":
+		if columns.X == "" || columns.Series == "" || values != 1 {
+			return fmt.Errorf("pivots need x, series and exactly one y column")
+		}
+		if visualization.Aggregation == "none" {
+			return fmt.Errorf("pivots need an aggregation")
+		}
+	default:
+		if columns.X == "" || values == 0 {
+			return fmt.Errorf("%s charts need an x column and at least one y column", visualization.Type)
+		}
+	}
+	return nil
+}
+
+// chartCell aggregates the values of one series at one label
+type chartCell struct {
+	count int64 // Non-null values, or rows when counting rows
+	n     int64 // Numeric values
+	sum   float64
+	min   float64
+	max   float64
+	last  *float64
+}
+
+func (cell *chartCell) add(raw string, value interface{}) {
+	if raw == "" {
+		return
+	}
+	cell.count++
+
+	x, ok := numericValue(value)
+	if !ok || math.IsNaN(x) || math.IsInf(x, 0) {
+		return
+	}
+	if cell.n == 0 || x < cell.min {
+		cell.min = x
+	}
+	if cell.n == 0 || x > cell.max {
+		cell.max = x
+	}
+	cell.n++
+	cell.sum += x
+	cell.last = &x
+}
+
+func (cell *chartCell) value(aggregation string) *float64 {
+	var value float64
+	switch aggregation {
+	case "count":
+		value = float64(cell.count)
+	case "none":
+		return cell.last
+	case "sum":
+		value = cell.sum
+	case "avg":
+		value = cell.sum / float64(cell.n)
+	case "min":
+		value = cell.min
+	case "max":
+		value = cell.max
+	}
+	if cell.n == 0 && aggregation != "count" {
+		return nil
+	}
+	return &value
+}
+
+// chartBuilder groups result rows into the labels and series of a chart
+type chartBuilder struct {
+	visualization *Visualization
+	columns       []resultColumn
+	x, series     int   // Column indexes, -1 when not mapped
+	y             []int // Column indexes of the values, -1 for counting rows
+
+	data        *ChartData
+	labelValues []interface{} // Typed x values, for ordering the labels
+	labelIndex  map[string]int
+	seriesIndex map[string]int
+	cells       []map[int]*chartCell // By series, then label
+}
+
+// Helper function to resolve the mapped columns against the result columns
+func newChartBuilder(visualization *Visualization, run QueryRun, columns []resultColumn) (*chartBuilder, error) {
+	b := &chartBuilder{
+		visualization: visualization,
+		columns:       columns,
+		x:             -1,
+		series:        -1,
+		data: &ChartData{
+			VisualizationID: visualization.ID,
+			RunID:           run.ID,
+			Type:            visualization.Type,
+			Labels:          []string{},
+			Series:          []ChartSeries{},
+		},
+		labelIndex:  map[string]int{},
+		seriesIndex: map[string]int{},
+	}
+
+	var err error
+	if visualization.Columns.X != "" && visualization.Type != "counter" {
+		if b.x, err = resultColumnIndex(columns, visualization.Columns.X); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidResultQuery, err)
+		}
+	}
+	if visualization.Columns.Series != "" && visualization.Type != "counter" {
+		if b.series, err = resultColumnIndex(columns, visualization.Columns.Series); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidResultQuery, err)
+		}
+	}
+	for _, name := range visualization.Columns.Y {
+		i, err := resultColumnIndex(columns, name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidResultQuery, err)
+		}
+		b.y = append(b.y, i)
+	}
+	if len(b.y) == 0 {
+		b.y = []int{-1}
+	}
+	return b, nil
+}
+
+// Helper function to name the series of a value column and series value
+func (b *chartBuilder) seriesName(y int, seriesValue string) string {
+	name := "count"
+	if y >= 0 {
+		name = b.columns[y].Name
+	}
+	switch {
+	case b.series < 0:
+		return name
+	case len(b.y) > 1:
+		return seriesValue + " / " + name
+	}
+	return seriesValue
+}
+
+// Helper function to find or add the series of a name, -1 past maxChartSeries
+func (b *chartBuilder) seriesOf(name string) int {
+	if i, ok := b.seriesIndex[name]; ok {
+		return i
+	}
+	if len(b.data.Series) == maxChartSeries {
+		b.data.Truncated = true
+		return -1
+	}
+	b.seriesIndex[name] = len(b.data.Series)
+	b.data.Series = append(b.data.Series, ChartSeries{Name: name})
+	b.cells = append(b.cells, map[int]*chartCell{})
+	return len(b.data.Series) - 1
+}
+
+// Helper function to find or add the label of a row, -1 past maxChartLabels.
+// Without aggregation every row is a label of its own.
+func (b *chartBuilder) labelOf(record []string) int {
+	raw := ""
+	if b.x >= 0 {
+		raw = record[b.x]
+	}
+
+	if b.visualization.Aggregation != "none" || b.visualization.Type == "counter" {
+		if i, ok := b.labelIndex[raw]; ok {
+			return i
+		}
+	}
+	if len(b.data.Labels) == maxChartLabels {
+		b.data.Truncated = true
+		return -1
+	}
+
+	b.labelIndex[raw] = len(b.data.Labels)
+	b.data.Labels = append(b.data.Labels, raw)
+	if b.x >= 0 {
+		b.labelValues = append(b.labelValues, typedValue(b.columns[b.x].Type, raw))
+	}
+	return len(b.data.Labels) - 1
+}
+
+func (b *chartBuilder) add(record []string) {
+	b.data.Rows++
+	// Counters show the value of the first row when not aggregating
+	if b.visualization.Type == "counter" && b.visualization.Aggregation == "none" && b.data.Rows > 1 {
+		return
+	}
+
+	label := b.labelOf(record)
+	if label < 0 {
+		return
+	}
+
+	seriesValue := ""
+	if b.series >= 0 {
+		seriesValue = record[b.series]
+	}
+	for _, y := range b.y {
+		series := b.seriesOf(b.seriesName(y, seriesValue))
+		if series < 0 {
+			continue
+		}
+
+		cell := b.cells[series][label]
+		if cell == nil {
+			cell = &chartCell{}
+			b.cells[series][label] = cell
+		}
+		if y < 0 {
+			cell.count++
+		} else {
+			cell.add(record[y], typedValue(b.columns[y].Type, record[y]))
+		}
+	}
+}
+
+// Helper function to turn the aggregated cells into the chart. Aggregated
+// labels are ordered by their x values, rows keep the order of the results.
+func (b *chartBuilder) finish() *ChartData {
+	aggregation := b.visualization.Aggregation
+
+	if b.visualization.Type == "counter" {
+		if len(b.cells) > 0 && b.cells[0][0] != nil {
+			b.data.Value = b.cells[0][0].value(aggregation)
+		}
+		if b.data.Value == nil && aggregation == "count" {
+			zero := 0.0
+			b.data.Value = &zero
+		}
+		b.data.Labels = []string{}
+		b.data.Series = []ChartSeries{}
+		return b.data
+	}
+
+	order := make([]int, len(b.data.Labels))
+	for i := range order {
+		order[i] = i
+	}
+	if aggregation != "none" && b.x >= 0 {
+		sort.SliceStable(order, func(i, j int) bool {
+			return compareResultValues(b.labelValues[order[i]], b.labelValues[order[j]]) < 0
+		})
+	}
+
+	labels := make([]string, len(order))
+	for i, label := range order {
+		labels[i] = b.data.Labels[label]
+	}
+	b.data.Labels = labels
+
+	for series := range b.data.Series {
+		values := make([]*float64, len(order))
+		for i, label := range order {
+			if cell := b.cells[series][label]; cell != nil {
+				values[i] = cell.value(aggregation)
+			} else if aggregation == "count" {
+				zero := 0.0
+				values[i] = &zero
+			}
+		}
+		b.data.Series[series].Values = values
+	}
+	return b.data
+}
+
+// Helper function to aggregate a visualization over the result file of a run
+func (s *Server) computeChartData(ctx context.Context, visualization *Visualization, run QueryRun) (*ChartData, error) {
+	columns, err := s.getResultColumns(run.ExecutionID)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := newChartBuilder(visualization, run, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.scanResultFile(ctx, run.ResultsS3URL, len(columns), func(record []string) error {
+		b.add(record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.finish(), nil
+}
+
+// Helper function to pick the run a chart is drawn from: the run given by the
+// run parameter, otherwise the latest run of the query that succeeded
+func (s *Server) chartRun(ctx context.Context, c *gin.Context, queryID primitive.ObjectID) (QueryRun, int, error) {
+	if param := c.Query("run"); param != "" {
+		id, err := primitive.ObjectIDFromHex(param)
+		if err != nil {
+			return QueryRun{}, http.StatusBadRequest, errors.New("Invalid query run ID")
+		}
+		run, err := s.store.GetQueryRun(ctx, id)
+		if err == ErrNotFound || err == nil && (run.QueryID != queryID || run.DeletedAt != nil) {
+			return QueryRun{}, http.StatusNotFound, errors.New("Query run not found")
+		}
+		if err != nil {
+			return QueryRun{}, http.StatusInternalServerError, err
+		}
+		if run.Status == "RUNNING" || run.Status == "QUEUED" {
+			if run, err = s.updateQueryRunStatus(ctx, run); err != nil {
+				return QueryRun{}, http.StatusInternalServerError, err
+			}
+		}
+		if run.Status != "SUCCEEDED" || run.ResultsS3URL == "" {
+			return QueryRun{}, http.StatusConflict, fmt.Errorf("Query run %s has not succeeded", run.ID.Hex())
+		}
+		return run, http.StatusOK, nil
+	}
+
+	// The latest run may have finished without anyone asking Athena yet
+	page := pageRequest{Sort: queryRunSortFields["executed"], Desc: true, Limit: 1}
+	runs, _, _, err := s.store.ListQueryRuns(ctx, QueryRunFilter{QueryID: &queryID}, page)
+	if err != nil {
+		return QueryRun{}, http.StatusInternalServerError, err
+	}
+	if len(runs) == 1 && (runs[0].Status == "RUNNING" || runs[0].Status == "QUEUED") {
+		if _, err := s.updateQueryRunStatus(ctx, runs[0]); err != nil {
+			return QueryRun{}, http.StatusInternalServerError, err
+		}
+	}
+
+	filter := QueryRunFilter{QueryID: &queryID, Statuses: []string{"SUCCEEDED"}}
+	runs, _, _, err = s.store.ListQueryRuns(ctx, filter, page)
+	if err != nil {
+		return QueryRun{}, http.StatusInternalServerError, err
+	}
+	if len(runs) == 0 || runs[0].ResultsS3URL == "" {
+		return QueryRun{}, http.StatusConflict, errors.New("Query has no succeeded runs")
+	}
+	return runs[0], http.StatusOK, nil
+}
+
+// Helper function to load the visualization of the id parameter, answering
+// the request when it can't
+func (s *Server) loadVisualization(ctx context.Context, c *gin.Context) (Visualization, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid visualization ID"})
+		return Visualization{}, false
+	}
+
+	visualization, err := s.store.GetVisualization(ctx, id)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Visualization not found"})
+		return visualization, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return visualization, false
+	}
+	return visualization, true
+}
+
+func (s *Server) getVisualizations(c *gin.Context) {
+	queryID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
+		return
+	}
+
+	ctx := context.Background()
+	if query, err := s.store.GetQuery(ctx, queryID); err != nil || query.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query not found"})
+		return
+	}
+
+	charts, err := s.store.ListVisualizations(ctx, queryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, charts)
+}
+
+func (s *Server) createVisualization(c *gin.Context) {
+	queryID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
+		return
+	}
+
+	var req VisualizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	visualization := Visualization{
+		QueryID:     queryID,
+		Name:        req.Name,
+		Type:        req.Type,
+		Columns:     req.Columns,
+		Aggregation: req.Aggregation,
+		Options:     req.Options,
+		CreatedBy:   requestUser(c),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := validateVisualization(&visualization); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	if query, err := s.store.GetQuery(ctx, queryID); err != nil || query.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query not found"})
+		return
+	}
+
+	if err := s.store.CreateVisualization(ctx, &visualization); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, visualization)
+}
+
+func (s *Server) getVisualization(c *gin.Context) {
+	visualization, ok := s.loadVisualization(context.Background(), c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, visualization)
+}
+
+func (s *Server) updateVisualization(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid visualization ID"})
+		return
+	}
+
+	var req VisualizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	visualization := Visualization{
+		ID:          id,
+		Name:        req.Name,
+		Type:        req.Type,
+		Columns:     req.Columns,
+		Aggregation: req.Aggregation,
+		Options:     req.Options,
+		UpdatedAt:   time.Now(),
+	}
+	if err := validateVisualization(&visualization); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = s.store.UpdateVisualization(context.Background(), &visualization)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Visualization not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, visualization)
+}
+
+func (s *Server) deleteVisualization(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid visualization ID"})
+		return
+	}
+
+	err = s.store.DeleteVisualization(context.Background(), id)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Visualization not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Visualization deleted"})
+}
+
+// The chart is aggregated on the server so large results never reach the browser
+func (s *Server) getVisualizationData(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	visualization, ok := s.loadVisualization(ctx, c)
+	if !ok {
+		return
+	}
+
+	run, status, err := s.chartRun(ctx, c, visualization.QueryID)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := s.computeChartData(ctx, &visualization, run)
+	if errors.Is(err, ErrInvalidResultQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(s3ErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var chartTestScript = fakeQuery{
+	Columns: []string{"month", "region", "revenue", "units"},
+	Types:   []string{"bigint", "varchar", "bigint", "bigint"},
+	Rows: [][]string{
+		{"2", "eu", "10", "1"},
+		{"1", "us", "5", "2"},
+		{"2", "us", "", "3"},
+		{"1", "eu", "7", "4"},
+		{"10", "eu", "1", "5"},
+	},
+}
+
+// Helper function to write chart values as text, with "-" for missing values
+func chartValues(values []*float64) string {
+	text := make([]string, len(values))
+	for i, value := range values {
+		text[i] = "-"
+		if value != nil {
+			text[i] = strconv.FormatFloat(*value, 'g', -1, 64)
+		}
+	}
+	return strings.Join(text, " ")
+}
+
+func TestVisualizationCRUD(t *testing.T) {
+	ts := newTestServer(t)
+	query := createTestQuery(t, ts, "Revenue", "SELECT * FROM revenue")
+	path := "/api/queries/" + query.ID.Hex() + "/visualizations"
+
+	created := doJSON[Visualization](t, ts, http.MethodPost, path, gin.H{
+		"name":    "By month",
+		"type":    "bar",
+		"columns": gin.H{"x": "month", "y": []string{"revenue"}},
+		"options": gin.H{"stacked": true},
+	}, http.StatusCreated, "X-Forwarded-User", "jane")
+	if created.QueryID != query.ID || created.Aggregation != "none" || created.CreatedBy != "jane" || created.Options["stacked"] != true {
+		t.Errorf("created %+v", created)
+	}
+
+	listed := doJSON[[]Visualization](t, ts, http.MethodGet, path, nil, http.StatusOK)
+	if len(listed) != 1 || listed[0].ID != created.ID {
+		t.Errorf("listed %+v, want the created visualization", listed)
+	}
+
+	updated := doJSON[Visualization](t, ts, http.MethodPut, "/api/visualizations/"+created.ID.Hex(), gin.H{
+		"name":        "Units by region",
+		"type":        "pie",
+		"columns":     gin.H{"x": "region", "y": []string{"units"}},
+		"aggregation": "sum",
+	}, http.StatusOK)
+	fetched := doJSON[Visualization](t, ts, http.MethodGet, "/api/visualizations/"+created.ID.Hex(), nil, http.StatusOK)
+	if fetched.Name != updated.Name || fetched.Type != "pie" || fetched.Aggregation != "sum" || fetched.QueryID != query.ID {
+		t.Errorf("fetched %+v after updating to %+v", fetched, updated)
+	}
+
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/visualizations/"+created.ID.Hex(), nil, http.StatusOK)
+	doJSON[gin.H](t, ts, http.MethodGet, "/api/visualizations/"+created.ID.Hex(), nil, http.StatusNotFound)
+	doJSON[gin.H](t, ts, http.MethodDelete, "/api/visualizations/"+created.ID.Hex(), nil, http.StatusNotFound)
+}
+
+func TestVisualizationValidation(t *testing.T) {
+	ts := newTestServer(t)
+	query := createTestQuery(t, ts, "Revenue", "SELECT * FROM revenue")
+	path := "/api/queries/" + query.ID.Hex() + "/visualizations"
+
+	tests := []struct {
+		name   string
+		path   string
+		body   gin.H
+		status int
+	}{
+		{name: "line", body: gin.H{"name": "c", "type": "line", "columns": gin.H{"x": "month", "y": []string{"revenue", "units"}}}, status: http.StatusCreated},
+		{name: "counted pie", body: gin.H{"name": "c", "type": "pie", "columns": gin.H{"x": "region"}, "aggregation": "count"}, status: http.StatusCreated},
+		{name: "missing name", body: gin.H{"type": "bar", "columns": gin.H{"x": "month", "y": []string{"revenue"}}}, status: http.StatusBadRequest},
+		{name: "unknown type", body: gin.H{"name": "c", "type": "radar", "columns": gin.H{"x": "month", "y": []string{"revenue"}}}, status: http.StatusBadRequest},
+		{name: "unknown aggregation", body: gin.H{"name": "c", "type": "bar", "columns": gin.H{"x": "month", "y": []string{"revenue"}}, "aggregation": "median"}, status: http.StatusBadRequest},
+		{name: "bar without x", body: gin.H{"name": "c", "type": "bar", "columns": gin.H{"y": []string{"revenue"}}}, status: http.StatusBadRequest},
+		{name: "pie with series", body: gin.H{"name": "c", "type": "pie", "columns": gin.H{"x": "region", "y": []string{"revenue"}, "series": "month"}}, status: http.StatusBadRequest},
+		{name: "counter with two values", body: gin.H{"name": "c", "type": "counter", "columns": gin.H{"y": []string{"revenue", "units"}}}, status: http.StatusBadRequest},
+		{name: "pivot without aggregation", body: gin.H{"name": "c", "type": "pivot", "columns": gin.H{"x": "region", "y": []string{"units"}, "series": "month"}}, status: http.StatusBadRequest},
+		{name: "invalid query ID", path: "/api/queries/nope/visualizations", body: gin.H{"name": "c", "type": "counter", "aggregation": "count"}, status: http.StatusBadRequest},
+		{name: "unknown query", path: "/api/queries/0123456789abcdef01234567/visualizations", body: gin.H{"name": "c", "type": "counter", "aggregation": "count"}, status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.path == "" {
+				tt.path = path
+			}
+			doJSON[gin.H](t, ts, http.MethodPost, tt.path, tt.body, tt.status)
+		})
+	}
+}
+
+func TestVisualizationData(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   gin.H
+		labels []string
+		series map[string]string // Values by series name
+		value  string            // Of counters
+	}{
+		{
+			name:   "sums by label in x order",
+			body:   gin.H{"type": "bar", "columns": gin.H{"x": "month", "y": []string{"revenue"}}, "aggregation": "sum"},
+			labels: []string{"1", "2", "10"},
+			series: map[string]string{"revenue": "12 10 1"},
+		},
+		{
+			name:   "splits series",
+			body:   gin.H{"type": "line", "columns": gin.H{"x": "month", "y": []string{"revenue"}, "series": "region"}, "aggregation": "sum"},
+			labels: []string{"1", "2", "10"},
+			series: map[string]string{"eu": "7 10 1", "us": "5 - -"},
+		},
+		{
+			name:   "counts rows",
+			body:   gin.H{"type": "pie", "columns": gin.H{"x": "region"}, "aggregation": "count"},
+			labels: []string{"eu", "us"},
+			series: map[string]string{"count": "3 2"},
+		},
+		{
+			name:   "pivots",
+			body:   gin.H{"type": "pivot", "columns": gin.H{"x": "region", "y": []string{"units"}, "series": "month"}, "aggregation": "max"},
+			labels: []string{"eu", "us"},
+			series: map[string]string{"2": "1 3", "1": "4 2", "10": "5 -"},
+		},
+		{
+			name:   "keeps rows without aggregation",
+			body:   gin.H{"type": "scatter", "columns": gin.H{"x": "units", "y": []string{"revenue"}}},
+			labels: []string{"1", "2", "3", "4", "5"},
+			series: map[string]string{"revenue": "10 5 - 7 1"},
+		},
+		{
+			name:  "counter",
+			body:  gin.H{"type": "counter", "columns": gin.H{"y": []string{"units"}}, "aggregation": "avg"},
+			value: "3",
+		},
+		{
+			name:  "counter of the first row",
+			body:  gin.H{"type": "counter", "columns": gin.H{"y": []string{"revenue"}}},
+			value: "10",
+		},
+	}
+
+	ts := newTestServer(t)
+	ts.athena.Script("from revenue", chartTestScript)
+	query := createTestQuery(t, ts, "Revenue", "SELECT * FROM revenue")
+	run := runTestQuery(t, ts, query, nil)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.body["name"] = tt.name
+			visualization := doJSON[Visualization](t, ts, http.MethodPost,
+				"/api/queries/"+query.ID.Hex()+"/visualizations", tt.body, http.StatusCreated)
+
+			data := doJSON[ChartData](t, ts, http.MethodGet, "/api/visualizations/"+visualization.ID.Hex()+"/data", nil, http.StatusOK)
+			if data.RunID != run.ID || data.Rows != 5 || data.Truncated {
+				t.Errorf("chart of run %s with %d rows, truncated %v", data.RunID.Hex(), data.Rows, data.Truncated)
+			}
+
+			if tt.value != "" {
+				if got := chartValues([]*float64{data.Value}); got != tt.value {
+					t.Errorf("value %s, want %s", got, tt.value)
+				}
+				return
+			}
+			if !reflect.DeepEqual(data.Labels, tt.labels) {
+				t.Errorf("labels %v, want %v", data.Labels, tt.labels)
+			}
+			series := map[string]string{}
+			for _, s := range data.Series {
+				series[s.Name] = chartValues(s.Values)
+			}
+			if !reflect.DeepEqual(series, tt.series) {
+				t.Errorf("series %v, want %v", series, tt.series)
+			}
+		})
+	}
+}
+
+func TestVisualizationDataRuns(t *testing.T) {
+	ts := newTestServer(t)
+	ts.athena.Script("from revenue", chartTestScript)
+	ts.athena.Script("from broken", fakeQuery{States: []string{"FAILED"}, Reason: "boom"})
+	query := createTestQuery(t, ts, "Revenue", "SELECT * FROM revenue")
+
+	visualization := doJSON[Visualization](t, ts, http.MethodPost, "/api/queries/"+query.ID.Hex()+"/visualizations",
+		gin.H{"name": "Rows", "type": "counter", "aggregation": "count"}, http.StatusCreated)
+	missingColumn := doJSON[Visualization](t, ts, http.MethodPost, "/api/queries/"+query.ID.Hex()+"/visualizations",
+		gin.H{"name": "Profit", "type": "bar", "columns": gin.H{"x": "month", "y": []string{"profit"}}}, http.StatusCreated)
+	path := "/api/visualizations/" + visualization.ID.Hex() + "/data"
+
+	doJSON[gin.H](t, ts, http.MethodGet, path, nil, http.StatusConflict)
+
+	succeeded := runTestQuery(t, ts, query, nil)
+	failed := runTestQuery(t, ts, query, gin.H{"sql": "SELECT * FROM broken"})
+	other := runTestQuery(t, ts, createTestQuery(t, ts, "Other", "SELECT 1"), nil)
+
+	// The latest run failed, so the chart is drawn from the one before
+	data := doJSON[ChartData](t, ts, http.MethodGet, path, nil, http.StatusOK)
+	if data.RunID != succeeded.ID || data.Value == nil || *data.Value != 5 {
+		t.Errorf("chart of run %s with value %v, want %s with 5", data.RunID.Hex(), data.Value, succeeded.ID.Hex())
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{name: "given run", path: path + "?run=" + succeeded.ID.Hex(), status: http.StatusOK},
+		{name: "failed run", path: path + "?run=" + failed.ID.Hex(), status: http.StatusConflict},
+		{name: "run of another query", path: path + "?run=" + other.ID.Hex(), status: http.StatusNotFound},
+		{name: "invalid run ID", path: path + "?run=nope", status: http.StatusBadRequest},
+		{name: "missing column", path: "/api/visualizations/" + missingColumn.ID.Hex() + "/data", status: http.StatusBadRequest},
+		{name: "unknown visualization", path: "/api/visualizations/0123456789abcdef01234567/data", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, tt.path, nil)
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
@@ -35,7 +35,7 @@ function AppContent() {
 
   const { data: queries = [], refetch: refetchQueries } = useQuery({
     queryKey: ['queries'],
-    queryFn: () => queryApi.getQueries().then(res => res.data),
+    queryFn: () => queryApi.getAllQueries(),
   })
 
   // Clean up localStorage queries that no longer exist in the database
@@ -252,11 +252,7 @@ function AppContent() {
     const query = queries.find(q => q.id === queryId)
     if (!query) return
 
-    queryApi.updateQuery(queryId, {
-      name: newName,
-      sql: query.sql,
-      description: query.description,
-    }).then((response) => {
+    queryApi.patchQuery(queryId, { name: newName }).then((response) => {
       const savedQuery = response.data
       refetchQueries()
       setEditQueryId(null)
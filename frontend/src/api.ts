@@ -1,29 +1,208 @@
-import axios from 'axios';
-import type { Query, QueryRun, QueryResults, AthenaCatalog } from './types';
+import axios, { type AxiosResponse } from 'axios';
+import type { Query, QueryRun, QueryResults, AthenaCatalog, CatalogDatabase, CatalogDatabaseInfo, CatalogTable, TagCount, FolderCount, SearchResponse, DownloadLink, RunDiff, ResultProfile, Visualization, VisualizationInput, ChartData, Dashboard, DashboardInput, DashboardRuns, ShareLink, SharePermission, SharedRun, ExecutionContext, DataCatalog, WorkGroupList, TablePreviewOptions, CatalogSearchHit, CatalogSearchResponse, AutocompleteRequest, AutocompleteResponse, SchemaChange, QuerySchemaChanges, TableUsage, LineageGraph, LineageOptions, SQLFormatRequest, SQLLintRequest, SQLLintResponse } from './types';
 
 const api = axios.create({
   baseURL: '/api',
 });
 
+// Listings are paged; follow X-Next-Cursor until every item was read
+const fetchAllPages = async <T>(fetchPage: (cursor?: string) => Promise<AxiosResponse<T[]>>): Promise<T[]> => {
+  const items: T[] = [];
+  let cursor: string | undefined;
+  do {
+    const res = await fetchPage(cursor);
+    items.push(...res.data);
+    cursor = res.headers['x-next-cursor'] || undefined;
+  } while (cursor);
+  return items;
+};
+
+// Largest page the listing endpoints return
+const maxPageSize = 1000;
+
+interface PageParams {
+  limit?: number;
+  cursor?: string;
+}
+
+interface QueryListFilters {
+  folder?: string;
+  tag?: string[];
+  recursive?: boolean;
+  sort?: 'updated' | 'created' | 'name' | 'lastRun';
+  order?: 'asc' | 'desc';
+  createdAfter?: string;
+  createdBefore?: string;
+  updatedAfter?: string;
+  updatedBefore?: string;
+}
+
+interface QueryRunListFilters {
+  status?: QueryRun['status'][];
+  sort?: 'executed' | 'completed';
+  order?: 'asc' | 'desc';
+  executedAfter?: string;
+  executedBefore?: string;
+}
+
 export const queryApi = {
-  getQueries: () => api.get<Query[]>('/queries'),
-  createQuery: (data: { name: string; sql?: string; description?: string }) =>
+  getQueries: (filters?: QueryListFilters & PageParams) => api.get<Query[]>('/queries', { params: filters, paramsSerializer: { indexes: null } }),
+  getAllQueries: (filters?: QueryListFilters) =>
+    fetchAllPages(cursor => api.get<Query[]>('/queries', {
+      params: { ...filters, limit: maxPageSize, cursor },
+      paramsSerializer: { indexes: null },
+    })),
+  createQuery: (data: { name: string; sql?: string; description?: string } & ExecutionContext) =>
     api.post<Query>('/queries', data),
   getQuery: (id: string) => api.get<Query>(`/queries/${id}`),
   updateQuery: (id: string, data: { name?: string; sql?: string; description?: string }) =>
     api.put<Query>(`/queries/${id}`, data),
+  patchQuery: (id: string, data: {
+    name?: string;
+    sql?: string | null;
+    description?: string | null;
+    catalog?: string | null;
+    database?: string | null;
+    workGroup?: string | null;
+  }) =>
+    api.patch<Query>(`/queries/${id}`, data, {
+      headers: { 'Content-Type': 'application/merge-patch+json' },
+    }),
   deleteQuery: (id: string) => api.delete(`/queries/${id}`),
+  moveQuery: (id: string, folder: string) => api.put<Query>(`/queries/${id}/folder`, { folder }),
+  setQueryTags: (id: string, tags: string[]) => api.put<Query>(`/queries/${id}/tags`, { tags }),
+  addQueryTags: (id: string, tags: string[]) => api.post<Query>(`/queries/${id}/tags`, { tags }),
+  removeQueryTag: (id: string, tag: string) =>
+    api.delete<Query>(`/queries/${id}/tags/${encodeURIComponent(tag)}`),
+  getFolders: () => api.get<FolderCount[]>('/folders'),
+  getTags: () => api.get<TagCount[]>('/tags'),
+  search: (params: {
+    q: string;
+    author?: string;
+    tag?: string[];
+    ranAfter?: string;
+    ranBefore?: string;
+    scope?: string;
+    limit?: number;
+  }) => api.get<SearchResponse>('/search', { params, paramsSerializer: { indexes: null } }),
   
-  getQueryRuns: (queryId: string) => api.get<QueryRun[]>(`/queries/${queryId}/runs`),
-  executeQuery: (queryId: string, sql: string, parameters?: Record<string, string>) =>
-    api.post<QueryRun>(`/queries/${queryId}/runs`, { sql, parameters }),
+  getQueryRuns: (queryId: string, filters?: QueryRunListFilters & PageParams) => api.get<QueryRun[]>(`/queries/${queryId}/runs`, {
+    params: filters,
+    paramsSerializer: { indexes: null },
+  }),
+  // One page of run history with the cursor of the next, if there are more runs
+  getQueryRunsPage: async (queryId: string, filters?: QueryRunListFilters & PageParams) => {
+    const res = await api.get<QueryRun[]>(`/queries/${queryId}/runs`, {
+      params: filters,
+      paramsSerializer: { indexes: null },
+    });
+    return { runs: res.data, nextCursor: (res.headers['x-next-cursor'] as string | undefined) || undefined };
+  },
+  executeQuery: (queryId: string, sql: string, parameters?: Record<string, string>, noCache?: boolean, context?: ExecutionContext) =>
+    api.post<QueryRun>(`/queries/${queryId}/runs`, { sql, parameters, noCache, ...context }),
   deleteQueryRun: (id: string) => api.delete(`/query-runs/${id}`),
+  diffQueryRuns: (id: string, otherId: string, keys: string[], limit?: number) =>
+    api.get<RunDiff>(`/query-runs/${id}/diff/${otherId}`, { params: { keys: keys.join(','), limit } }),
+
+  getShareLinks: (runId: string) => api.get<ShareLink[]>(`/query-runs/${runId}/shares`),
+  createShareLink: (runId: string, options?: { permission?: SharePermission; expiresIn?: number; password?: string }) =>
+    api.post<ShareLink>(`/query-runs/${runId}/shares`, options ?? {}),
+  revokeShareLink: (id: string) => api.delete<ShareLink>(`/shares/${id}`),
+
+  // Public share endpoints, reachable without signing in
+  getSharedRun: (token: string) => api.get<SharedRun>(`/public/shares/${token}`),
+  getSharedResults: (token: string, page: number = 1, size: number = 50, password?: string) =>
+    api.get<QueryResults>(`/public/shares/${token}/results`, {
+      params: { page, size },
+      headers: password ? { 'X-Share-Password': password } : undefined,
+    }),
+  downloadSharedResults: (token: string, password?: string) =>
+    api.get(`/public/shares/${token}/download`, {
+      responseType: 'blob',
+      headers: password ? { 'X-Share-Password': password } : undefined,
+    }),
+
+  getVisualizations: (queryId: string) => api.get<Visualization[]>(`/queries/${queryId}/visualizations`),
+  createVisualization: (queryId: string, visualization: VisualizationInput) =>
+    api.post<Visualization>(`/queries/${queryId}/visualizations`, visualization),
+  updateVisualization: (id: string, visualization: VisualizationInput) =>
+    api.put<Visualization>(`/visualizations/${id}`, visualization),
+  deleteVisualization: (id: string) => api.delete(`/visualizations/${id}`),
+  getChartData: (id: string, runId?: string) =>
+    api.get<ChartData>(`/visualizations/${id}/data`, { params: { run: runId } }),
+
+  getDashboards: () => api.get<Dashboard[]>('/dashboards'),
+  getDashboard: (id: string) => api.get<Dashboard>(`/dashboards/${id}`),
+  createDashboard: (dashboard: DashboardInput) => api.post<Dashboard>('/dashboards', dashboard),
+  updateDashboard: (id: string, dashboard: DashboardInput) => api.put<Dashboard>(`/dashboards/${id}`, dashboard),
+  deleteDashboard: (id: string) => api.delete(`/dashboards/${id}`),
+  refreshDashboard: (id: string, parameters?: Record<string, string>, noCache?: boolean) =>
+    api.post<DashboardRuns>(`/dashboards/${id}/refresh`, { parameters, noCache }),
+  getDashboardRuns: (id: string, parameters?: Record<string, string>) =>
+    api.get<DashboardRuns>(`/dashboards/${id}/runs`, { params: parameters }),
+
+  getTrashedQueries: (params?: { limit?: number; cursor?: string }) =>
+    api.get<Query[]>('/trash/queries', { params }),
+  getTrashedQueryRuns: (params?: { queryId?: string; limit?: number; cursor?: string }) =>
+    api.get<QueryRun[]>('/trash/query-runs', { params }),
+  restoreQuery: (id: string) => api.post<Query>(`/trash/queries/${id}/restore`),
+  restoreQueryRun: (id: string) => api.post<QueryRun>(`/trash/query-runs/${id}/restore`),
+  purgeQuery: (id: string) => api.delete(`/trash/queries/${id}`),
+  purgeQueryRun: (id: string) => api.delete(`/trash/query-runs/${id}`),
   
-  executeAthenaQuery: (sql: string, parameters?: Record<string, string>) => 
-    api.post<{ executionId: string }>('/athena/execute', { sql, parameters }),
-  getQueryResults: (executionId: string, page: number = 1, size: number = 50) =>
-    api.get<QueryResults>(`/athena/results/${executionId}?page=${page}&size=${size}`),
-  exportResults: (executionId: string) =>
-    api.get(`/athena/export/${executionId}`, { responseType: 'blob' }),
-  getAthenaCatalog: () => api.get<AthenaCatalog>('/athena/catalog'),
+  executeAthenaQuery: (sql: string, parameters?: Record<string, string>, noCache?: boolean, context?: ExecutionContext) => 
+    api.post<{ executionId: string; fromCache?: boolean }>('/athena/execute', { sql, parameters, noCache, ...context }),
+  getQueryResults: (
+    executionId: string,
+    page: number = 1,
+    size: number = 50,
+    options?: { sort?: string; filter?: string[]; columns?: string[] }
+  ) =>
+    api.get<QueryResults>(`/athena/results/${executionId}`, {
+      params: { page, size, sort: options?.sort, filter: options?.filter, columns: options?.columns?.join(',') },
+      paramsSerializer: { indexes: null },
+    }),
+  exportResults: (executionId: string, format: 'csv' | 'json' | 'ndjson' | 'parquet' | 'xlsx' = 'csv') =>
+    api.get(`/athena/export/${executionId}`, { params: { format }, responseType: 'blob' }),
+  getResultProfile: (executionId: string) =>
+    api.get<ResultProfile>(`/athena/results/${executionId}/profile`),
+  getDownloadLink: (executionId: string, expiresIn?: number) =>
+    api.get<DownloadLink>(`/athena/export/${executionId}`, { params: { link: 'json', expiresIn } }),
+  getAthenaCatalog: (refresh?: boolean, catalog?: string) =>
+    api.get<AthenaCatalog>('/athena/catalog', { params: { refresh: refresh || undefined, catalog } }),
+  getCatalogDatabases: (refresh?: boolean, catalog?: string) =>
+    api.get<CatalogDatabaseInfo[]>('/catalog', { params: { refresh: refresh || undefined, catalog } }),
+  getCatalogDatabase: (db: string, refresh?: boolean, catalog?: string) =>
+    api.get<CatalogDatabase>(`/catalog/${encodeURIComponent(db)}`, { params: { refresh: refresh || undefined, catalog } }),
+  getCatalogTable: (db: string, table: string, catalog?: string) =>
+    api.get<CatalogTable>(`/catalog/${encodeURIComponent(db)}/${encodeURIComponent(table)}`, { params: { catalog } }),
+  previewTable: (db: string, table: string, options?: TablePreviewOptions, catalog?: string) =>
+    api.post<QueryResults>(`/catalog/${encodeURIComponent(db)}/${encodeURIComponent(table)}/preview`, options ?? {}, { params: { catalog } }),
+  searchCatalog: (q: string, options?: { type?: CatalogSearchHit['type'][]; limit?: number; catalog?: string }) =>
+    api.get<CatalogSearchResponse>('/catalog/search', {
+      params: { q, type: options?.type?.join(','), limit: options?.limit, catalog: options?.catalog },
+    }),
+  autocompleteSQL: (request: AutocompleteRequest) =>
+    api.post<AutocompleteResponse>('/catalog/autocomplete', request),
+  formatSQL: (request: SQLFormatRequest) =>
+    api.post<{ sql: string }>('/sql/format', request),
+  lintSQL: (request: SQLLintRequest) =>
+    api.post<SQLLintResponse>('/sql/lint', request),
+  getSchemaChanges: (options?: { catalog?: string; database?: string; table?: string; since?: string; limit?: number }) =>
+    api.get<SchemaChange[]>('/schema-changes', { params: options }),
+  getTableSchemaChanges: (db: string, table: string, options?: { since?: string; limit?: number; catalog?: string }) =>
+    api.get<SchemaChange[]>(`/catalog/${encodeURIComponent(db)}/${encodeURIComponent(table)}/changes`, { params: options }),
+  getQuerySchemaChanges: (id: string) =>
+    api.get<QuerySchemaChanges>(`/queries/${id}/schema-changes`),
+  getAffectedQueries: (since?: string) =>
+    api.get<QuerySchemaChanges[]>('/schema-changes/affected-queries', { params: { since } }),
+  getTableQueries: (db: string, table: string, catalog?: string) =>
+    api.get<TableUsage[]>(`/catalog/${encodeURIComponent(db)}/${encodeURIComponent(table)}/queries`, { params: { catalog } }),
+  getTableLineage: (db: string, table: string, options?: LineageOptions & { catalog?: string }) =>
+    api.get<LineageGraph>(`/catalog/${encodeURIComponent(db)}/${encodeURIComponent(table)}/lineage`, { params: options }),
+  getLineage: (options?: LineageOptions & { table?: string; catalog?: string }) =>
+    api.get<LineageGraph>('/lineage', { params: options }),
+  getDataCatalogs: (refresh?: boolean) =>
+    api.get<DataCatalog[]>('/catalogs', { params: { refresh: refresh || undefined } }),
+  getWorkGroups: () => api.get<WorkGroupList>('/workgroups'),
 };
\ No newline at end of file
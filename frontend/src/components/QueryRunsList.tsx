@@ -1,10 +1,13 @@
-import { useState, useEffect } from 'react'
+import { useState, useEffect, useMemo } from 'react'
 import { useQuery } from '@tanstack/react-query'
 import { IconTrash, IconRefresh, IconLoader } from '@tabler/icons-react'
 import { queryApi } from '../api'
 import { useDarkMode } from '../hooks/useDarkMode'
 import type { QueryRun } from '../types'
 
+// Runs fetched per page of history
+const runHistoryPageSize = 50
+
 interface QueryRunsListProps {
   queryId?: string
   onRunClick?: (queryRun: QueryRun) => void
@@ -15,15 +18,51 @@ export default function QueryRunsList({ queryId, onRunClick, onQueryRunsUpdate }
   const { isDarkMode } = useDarkMode()
   const [hoveredRun, setHoveredRun] = useState<string | null>(null)
 
-  const { data: queryRuns = [], refetch, isLoading } = useQuery({
+  // Only the first page is polled; older runs are loaded on request and rarely change
+  const { data: firstPage, refetch, isLoading } = useQuery({
     queryKey: ['queryRuns', queryId],
-    queryFn: () => queryId ? queryApi.getQueryRuns(queryId).then(res => res.data) : Promise.resolve([]),
+    queryFn: () => queryId
+      ? queryApi.getQueryRunsPage(queryId, { limit: runHistoryPageSize })
+      : Promise.resolve({ runs: [] as QueryRun[], nextCursor: undefined }),
     enabled: !!queryId,
     refetchInterval: 5000, // Refetch every 5 seconds to update status
     staleTime: 0, // Always consider data stale to ensure fresh fetches
     refetchOnWindowFocus: true, // Refetch when window regains focus
   })
 
+  const [olderRuns, setOlderRuns] = useState<QueryRun[]>([])
+  // null until older runs are loaded, then the cursor after the last of them
+  const [olderCursor, setOlderCursor] = useState<string | null | undefined>(null)
+  const [loadingMore, setLoadingMore] = useState(false)
+
+  // Older pages belong to the query they were loaded for
+  useEffect(() => {
+    setOlderRuns([])
+    setOlderCursor(null)
+  }, [queryId])
+
+  const queryRuns = useMemo(() => {
+    const firstRuns = firstPage?.runs ?? []
+    const seen = new Set(firstRuns.map(run => run.id))
+    return [...firstRuns, ...olderRuns.filter(run => !seen.has(run.id))]
+  }, [firstPage, olderRuns])
+
+  const nextCursor = olderCursor === null ? firstPage?.nextCursor : olderCursor
+
+  const handleLoadMore = async () => {
+    if (!queryId || !nextCursor) return
+    setLoadingMore(true)
+    try {
+      const page = await queryApi.getQueryRunsPage(queryId, { limit: runHistoryPageSize, cursor: nextCursor })
+      setOlderRuns(runs => [...runs, ...page.runs])
+      setOlderCursor(page.nextCursor)
+    } catch (error) {
+      console.error('Failed to load more query runs:', error)
+    } finally {
+      setLoadingMore(false)
+    }
+  }
+
   // Notify parent component when query runs data changes
   useEffect(() => {
     if (queryRuns.length > 0 && onQueryRunsUpdate) {
@@ -35,6 +74,7 @@ export default function QueryRunsList({ queryId, onRunClick, onQueryRunsUpdate }
     e.stopPropagation()
     try {
       await queryApi.deleteQueryRun(runId)
+      setOlderRuns(runs => runs.filter(run => run.id !== runId))
       refetch()
     } catch (error) {
       console.error('Failed to delete query run:', error)
@@ -198,6 +238,19 @@ export default function QueryRunsList({ queryId, onRunClick, onQueryRunsUpdate }
                 )}
               </div>
             ))}
+            {nextCursor && (
+              <div className="px-4 py-3 text-center">
+                <button
+                  onClick={handleLoadMore}
+                  disabled={loadingMore}
+                  className={`text-xs font-medium transition-colors cursor-pointer disabled:opacity-50 ${
+                    isDarkMode ? 'text-blue-400 hover:text-blue-300' : 'text-blue-600 hover:text-blue-700'
+                  }`}
+                >
+                  {loadingMore ? 'Loading...' : 'Load more runs'}
+                </button>
+              </div>
+            )}
           </div>
         )}
       </div>
@@ -3,8 +3,34 @@ export interface Query {
   name: string;
   sql: string;
   description?: string;
+  folder?: string;
+  tags?: string[] | null;
+  tables?: string[] | null;
+  databases?: string[] | null;
+  columns?: string[] | null; // As table.column
+  lineage?: QueryLineage;
+  catalog?: string;
+  database?: string;
+  workGroup?: string;
+  createdBy?: string;
   createdAt: string;
   updatedAt: string;
+  lastRunAt?: string;
+  deletedAt?: string;
+  deletedBy?: string;
+  resultCacheTtl?: number;
+}
+
+export interface TagCount {
+  tag: string;
+  count: number;
+}
+
+export interface FolderCount {
+  path: string;
+  name: string;
+  depth: number;
+  count: number;
 }
 
 export interface QueryRun {
@@ -16,8 +42,71 @@ export interface QueryRun {
   resultsS3Url?: string;
   errorMessage?: string;
   parameters?: Record<string, string>;
+  catalog?: string;
+  database?: string;
+  workGroup?: string;
+  executedBy?: string;
   executedAt: string;
   completedAt?: string;
+  deletedAt?: string;
+  deletedBy?: string;
+  fromCache?: boolean;
+}
+
+export interface DownloadLink {
+  url: string;
+  presigned: boolean;
+  expiresAt?: string;
+}
+
+export type SharePermission = 'view' | 'download';
+
+export interface ShareLink {
+  id: string;
+  runId: string;
+  permission: SharePermission;
+  token: string;
+  passwordProtected: boolean;
+  createdBy?: string;
+  createdAt: string;
+  expiresAt: string;
+  revokedAt?: string;
+  revokedBy?: string;
+}
+
+export interface SharedRun {
+  permission: SharePermission;
+  passwordProtected: boolean;
+  expiresAt: string;
+  executedAt: string;
+  completedAt?: string;
+}
+
+export interface DiffRow {
+  key: string[];
+  values: string[];
+}
+
+export interface RunDiff {
+  from: string;
+  to: string;
+  keys: string[];
+  columns: string[];
+  added: DiffRow[];
+  removed: DiffRow[];
+  changed: { key: string[]; cells: { column: string; before: string; after: string; delta?: number }[] }[];
+  summary: {
+    fromRows: number;
+    toRows: number;
+    added: number;
+    removed: number;
+    changed: number;
+    unchanged: number;
+    duplicateKeys: number;
+    addedColumns: string[];
+    removedColumns: string[];
+  };
+  truncated: boolean;
 }
 
 export interface QueryResults {
@@ -29,6 +118,244 @@ export interface QueryResults {
   status: 'QUEUED' | 'RUNNING' | 'SUCCEEDED' | 'FAILED' | 'CANCELLED';
   errorMessage?: string;
   completedAt?: string;
+  totalUnfiltered?: number;
+  executionId?: string; // Set on table previews
+  sql?: string;
+  fromCache?: boolean;
+}
+
+export interface CatalogSearchHit {
+  type: 'database' | 'table' | 'column';
+  score: number;
+  database: string;
+  table?: string;
+  column?: string;
+  dataType?: string;
+  description?: string;
+}
+
+export interface CatalogSearchResponse {
+  query: string;
+  catalog: string;
+  hits: CatalogSearchHit[];
+  total: number;
+}
+
+export interface AutocompleteRequest {
+  sql: string;
+  cursor?: number; // In characters, the end of the SQL by default
+  catalog?: string;
+  database?: string;
+}
+
+export interface AutocompleteSuggestion {
+  label: string;
+  type: 'database' | 'table' | 'column';
+  detail?: string;
+  insertText: string;
+  score: number;
+}
+
+export interface AutocompleteResponse {
+  context: 'table' | 'column' | 'none';
+  prefix: string;
+  replaceFrom: number;
+  suggestions: AutocompleteSuggestion[];
+}
+
+export interface SQLFormatRequest {
+  sql: string;
+  keywordCase?: 'upper' | 'lower' | 'preserve';
+  indent?: number; // Spaces per level, 2 by default
+  useTabs?: boolean;
+}
+
+export interface SQLLintRequest {
+  sql: string;
+  catalog?: string;
+  database?: string;
+}
+
+export interface SQLProblem {
+  rule: 'placeholder' | 'select-star' | 'partition-filter' | 'cross-join' | 'unknown-table' | 'unknown-column';
+  severity: 'error' | 'warning';
+  message: string;
+  line: number; // From 1
+  column: number; // From 1
+  endLine: number;
+  endColumn: number; // Exclusive
+}
+
+export interface SQLLintResponse {
+  problems: SQLProblem[];
+  truncated: boolean; // Only the first problems were returned
+  catalogError?: string; // Set when tables and columns weren't checked
+}
+
+export interface TablePreviewOptions {
+  limit?: number;
+  noCache?: boolean;
+  workGroup?: string;
+}
+
+export interface SchemaChange {
+  id: string;
+  catalog: string;
+  database: string;
+  table: string;
+  type: 'table_added' | 'table_dropped' | 'column_added' | 'column_removed' | 'column_retyped';
+  column?: string;
+  oldType?: string;
+  newType?: string;
+  detectedAt: string;
+}
+
+export interface QuerySchemaChanges {
+  queryId: string;
+  name: string;
+  lastSucceededAt?: string; // Unset when the query never ran successfully
+  changes: SchemaChange[];
+}
+
+export interface QueryLineage {
+  type: 'ctas' | 'view' | 'insert' | 'merge';
+  target: string;
+  sources: string[];
+}
+
+export interface TableUsage {
+  queryId: string;
+  name: string;
+  folder: string;
+  reads: boolean;
+  writes: boolean;
+  columns: string[];
+  updatedAt: string;
+}
+
+export interface LineageNode {
+  id: string;
+  catalog: string;
+  database?: string; // Unset for unqualified tables of queries without a database
+  table: string;
+}
+
+export interface LineageEdge {
+  source: string;
+  target: string;
+  type: QueryLineage['type'];
+  queryId: string;
+  queryName: string;
+}
+
+export interface LineageGraph {
+  nodes: LineageNode[];
+  edges: LineageEdge[];
+}
+
+export interface LineageOptions {
+  direction?: 'upstream' | 'downstream' | 'both';
+  depth?: number;
+}
+
+export type VisualizationType = 'line' | 'bar' | 'pie' | 'scatter' | 'pivot' | 'counter';
+export type ChartAggregation = 'none' | 'count' | 'sum' | 'avg' | 'min' | 'max';
+
+export interface Visualization {
+  id: string;
+  queryId: string;
+  name: string;
+  type: VisualizationType;
+  columns: { x?: string; y: string[]; series?: string };
+  aggregation: ChartAggregation;
+  options?: Record<string, unknown>;
+  createdBy?: string;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export type VisualizationInput = Pick<Visualization, 'name' | 'type' | 'columns' | 'options'> & {
+  aggregation?: ChartAggregation;
+};
+
+export interface ChartData {
+  visualizationId: string;
+  runId: string;
+  type: VisualizationType;
+  labels: string[];
+  series: { name: string; values: (number | null)[] }[];
+  value?: number;
+  rows: number;
+  truncated: boolean;
+}
+
+export interface DashboardWidget {
+  id?: string;
+  queryId: string;
+  visualizationId?: string;
+  title?: string;
+  layout: { x: number; y: number; w: number; h: number };
+  parameters?: Record<string, string>; // Query placeholder to dashboard parameter
+}
+
+export interface Dashboard {
+  id: string;
+  name: string;
+  description: string;
+  parameters: { name: string; default: string }[];
+  widgets: DashboardWidget[];
+  createdBy?: string;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export type DashboardInput = Pick<Dashboard, 'name' | 'parameters' | 'widgets'> & { description?: string };
+
+export interface DashboardRuns {
+  dashboardId: string;
+  widgets: { widgetId: string; run?: QueryRun; error?: string }[];
+}
+
+export interface ColumnProfile {
+  name: string;
+  type: string;
+  nulls: number;
+  distinct: number;
+  distinctCapped?: boolean;
+  min?: string;
+  max?: string;
+  mean?: number;
+  stdDev?: number;
+  percentiles?: Record<string, number>;
+  topValues?: { value: string; count: number }[];
+  histogram?: { start: string; end: string; count: number }[];
+}
+
+export interface ResultProfile {
+  executionId: string;
+  rows: number;
+  columns: ColumnProfile[];
+  sampled: boolean;
+  computedAt: string;
+}
+
+export interface Highlight {
+  field: string;
+  fragment: string;
+  matches: [number, number][];
+}
+
+export interface SearchHit {
+  type: 'query' | 'run';
+  score: number;
+  query?: Query;
+  run?: QueryRun;
+  highlights: Highlight[];
+}
+
+export interface SearchResponse {
+  query: string;
+  hits: SearchHit[];
 }
 
 export interface OpenQuery {
@@ -43,22 +370,83 @@ export interface OpenQuery {
 export interface Column {
   name: string;
   type: string;
+  comment?: string;
 }
 
 export interface CatalogTable {
   name: string;
   type: string;
   columns: Column[];
+  comment?: string;
+  partitionKeys?: Column[];
   location?: string;
   inputFormat?: string;
+  outputFormat?: string;
+  serde?: string;
+  classification?: string;
+  properties?: Record<string, string>;
+  createdAt?: string;
+  updatedAt?: string;
+  partitions?: TablePartitions; // Only on single table requests
+}
+
+export interface TablePartitions {
+  count: number;
+  truncated?: boolean;
+  projected?: boolean;
+  recent: Partition[];
+  loadedAt: string;
+  error?: string;
+}
+
+export interface Partition {
+  values: string[]; // In the order of partitionKeys
+  location?: string;
+  createdAt?: string;
 }
 
 export interface CatalogDatabase {
   name: string;
   description?: string;
   tables: CatalogTable[];
+  loadedAt?: string;
+  error?: string; // The last table listing failed; tables may be stale or partial
+}
+
+export interface CatalogDatabaseInfo {
+  name: string;
+  description?: string;
+  tableCount?: number;
+  loadedAt?: string;
+  error?: string;
 }
 
 export interface AthenaCatalog {
+  catalog: string;
   databases: CatalogDatabase[];
+  listedAt: string;
+}
+
+export interface ExecutionContext {
+  catalog?: string;
+  database?: string;
+  workGroup?: string;
+}
+
+export interface DataCatalog {
+  name: string;
+  type: string;
+}
+
+export interface WorkGroup {
+  name: string;
+  state: string;
+  description?: string;
+  engineVersion?: string;
+  createdAt?: string;
+}
+
+export interface WorkGroupList {
+  default: string;
+  workGroups: WorkGroup[];
 }
\ No newline at end of file